@@ -3,16 +3,26 @@ package builtin
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 )
 
+// vcsExcludes are the names skipped by tar --exclude-vcs
+var vcsExcludes = []string{
+	".git", ".gitignore", ".gitattributes", ".gitmodules",
+	".svn", ".hg", ".hgignore", ".hgtags", ".bzr", ".bzrignore",
+	"CVS", ".cvsignore", "_darcs",
+}
+
 // Tar creates and extracts tar archives
-func Tar(args []string) error {
+func Tar(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("tar: missing operation")
 	}
@@ -21,11 +31,27 @@ func Tar(args []string) error {
 	var verbose bool
 	var gzipCompress bool
 	var archive string
+	var fileLists []string
+	var excludes []string
 	var files []string
 
 	// Parse arguments
-	for i, arg := range args {
-		if strings.HasPrefix(arg, "-") {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case strings.HasPrefix(arg, "--exclude="):
+			excludes = append(excludes, strings.TrimPrefix(arg, "--exclude="))
+		case arg == "--exclude":
+			if i+1 >= len(args) {
+				return fmt.Errorf("tar: --exclude requires a pattern")
+			}
+			i++
+			excludes = append(excludes, args[i])
+		case arg == "--exclude-vcs":
+			excludes = append(excludes, vcsExcludes...)
+		case strings.HasPrefix(arg, "--files-from="):
+			fileLists = append(fileLists, strings.TrimPrefix(arg, "--files-from="))
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
 			flags := arg[1:]
 			for _, flag := range flags {
 				switch flag {
@@ -39,13 +65,20 @@ func Tar(args []string) error {
 					verbose = true
 				case 'z':
 					gzipCompress = true
-				case 'f':
-					if i+1 < len(args) {
-						archive = args[i+1]
+				case 'f', 'T':
+					// Options taking a value consume the following arguments in order
+					if i+1 >= len(args) {
+						return fmt.Errorf("tar: option -%c requires an argument", flag)
+					}
+					i++
+					if flag == 'f' {
+						archive = args[i]
+					} else {
+						fileLists = append(fileLists, args[i])
 					}
 				}
 			}
-		} else if arg != archive {
+		default:
 			files = append(files, arg)
 		}
 	}
@@ -54,19 +87,53 @@ func Tar(args []string) error {
 		return fmt.Errorf("tar: missing archive file")
 	}
 
+	for _, listFile := range fileLists {
+		listed, err := readFileList(ctx, listFile)
+		if err != nil {
+			return fmt.Errorf("tar: %v", err)
+		}
+		files = append(files, listed...)
+	}
+
 	if create {
-		return tarCreate(archive, files, verbose, gzipCompress)
+		return tarCreate(ctx, archive, files, excludes, verbose, gzipCompress)
 	} else if extract {
-		return tarExtract(archive, verbose, gzipCompress)
+		return tarExtract(ctx, archive, verbose, gzipCompress)
 	} else if list {
-		return tarList(archive, verbose, gzipCompress)
+		return tarList(ctx, archive, verbose, gzipCompress)
 	}
 
 	return fmt.Errorf("tar: no operation specified")
 }
 
+// readFileList reads one path per line from a file, or stdin for "-"
+func readFileList(ctx *Context, name string) ([]string, error) {
+	var reader io.Reader = ctx.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	lines, err := readLines(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, nil
+}
+
 // tarCreate creates a tar archive
-func tarCreate(archiveName string, files []string, verbose, gzipCompress bool) error {
+func tarCreate(ctx *Context, archiveName string, files, excludes []string, verbose, gzipCompress bool) error {
 	// Create archive file
 	archiveFile, err := os.Create(archiveName)
 	if err != nil {
@@ -88,9 +155,12 @@ func tarCreate(archiveName string, files []string, verbose, gzipCompress bool) e
 	tarWriter := tar.NewWriter(writer)
 	defer tarWriter.Close()
 
+	// Never add the archive to itself
+	excludes = append(excludes, filepath.Clean(archiveName))
+
 	// Add files to archive
 	for _, file := range files {
-		if err := addFileToTar(tarWriter, file, verbose); err != nil {
+		if err := addFileToTar(ctx, tarWriter, file, excludes, verbose); err != nil {
 			return err
 		}
 	}
@@ -98,13 +168,37 @@ func tarCreate(archiveName string, files []string, verbose, gzipCompress bool) e
 	return nil
 }
 
+// isExcluded reports whether a path matches any exclude pattern, either by
+// its base name or by the whole path
+func isExcluded(path string, excludes []string) bool {
+	base := filepath.Base(path)
+	clean := filepath.Clean(path)
+	for _, pattern := range excludes {
+		if matched, _ := filepath.Match(pattern, base); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(filepath.Clean(pattern), clean); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // addFileToTar adds a file to tar archive
-func addFileToTar(tarWriter *tar.Writer, filename string, verbose bool) error {
+func addFileToTar(ctx *Context, tarWriter *tar.Writer, filename string, excludes []string, verbose bool) error {
 	return filepath.Walk(filename, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
+		// Skip excluded files and whole excluded directories
+		if isExcluded(path, excludes) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		// Create tar header
 		header, err := tar.FileInfoHeader(info, "")
 		if err != nil {
@@ -119,7 +213,7 @@ func addFileToTar(tarWriter *tar.Writer, filename string, verbose bool) error {
 		}
 
 		if verbose {
-			fmt.Println(path)
+			fmt.Fprintln(ctx.Stdout, path)
 		}
 
 		// Write file content if it's a regular file
@@ -139,7 +233,7 @@ func addFileToTar(tarWriter *tar.Writer, filename string, verbose bool) error {
 }
 
 // tarExtract extracts a tar archive
-func tarExtract(archiveName string, verbose, gzipCompress bool) error {
+func tarExtract(ctx *Context, archiveName string, verbose, gzipCompress bool) error {
 	// Open archive file
 	archiveFile, err := os.Open(archiveName)
 	if err != nil {
@@ -173,7 +267,7 @@ func tarExtract(archiveName string, verbose, gzipCompress bool) error {
 		}
 
 		if verbose {
-			fmt.Println(header.Name)
+			fmt.Fprintln(ctx.Stdout, header.Name)
 		}
 
 		// Create file/directory
@@ -212,7 +306,7 @@ func tarExtract(archiveName string, verbose, gzipCompress bool) error {
 }
 
 // tarList lists contents of tar archive
-func tarList(archiveName string, verbose, gzipCompress bool) error {
+func tarList(ctx *Context, archiveName string, verbose, gzipCompress bool) error {
 	// Open archive file
 	archiveFile, err := os.Open(archiveName)
 	if err != nil {
@@ -246,42 +340,72 @@ func tarList(archiveName string, verbose, gzipCompress bool) error {
 		}
 
 		if verbose {
-			fmt.Printf("%s %10d %s %s\n",
+			fmt.Fprintf(ctx.Stdout, "%s %10d %s %s\n",
 				header.FileInfo().Mode(),
 				header.Size,
 				header.ModTime.Format("2006-01-02 15:04"),
 				header.Name)
 		} else {
-			fmt.Println(header.Name)
+			fmt.Fprintln(ctx.Stdout, header.Name)
 		}
 	}
 
 	return nil
 }
 
+// Gzip compression tuning for large inputs: files at least
+// parallelGzipThreshold bytes long are split into parallelGzipBlockSize
+// blocks that are compressed concurrently
+const (
+	parallelGzipThreshold = 4 << 20
+	parallelGzipBlockSize = 1 << 20
+)
+
 // Gzip compresses files using gzip
-func Gzip(args []string) error {
+func Gzip(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("gzip: missing file")
 	}
 
 	var decompress bool
 	var keep bool
+	var level = gzip.DefaultCompression
+	var workers = runtime.NumCPU()
 	var files []string
 
 	// Parse arguments
-	for _, arg := range args {
-		if strings.HasPrefix(arg, "-") {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--fast":
+			level = gzip.BestSpeed
+		case arg == "--best":
+			level = gzip.BestCompression
+		case arg == "-p" || arg == "--processes":
+			if i+1 >= len(args) {
+				return fmt.Errorf("gzip: %s requires an argument", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return fmt.Errorf("gzip: invalid number of processes: %s", args[i])
+			}
+			workers = n
+		case strings.HasPrefix(arg, "-"):
 			flags := arg[1:]
 			for _, flag := range flags {
-				switch flag {
-				case 'd':
+				switch {
+				case flag == 'd':
 					decompress = true
-				case 'k':
+				case flag == 'k':
 					keep = true
+				case flag >= '1' && flag <= '9':
+					level = int(flag - '0')
+				default:
+					return fmt.Errorf("gzip: invalid option: -%c", flag)
 				}
 			}
-		} else {
+		default:
 			files = append(files, arg)
 		}
 	}
@@ -289,11 +413,11 @@ func Gzip(args []string) error {
 	for _, file := range files {
 		if decompress {
 			if err := gunzipFile(file, keep); err != nil {
-				fmt.Printf("gzip: %v\n", err)
+				fmt.Fprintf(ctx.Stderr, "gzip: %v\n", err)
 			}
 		} else {
-			if err := gzipFile(file, keep); err != nil {
-				fmt.Printf("gzip: %v\n", err)
+			if err := gzipFile(file, keep, level, workers); err != nil {
+				fmt.Fprintf(ctx.Stderr, "gzip: %v\n", err)
 			}
 		}
 	}
@@ -302,7 +426,7 @@ func Gzip(args []string) error {
 }
 
 // gzipFile compresses a file
-func gzipFile(filename string, keep bool) error {
+func gzipFile(filename string, keep bool, level, workers int) error {
 	// Open input file
 	inputFile, err := os.Open(filename)
 	if err != nil {
@@ -310,6 +434,11 @@ func gzipFile(filename string, keep bool) error {
 	}
 	defer inputFile.Close()
 
+	info, err := inputFile.Stat()
+	if err != nil {
+		return err
+	}
+
 	// Create output file
 	outputFile, err := os.Create(filename + ".gz")
 	if err != nil {
@@ -317,12 +446,15 @@ func gzipFile(filename string, keep bool) error {
 	}
 	defer outputFile.Close()
 
-	// Create gzip writer
-	gzipWriter := gzip.NewWriter(outputFile)
-	defer gzipWriter.Close()
-
-	// Copy data
-	if _, err := io.Copy(gzipWriter, inputFile); err != nil {
+	// Compress large files across cores, small ones in a single stream
+	if workers > 1 && info.Size() >= parallelGzipThreshold {
+		err = parallelGzip(outputFile, inputFile, level, workers)
+	} else {
+		err = serialGzip(outputFile, inputFile, level)
+	}
+	if err != nil {
+		outputFile.Close()
+		os.Remove(filename + ".gz")
 		return err
 	}
 
@@ -334,6 +466,81 @@ func gzipFile(filename string, keep bool) error {
 	return nil
 }
 
+// serialGzip compresses reader into writer as a single gzip stream
+func serialGzip(writer io.Writer, reader io.Reader, level int) error {
+	gzipWriter, err := gzip.NewWriterLevel(writer, level)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(gzipWriter, reader); err != nil {
+		gzipWriter.Close()
+		return err
+	}
+
+	return gzipWriter.Close()
+}
+
+// parallelGzip compresses reader in fixed-size blocks on several workers and
+// writes them in order as consecutive gzip members. Concatenated members are
+// a valid gzip file that gunzip (and Go's gzip reader) decompress as one.
+func parallelGzip(writer io.Writer, reader io.Reader, level, workers int) error {
+	type block struct {
+		data []byte
+		err  error
+	}
+
+	// Each queued channel delivers one compressed block; the queue's capacity
+	// bounds how many blocks are in flight at once
+	queue := make(chan chan block, workers)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(queue)
+		for {
+			buf := make([]byte, parallelGzipBlockSize)
+			n, err := io.ReadFull(reader, buf)
+			if n > 0 {
+				result := make(chan block, 1)
+				queue <- result
+				go func(data []byte) {
+					var out bytes.Buffer
+					err := serialGzip(&out, bytes.NewReader(data), level)
+					result <- block{out.Bytes(), err}
+				}(buf[:n])
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				readErr <- nil
+				return
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	var writeErr error
+	for result := range queue {
+		compressed := <-result
+		if writeErr != nil {
+			continue // drain the queue so the reader goroutine can finish
+		}
+		if compressed.err != nil {
+			writeErr = compressed.err
+			continue
+		}
+		if _, err := writer.Write(compressed.data); err != nil {
+			writeErr = err
+		}
+	}
+
+	if err := <-readErr; err != nil {
+		return err
+	}
+	return writeErr
+}
+
 // gunzipFile decompresses a gzip file
 func gunzipFile(filename string, keep bool) error {
 	// Open input file
@@ -379,7 +586,7 @@ func gunzipFile(filename string, keep bool) error {
 }
 
 // Zip creates and extracts zip archives
-func Zip(args []string) error {
+func Zip(ctx *Context, args []string) error {
 	if len(args) < 2 {
 		return fmt.Errorf("zip: missing arguments")
 	}
@@ -416,14 +623,14 @@ func Zip(args []string) error {
 	}
 
 	if extract {
-		return unzipArchive(archive, verbose)
+		return unzipArchive(ctx, archive, verbose)
 	} else {
-		return createZipArchive(archive, files, verbose)
+		return createZipArchive(ctx, archive, files, verbose)
 	}
 }
 
 // createZipArchive creates a zip archive
-func createZipArchive(archiveName string, files []string, verbose bool) error {
+func createZipArchive(ctx *Context, archiveName string, files []string, verbose bool) error {
 	// Create archive file
 	archiveFile, err := os.Create(archiveName)
 	if err != nil {
@@ -437,7 +644,7 @@ func createZipArchive(archiveName string, files []string, verbose bool) error {
 
 	// Add files to archive
 	for _, file := range files {
-		if err := addFileToZip(zipWriter, file, verbose); err != nil {
+		if err := addFileToZip(ctx, zipWriter, file, verbose); err != nil {
 			return err
 		}
 	}
@@ -446,7 +653,7 @@ func createZipArchive(archiveName string, files []string, verbose bool) error {
 }
 
 // addFileToZip adds a file to zip archive
-func addFileToZip(zipWriter *zip.Writer, filename string, verbose bool) error {
+func addFileToZip(ctx *Context, zipWriter *zip.Writer, filename string, verbose bool) error {
 	return filepath.Walk(filename, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -475,7 +682,7 @@ func addFileToZip(zipWriter *zip.Writer, filename string, verbose bool) error {
 		}
 
 		if verbose {
-			fmt.Printf("adding: %s\n", path)
+			fmt.Fprintf(ctx.Stdout, "adding: %s\n", path)
 		}
 
 		return nil
@@ -483,7 +690,7 @@ func addFileToZip(zipWriter *zip.Writer, filename string, verbose bool) error {
 }
 
 // unzipArchive extracts a zip archive
-func unzipArchive(archiveName string, verbose bool) error {
+func unzipArchive(ctx *Context, archiveName string, verbose bool) error {
 	// Open zip file
 	reader, err := zip.OpenReader(archiveName)
 	if err != nil {
@@ -494,7 +701,7 @@ func unzipArchive(archiveName string, verbose bool) error {
 	// Extract files
 	for _, file := range reader.File {
 		if verbose {
-			fmt.Printf("extracting: %s\n", file.Name)
+			fmt.Fprintf(ctx.Stdout, "extracting: %s\n", file.Name)
 		}
 
 		// Create parent directories
@@ -0,0 +1,54 @@
+package builtin
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// gzipBenchmarkSize is large enough for gzip to split the input across
+// workers
+const gzipBenchmarkSize = 4 * parallelGzipThreshold
+
+// writeBenchmarkInput writes size bytes of text that compresses about as
+// well as logs or source code do
+func writeBenchmarkInput(b *testing.B, path string, size int) {
+	b.Helper()
+	words := []string{"gex", "shell", "pipeline", "builtin", "error", "status", "\n"}
+	random := rand.New(rand.NewSource(1))
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		data = append(data, words[random.Intn(len(words))]...)
+		data = append(data, ' ')
+		data = strconv.AppendInt(data, random.Int63n(1<<20), 10)
+		data = append(data, ' ')
+	}
+	if err := os.WriteFile(path, data[:size], 0644); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkGzip(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "input")
+	writeBenchmarkInput(b, path, gzipBenchmarkSize)
+	ctx := &Context{Stdout: os.Stdout, Stderr: os.Stderr}
+
+	for _, bench := range []struct {
+		name string
+		args []string
+	}{
+		{"serial", []string{"-k", "-p", "1", path}},
+		{"parallel", []string{"-k", path}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(gzipBenchmarkSize)
+			for i := 0; i < b.N; i++ {
+				if err := Gzip(ctx, bench.args); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
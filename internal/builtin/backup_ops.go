@@ -0,0 +1,333 @@
+package builtin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotLayout is the name format of backup snapshot directories
+const snapshotLayout = "2006-01-02_150405"
+
+// snapshotTempPrefix marks a snapshot that is still being written
+const snapshotTempPrefix = ".incomplete-"
+
+// backupStats counts what a snapshot run did
+type backupStats struct {
+	copied  int
+	linked  int
+	dirs    int
+	skipped int
+}
+
+// Backup creates incremental snapshots (like rsync --link-dest). Files that
+// are unchanged since the previous snapshot are hard-linked instead of copied.
+func Backup(ctx *Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("backup: usage: backup [-v] [--exclude=GLOB] SRC DEST | backup list DEST | backup prune [--keep N] DEST")
+	}
+
+	switch args[0] {
+	case "list":
+		return backupList(ctx, args[1:])
+	case "prune":
+		return backupPrune(ctx, args[1:])
+	}
+
+	var verbose bool
+	var excludes []string
+	var paths []string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-v" || arg == "--verbose":
+			verbose = true
+		case strings.HasPrefix(arg, "--exclude="):
+			excludes = append(excludes, strings.TrimPrefix(arg, "--exclude="))
+		case arg == "--exclude":
+			if i+1 >= len(args) {
+				return fmt.Errorf("backup: --exclude requires a pattern")
+			}
+			i++
+			excludes = append(excludes, args[i])
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			return fmt.Errorf("backup: invalid option: %s", arg)
+		default:
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) != 2 {
+		return fmt.Errorf("backup: expected SRC and DEST")
+	}
+
+	return backupCreate(ctx, paths[0], paths[1], excludes, verbose)
+}
+
+// backupCreate writes a new snapshot of src under dest
+func backupCreate(ctx *Context, src, dest string, excludes []string, verbose bool) error {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+	if !srcInfo.IsDir() {
+		return fmt.Errorf("backup: %s: not a directory", src)
+	}
+
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	snapshots, err := listSnapshots(dest)
+	if err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	name := time.Now().Format(snapshotLayout)
+	final := filepath.Join(dest, name)
+	if _, err := os.Lstat(final); err == nil {
+		return fmt.Errorf("backup: snapshot %s already exists", name)
+	}
+
+	var previous string
+	if len(snapshots) > 0 {
+		previous = filepath.Join(dest, snapshots[len(snapshots)-1])
+	}
+
+	// Write into a temporary directory so an interrupted run is never used
+	// as the base of the next snapshot
+	temp := filepath.Join(dest, snapshotTempPrefix+name)
+	if err := os.RemoveAll(temp); err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	// Never back up the destination into itself
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	start := time.Now()
+	stats := &backupStats{}
+	if err := snapshotTree(ctx, src, temp, previous, absDest, excludes, verbose, stats); err != nil {
+		os.RemoveAll(temp)
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	if err := os.Rename(temp, final); err != nil {
+		os.RemoveAll(temp)
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	fmt.Fprintf(ctx.Stdout, "Snapshot %s: %d copied, %d linked, %d directories", final, stats.copied, stats.linked, stats.dirs)
+	if stats.skipped > 0 {
+		fmt.Fprintf(ctx.Stdout, ", %d skipped", stats.skipped)
+	}
+	fmt.Fprintf(ctx.Stdout, " (%v)\n", time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
+// snapshotTree copies src into target, hard-linking files that are
+// unchanged in the previous snapshot. The backup directory dest, an
+// absolute path, is skipped when it lies inside src.
+func snapshotTree(ctx *Context, src, target, previous, dest string, excludes []string, verbose bool, stats *backupStats) error {
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "backup: %v\n", err)
+			stats.skipped++
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if rel != "." && (filepath.Join(absSrc, rel) == dest || isExcluded(path, excludes)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		destPath := filepath.Join(target, rel)
+
+		switch {
+		case info.IsDir():
+			stats.dirs++
+			return os.MkdirAll(destPath, info.Mode().Perm()|0700)
+
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			stats.copied++
+			return os.Symlink(link, destPath)
+
+		case info.Mode().IsRegular():
+			if previous != "" && unchangedSince(info, filepath.Join(previous, rel)) {
+				if err := os.Link(filepath.Join(previous, rel), destPath); err == nil {
+					stats.linked++
+					return nil
+				}
+			}
+
+			if verbose {
+				fmt.Fprintln(ctx.Stdout, rel)
+			}
+			if err := copyRegularFile(path, destPath, info, true); err != nil {
+				return err
+			}
+			stats.copied++
+			return nil
+		}
+
+		// Devices, sockets and pipes are not backed up
+		stats.skipped++
+		return nil
+	})
+}
+
+// unchangedSince reports whether the previous copy of a file has the same
+// size, modification time and permissions
+func unchangedSince(info os.FileInfo, previousPath string) bool {
+	prevInfo, err := os.Lstat(previousPath)
+	if err != nil || !prevInfo.Mode().IsRegular() {
+		return false
+	}
+	return prevInfo.Size() == info.Size() &&
+		prevInfo.ModTime().Equal(info.ModTime()) &&
+		prevInfo.Mode() == info.Mode()
+}
+
+// listSnapshots returns the snapshot names in dest, oldest first
+func listSnapshots(dest string) ([]string, error) {
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(snapshotLayout, entry.Name()); err == nil {
+			snapshots = append(snapshots, entry.Name())
+		}
+	}
+
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// backupList shows the snapshots in a backup directory
+func backupList(ctx *Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("backup: usage: backup list DEST")
+	}
+
+	snapshots, err := listSnapshots(args[0])
+	if err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Fprintln(ctx.Stdout, "No snapshots")
+		return nil
+	}
+
+	for _, name := range snapshots {
+		created, _ := time.ParseInLocation(snapshotLayout, name, time.Local)
+		files, size := snapshotUsage(filepath.Join(args[0], name))
+		fmt.Fprintf(ctx.Stdout, "%s  %s  %6d files  %8s\n", name, created.Format("Mon Jan 02 15:04:05 2006"), files, formatHumanReadable(size))
+	}
+
+	return nil
+}
+
+// snapshotUsage counts the files in a snapshot and their apparent size
+func snapshotUsage(path string) (int, int64) {
+	var files int
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size
+}
+
+// backupPrune removes all but the newest snapshots
+func backupPrune(ctx *Context, args []string) error {
+	keep := 7
+	var dest string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--keep" || arg == "-k":
+			if i+1 >= len(args) {
+				return fmt.Errorf("backup: %s requires a number", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return fmt.Errorf("backup: invalid keep count: %s", args[i])
+			}
+			keep = n
+		case strings.HasPrefix(arg, "--keep="):
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--keep="))
+			if err != nil || n < 1 {
+				return fmt.Errorf("backup: invalid keep count: %s", arg)
+			}
+			keep = n
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("backup: invalid option: %s", arg)
+		default:
+			dest = arg
+		}
+	}
+
+	if dest == "" {
+		return fmt.Errorf("backup: usage: backup prune [--keep N] DEST")
+	}
+
+	snapshots, err := listSnapshots(dest)
+	if err != nil {
+		return fmt.Errorf("backup: %v", err)
+	}
+
+	if len(snapshots) <= keep {
+		fmt.Fprintf(ctx.Stdout, "Nothing to prune (%d snapshots)\n", len(snapshots))
+		return nil
+	}
+
+	for _, name := range snapshots[:len(snapshots)-keep] {
+		if err := os.RemoveAll(filepath.Join(dest, name)); err != nil {
+			fmt.Fprintf(ctx.Stderr, "backup: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(ctx.Stdout, "Removed %s\n", name)
+	}
+
+	return nil
+}
@@ -2,22 +2,45 @@ package builtin
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"gex/internal/cli"
+	"gex/internal/core"
 	"gex/internal/shell"
 	"gex/internal/ui"
 )
 
+// ExitStatus is returned by builtins that fail without an error message,
+// such as a test that evaluates to false
+type ExitStatus int
+
+func (s ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(s))
+}
+
+// ShellExit asks the shell to exit with the given status, from exit N or
+// from set -e after a failed command
+type ShellExit int
+
+func (s ShellExit) Error() string {
+	return "exit"
+}
+
 // Cd changes the current working directory
-func Cd(args []string, session *shell.Session) error {
+func Cd(ctx *Context, args []string, session *shell.Session) error {
 	var target string
 
 	if len(args) == 0 {
 		// No arguments - go to home directory
-		home := os.Getenv("HOME")
+		home := ctx.Getenv("HOME")
 		if home == "" {
 			return fmt.Errorf("HOME environment variable not set")
 		}
@@ -34,18 +57,30 @@ func Cd(args []string, session *shell.Session) error {
 			return fmt.Errorf("no previous directory")
 		}
 		target = prev
-		fmt.Println(target) // Print the directory we're going to
+		fmt.Fprintln(ctx.Stdout, target) // Print the directory we're going to
 	}
 
 	// Expand ~ to home directory
 	if strings.HasPrefix(target, "~/") {
-		home := os.Getenv("HOME")
+		home := ctx.Getenv("HOME")
 		if home == "" {
 			return fmt.Errorf("HOME environment variable not set")
 		}
 		target = home + target[1:]
 	}
 
+	// A relative name is looked for in the directories of CDPATH; where
+	// one found away from the current directory leads is printed
+	announce := false
+	for _, dir := range session.CdPath(target) {
+		candidate := filepath.Join(dir, target)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			announce = dir != "."
+			target = candidate
+			break
+		}
+	}
+
 	// Change directory
 	if err := os.Chdir(target); err != nil {
 		return err
@@ -60,79 +95,84 @@ func Cd(args []string, session *shell.Session) error {
 
 	session.SetWorkingDir(newDir)
 	session.SetPreviousDir(oldDir)
+	if announce {
+		fmt.Fprintln(ctx.Stdout, newDir)
+	}
 
 	return nil
 }
 
 // Pwd prints the current working directory
-func Pwd(args []string) error {
+func Pwd(ctx *Context, args []string) error {
 	wd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	fmt.Println(wd)
+	fmt.Fprintln(ctx.Stdout, wd)
 	return nil
 }
 
 // Echo displays text
-func Echo(args []string) error {
+func Echo(ctx *Context, args []string) error {
 	output := strings.Join(args, " ")
-	fmt.Println(output)
+	fmt.Fprintln(ctx.Stdout, output)
 	return nil
 }
 
 // Exit exits the shell
-func Exit(args []string) error {
-	code := 0
+func Exit(ctx *Context, args []string, session *shell.Session) error {
+	// Without an argument the shell exits with the last command's status
+	code := session.LastStatus()
 	if len(args) > 0 {
 		if c, err := strconv.Atoi(args[0]); err == nil {
 			code = c
 		}
 	}
 
+	// Let the shell run its EXIT trap and exit with the status
 	if code != 0 {
-		os.Exit(code)
+		return ShellExit(code)
 	}
 
 	return fmt.Errorf("exit")
 }
 
 // Help displays help information
-func Help(args []string) error {
+func Help(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		// General help with colors
-		ui.PrintHeader("Gex Shell - High-Performance Linux Shell")
-		fmt.Println()
+		ui.FprintHeader(ctx.Stdout, "Gex Shell - High-Performance Linux Shell")
+		fmt.Fprintln(ctx.Stdout)
 
-		ui.PrintInfo("Built-in commands:")
-		fmt.Println()
+		ui.FprintInfo(ctx.Stdout, "Built-in commands:")
+		fmt.Fprintln(ctx.Stdout)
 
 		builtins := cli.GetAllBuiltins()
 
 		// Group commands by category for better display
 		categories := map[string][]string{
-			"🏠 Shell":       {"cd", "pwd", "echo", "exit", "help", "history", "alias", "unalias", "env", "export", "which", "type"},
-			"📁 Files":       {"ls", "mkdir", "rmdir", "rm", "cp", "mv", "touch"},
-			"📝 Text":        {"cat", "head", "tail", "wc", "grep", "sort"},
-			"🖥️  System":    {"ps", "kill", "df", "du", "free", "uptime", "uname"},
+			"🏠 Shell":       {"cd", "pwd", "echo", "exit", "help", "history", "fc", "last-output", "alias", "unalias", "env", "printenv", "export", "declare", "local", "readonly", "set", "unset", "trap", "let", "shift", "getopts", "which", "type", "hash", "rehash", "exec", "command", "builtin", "enable", "gex-cache"},
+			"📁 Files":       {"ls", "mkdir", "rmdir", "rm", "cp", "mv", "touch", "open"},
+			"📝 Text":        {"cat", "head", "tail", "wc", "grep", "sort", "more", "edit", "imgcat", "qr"},
+			"🖥️  System":    {"ps", "kill", "df", "du", "free", "uptime", "uname", "jobs", "wait", "disown", "nohup", "withlock", "timeout", "worldclock"},
 			"🔍 Search":      {"find", "locate"},
 			"🔐 Permissions": {"chmod", "chown", "chgrp"},
-			"🌐 Network":     {"ping", "wget", "curl", "netstat"},
-			"📦 Archives":    {"tar", "gzip", "gunzip", "zip", "unzip"},
+			"🌐 Network":     {"ping", "wget", "curl", "http", "netstat", "http-cache", "portcheck", "port-scan", "netspeed", "resolve", "whois", "geoip", "arp", "route", "ip", "weather"},
+			"📦 Archives":    {"tar", "gzip", "gunzip", "zip", "unzip", "backup"},
 		}
 
 		for category, commands := range categories {
-			fmt.Printf("%s%s%s\n", ui.BrightCyan, category, ui.Reset)
+			fmt.Fprintf(ctx.Stdout, "%s%s%s\n", ui.BrightCyan, category, ui.Reset)
 			for _, name := range commands {
 				if info, exists := builtins[name]; exists {
 					coloredName := ui.Colorize(name, ui.BrightYellow)
-					fmt.Printf("  %-20s %s\n", coloredName, info.Description)
+					fmt.Fprintf(ctx.Stdout, "  %-20s %s\n", coloredName, info.Description)
 				}
 			}
-			fmt.Println()
+			fmt.Fprintln(ctx.Stdout)
 		}
 
-		ui.PrintInfo("Use 'help <command>' for specific command help")
+		ui.FprintInfo(ctx.Stdout, "Use 'help <command>' for specific command help")
 		return nil
 	}
 
@@ -140,16 +180,53 @@ func Help(args []string) error {
 	cmdName := args[0]
 	info := cli.GetCommandInfo(cmdName)
 
-	fmt.Printf("%sCommand:%s %s\n", ui.BrightCyan, ui.Reset, ui.Colorize(info.Name, ui.BrightYellow))
-	fmt.Printf("%sDescription:%s %s\n", ui.BrightCyan, ui.Reset, info.Description)
-	fmt.Printf("%sUsage:%s %s\n", ui.BrightCyan, ui.Reset, ui.Colorize(info.Usage, ui.BrightGreen))
+	fmt.Fprintf(ctx.Stdout, "%sCommand:%s %s\n", ui.BrightCyan, ui.Reset, ui.Colorize(info.Name, ui.BrightYellow))
+	fmt.Fprintf(ctx.Stdout, "%sDescription:%s %s\n", ui.BrightCyan, ui.Reset, info.Description)
+	fmt.Fprintf(ctx.Stdout, "%sUsage:%s %s\n", ui.BrightCyan, ui.Reset, ui.Colorize(info.Usage, ui.BrightGreen))
 
 	return nil
 }
 
-// History displays command history
-func History(args []string, session *shell.Session) error {
-	history := session.GetHistory()
+// defaultHistoryTimeFormat shows history times with -t when
+// HISTTIMEFORMAT is not set
+const defaultHistoryTimeFormat = "%F %T  "
+
+// History displays command history. Each command is shown with the time it
+// was entered when HISTTIMEFORMAT is set, in its strftime format, or with
+// -t. -v adds how long it ran, its exit status and the directory it ran
+// in, where they were recorded.
+func History(ctx *Context, args []string, session *shell.Session) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "export":
+			return historyExport(ctx, args[1:], session)
+		case "import":
+			return historyImport(ctx, args[1:], session)
+		}
+	}
+	history := session.GetHistoryEntries()
+
+	timeFormat, showTimes := session.LookupVariable("HISTTIMEFORMAT")
+	timesFlag, verbose := false, false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 't':
+				timesFlag = true
+			case 'v':
+				timesFlag, verbose = true, true
+			default:
+				return fmt.Errorf("history: -%c: invalid option", flag)
+			}
+		}
+		args = args[1:]
+	}
+	if timesFlag {
+		if !showTimes || timeFormat == "" {
+			timeFormat = defaultHistoryTimeFormat
+		}
+		showTimes = true
+	}
 
 	limit := len(history)
 	if len(args) > 0 {
@@ -164,19 +241,279 @@ func History(args []string, session *shell.Session) error {
 	}
 
 	for i := start; i < len(history); i++ {
-		fmt.Printf("%4d  %s\n", i+1, history[i])
+		prefix := fmt.Sprintf("%4d  ", i+1)
+		if showTimes {
+			prefix += Strftime(timeFormat, history[i].Time)
+		}
+		if verbose {
+			prefix += historyDetails(history[i])
+		}
+		fmt.Fprintln(ctx.Stdout, FormatHistoryEntry(prefix, history[i].Line))
 	}
 
 	return nil
 }
 
+// historyExport writes the history to a file, or to stdout without one,
+// as JSON with --json and otherwise the way bash saves it with timestamps
+func historyExport(ctx *Context, args []string, session *shell.Session) error {
+	asJSON := false
+	if len(args) > 0 && args[0] == "--json" {
+		asJSON = true
+		args = args[1:]
+	}
+	if len(args) > 1 || (len(args) == 1 && strings.HasPrefix(args[0], "-")) {
+		return fmt.Errorf("history: usage: history export [--json] [file]")
+	}
+
+	output := ctx.Stdout
+	var file *os.File
+	if len(args) == 1 {
+		var err error
+		file, err = os.OpenFile(args[0], os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("history: %v", err)
+		}
+		output = file
+	}
+
+	var err error
+	if asJSON {
+		err = session.ExportHistory(output)
+	} else {
+		err = session.ExportHistoryText(output)
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("history: %v", err)
+	}
+	return nil
+}
+
+// historyImport adds the commands of a history file, in gex's JSON or
+// bash's or zsh's format, leaving out those already in history. A file
+// of - is read from stdin.
+func historyImport(ctx *Context, args []string, session *shell.Session) error {
+	if len(args) != 1 {
+		return fmt.Errorf("history: usage: history import file")
+	}
+
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(ctx.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("history: %v", err)
+	}
+
+	entries, err := shell.ParseHistory(data)
+	if err != nil {
+		return fmt.Errorf("history: %s: %v", args[0], err)
+	}
+	added, err := session.ImportHistory(entries)
+	fmt.Fprintf(ctx.Stdout, "Imported %d of %d commands\n", added, len(entries))
+	if err != nil {
+		return fmt.Errorf("history: %v", err)
+	}
+	return nil
+}
+
+// LastOutput prints the output of the last command, or with -a all the
+// output kept, captured with capture_output on. With a pattern only the
+// lines it matches are printed, as ?pattern at the prompt does.
+func LastOutput(ctx *Context, args []string, session *shell.Session) error {
+	all := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		if arg != "-a" {
+			return fmt.Errorf("last-output: %s: invalid option", arg)
+		}
+		all = true
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("last-output: usage: last-output [-a] [pattern]")
+	}
+
+	ring := session.OutputRing()
+	if ring == nil {
+		return fmt.Errorf("last-output: output is not captured; set \"capture_output\": true in ~/.gexrc")
+	}
+	output := ring.Last()
+	if all {
+		output = ring.All()
+	}
+
+	if len(args) == 0 {
+		fmt.Fprint(ctx.Stdout, output)
+		return nil
+	}
+	pattern := args[0]
+	if !session.Config().CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("last-output: %v", err)
+	}
+	found := false
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if re.MatchString(line) {
+			fmt.Fprintln(ctx.Stdout, line)
+			found = true
+		}
+	}
+	if !found {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// historyDetails formats how long a history entry ran, its exit status
+// and its directory in columns, with - for what was not recorded
+func historyDetails(entry shell.HistoryEntry) string {
+	duration, status := "-", "-"
+	if entry.Finished {
+		duration = formatElapsed(entry.Duration)
+		status = strconv.Itoa(entry.Status)
+	}
+	dir := "-"
+	if entry.Dir != "" {
+		dir = entry.Dir
+		if home := os.Getenv("HOME"); home != "" && (dir == home || strings.HasPrefix(dir, home+"/")) {
+			dir = "~" + dir[len(home):]
+		}
+	}
+	return fmt.Sprintf("%8s  %3s  %s  ", duration, status, dir)
+}
+
+// formatElapsed formats a duration briefly: seconds to two places under
+// a minute, then minutes and seconds, then hours and minutes
+func formatElapsed(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+// FormatHistoryEntry puts a prefix such as its number before a history
+// entry. The later lines of a command of several are lined up under the
+// first.
+func FormatHistoryEntry(prefix, line string) string {
+	indent := "\n" + strings.Repeat(" ", utf8.RuneCountInString(prefix))
+	return prefix + strings.ReplaceAll(line, "\n", indent)
+}
+
+// Strftime formats a time with the conversions of strftime(3): %Y-%m-%d
+// and the like. Unknown conversions are kept as they are.
+func Strftime(format string, t time.Time) string {
+	var result strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			result.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			result.WriteString(strconv.Itoa(t.Year()))
+		case 'y':
+			fmt.Fprintf(&result, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&result, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&result, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&result, "%2d", t.Day())
+		case 'H':
+			fmt.Fprintf(&result, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&result, "%02d", (t.Hour()+11)%12+1)
+		case 'M':
+			fmt.Fprintf(&result, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&result, "%02d", t.Second())
+		case 'p':
+			result.WriteString(t.Format("PM"))
+		case 'j':
+			fmt.Fprintf(&result, "%03d", t.YearDay())
+		case 'a':
+			result.WriteString(t.Format("Mon"))
+		case 'A':
+			result.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			result.WriteString(t.Format("Jan"))
+		case 'B':
+			result.WriteString(t.Format("January"))
+		case 'c':
+			result.WriteString(t.Format("Mon Jan  2 15:04:05 2006"))
+		case 'D':
+			result.WriteString(t.Format("01/02/06"))
+		case 'F':
+			result.WriteString(t.Format("2006-01-02"))
+		case 'R':
+			result.WriteString(t.Format("15:04"))
+		case 'T':
+			result.WriteString(t.Format("15:04:05"))
+		case 's':
+			result.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'z':
+			result.WriteString(t.Format("-0700"))
+		case 'Z':
+			result.WriteString(t.Format("MST"))
+		case 'n':
+			result.WriteByte('\n')
+		case 't':
+			result.WriteByte('\t')
+		case '%':
+			result.WriteByte('%')
+		default:
+			result.WriteByte('%')
+			result.WriteByte(format[i])
+		}
+	}
+	return result.String()
+}
+
 // Alias manages command aliases
-func Alias(args []string, session *shell.Session) error {
+func Alias(ctx *Context, args []string, session *shell.Session) error {
+	// -g defines global aliases, expanded anywhere on the line, and -s
+	// suffix aliases, which open files with the extension they name
+	global := len(args) > 0 && args[0] == "-g"
+	suffix := len(args) > 0 && args[0] == "-s"
+	if global || suffix {
+		args = args[1:]
+	}
+
 	if len(args) == 0 {
-		// Display all aliases
-		aliases := session.GetAliases()
-		for name, value := range aliases {
-			fmt.Printf("%s='%s'\n", name, value)
+		// Display all aliases, or only the global or suffix ones
+		if suffix {
+			for name, value := range session.GetSuffixAliases() {
+				fmt.Fprintf(ctx.Stdout, "%s='%s'\n", name, value)
+			}
+			return nil
+		}
+		if !global {
+			for name, value := range session.GetAliases() {
+				fmt.Fprintf(ctx.Stdout, "%s='%s'\n", name, value)
+			}
+		}
+		for name, value := range session.GetGlobalAliases() {
+			fmt.Fprintf(ctx.Stdout, "%s='%s'\n", name, value)
 		}
 		return nil
 	}
@@ -194,13 +531,33 @@ func Alias(args []string, session *shell.Session) error {
 				value = value[1 : len(value)-1]
 			}
 
-			session.SetAlias(name, value)
+			if suffix {
+				// A suffix is given without its dot; .md works too
+				name = strings.TrimPrefix(name, ".")
+				if name == "" || strings.ContainsAny(name, "./") {
+					return fmt.Errorf("alias: %s: invalid suffix", parts[0])
+				}
+				session.SetSuffixAlias(name, value)
+			} else if global {
+				session.SetGlobalAlias(name, value)
+			} else {
+				session.SetAlias(name, value)
+			}
+		} else if suffix {
+			// Display specific suffix alias
+			if value, exists := session.GetSuffixAliases()[strings.TrimPrefix(arg, ".")]; exists {
+				fmt.Fprintf(ctx.Stdout, "%s='%s'\n", arg, value)
+			} else {
+				fmt.Fprintf(ctx.Stderr, "alias: %s: not found\n", arg)
+			}
 		} else {
 			// Display specific alias
 			if value, exists := session.GetAliases()[arg]; exists {
-				fmt.Printf("%s='%s'\n", arg, value)
+				fmt.Fprintf(ctx.Stdout, "%s='%s'\n", arg, value)
+			} else if value, exists := session.GetGlobalAliases()[arg]; exists {
+				fmt.Fprintf(ctx.Stdout, "%s='%s'\n", arg, value)
 			} else {
-				fmt.Printf("alias: %s: not found\n", arg)
+				fmt.Fprintf(ctx.Stderr, "alias: %s: not found\n", arg)
 			}
 		}
 	}
@@ -208,25 +565,33 @@ func Alias(args []string, session *shell.Session) error {
 	return nil
 }
 
-// Unalias removes aliases
-func Unalias(args []string, session *shell.Session) error {
+// Unalias removes aliases, or suffix aliases with -s
+func Unalias(ctx *Context, args []string, session *shell.Session) error {
+	suffix := len(args) > 0 && args[0] == "-s"
+	if suffix {
+		args = args[1:]
+	}
 	if len(args) == 0 {
-		return fmt.Errorf("unalias: usage: unalias name [name ...]")
+		return fmt.Errorf("unalias: usage: unalias [-s] name [name ...]")
 	}
 
 	for _, name := range args {
-		session.RemoveAlias(name)
+		if suffix {
+			session.RemoveSuffixAlias(strings.TrimPrefix(name, "."))
+		} else {
+			session.RemoveAlias(name)
+		}
 	}
 
 	return nil
 }
 
 // Env displays or sets environment variables
-func Env(args []string) error {
+func Env(ctx *Context, args []string, session *shell.Session) error {
 	if len(args) == 0 {
 		// Display all environment variables
-		for _, env := range os.Environ() {
-			fmt.Println(env)
+		for _, env := range ctx.Environ() {
+			fmt.Fprintln(ctx.Stdout, env)
 		}
 		return nil
 	}
@@ -235,11 +600,14 @@ func Env(args []string) error {
 		if strings.Contains(arg, "=") {
 			// Set environment variable
 			parts := strings.SplitN(arg, "=", 2)
+			if session.IsReadonly(parts[0]) {
+				return fmt.Errorf("env: %s: readonly variable", parts[0])
+			}
 			os.Setenv(parts[0], parts[1])
 		} else {
 			// Display specific variable
-			if value := os.Getenv(arg); value != "" {
-				fmt.Printf("%s=%s\n", arg, value)
+			if value := ctx.Getenv(arg); value != "" {
+				fmt.Fprintf(ctx.Stdout, "%s=%s\n", arg, value)
 			}
 		}
 	}
@@ -247,23 +615,62 @@ func Env(args []string) error {
 	return nil
 }
 
+// Printenv prints the whole environment, or the values of the named
+// variables; the status is 1 when one of them is not set
+func Printenv(ctx *Context, args []string) error {
+	if len(args) == 0 {
+		for _, env := range ctx.Environ() {
+			fmt.Fprintln(ctx.Stdout, env)
+		}
+		return nil
+	}
+
+	failed := false
+	for _, name := range args {
+		if value, exists := ctx.LookupEnv(name); exists {
+			fmt.Fprintln(ctx.Stdout, value)
+		} else {
+			failed = true
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
 // Export exports environment variables
-func Export(args []string) error {
+func Export(ctx *Context, args []string, session *shell.Session) error {
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
 	if len(args) == 0 {
-		// Display all exported variables (same as env for now)
-		return Env(args)
+		// Display all exported variables in a form that can be sourced
+		environ := os.Environ()
+		sort.Strings(environ)
+		for _, env := range environ {
+			name, value, _ := strings.Cut(env, "=")
+			if cli.IsValidVariableName(name) {
+				fmt.Fprintf(ctx.Stdout, "export %s=%s\n", name, doubleQuote(value))
+			}
+		}
+		return nil
 	}
 
 	for _, arg := range args {
 		if strings.Contains(arg, "=") {
 			// Set and export
 			parts := strings.SplitN(arg, "=", 2)
-			os.Setenv(parts[0], parts[1])
-		} else {
-			// Export existing variable
-			if value := os.Getenv(arg); value != "" {
-				os.Setenv(arg, value)
+			if session.IsReadonly(parts[0]) {
+				return fmt.Errorf("export: %s: readonly variable", parts[0])
 			}
+			os.Setenv(parts[0], parts[1])
+			session.RemoveVariable(parts[0])
+		} else if value, exists := session.GetVariable(arg); exists {
+			// Move a shell variable into the environment
+			os.Setenv(arg, value)
+			session.RemoveVariable(arg)
 		}
 	}
 
@@ -271,96 +678,306 @@ func Export(args []string) error {
 }
 
 // Which locates a command
-func Which(args []string) error {
+func Which(ctx *Context, args []string, session *shell.Session) error {
 	if len(args) == 0 {
 		return fmt.Errorf("which: usage: which command [command ...]")
 	}
 
-	path := os.Getenv("PATH")
-	if path == "" {
-		path = "/usr/local/bin:/usr/bin:/bin"
-	}
-
+	failed := false
 	for _, cmd := range args {
-		found := false
-
-		// Check if it's a built-in
-		if cli.IsBuiltin(cmd) {
-			fmt.Printf("%s: shell built-in command\n", cmd)
-			found = true
+		// Functions and builtins are run before anything in PATH
+		if _, exists := session.GetFunction(cmd); exists {
+			fmt.Fprintf(ctx.Stdout, "%s: shell function\n", cmd)
+			continue
+		}
+		if session.IsBuiltin(cmd) {
+			fmt.Fprintf(ctx.Stdout, "%s: shell built-in command\n", cmd)
 			continue
 		}
 
-		// Search in PATH
-		for _, dir := range strings.Split(path, ":") {
-			if dir == "" {
-				continue
-			}
+		if path, ok := hashedCommand(cmd); ok {
+			fmt.Fprintln(ctx.Stdout, path)
+		} else if path, ok := searchCommand(cmd); ok {
+			fmt.Fprintln(ctx.Stdout, path)
+		} else {
+			fmt.Fprintf(ctx.Stdout, "%s not found\n", cmd)
+			failed = true
+		}
+	}
 
-			fullPath := dir + "/" + cmd
-			if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
-				// Check if executable
-				if info.Mode()&0111 != 0 {
-					fmt.Println(fullPath)
-					found = true
-					break
-				}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// Type describes how each name would be run, in the order the shell looks:
+// alias, function, builtin, then PATH. -t prints just the kind (alias,
+// function, builtin or file) for scripts, and nothing for unknown names.
+func Type(ctx *Context, args []string, session *shell.Session) error {
+	kindOnly := len(args) > 0 && args[0] == "-t"
+	if kindOnly {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("type: usage: type [-t] command [command ...]")
+	}
+
+	describe := func(kind, format string, a ...interface{}) {
+		if kindOnly {
+			fmt.Fprintln(ctx.Stdout, kind)
+		} else {
+			fmt.Fprintf(ctx.Stdout, format+"\n", a...)
+		}
+	}
+
+	failed := false
+	for _, cmd := range args {
+		if alias, exists := session.GetAliases()[cmd]; exists {
+			describe("alias", "%s is aliased to `%s'", cmd, alias)
+		} else if alias, exists := session.GetGlobalAliases()[cmd]; exists {
+			describe("alias", "%s is globally aliased to `%s'", cmd, alias)
+		} else if _, exists := session.GetFunction(cmd); exists {
+			describe("function", "%s is a function", cmd)
+		} else if session.IsBuiltin(cmd) {
+			describe("builtin", "%s is a shell builtin", cmd)
+		} else if path, ok := hashedCommand(cmd); ok {
+			describe("file", "%s is hashed (%s)", cmd, path)
+		} else if path, ok := searchCommand(cmd); ok {
+			describe("file", "%s is %s", cmd, path)
+		} else {
+			if !kindOnly {
+				fmt.Fprintf(ctx.Stdout, "%s: not found\n", cmd)
 			}
+			failed = true
 		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
 
-		if !found {
-			fmt.Printf("%s not found\n", cmd)
+// CommandPath prints what each name runs, for command -v: an alias as
+// the alias command that defines it, a function or builtin as its name
+// and a program as its path. Unknown names print nothing and make the
+// status 1.
+func CommandPath(ctx *Context, args []string, session *shell.Session) error {
+	failed := false
+	for _, cmd := range args {
+		if alias, exists := session.GetAliases()[cmd]; exists {
+			fmt.Fprintf(ctx.Stdout, "alias %s=%s\n", cmd, shellQuote(alias))
+		} else if _, exists := session.GetFunction(cmd); exists || session.IsBuiltin(cmd) {
+			fmt.Fprintln(ctx.Stdout, cmd)
+		} else if path, ok := hashedCommand(cmd); ok {
+			fmt.Fprintln(ctx.Stdout, path)
+		} else if path, ok := searchCommand(cmd); ok {
+			fmt.Fprintln(ctx.Stdout, path)
+		} else {
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
-// Type displays information about command type
-func Type(args []string, session *shell.Session) error {
+// Enable turns builtins back on, or with -n off so that their names run
+// the programs in PATH (like enable command). Without names it lists the
+// enabled builtins, the disabled ones with -n and all of them with -a.
+func Enable(ctx *Context, args []string, session *shell.Session) error {
+	var disable, all bool
+	for len(args) > 0 && len(args[0]) > 1 && strings.HasPrefix(args[0], "-") {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'n':
+				disable = true
+			case 'a':
+				all = true
+			default:
+				return fmt.Errorf("enable: invalid option: -%c", flag)
+			}
+		}
+		args = args[1:]
+	}
+
 	if len(args) == 0 {
-		return fmt.Errorf("type: usage: type command [command ...]")
+		names := make([]string, 0, len(cli.GetAllBuiltins()))
+		for name := range cli.GetAllBuiltins() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			enabled := session.IsBuiltin(name)
+			switch {
+			case enabled && (all || !disable):
+				fmt.Fprintf(ctx.Stdout, "enable %s\n", name)
+			case !enabled && (all || disable):
+				fmt.Fprintf(ctx.Stdout, "enable -n %s\n", name)
+			}
+		}
+		return nil
 	}
 
-	for _, cmd := range args {
-		// Check aliases first
-		if alias, exists := session.GetAliases()[cmd]; exists {
-			fmt.Printf("%s is aliased to `%s'\n", cmd, alias)
+	failed := false
+	for _, name := range args {
+		if !cli.IsBuiltin(name) {
+			fmt.Fprintf(ctx.Stderr, "enable: %s: not a shell builtin\n", name)
+			failed = true
 			continue
 		}
+		session.SetBuiltinEnabled(name, !disable)
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
 
-		// Check built-ins
-		if cli.IsBuiltin(cmd) {
-			fmt.Printf("%s is a shell builtin\n", cmd)
+// searchPath returns the directories searched for commands
+func searchPath() string {
+	path := os.Getenv("PATH")
+	if path == "" {
+		path = "/usr/local/bin:/usr/bin:/bin"
+	}
+	return path
+}
+
+// hashedCommand returns where the shell remembers finding a command, if it
+// found it in the current PATH and the file is still there
+func hashedCommand(name string) (string, bool) {
+	hashed, ok := core.PathCache.Get(name)
+	if !ok {
+		return "", false
+	}
+	if hashed.SearchPath != searchPath() || !isExecutableFile(hashed.Path) {
+		return "", false
+	}
+	return hashed.Path, true
+}
+
+// searchCommand looks for an executable in PATH without remembering it. A
+// name with a slash is a path to the file itself.
+func searchCommand(name string) (string, bool) {
+	if strings.Contains(name, "/") {
+		return name, isExecutableFile(name)
+	}
+	for _, dir := range strings.Split(searchPath(), ":") {
+		if dir == "" {
 			continue
 		}
-
-		// Check PATH
-		path := os.Getenv("PATH")
-		if path == "" {
-			path = "/usr/local/bin:/usr/bin:/bin"
+		if fullPath := dir + "/" + name; isExecutableFile(fullPath) {
+			return fullPath, true
 		}
+	}
+	return "", false
+}
 
-		found := false
-		for _, dir := range strings.Split(path, ":") {
-			if dir == "" {
-				continue
-			}
+func isExecutableFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
 
-			fullPath := dir + "/" + cmd
-			if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
-				if info.Mode()&0111 != 0 {
-					fmt.Printf("%s is %s\n", cmd, fullPath)
-					found = true
-					break
+// Hash lists the commands whose location the shell remembers, looks up
+// and remembers the given ones, or with -r forgets them all, along with
+// the command names found for completion. lookup searches PATH the way
+// running a command does.
+func Hash(ctx *Context, args []string, lookup func(string) (string, error)) error {
+	if len(args) == 0 {
+		path := searchPath()
+
+		// Entries found in an earlier PATH are no longer used
+		var lines []string
+		for _, name := range core.PathCache.Keys() {
+			if hashed, ok := core.PathCache.Get(name); ok {
+				if hashed.SearchPath == path {
+					lines = append(lines, name+"="+hashed.Path)
 				}
 			}
 		}
+		if len(lines) == 0 {
+			fmt.Fprintln(ctx.Stdout, "hash: hash table empty")
+			return nil
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			fmt.Fprintln(ctx.Stdout, line)
+		}
+		return nil
+	}
+
+	forget := false
+	var names []string
+	for _, arg := range args {
+		switch {
+		case arg == "-r":
+			core.PathCache.Clear()
+			core.CompletionCache.Clear()
+		case arg == "-d":
+			forget = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("hash: invalid option: %s", arg)
+		default:
+			names = append(names, arg)
+		}
+	}
 
-		if !found {
-			fmt.Printf("%s: not found\n", cmd)
+	failed := false
+	for _, name := range names {
+		core.PathCache.Delete(name)
+		if forget || strings.Contains(name, "/") {
+			continue
+		}
+		if _, err := lookup(name); err != nil {
+			fmt.Fprintf(ctx.Stderr, "hash: %s: not found\n", name)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// GexCache reports on the shell's internal caches: with stats, how full
+// each is and how often lookups hit, missed or pushed an entry out
+func GexCache(ctx *Context, args []string) error {
+	subcommand := "stats"
+	if len(args) > 0 {
+		subcommand = args[0]
+	}
+	if subcommand != "stats" || len(args) > 1 {
+		return fmt.Errorf("gex-cache: usage: %s", cli.GetCommandInfo("gex-cache").Usage)
+	}
+
+	caches := []struct {
+		name  string
+		stats core.CacheStats
+	}{
+		{"command", core.CommandCache.Stats()},
+		{"completion", core.CompletionCache.Stats()},
+		{"path", core.PathCache.Stats()},
+	}
+
+	fmt.Fprintf(ctx.Stdout, "%-12s %9s %9s %9s %9s %9s %9s %7s\n",
+		"CACHE", "ENTRIES", "MAX", "HITS", "MISSES", "EVICTED", "EXPIRED", "HIT%")
+	for _, c := range caches {
+		stats := c.stats
+		limit := "-"
+		if stats.MaxEntries > 0 {
+			limit = strconv.Itoa(stats.MaxEntries)
+		}
+		ratio := "-"
+		if lookups := stats.Hits + stats.Misses; lookups > 0 {
+			ratio = fmt.Sprintf("%.1f", 100*float64(stats.Hits)/float64(lookups))
+		}
+		fmt.Fprintf(ctx.Stdout, "%-12s %9d %9s %9d %9d %9d %9d %7s\n",
+			c.name, stats.Entries, limit, stats.Hits, stats.Misses, stats.Evictions, stats.Expired, ratio)
+	}
 	return nil
 }
@@ -0,0 +1,354 @@
+package builtin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gex/internal/config"
+)
+
+// httpCacheEntry is the metadata stored next to a cached response body
+type httpCacheEntry struct {
+	URL          string      `json:"url"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Proto        string      `json:"proto"`
+	Status       string      `json:"status"`
+	Header       http.Header `json:"header"`
+	// Vary holds the request headers the response varies on, which a
+	// request must repeat to be served from the entry
+	Vary   map[string]string `json:"vary,omitempty"`
+	Size   int64             `json:"size"`
+	Stored time.Time         `json:"stored"`
+}
+
+// httpCacheDir returns the directory holding cached responses
+func httpCacheDir() string {
+	return filepath.Join(config.GetDataDir(), "http-cache")
+}
+
+// httpCacheKey maps a URL to its cache file name
+func httpCacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func httpCacheMetaPath(url string) string {
+	return filepath.Join(httpCacheDir(), httpCacheKey(url)+".json")
+}
+
+func httpCacheBodyPath(url string) string {
+	return filepath.Join(httpCacheDir(), httpCacheKey(url)+".body")
+}
+
+// loadHTTPCache returns the cached entry for a URL if its body is intact
+func loadHTTPCache(url string) (*httpCacheEntry, bool) {
+	return loadHTTPCacheFor(url, nil)
+}
+
+// loadHTTPCacheFor returns the cached entry for a URL if its body is intact
+// and, when header is given, it has the values the entry varies on
+func loadHTTPCacheFor(url string, header http.Header) (*httpCacheEntry, bool) {
+	data, err := os.ReadFile(httpCacheMetaPath(url))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry httpCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		return nil, false
+	}
+	if header != nil {
+		for name, value := range entry.Vary {
+			if header.Get(name) != value {
+				return nil, false
+			}
+		}
+	}
+
+	info, err := os.Stat(httpCacheBodyPath(url))
+	if err != nil || info.Size() != entry.Size {
+		return nil, false
+	}
+
+	return &entry, true
+}
+
+// doCachedRequest performs a GET request through the on-disk cache. A cached
+// copy is revalidated with If-None-Match/If-Modified-Since; on 304 the
+// returned response serves the cached body. Fresh 200 responses carrying
+// an ETag or Last-Modified header are stored as they are read, unless
+// cacheable says otherwise. Requests with credentials bypass the cache.
+func doCachedRequest(client *http.Client, req *http.Request) (*http.Response, bool, error) {
+	url := req.URL.String()
+	if req.Header.Get("Authorization") != "" {
+		resp, err := client.Do(req)
+		return resp, false, err
+	}
+
+	entry, cached := loadHTTPCacheFor(url, req.Header)
+	if cached {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if cached && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+
+		body, err := os.Open(httpCacheBodyPath(url))
+		if err != nil {
+			return nil, false, err
+		}
+
+		status := entry.Status
+		code := http.StatusOK
+		fmt.Sscanf(status, "%d", &code)
+
+		return &http.Response{
+			Status:        status,
+			StatusCode:    code,
+			Proto:         entry.Proto,
+			Header:        entry.Header,
+			Body:          body,
+			ContentLength: entry.Size,
+			Request:       req,
+		}, true, nil
+	}
+
+	vary, ok := cacheable(resp)
+	if !ok {
+		return resp, false, nil
+	}
+
+	// Cached responses are for this user only
+	if err := os.MkdirAll(httpCacheDir(), 0700); err != nil {
+		return resp, false, nil
+	}
+	os.Chmod(httpCacheDir(), 0700)
+	temp, err := os.CreateTemp(httpCacheDir(), "fetch-*.tmp")
+	if err != nil {
+		return resp, false, nil
+	}
+
+	resp.Body = &cachingBody{
+		body: resp.Body,
+		temp: temp,
+		entry: &httpCacheEntry{
+			URL:          url,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Proto:        resp.Proto,
+			Status:       resp.Status,
+			Header:       resp.Header,
+			Vary:         vary,
+			Stored:       time.Now(),
+		},
+		expected: resp.ContentLength,
+	}
+	return resp, false, nil
+}
+
+// cacheable reports whether a response may be stored: a 200 that can be
+// revalidated, was not sent with credentials, such as those netrc adds,
+// and is neither private nor setting cookies. It also returns the request
+// headers the response varies on.
+func cacheable(resp *http.Response) (map[string]string, bool) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+	if resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "" {
+		return nil, false
+	}
+	if resp.Header.Get("Set-Cookie") != "" {
+		return nil, false
+	}
+	if resp.Request == nil || resp.Request.Header.Get("Authorization") != "" {
+		return nil, false
+	}
+	for _, value := range resp.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if strings.EqualFold(name, "no-store") || strings.EqualFold(name, "private") {
+				return nil, false
+			}
+		}
+	}
+
+	var vary map[string]string
+	for _, value := range resp.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			switch name {
+			case "":
+				continue
+			case "*":
+				return nil, false
+			}
+			if vary == nil {
+				vary = make(map[string]string)
+			}
+			vary[name] = resp.Request.Header.Get(name)
+		}
+	}
+	return vary, true
+}
+
+// cachingBody copies a response body into the cache while it is read and
+// keeps it only if the whole body arrived
+type cachingBody struct {
+	body     io.ReadCloser
+	temp     *os.File
+	entry    *httpCacheEntry
+	expected int64
+	written  int64
+	complete bool
+	failed   bool
+}
+
+func (c *cachingBody) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	if n > 0 && !c.failed {
+		if _, werr := c.temp.Write(p[:n]); werr != nil {
+			c.failed = true
+		}
+		c.written += int64(n)
+	}
+	if err == io.EOF {
+		c.complete = true
+	}
+	return n, err
+}
+
+func (c *cachingBody) Close() error {
+	err := c.body.Close()
+	c.temp.Close()
+
+	if !c.complete || c.failed || (c.expected >= 0 && c.written != c.expected) {
+		os.Remove(c.temp.Name())
+		return err
+	}
+
+	c.entry.Size = c.written
+	meta, merr := json.MarshalIndent(c.entry, "", "  ")
+	if merr != nil || os.Rename(c.temp.Name(), httpCacheBodyPath(c.entry.URL)) != nil {
+		os.Remove(c.temp.Name())
+		return err
+	}
+	os.WriteFile(httpCacheMetaPath(c.entry.URL), meta, 0600)
+
+	return err
+}
+
+// HTTPCache manages the on-disk HTTP cache used by wget and curl
+func HTTPCache(ctx *Context, args []string) error {
+	subcommand := "list"
+	if len(args) > 0 {
+		subcommand = args[0]
+	}
+
+	switch subcommand {
+	case "list", "ls":
+		return listHTTPCache(ctx)
+
+	case "info":
+		entries := readHTTPCache()
+		var total int64
+		for _, entry := range entries {
+			total += entry.Size
+		}
+		fmt.Fprintf(ctx.Stdout, "Directory: %s\n", httpCacheDir())
+		fmt.Fprintf(ctx.Stdout, "Entries:   %d\n", len(entries))
+		fmt.Fprintf(ctx.Stdout, "Size:      %s\n", formatHumanReadable(total))
+		return nil
+
+	case "rm", "remove":
+		if len(args) < 2 {
+			return fmt.Errorf("http-cache: rm requires a URL")
+		}
+		for _, url := range args[1:] {
+			if !strings.Contains(url, "://") {
+				url = "http://" + url
+			}
+			if _, cached := loadHTTPCache(url); !cached {
+				fmt.Fprintf(ctx.Stderr, "http-cache: %s: not cached\n", url)
+				continue
+			}
+			os.Remove(httpCacheMetaPath(url))
+			os.Remove(httpCacheBodyPath(url))
+		}
+		return nil
+
+	case "clear":
+		entries := readHTTPCache()
+		if err := os.RemoveAll(httpCacheDir()); err != nil {
+			return fmt.Errorf("http-cache: %v", err)
+		}
+		fmt.Fprintf(ctx.Stdout, "Removed %d cached responses\n", len(entries))
+		return nil
+	}
+
+	return fmt.Errorf("http-cache: unknown subcommand: %s (use list, info, rm or clear)", subcommand)
+}
+
+// readHTTPCache loads every valid cache entry
+func readHTTPCache() []*httpCacheEntry {
+	files, _ := filepath.Glob(filepath.Join(httpCacheDir(), "*.json"))
+
+	var entries []*httpCacheEntry
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var entry httpCacheEntry
+		if json.Unmarshal(data, &entry) == nil {
+			entries = append(entries, &entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Stored.After(entries[j].Stored)
+	})
+	return entries
+}
+
+// listHTTPCache prints cached URLs, newest first
+func listHTTPCache(ctx *Context) error {
+	entries := readHTTPCache()
+	if len(entries) == 0 {
+		fmt.Fprintln(ctx.Stdout, "HTTP cache is empty")
+		return nil
+	}
+
+	for _, entry := range entries {
+		validator := "etag"
+		if entry.ETag == "" {
+			validator = "last-modified"
+		}
+		fmt.Fprintf(ctx.Stdout, "%8s  %s  %-13s  %s\n",
+			formatHumanReadable(entry.Size),
+			entry.Stored.Format("2006-01-02 15:04"),
+			validator,
+			entry.URL)
+	}
+
+	return nil
+}
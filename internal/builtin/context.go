@@ -0,0 +1,104 @@
+package builtin
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gex/internal/readline"
+)
+
+// Context holds the streams a builtin reads and writes, and the variables
+// assigned for it. Builtins never use os.Stdin or os.Stdout directly, so
+// several of them can run at once, each with its own pipes.
+type Context struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Interrupt is closed when Ctrl+C interrupts the command line; builtins
+	// that run until stopped watch it. Nil when the shell exits instead.
+	Interrupt <-chan struct{}
+
+	// Env holds the variables assigned for this command alone, as in
+	// NAME=value command. They hide those of the environment, which the
+	// shell shares with commands running at the same time.
+	Env map[string]string
+}
+
+// StdContext returns a context on the standard streams of the shell
+func StdContext() *Context {
+	return &Context{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// Interrupted reports whether Ctrl+C has interrupted the command line
+func (c *Context) Interrupted() bool {
+	select {
+	case <-c.Interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// LookupEnv returns an environment variable as the command sees it
+func (c *Context) LookupEnv(name string) (string, bool) {
+	if value, ok := c.Env[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// Getenv returns an environment variable as the command sees it
+func (c *Context) Getenv(name string) string {
+	value, _ := c.LookupEnv(name)
+	return value
+}
+
+// Environ returns the environment of the command as NAME=value strings
+func (c *Context) Environ() []string {
+	environ := os.Environ()
+	if len(c.Env) == 0 {
+		return environ
+	}
+
+	result := make([]string, 0, len(environ)+len(c.Env))
+	for _, entry := range environ {
+		name, _, _ := strings.Cut(entry, "=")
+		if _, hidden := c.Env[name]; !hidden {
+			result = append(result, entry)
+		}
+	}
+	names := make([]string, 0, len(c.Env))
+	for name := range c.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, name+"="+c.Env[name])
+	}
+	return result
+}
+
+// IsTerminal reports whether a stream is a terminal, or a writer such as
+// the output throttle in front of one. The descriptor is looked at without
+// taking the file out of non-blocking mode.
+func IsTerminal(stream interface{}) bool {
+	if wrapper, ok := stream.(interface{ File() *os.File }); ok {
+		stream = wrapper.File()
+	}
+	file, ok := stream.(*os.File)
+	if !ok || file == nil {
+		return false
+	}
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return false
+	}
+	terminal := false
+	conn.Control(func(fd uintptr) {
+		terminal = readline.IsTerminalFd(int(fd))
+	})
+	return terminal
+}
@@ -0,0 +1,306 @@
+package builtin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errNoRangeSupport means the server can't serve a segmented download
+var errNoRangeSupport = errors.New("server does not support range requests")
+
+// maxDownloadSegments limits the connections used by --parallel
+const maxDownloadSegments = 32
+
+// segmentedDownload describes a download split into byte ranges that are
+// fetched concurrently into numbered .part files
+type segmentedDownload struct {
+	url      string
+	output   string
+	size     int64
+	segments int
+	client   *http.Client
+	done     atomic.Int64
+}
+
+// downloadParallel fetches url into output using several Range requests.
+// Partial segments left by an interrupted run are resumed. It returns
+// errNoRangeSupport when the server doesn't report a length or byte ranges.
+// The client should have no overall timeout, which would abort long
+// transfers; limit the wait for response headers instead.
+func downloadParallel(ctx *Context, client *http.Client, url, output string, segments int, quiet bool) error {
+	if segments > maxDownloadSegments {
+		segments = maxDownloadSegments
+	}
+
+	size, err := probeRangeSupport(client, url)
+	if err != nil {
+		return err
+	}
+
+	// Small files aren't worth more than one connection per byte
+	if int64(segments) > size {
+		segments = int(size)
+	}
+	if segments < 1 {
+		return errNoRangeSupport
+	}
+
+	d := &segmentedDownload{
+		url:      url,
+		output:   output,
+		size:     size,
+		segments: segments,
+		client:   client,
+	}
+
+	if err := d.prepareResume(); err != nil {
+		return err
+	}
+
+	if !quiet {
+		fmt.Fprintf(ctx.Stdout, "Length: %d (%s), %d segments\n", size, formatHumanReadable(size), segments)
+		if resumed := d.done.Load(); resumed > 0 {
+			fmt.Fprintf(ctx.Stdout, "Resuming with %s already downloaded\n", formatHumanReadable(resumed))
+		}
+	}
+
+	// Report aggregate progress until all segments finish
+	stop := make(chan struct{})
+	var progress sync.WaitGroup
+	if !quiet {
+		progress.Add(1)
+		go func() {
+			defer progress.Done()
+			d.showProgress(ctx, stop)
+		}()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, segments)
+	for i := 0; i < segments; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = d.fetchSegment(i)
+		}(i)
+	}
+	wg.Wait()
+
+	close(stop)
+	progress.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("segment %d: %v (run again to resume)", i, err)
+		}
+	}
+
+	return d.assemble()
+}
+
+// probeRangeSupport asks the server for the content length and whether it
+// accepts byte ranges
+func probeRangeSupport(client *http.Client, url string) (int64, error) {
+	resp, err := client.Head(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if resp.ContentLength <= 0 || resp.Header.Get("Accept-Ranges") != "bytes" {
+		return 0, errNoRangeSupport
+	}
+
+	return resp.ContentLength, nil
+}
+
+// bounds returns the first and last byte of a segment
+func (d *segmentedDownload) bounds(i int) (int64, int64) {
+	segmentSize := d.size / int64(d.segments)
+	start := int64(i) * segmentSize
+	end := start + segmentSize - 1
+	if i == d.segments-1 {
+		end = d.size - 1
+	}
+	return start, end
+}
+
+func (d *segmentedDownload) partName(i int) string {
+	return fmt.Sprintf("%s.part%d", d.output, i)
+}
+
+func (d *segmentedDownload) stateName() string {
+	return d.output + ".segments"
+}
+
+// prepareResume keeps the part files of a previous run of the same
+// download and discards them if the URL, size or segment count changed
+func (d *segmentedDownload) prepareResume() error {
+	state := fmt.Sprintf("%s\n%d\n%d\n", d.url, d.size, d.segments)
+
+	if previous, err := os.ReadFile(d.stateName()); err != nil || string(previous) != state {
+		d.removeParts(maxDownloadSegments)
+		return os.WriteFile(d.stateName(), []byte(state), 0644)
+	}
+
+	for i := 0; i < d.segments; i++ {
+		if info, err := os.Stat(d.partName(i)); err == nil {
+			start, end := d.bounds(i)
+			if info.Size() > end-start+1 {
+				// A part larger than its range is corrupt; fetch it again
+				os.Remove(d.partName(i))
+				continue
+			}
+			d.done.Add(info.Size())
+		}
+	}
+
+	return nil
+}
+
+// fetchSegment downloads the missing bytes of one segment
+func (d *segmentedDownload) fetchSegment(i int) error {
+	part, err := os.OpenFile(d.partName(i), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	info, err := part.Stat()
+	if err != nil {
+		return err
+	}
+
+	start, end := d.bounds(i)
+	start += info.Size()
+	if start > end {
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", d.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("server returned %s for range request", resp.Status)
+	}
+
+	written, err := io.Copy(part, &countingReader{reader: resp.Body, count: &d.done})
+	if err != nil {
+		return err
+	}
+	if written != end-start+1 {
+		return fmt.Errorf("short read: got %d of %d bytes", written, end-start+1)
+	}
+
+	return nil
+}
+
+// assemble joins the segments into the output file and removes the parts
+func (d *segmentedDownload) assemble() error {
+	out, err := os.Create(d.output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for i := 0; i < d.segments; i++ {
+		part, err := os.Open(d.partName(i))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	d.removeParts(d.segments)
+	os.Remove(d.stateName())
+	return nil
+}
+
+// removeParts deletes leftover part files
+func (d *segmentedDownload) removeParts(count int) {
+	for i := 0; i < count; i++ {
+		os.Remove(d.partName(i))
+	}
+}
+
+// showProgress redraws a single progress line until stop is closed
+func (d *segmentedDownload) showProgress(ctx *Context, stop <-chan struct{}) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	start := time.Now()
+	initial := d.done.Load()
+
+	draw := func() {
+		done := d.done.Load()
+		percent := float64(done) / float64(d.size) * 100
+
+		const width = 30
+		filled := int(percent / 100 * width)
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+
+		var rate string
+		if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+			rate = formatHumanReadable(int64(float64(done-initial)/elapsed)) + "/s"
+		}
+
+		fmt.Fprintf(ctx.Stdout, "\r%5.1f%% [%s] %s/%s %s  ", percent, bar,
+			formatHumanReadable(done), formatHumanReadable(d.size), rate)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			draw()
+		case <-stop:
+			draw()
+			fmt.Fprintln(ctx.Stdout)
+			return
+		}
+	}
+}
+
+// countingReader adds the bytes read to a shared counter
+type countingReader struct {
+	reader io.Reader
+	count  *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count.Add(int64(n))
+	return n, err
+}
+
+// parseParallel parses the value of --parallel
+func parseParallel(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid segment count: %s", value)
+	}
+	return n, nil
+}
@@ -0,0 +1,556 @@
+package builtin
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gex/internal/readline"
+)
+
+// Keys decoded from escape sequences, outside the rune range
+const (
+	keyUp = unicode.MaxRune + 1 + iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyPageUp
+	keyPageDown
+	keyDelete
+	keyEscape
+)
+
+const editTabStop = 8
+
+// editor is the state of the full-screen text editor
+type editor struct {
+	path    string
+	mode    os.FileMode
+	lines   [][]rune
+	newline bool // file ends with a newline
+
+	cx, cy         int // cursor position in the buffer
+	rowOff, colOff int // first line and column on screen
+	rows, cols     int // text area size
+
+	dirty      bool
+	quitting   bool // Ctrl+X pressed once with unsaved changes
+	cutting    bool // the previous key cut a line
+	message    string
+	lastSearch string
+	cutBuffer  [][]rune
+
+	in  *bufio.Reader
+	out *bufio.Writer
+}
+
+// Edit opens a file in a minimal full-screen editor with nano-like keys:
+// Ctrl+S save, Ctrl+X quit, Ctrl+W search, Ctrl+K cut and Ctrl+U paste a
+// line. A leading +N starts on line N.
+func Edit(ctx *Context, args []string) error {
+	startLine := 0
+	var path string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "+") && path == "" {
+			n, err := strconv.Atoi(arg[1:])
+			if err != nil {
+				return fmt.Errorf("edit: invalid line number: %s", arg)
+			}
+			startLine = n
+			continue
+		}
+		if path != "" {
+			return fmt.Errorf("edit: too many arguments")
+		}
+		path = arg
+	}
+	if path == "" {
+		return fmt.Errorf("edit: usage: edit [+LINE] FILE")
+	}
+
+	if !IsTerminal(ctx.Stdin) {
+		return fmt.Errorf("edit: standard input is not a terminal")
+	}
+	if !IsTerminal(ctx.Stdout) {
+		return fmt.Errorf("edit: standard output is not a terminal")
+	}
+
+	e := &editor{
+		path:    path,
+		mode:    0644,
+		newline: true,
+		in:      bufio.NewReader(ctx.Stdin),
+		out:     bufio.NewWriter(ctx.Stdout),
+	}
+	if err := e.load(); err != nil {
+		return fmt.Errorf("edit: %v", err)
+	}
+	if startLine > 0 {
+		e.cy = min(startLine, len(e.lines)) - 1
+	}
+
+	oldState, err := readline.SetRawMode()
+	if err != nil {
+		return fmt.Errorf("edit: %v", err)
+	}
+	defer readline.RestoreTerminal(oldState)
+
+	// Use the alternate screen so the shell's output is back afterwards
+	fmt.Fprint(ctx.Stdout, "\x1b[?1049h")
+	defer fmt.Fprint(ctx.Stdout, "\x1b[?1049l")
+
+	if e.message == "" {
+		e.message = "^S Save  ^X Exit  ^W Search  ^K Cut line  ^U Paste"
+	}
+	for {
+		e.refresh()
+		key, err := e.readKey()
+		if err != nil {
+			return fmt.Errorf("edit: %v", err)
+		}
+		if !e.handleKey(key) {
+			return nil
+		}
+	}
+}
+
+// load reads the file into lines; a missing file starts out empty
+func (e *editor) load() error {
+	data, err := os.ReadFile(e.path)
+	if os.IsNotExist(err) {
+		e.lines = [][]rune{{}}
+		e.message = "New file"
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(e.path); err == nil {
+		e.mode = info.Mode().Perm()
+	}
+
+	text := string(data)
+	e.newline = text == "" || strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\n")
+	for _, line := range strings.Split(text, "\n") {
+		e.lines = append(e.lines, []rune(strings.TrimSuffix(line, "\r")))
+	}
+	return nil
+}
+
+// save writes the buffer back to the file
+func (e *editor) save() {
+	var b strings.Builder
+	for i, line := range e.lines {
+		b.WriteString(string(line))
+		if i < len(e.lines)-1 || e.newline {
+			b.WriteByte('\n')
+		}
+	}
+
+	if err := os.WriteFile(e.path, []byte(b.String()), e.mode); err != nil {
+		e.message = fmt.Sprintf("Cannot save: %v", err)
+		return
+	}
+	e.dirty = false
+	e.message = fmt.Sprintf("Wrote %d lines to %s", len(e.lines), e.path)
+}
+
+// handleKey applies a key and reports whether editing continues
+func (e *editor) handleKey(key rune) bool {
+	quitting, cutting := e.quitting, e.cutting
+	e.quitting, e.cutting = false, false
+
+	switch key {
+	case 0x18, 0x11: // Ctrl+X, Ctrl+Q
+		if e.dirty && !quitting {
+			e.quitting = true
+			e.message = "Unsaved changes: ^X again to discard them, ^S to save"
+			return true
+		}
+		return false
+	case 0x13, 0x0f: // Ctrl+S, Ctrl+O
+		e.save()
+	case 0x17, 0x06: // Ctrl+W, Ctrl+F
+		e.search()
+	case 0x0b: // Ctrl+K
+		e.cutLine(cutting)
+	case 0x15: // Ctrl+U
+		e.paste()
+	case 0x0c: // Ctrl+L
+		e.message = ""
+
+	case keyUp:
+		e.cy--
+	case keyDown:
+		e.cy++
+	case keyLeft:
+		if e.cx > 0 {
+			e.cx--
+		} else if e.cy > 0 {
+			e.cy--
+			e.cx = len(e.lines[e.cy])
+		}
+	case keyRight:
+		if e.cx < len(e.lines[e.cy]) {
+			e.cx++
+		} else if e.cy < len(e.lines)-1 {
+			e.cy++
+			e.cx = 0
+		}
+	case keyHome, 0x01: // Home, Ctrl+A
+		e.cx = 0
+	case keyEnd, 0x05: // End, Ctrl+E
+		e.cx = len(e.lines[e.cy])
+	case keyPageUp:
+		e.cy -= e.rows
+	case keyPageDown:
+		e.cy += e.rows
+
+	case '\r', '\n':
+		e.insertNewline()
+	case 0x7f, 0x08: // Backspace
+		e.backspace()
+	case keyDelete, 0x04: // Delete, Ctrl+D
+		e.deleteChar()
+	case keyEscape:
+
+	default:
+		if key == '\t' || key >= 32 && key < unicode.MaxRune {
+			e.insertRune(key)
+		}
+	}
+
+	e.clampCursor()
+	return true
+}
+
+func (e *editor) clampCursor() {
+	e.cy = max(0, min(e.cy, len(e.lines)-1))
+	e.cx = max(0, min(e.cx, len(e.lines[e.cy])))
+}
+
+func (e *editor) insertRune(r rune) {
+	line := e.lines[e.cy]
+	line = append(line[:e.cx], append([]rune{r}, line[e.cx:]...)...)
+	e.lines[e.cy] = line
+	e.cx++
+	e.dirty = true
+}
+
+func (e *editor) insertNewline() {
+	line := e.lines[e.cy]
+	rest := append([]rune{}, line[e.cx:]...)
+	e.lines[e.cy] = line[:e.cx]
+	e.lines = append(e.lines[:e.cy+1], append([][]rune{rest}, e.lines[e.cy+1:]...)...)
+	e.cy++
+	e.cx = 0
+	e.dirty = true
+}
+
+func (e *editor) backspace() {
+	switch {
+	case e.cx > 0:
+		line := e.lines[e.cy]
+		e.lines[e.cy] = append(line[:e.cx-1], line[e.cx:]...)
+		e.cx--
+	case e.cy > 0:
+		// Join with the previous line
+		prev := e.lines[e.cy-1]
+		e.cx = len(prev)
+		e.lines[e.cy-1] = append(prev, e.lines[e.cy]...)
+		e.lines = append(e.lines[:e.cy], e.lines[e.cy+1:]...)
+		e.cy--
+	default:
+		return
+	}
+	e.dirty = true
+}
+
+func (e *editor) deleteChar() {
+	line := e.lines[e.cy]
+	switch {
+	case e.cx < len(line):
+		e.lines[e.cy] = append(line[:e.cx], line[e.cx+1:]...)
+	case e.cy < len(e.lines)-1:
+		e.lines[e.cy] = append(line, e.lines[e.cy+1]...)
+		e.lines = append(e.lines[:e.cy+1], e.lines[e.cy+2:]...)
+	default:
+		return
+	}
+	e.dirty = true
+}
+
+// cutLine removes the current line into the cut buffer; consecutive cuts
+// collect several lines like in nano
+func (e *editor) cutLine(extend bool) {
+	line := e.lines[e.cy]
+	if !extend {
+		e.cutBuffer = nil
+	}
+	e.cutBuffer = append(e.cutBuffer, append([]rune{}, line...))
+
+	if len(e.lines) == 1 {
+		e.lines[0] = []rune{}
+	} else {
+		e.lines = append(e.lines[:e.cy], e.lines[e.cy+1:]...)
+	}
+	e.cx = 0
+	e.dirty = true
+	e.cutting = true
+}
+
+// paste inserts the cut buffer above the current line
+func (e *editor) paste() {
+	if len(e.cutBuffer) == 0 {
+		return
+	}
+	pasted := make([][]rune, len(e.cutBuffer))
+	for i, line := range e.cutBuffer {
+		pasted[i] = append([]rune{}, line...)
+	}
+	e.lines = append(e.lines[:e.cy], append(pasted, e.lines[e.cy:]...)...)
+	e.cy += len(pasted)
+	e.cx = 0
+	e.dirty = true
+}
+
+// search prompts for text and moves to its next occurrence after the
+// cursor, wrapping around the end of the file
+func (e *editor) search() {
+	label := "Search: "
+	if e.lastSearch != "" {
+		label = fmt.Sprintf("Search [%s]: ", e.lastSearch)
+	}
+	query, ok := e.prompt(label)
+	if !ok {
+		e.message = "Cancelled"
+		return
+	}
+	if query == "" {
+		query = e.lastSearch
+	}
+	if query == "" {
+		e.message = ""
+		return
+	}
+	e.lastSearch = query
+
+	needle := []rune(query)
+	for i := 0; i <= len(e.lines); i++ {
+		y := (e.cy + i) % len(e.lines)
+		from := 0
+		if i == 0 {
+			from = e.cx + 1
+		}
+		if x := indexRunes(e.lines[y], needle, from); x >= 0 {
+			e.message = ""
+			if y < e.cy || (y == e.cy && x <= e.cx) {
+				e.message = "Search wrapped"
+			}
+			e.cy, e.cx = y, x
+			return
+		}
+	}
+	e.message = fmt.Sprintf("%q not found", query)
+}
+
+// indexRunes finds needle in line at or after from
+func indexRunes(line, needle []rune, from int) int {
+	for i := from; i+len(needle) <= len(line); i++ {
+		if string(line[i:i+len(needle)]) == string(needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+// prompt reads a line of input on the message line
+func (e *editor) prompt(label string) (string, bool) {
+	var input []rune
+	for {
+		e.message = label + string(input)
+		e.refresh()
+
+		key, err := e.readKey()
+		if err != nil {
+			return "", false
+		}
+		switch {
+		case key == '\r' || key == '\n':
+			return string(input), true
+		case key == keyEscape || key == 0x03 || key == 0x07: // Esc, Ctrl+C, Ctrl+G
+			return "", false
+		case key == 0x7f || key == 0x08:
+			if len(input) > 0 {
+				input = input[:len(input)-1]
+			}
+		case key >= 32 && key < unicode.MaxRune:
+			input = append(input, key)
+		}
+	}
+}
+
+// readKey reads a key, decoding the escape sequences of special keys
+func (e *editor) readKey() (rune, error) {
+	return readKey(e.in)
+}
+
+// readKey reads a key from a terminal in raw mode, decoding the escape
+// sequences of special keys
+func readKey(in *bufio.Reader) (rune, error) {
+	r, _, err := in.ReadRune()
+	if err != nil || r != 0x1b {
+		return r, err
+	}
+
+	// A lone Esc has nothing buffered after it
+	if in.Buffered() == 0 {
+		return keyEscape, nil
+	}
+	next, _, _ := in.ReadRune()
+	if next != '[' && next != 'O' {
+		return keyEscape, nil
+	}
+
+	code, _, _ := in.ReadRune()
+	switch code {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	case 'C':
+		return keyRight, nil
+	case 'D':
+		return keyLeft, nil
+	case 'H':
+		return keyHome, nil
+	case 'F':
+		return keyEnd, nil
+	}
+
+	// ESC [ N ~
+	if code >= '0' && code <= '9' {
+		if tilde, _, _ := in.ReadRune(); tilde == '~' {
+			switch code {
+			case '1', '7':
+				return keyHome, nil
+			case '4', '8':
+				return keyEnd, nil
+			case '3':
+				return keyDelete, nil
+			case '5':
+				return keyPageUp, nil
+			case '6':
+				return keyPageDown, nil
+			}
+		}
+	}
+	return keyEscape, nil
+}
+
+// renderColumn converts a buffer column to a screen column, expanding tabs
+func renderColumn(line []rune, cx int) int {
+	col := 0
+	for _, r := range line[:cx] {
+		if r == '\t' {
+			col += editTabStop - col%editTabStop
+		} else {
+			col++
+		}
+	}
+	return col
+}
+
+// renderLine expands tabs for display
+func renderLine(line []rune) []rune {
+	var out []rune
+	for _, r := range line {
+		if r == '\t' {
+			for pad := editTabStop - len(out)%editTabStop; pad > 0; pad-- {
+				out = append(out, ' ')
+			}
+			continue
+		}
+		if r < 32 || r == 0x7f {
+			r = '?'
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// scroll keeps the cursor inside the visible text area
+func (e *editor) scroll() {
+	col := renderColumn(e.lines[e.cy], e.cx)
+	if e.cy < e.rowOff {
+		e.rowOff = e.cy
+	}
+	if e.cy >= e.rowOff+e.rows {
+		e.rowOff = e.cy - e.rows + 1
+	}
+	if col < e.colOff {
+		e.colOff = col
+	}
+	if col >= e.colOff+e.cols {
+		e.colOff = col - e.cols + 1
+	}
+}
+
+// refresh redraws the whole screen: text, status bar and message line
+func (e *editor) refresh() {
+	width, height := readline.TerminalSize()
+	e.cols = width
+	e.rows = max(1, height-2)
+	e.scroll()
+
+	w := e.out
+	w.WriteString("\x1b[?25l\x1b[H")
+
+	for y := 0; y < e.rows; y++ {
+		row := e.rowOff + y
+		if row < len(e.lines) {
+			line := renderLine(e.lines[row])
+			if e.colOff < len(line) {
+				line = line[e.colOff:]
+				if len(line) > e.cols {
+					line = line[:e.cols]
+				}
+				w.WriteString(string(line))
+			}
+		} else {
+			w.WriteString("\x1b[34m~\x1b[0m")
+		}
+		w.WriteString("\x1b[K\r\n")
+	}
+
+	// Status bar in reverse video
+	name := e.path
+	if e.dirty {
+		name += " (modified)"
+	}
+	position := fmt.Sprintf("line %d/%d, col %d", e.cy+1, len(e.lines), e.cx+1)
+	status := " " + name
+	if pad := e.cols - len([]rune(status)) - len(position) - 1; pad > 0 {
+		status += strings.Repeat(" ", pad) + position + " "
+	}
+	if runes := []rune(status); len(runes) > e.cols {
+		status = string(runes[:e.cols])
+	}
+	w.WriteString("\x1b[7m" + status + "\x1b[K\x1b[0m\r\n")
+
+	message := []rune(e.message)
+	if len(message) > e.cols {
+		message = message[:e.cols]
+	}
+	w.WriteString(string(message) + "\x1b[K")
+
+	col := renderColumn(e.lines[e.cy], e.cx)
+	fmt.Fprintf(w, "\x1b[%d;%dH\x1b[?25h", e.cy-e.rowOff+1, col-e.colOff+1)
+	w.Flush()
+}
@@ -15,7 +15,7 @@ import (
 )
 
 // Ls lists directory contents (like ls command)
-func Ls(args []string) error {
+func Ls(ctx *Context, args []string) error {
 	var paths []string
 	var showHidden bool
 	var longFormat bool
@@ -23,6 +23,8 @@ func Ls(args []string) error {
 	var sortByTime bool
 	var reverse bool
 
+	loc, args := localeOption(ctx, args)
+
 	// Parse flags
 	for i, arg := range args {
 		if strings.HasPrefix(arg, "-") {
@@ -51,17 +53,22 @@ func Ls(args []string) error {
 		paths = []string{"."}
 	}
 
+	failed := false
 	for _, path := range paths {
-		if err := listDirectory(path, showHidden, longFormat, humanReadable, sortByTime, reverse); err != nil {
-			fmt.Printf("ls: %v\n", err)
+		if err := listDirectory(ctx, path, showHidden, longFormat, humanReadable, sortByTime, reverse, loc); err != nil {
+			fmt.Fprintf(ctx.Stderr, "ls: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // listDirectory implements the directory listing logic
-func listDirectory(path string, showHidden, longFormat, humanReadable, sortByTime, reverse bool) error {
+func listDirectory(ctx *Context, path string, showHidden, longFormat, humanReadable, sortByTime, reverse bool, loc locale) error {
 	entries, err := os.ReadDir(path)
 	if err != nil {
 		return err
@@ -86,16 +93,25 @@ func listDirectory(path string, showHidden, longFormat, humanReadable, sortByTim
 			return info1.ModTime().After(info2.ModTime())
 		})
 	} else {
+		// Names in the collation order of the locale
 		sort.Slice(files, func(i, j int) bool {
 			if reverse {
-				return files[i].Name() > files[j].Name()
+				return loc.compare(files[i].Name(), files[j].Name()) > 0
 			}
-			return files[i].Name() < files[j].Name()
+			return loc.compare(files[i].Name(), files[j].Name()) < 0
 		})
 	}
 
 	if longFormat {
-		return printLongFormat(files, path, humanReadable)
+		return printLongFormat(ctx, files, path, humanReadable)
+	}
+
+	// Piped output gets one plain name per line, like ls
+	if !IsTerminal(ctx.Stdout) {
+		for _, file := range files {
+			fmt.Fprintln(ctx.Stdout, file.Name())
+		}
+		return nil
 	}
 
 	// Simple format with colors
@@ -105,17 +121,17 @@ func listDirectory(path string, showHidden, longFormat, humanReadable, sortByTim
 		isExecutable := info != nil && info.Mode()&0111 != 0
 
 		coloredName := ui.ColorizeFilename(file.Name(), isDir, isExecutable)
-		fmt.Printf("%s  ", coloredName)
+		fmt.Fprintf(ctx.Stdout, "%s  ", coloredName)
 	}
 	if len(files) > 0 {
-		fmt.Println()
+		fmt.Fprintln(ctx.Stdout)
 	}
 
 	return nil
 }
 
 // printLongFormat prints files in long format (-l flag)
-func printLongFormat(files []os.DirEntry, basePath string, humanReadable bool) error {
+func printLongFormat(ctx *Context, files []os.DirEntry, basePath string, humanReadable bool) error {
 	for _, file := range files {
 		info, err := file.Info()
 		if err != nil {
@@ -152,7 +168,7 @@ func printLongFormat(files []os.DirEntry, basePath string, humanReadable bool) e
 		isExecutable := info.Mode()&0111 != 0
 		coloredName := ui.ColorizeFilename(file.Name(), isDir, isExecutable)
 
-		fmt.Printf("%s %s %s %s %8s %s %s\n",
+		fmt.Fprintf(ctx.Stdout, "%s %s %s %s %8s %s %s\n",
 			modeStr, links, owner, group, sizeStr, modTime, coloredName)
 	}
 
@@ -177,7 +193,7 @@ func formatHumanReadable(size int64) string {
 }
 
 // Mkdir creates directories (like mkdir command)
-func Mkdir(args []string) error {
+func Mkdir(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("mkdir: missing operand")
 	}
@@ -206,6 +222,7 @@ func Mkdir(args []string) error {
 		return fmt.Errorf("mkdir: missing operand")
 	}
 
+	failed := false
 	for _, path := range paths {
 		var err error
 		if createParents {
@@ -215,30 +232,39 @@ func Mkdir(args []string) error {
 		}
 
 		if err != nil {
-			fmt.Printf("mkdir: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "mkdir: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // Rmdir removes empty directories (like rmdir command)
-func Rmdir(args []string) error {
+func Rmdir(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("rmdir: missing operand")
 	}
 
+	failed := false
 	for _, path := range args {
 		if err := os.Remove(path); err != nil {
-			fmt.Printf("rmdir: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "rmdir: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // Rm removes files and directories (like rm command)
-func Rm(args []string) error {
+func Rm(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("rm: missing operand")
 	}
@@ -269,6 +295,7 @@ func Rm(args []string) error {
 		return fmt.Errorf("rm: missing operand")
 	}
 
+	failed := false
 	for _, path := range paths {
 		var err error
 		if recursive {
@@ -278,15 +305,19 @@ func Rm(args []string) error {
 		}
 
 		if err != nil && !force {
-			fmt.Printf("rm: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "rm: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // Cp copies files and directories (like cp command)
-func Cp(args []string) error {
+func Cp(ctx *Context, args []string) error {
 	if len(args) < 2 {
 		return fmt.Errorf("cp: missing operand")
 	}
@@ -324,6 +355,7 @@ func Cp(args []string) error {
 	destInfo, err := os.Stat(dest)
 	isDestDir := err == nil && destInfo.IsDir()
 
+	failed := false
 	for _, src := range sources {
 		var destPath string
 		if isDestDir {
@@ -333,10 +365,14 @@ func Cp(args []string) error {
 		}
 
 		if err := copyFile(src, destPath, recursive, preserve); err != nil {
-			fmt.Printf("cp: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "cp: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
@@ -425,7 +461,7 @@ func copyDir(src, dest string, preserve bool) error {
 }
 
 // Mv moves/renames files and directories (like mv command)
-func Mv(args []string) error {
+func Mv(ctx *Context, args []string) error {
 	if len(args) < 2 {
 		return fmt.Errorf("mv: missing operand")
 	}
@@ -444,36 +480,46 @@ func Mv(args []string) error {
 		return fmt.Errorf("mv: target '%s' is not a directory", dest)
 	}
 
+	failed := false
 	for _, src := range sources {
 		destPath := filepath.Join(dest, filepath.Base(src))
 		if err := os.Rename(src, destPath); err != nil {
-			fmt.Printf("mv: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "mv: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // Touch creates empty files or updates timestamps (like touch command)
-func Touch(args []string) error {
+func Touch(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("touch: missing operand")
 	}
 
 	now := time.Now()
 
+	failed := false
 	for _, path := range args {
 		// Try to update timestamp if file exists
 		if err := os.Chtimes(path, now, now); err != nil {
 			// File doesn't exist, create it
 			file, createErr := os.Create(path)
 			if createErr != nil {
-				fmt.Printf("touch: %v\n", createErr)
+				fmt.Fprintf(ctx.Stderr, "touch: %v\n", createErr)
+				failed = true
 				continue
 			}
 			file.Close()
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
@@ -0,0 +1,409 @@
+package builtin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gex/internal/config"
+	"gex/internal/shell"
+)
+
+// geoipDefaultPaths are searched when no database is configured
+var geoipDefaultPaths = []string{
+	"GeoLite2-City.mmdb",
+	"GeoLite2-Country.mmdb",
+	"/usr/share/GeoIP/GeoLite2-City.mmdb",
+	"/usr/share/GeoIP/GeoLite2-Country.mmdb",
+	"/var/lib/GeoIP/GeoLite2-City.mmdb",
+	"/var/lib/GeoIP/GeoLite2-Country.mmdb",
+}
+
+// Geoip looks up IP addresses (or host names) in a local MaxMind DB file,
+// so it works without network access
+func Geoip(ctx *Context, args []string, session *shell.Session) error {
+	dbPath := session.Config().GeoIPDatabase
+	raw := false
+	var targets []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-d", "--db":
+			if i+1 >= len(args) {
+				return fmt.Errorf("geoip: %s requires a file", arg)
+			}
+			i++
+			dbPath = args[i]
+		case "-j", "--json":
+			raw = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("geoip: invalid option: %s", arg)
+			}
+			targets = append(targets, arg)
+		}
+	}
+
+	if len(targets) == 0 {
+		return fmt.Errorf("geoip: usage: geoip [-d database] [-j] ip|host...")
+	}
+
+	if dbPath == "" {
+		dbPath = findGeoIPDatabase()
+		if dbPath == "" {
+			return fmt.Errorf("geoip: no database found (set geoip_database in the config or use -d FILE.mmdb)")
+		}
+	}
+
+	db, err := openMMDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("geoip: %s: %v", dbPath, err)
+	}
+
+	failed := false
+	for _, target := range targets {
+		ip := net.ParseIP(target)
+		if ip == nil {
+			addrs, err := net.LookupIP(target)
+			if err != nil || len(addrs) == 0 {
+				fmt.Fprintf(ctx.Stderr, "geoip: %s: cannot resolve\n", target)
+				failed = true
+				continue
+			}
+			ip = addrs[0]
+		}
+
+		record, err := db.lookup(ip)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "geoip: %s: %v\n", target, err)
+			failed = true
+			continue
+		}
+		if record == nil {
+			fmt.Fprintf(ctx.Stdout, "%s: not found in database\n", ip)
+			continue
+		}
+
+		if raw {
+			data, _ := json.MarshalIndent(record, "", "  ")
+			fmt.Fprintln(ctx.Stdout, string(data))
+			continue
+		}
+		printGeoIPRecord(ctx, ip, record)
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// findGeoIPDatabase returns the first database found in ~/.gex or the
+// usual system locations
+func findGeoIPDatabase() string {
+	for _, path := range geoipDefaultPaths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(config.GetDataDir(), path)
+		}
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// printGeoIPRecord prints the fields of City, Country and ASN databases
+func printGeoIPRecord(ctx *Context, ip net.IP, record interface{}) {
+	fmt.Fprintf(ctx.Stdout, "%s\n", ip)
+
+	field := func(label string, value interface{}) {
+		if value != nil && fmt.Sprint(value) != "" {
+			fmt.Fprintf(ctx.Stdout, "  %-12s %v\n", label+":", value)
+		}
+	}
+
+	country := geoField(record, "country", "names", "en")
+	if code := geoField(record, "country", "iso_code"); code != nil {
+		country = fmt.Sprintf("%v (%v)", country, code)
+	}
+	field("Country", country)
+	field("Region", geoField(record, "subdivisions", "0", "names", "en"))
+	field("City", geoField(record, "city", "names", "en"))
+	field("Postal code", geoField(record, "postal", "code"))
+
+	lat := geoField(record, "location", "latitude")
+	lon := geoField(record, "location", "longitude")
+	if lat != nil && lon != nil {
+		field("Location", fmt.Sprintf("%v, %v", lat, lon))
+	}
+	field("Time zone", geoField(record, "location", "time_zone"))
+
+	if asn := geoField(record, "autonomous_system_number"); asn != nil {
+		if org := geoField(record, "autonomous_system_organization"); org != nil {
+			asn = fmt.Sprintf("%v %v", asn, org)
+		}
+		field("ASN", fmt.Sprintf("AS%v", asn))
+	}
+}
+
+// geoField walks nested maps and arrays of a decoded record
+func geoField(value interface{}, path ...string) interface{} {
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[key]
+		case []interface{}:
+			var index int
+			if _, err := fmt.Sscanf(key, "%d", &index); err != nil || index >= len(v) {
+				return nil
+			}
+			value = v[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind DB file
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdb is a MaxMind DB file loaded into memory
+type mmdb struct {
+	data       []byte
+	nodeCount  uint64
+	recordSize uint64
+	ipVersion  uint64
+	dataStart  uint64
+	ipv4Start  uint64
+}
+
+// openMMDB reads a database and its metadata
+func openMMDB(path string) (*mmdb, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	marker := bytes.LastIndex(data, mmdbMetadataMarker)
+	if marker == -1 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+
+	db := &mmdb{data: data}
+	metaStart := uint64(marker + len(mmdbMetadataMarker))
+	meta, _, err := db.decode(metaStart, metaStart)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+
+	var ok1, ok2, ok3 bool
+	db.nodeCount, ok1 = geoField(meta, "node_count").(uint64)
+	db.recordSize, ok2 = geoField(meta, "record_size").(uint64)
+	db.ipVersion, ok3 = geoField(meta, "ip_version").(uint64)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errors.New("invalid metadata")
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+
+	// The data section follows the search tree and 16 zero bytes
+	treeSize := db.recordSize * 2 / 8 * db.nodeCount
+	db.dataStart = treeSize + 16
+	if db.dataStart > uint64(marker) {
+		return nil, errors.New("truncated search tree")
+	}
+
+	// IPv4 addresses live under ::/96 in IPv6 databases
+	if db.ipVersion == 6 {
+		node := uint64(0)
+		for i := 0; i < 96 && node < db.nodeCount; i++ {
+			node = db.readRecord(node, 0)
+		}
+		db.ipv4Start = node
+	}
+
+	return db, nil
+}
+
+// readRecord returns the left (bit 0) or right (bit 1) record of a node
+func (db *mmdb) readRecord(node uint64, bit uint) uint64 {
+	switch db.recordSize {
+	case 24:
+		offset := node*6 + uint64(bit)*3
+		b := db.data[offset : offset+3]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+	case 28:
+		b := db.data[node*7 : node*7+7]
+		if bit == 0 {
+			return uint64(b[3]&0xf0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		}
+		return uint64(b[3]&0x0f)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6])
+	default:
+		offset := node*8 + uint64(bit)*4
+		return uint64(binary.BigEndian.Uint32(db.data[offset : offset+4]))
+	}
+}
+
+// lookup walks the search tree for ip and decodes its record; it returns
+// nil if the address is not in the database
+func (db *mmdb) lookup(ip net.IP) (interface{}, error) {
+	address := ip.To4()
+	node := uint64(0)
+	if address != nil && db.ipVersion == 6 {
+		node = db.ipv4Start
+	} else if address == nil {
+		if db.ipVersion == 4 {
+			return nil, errors.New("IPv6 lookup in an IPv4-only database")
+		}
+		address = ip.To16()
+	}
+
+	for i := 0; i < len(address)*8 && node < db.nodeCount; i++ {
+		bit := uint(address[i/8]>>(7-uint(i%8))) & 1
+		node = db.readRecord(node, bit)
+	}
+
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("corrupt search tree")
+	}
+
+	offset := db.dataStart + (node - db.nodeCount - 16)
+	record, _, err := db.decode(offset, db.dataStart)
+	return record, err
+}
+
+// decode decodes the value at offset in the data section format. Pointers
+// are relative to base. It returns the value and the offset after it.
+func (db *mmdb) decode(offset, base uint64) (interface{}, uint64, error) {
+	if offset >= uint64(len(db.data)) {
+		return nil, 0, errors.New("offset out of range")
+	}
+
+	ctrl := db.data[offset]
+	offset++
+	kind := ctrl >> 5
+
+	if kind == 1 {
+		// Pointer: the size bits select how many bytes follow
+		size := (ctrl >> 3) & 0x3
+		value := uint64(ctrl & 0x7)
+		extra := [4]uint64{0, 2048, 526336, 0}
+		if size == 3 {
+			value = 0
+		}
+		n := uint64(size) + 1
+		if offset+n > uint64(len(db.data)) {
+			return nil, 0, errors.New("truncated pointer")
+		}
+		for _, b := range db.data[offset : offset+n] {
+			value = value<<8 | uint64(b)
+		}
+		target, _, err := db.decode(base+value+extra[size], base)
+		return target, offset + n, err
+	}
+
+	if kind == 0 {
+		if offset >= uint64(len(db.data)) {
+			return nil, 0, errors.New("truncated type")
+		}
+		kind = 7 + db.data[offset]
+		offset++
+	}
+
+	size := uint64(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint64(len(db.data)) {
+			return nil, 0, errors.New("truncated size")
+		}
+		var value uint64
+		for _, b := range db.data[offset : offset+n] {
+			value = value<<8 | uint64(b)
+		}
+		offset += n
+		size = [...]uint64{29, 285, 65821}[n-1] + value
+	}
+
+	switch kind {
+	case 7: // map
+		result := make(map[string]interface{}, size)
+		for i := uint64(0); i < size; i++ {
+			key, next, err := db.decode(offset, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, after, err := db.decode(next, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			result[fmt.Sprint(key)] = value
+			offset = after
+		}
+		return result, offset, nil
+	case 11: // array
+		result := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			value, next, err := db.decode(offset, base)
+			if err != nil {
+				return nil, 0, err
+			}
+			result = append(result, value)
+			offset = next
+		}
+		return result, offset, nil
+	case 14: // boolean, stored in the size
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint64(len(db.data)) {
+		return nil, 0, errors.New("truncated value")
+	}
+	payload := db.data[offset : offset+size]
+	offset += size
+
+	switch kind {
+	case 2: // UTF-8 string
+		return string(payload), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, errors.New("invalid double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case 4: // bytes
+		return append([]byte(nil), payload...), offset, nil
+	case 5, 6, 9: // unsigned integers
+		var value uint64
+		for _, b := range payload {
+			value = value<<8 | uint64(b)
+		}
+		return value, offset, nil
+	case 8: // int32
+		var value uint32
+		for _, b := range payload {
+			value = value<<8 | uint32(b)
+		}
+		return int32(value), offset, nil
+	case 10: // uint128, shown as hex
+		return fmt.Sprintf("0x%x", payload), offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, errors.New("invalid float")
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(payload)), offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported data type %d", kind)
+}
@@ -0,0 +1,73 @@
+package builtin
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gex/internal/core"
+)
+
+// gitTTL is how long the refs and remotes of a repository are kept for
+// completion; branches come and go often, so not long
+const gitTTL = 5 * time.Second
+
+// gitTimeout keeps Tab from hanging on a slow repository
+const gitTimeout = 2 * time.Second
+
+// gitCompletion returns the branches and tags, for kind git-ref, or the
+// remotes, for git-remote, of the repository in the current directory.
+// git only runs once they are asked for, and what it says is cached
+// briefly.
+func gitCompletion(kind string) []string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	key := kind + " " + dir
+	if values, cached := core.CompletionCache.Get(key); cached {
+		return values
+	}
+
+	var values []string
+	switch kind {
+	case "git-ref":
+		values = gitRefNames()
+	case "git-remote":
+		values = gitLines("remote")
+	}
+	core.CompletionCache.SetTTL(key, values, gitTTL)
+	return values
+}
+
+// gitRefNames returns the local and remote branches and the tags, named
+// as they are given to git
+func gitRefNames() []string {
+	var names []string
+	for _, ref := range gitLines("for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes", "refs/tags") {
+		if strings.HasSuffix(ref, "/HEAD") {
+			continue
+		}
+		for _, prefix := range []string{"refs/heads/", "refs/remotes/", "refs/tags/"} {
+			if name, ok := strings.CutPrefix(ref, prefix); ok {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// gitLines runs git and returns the lines it prints, or none when it
+// fails, as it does outside a repository
+func gitLines(args ...string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(output))
+}
@@ -0,0 +1,125 @@
+package builtin
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gex/internal/core"
+)
+
+// sshHostsTTL is how long the hosts read from ~/.ssh are kept for
+// completion; the files change rarely, but a host just added should not
+// take long to show up
+const sshHostsTTL = 30 * time.Second
+
+// sshHosts returns the hosts named in ~/.ssh/config and ~/.ssh/known_hosts,
+// sorted, from the completion cache while they are fresh
+func sshHosts() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	dir := filepath.Join(home, ".ssh")
+	key := "ssh hosts " + dir
+	if hosts, cached := core.CompletionCache.Get(key); cached {
+		return hosts
+	}
+
+	seen := make(map[string]bool)
+	readSSHConfig(filepath.Join(dir, "config"), dir, seen, 0)
+	readKnownHosts(filepath.Join(dir, "known_hosts"), seen)
+	hosts := make([]string, 0, len(seen))
+	for host := range seen {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	core.CompletionCache.SetTTL(key, hosts, sshHostsTTL)
+	return hosts
+}
+
+// maxSSHIncludes limits how deep Include directives are followed
+const maxSSHIncludes = 8
+
+// readSSHConfig adds the names of Host entries in an ssh config file,
+// leaving out patterns, and reads the files it includes, which are
+// relative to dir unless absolute
+func readSSHConfig(path, dir string, seen map[string]bool, depth int) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Keywords may be followed by = rather than blanks
+		line := strings.TrimSpace(scanner.Text())
+		end := strings.IndexAny(line, " \t=")
+		if end < 0 {
+			continue
+		}
+		keyword, rest := line[:end], strings.TrimLeft(line[end:], " \t=")
+
+		switch strings.ToLower(keyword) {
+		case "host":
+			for _, name := range strings.Fields(rest) {
+				if !strings.ContainsAny(name, "*?!") {
+					seen[name] = true
+				}
+			}
+		case "include":
+			if depth >= maxSSHIncludes {
+				continue
+			}
+			for _, pattern := range strings.Fields(rest) {
+				if strings.HasPrefix(pattern, "~/") {
+					pattern = filepath.Join(filepath.Dir(dir), pattern[2:])
+				} else if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(dir, pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, match := range matches {
+					readSSHConfig(match, dir, seen, depth+1)
+				}
+			}
+		}
+	}
+}
+
+// readKnownHosts adds the hosts of a known_hosts file. Hashed names
+// cannot be read back, and a host on another port, written [host]:port,
+// is added as host.
+func readKnownHosts(path string, seen map[string]bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+			// @cert-authority and @revoked come before the hosts
+			fields = fields[1:]
+		}
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "|") {
+			continue
+		}
+		for _, name := range strings.Split(fields[0], ",") {
+			if strings.HasPrefix(name, "[") {
+				if end := strings.Index(name, "]"); end > 0 {
+					name = name[1:end]
+				}
+			}
+			if name != "" && !strings.ContainsAny(name, "*?!") {
+				seen[name] = true
+			}
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package builtin
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// httpOptions controls how the network builtins reach servers
+type httpOptions struct {
+	// proxy overrides the proxy environment variables when set
+	proxy string
+	// noProxy disables proxies entirely
+	noProxy bool
+	// netrc adds credentials from ~/.netrc to requests without any
+	netrc bool
+	// headerTimeout limits the wait for response headers
+	headerTimeout time.Duration
+	// getenv reads the proxy and netrc variables, those assigned for the
+	// command included; os.Getenv when nil
+	getenv func(string) string
+}
+
+// newHTTPTransport builds the transport shared by wget, curl and the
+// segmented downloader
+func newHTTPTransport(opts httpOptions) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = opts.headerTimeout
+	getenv := opts.getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+
+	switch {
+	case opts.noProxy:
+		transport.Proxy = nil
+	case opts.proxy != "":
+		proxyURL, err := parseProxyURL(opts.proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	default:
+		// http.ProxyFromEnvironment reads the environment only once, but
+		// variables exported in the shell must take effect immediately
+		transport.Proxy = proxyFromEnvironment(getenv)
+	}
+
+	if !opts.netrc {
+		return transport, nil
+	}
+	return &netrcTransport{base: transport, getenv: getenv}, nil
+}
+
+// parseProxyURL accepts proxies with or without a scheme
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy: %s", proxy)
+	}
+	return proxyURL, nil
+}
+
+// getenvAny returns the first non-empty variable, checking upper then
+// lower case like curl and wget
+func getenvAny(getenv func(string) string, names ...string) string {
+	for _, name := range names {
+		if value := getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// proxyFromEnvironment returns the proxy function that picks a proxy from
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY for every request
+func proxyFromEnvironment(getenv func(string) string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		var proxy string
+		if req.URL.Scheme == "https" {
+			proxy = getenvAny(getenv, "HTTPS_PROXY", "https_proxy")
+		} else {
+			proxy = getenvAny(getenv, "HTTP_PROXY", "http_proxy")
+		}
+		if proxy == "" {
+			proxy = getenvAny(getenv, "ALL_PROXY", "all_proxy")
+		}
+
+		if proxy == "" || bypassProxy(req.URL, getenvAny(getenv, "NO_PROXY", "no_proxy")) {
+			return nil, nil
+		}
+		return parseProxyURL(proxy)
+	}
+}
+
+// bypassProxy reports whether noProxy, the value of NO_PROXY, covers the
+// host of u. Entries may be "*", host names (matching subdomains too),
+// host:port pairs, IPs or CIDRs.
+func bypassProxy(u *url.URL, noProxy string) bool {
+	if noProxy == "" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// An entry with a port only matches that port
+		if entryHost, entryPort, err := net.SplitHostPort(entry); err == nil {
+			if entryPort != port {
+				continue
+			}
+			entry = entryHost
+		}
+
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// netrcTransport adds basic auth from ~/.netrc to requests that carry no
+// credentials. It runs per request, so redirects to another host use that
+// host's entry; the default entry only covers the host first asked for.
+type netrcTransport struct {
+	base   http.RoundTripper
+	getenv func(string) string
+}
+
+func (t *netrcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" {
+		if login, password, ok := lookupNetrc(netrcPath(t.getenv), req.URL.Hostname(), sameHost(originalRequest(req).URL, req.URL)); ok {
+			req = req.Clone(req.Context())
+			req.SetBasicAuth(login, password)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// originalRequest follows a redirected request back to the one the user
+// made
+func originalRequest(req *http.Request) *http.Request {
+	for req.Response != nil && req.Response.Request != nil {
+		req = req.Response.Request
+	}
+	return req
+}
+
+// sameHost reports whether two URLs name the same host
+func sameHost(a, b *url.URL) bool {
+	return strings.EqualFold(a.Hostname(), b.Hostname())
+}
+
+// maxRedirects is how many redirects are followed, as by http.Client
+const maxRedirects = 10
+
+// checkRedirect follows redirects as http.Client does, but never sends the
+// credentials meant for one host to another, even a subdomain of it
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if !sameHost(via[0].URL, req.URL) {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// netrcPath returns $NETRC or ~/.netrc
+func netrcPath(getenv func(string) string) string {
+	if path := getenv("NETRC"); path != "" {
+		return path
+	}
+	return filepath.Join(getenv("HOME"), ".netrc")
+}
+
+// lookupNetrc finds the login and password for a host in the netrc file at
+// path, falling back to the default entry when useDefault is set
+func lookupNetrc(path, host string, useDefault bool) (string, string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", false
+	}
+	defer file.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Macro definitions run until the next blank line
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		fields := splitNetrcLine(line)
+		for i, field := range fields {
+			if field == "macdef" {
+				tokens = append(tokens, fields[:i]...)
+				inMacro = true
+				fields = nil
+				break
+			}
+		}
+		tokens = append(tokens, fields...)
+	}
+
+	var login, password string
+	var defaultLogin, defaultPassword string
+	var found, hasDefault bool
+	current := ""
+
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if found {
+				return login, password, true
+			}
+			if i+1 < len(tokens) {
+				i++
+				current = tokens[i]
+				found = strings.EqualFold(current, host)
+			}
+		case "default":
+			if found {
+				return login, password, true
+			}
+			current = ""
+			hasDefault = true
+		case "login", "password", "account":
+			if i+1 >= len(tokens) {
+				break
+			}
+			i++
+			switch {
+			case found && tokens[i-1] == "login":
+				login = tokens[i]
+			case found && tokens[i-1] == "password":
+				password = tokens[i]
+			case current == "" && hasDefault && tokens[i-1] == "login":
+				defaultLogin = tokens[i]
+			case current == "" && hasDefault && tokens[i-1] == "password":
+				defaultPassword = tokens[i]
+			}
+		}
+	}
+
+	if found {
+		return login, password, true
+	}
+	if useDefault && hasDefault && defaultLogin != "" {
+		return defaultLogin, defaultPassword, true
+	}
+	return "", "", false
+}
+
+// splitNetrcLine splits a line into tokens, honoring double quotes
+func splitNetrcLine(line string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuotes, inToken := false, false
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case ch == '"':
+			inQuotes = !inQuotes
+			inToken = true
+		case ch == '\\' && inQuotes && i+1 < len(line):
+			i++
+			current.WriteByte(line[i])
+		case (ch == ' ' || ch == '\t') && !inQuotes:
+			if inToken {
+				fields = append(fields, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteByte(ch)
+			inToken = true
+		}
+	}
+	if inToken {
+		fields = append(fields, current.String())
+	}
+
+	return fields
+}
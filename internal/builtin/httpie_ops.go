@@ -0,0 +1,525 @@
+package builtin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gex/internal/config"
+	"gex/internal/ui"
+)
+
+// httpItemSeparators are checked at every position of a request item, the
+// longest first, so "a:=1" is a JSON field and not a header
+var httpItemSeparators = []string{":=@", "==", ":=", "=@", "=", ":"}
+
+// httpMethodPattern matches an explicit method argument such as GET or PATCH
+var httpMethodPattern = regexp.MustCompile(`^[A-Za-z]+$`)
+
+// httpRequestSpec is a parsed http command line
+type httpRequestSpec struct {
+	method      string
+	url         string
+	headers     http.Header
+	query       url.Values
+	fields      map[string]interface{}
+	fieldOrder  []string
+	form        bool
+	auth        string
+	follow      bool
+	verbose     bool
+	printHeader bool
+	printBody   bool
+	checkStatus bool
+	timeout     time.Duration
+	session     string
+	readOnly    bool
+}
+
+// httpSession is a named session file keeping headers and cookies between
+// requests to the same host
+type httpSession struct {
+	Headers map[string]string `json:"headers"`
+	Cookies map[string]string `json:"cookies"`
+	Auth    string            `json:"auth,omitempty"`
+}
+
+// Http sends an HTTP request with httpie syntax: http [METHOD] URL
+// [Header:value] [field=string] [field:=json] [param==value]
+func Http(ctx *Context, args []string) error {
+	spec := &httpRequestSpec{
+		headers:     make(http.Header),
+		query:       make(url.Values),
+		fields:      make(map[string]interface{}),
+		printHeader: true,
+		printBody:   true,
+		timeout:     30 * time.Second,
+	}
+
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-f" || arg == "--form":
+			spec.form = true
+		case arg == "-j" || arg == "--json":
+			spec.form = false
+		case arg == "-F" || arg == "--follow":
+			spec.follow = true
+		case arg == "-v" || arg == "--verbose":
+			spec.verbose = true
+		case arg == "-h" || arg == "--headers":
+			spec.printHeader, spec.printBody = true, false
+		case arg == "-b" || arg == "--body":
+			spec.printHeader, spec.printBody = false, true
+		case arg == "--check-status":
+			spec.checkStatus = true
+		case arg == "-a" || arg == "--auth":
+			if i+1 >= len(args) {
+				return fmt.Errorf("http: %s requires user:password", arg)
+			}
+			i++
+			spec.auth = args[i]
+		case arg == "--timeout":
+			if i+1 >= len(args) {
+				return fmt.Errorf("http: --timeout requires a value")
+			}
+			i++
+			d, err := parseScanTimeout(args[i])
+			if err != nil {
+				return fmt.Errorf("http: %v", err)
+			}
+			spec.timeout = d
+		case arg == "--session" || arg == "--session-read-only":
+			if i+1 >= len(args) {
+				return fmt.Errorf("http: %s requires a name", arg)
+			}
+			i++
+			spec.session = args[i]
+			spec.readOnly = arg == "--session-read-only"
+		case strings.HasPrefix(arg, "--session="):
+			spec.session = strings.TrimPrefix(arg, "--session=")
+		case strings.HasPrefix(arg, "--session-read-only="):
+			spec.session = strings.TrimPrefix(arg, "--session-read-only=")
+			spec.readOnly = true
+		case strings.HasPrefix(arg, "-") && len(positional) == 0 && arg != "-":
+			return fmt.Errorf("http: invalid option: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) == 0 {
+		return fmt.Errorf("http: usage: http [options] [METHOD] URL [items...]")
+	}
+
+	// The method is optional: a leading word followed by the URL rather
+	// than a request item names it
+	if len(positional) > 1 && httpMethodPattern.MatchString(positional[0]) && !isHTTPItem(positional[1]) {
+		spec.method = strings.ToUpper(positional[0])
+		positional = positional[1:]
+	}
+	spec.url = expandHTTPURL(positional[0])
+
+	for _, item := range positional[1:] {
+		if err := spec.addItem(item); err != nil {
+			return fmt.Errorf("http: %v", err)
+		}
+	}
+
+	if spec.method == "" {
+		spec.method = "GET"
+		if len(spec.fields) > 0 {
+			spec.method = "POST"
+		}
+	}
+
+	return sendHTTPRequest(ctx, spec)
+}
+
+// expandHTTPURL applies httpie's shorthands: ":3000/x" means localhost and
+// a missing scheme means http
+func expandHTTPURL(raw string) string {
+	if strings.HasPrefix(raw, ":") {
+		raw = "localhost" + raw
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	return raw
+}
+
+// isHTTPItem reports whether an argument is a request item rather than a
+// URL such as :3000/path
+func isHTTPItem(arg string) bool {
+	for i := 1; i < len(arg); i++ {
+		for _, sep := range httpItemSeparators {
+			if strings.HasPrefix(arg[i:], sep) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addItem parses one request item by its separator
+func (spec *httpRequestSpec) addItem(item string) error {
+	for i := 0; i < len(item); i++ {
+		for _, sep := range httpItemSeparators {
+			if !strings.HasPrefix(item[i:], sep) {
+				continue
+			}
+			key, value := item[:i], item[i+len(sep):]
+			if key == "" {
+				return fmt.Errorf("invalid item: %s", item)
+			}
+			return spec.applyItem(key, sep, value)
+		}
+	}
+	return fmt.Errorf("invalid item: %s (use Header:value, field=value, field:=json or param==value)", item)
+}
+
+func (spec *httpRequestSpec) applyItem(key, sep, value string) error {
+	switch sep {
+	case ":":
+		if value == "" {
+			// "Header:" sends the header empty, as httpie does
+			spec.headers[http.CanonicalHeaderKey(key)] = []string{""}
+			return nil
+		}
+		spec.headers.Add(key, value)
+	case "==":
+		spec.query.Add(key, value)
+	case "=":
+		spec.setField(key, value)
+	case "=@":
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return err
+		}
+		spec.setField(key, string(data))
+	case ":=", ":=@":
+		raw := []byte(value)
+		if sep == ":=@" {
+			data, err := os.ReadFile(value)
+			if err != nil {
+				return err
+			}
+			raw = data
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			return fmt.Errorf("%s: invalid JSON: %v", key, err)
+		}
+		spec.setField(key, decoded)
+	}
+	return nil
+}
+
+func (spec *httpRequestSpec) setField(key string, value interface{}) {
+	if _, exists := spec.fields[key]; !exists {
+		spec.fieldOrder = append(spec.fieldOrder, key)
+	}
+	spec.fields[key] = value
+}
+
+// body encodes the data fields as JSON or as a urlencoded form
+func (spec *httpRequestSpec) body() ([]byte, string, error) {
+	if len(spec.fields) == 0 {
+		return nil, "", nil
+	}
+
+	if spec.form {
+		form := make(url.Values)
+		for _, key := range spec.fieldOrder {
+			value, ok := spec.fields[key].(string)
+			if !ok {
+				data, _ := json.Marshal(spec.fields[key])
+				value = string(data)
+			}
+			form.Add(key, value)
+		}
+		return []byte(form.Encode()), "application/x-www-form-urlencoded; charset=utf-8", nil
+	}
+
+	data, err := json.Marshal(spec.fields)
+	return data, "application/json", err
+}
+
+// sendHTTPRequest builds, sends and prints a request and its response
+func sendHTTPRequest(ctx *Context, spec *httpRequestSpec) error {
+	target, err := url.Parse(spec.url)
+	if err != nil || target.Host == "" {
+		return fmt.Errorf("http: invalid URL: %s", spec.url)
+	}
+	query := target.Query()
+	for key, values := range spec.query {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	body, contentType, err := spec.body()
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+
+	req, err := http.NewRequest(spec.method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	req.Header.Set("User-Agent", "gex-http/1.0")
+	req.Header.Set("Accept", "application/json, */*;q=0.5")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	var sess *httpSession
+	sessionPath := ""
+	if spec.session != "" {
+		sessionPath = httpSessionPath(target.Host, spec.session)
+		sess = loadHTTPSession(sessionPath)
+		for name, value := range sess.Headers {
+			req.Header.Set(name, value)
+		}
+		for name, value := range sess.Cookies {
+			req.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+		if spec.auth == "" {
+			spec.auth = sess.Auth
+		}
+	}
+
+	for name, values := range spec.headers {
+		req.Header[name] = values
+	}
+	if spec.auth != "" {
+		user, password, _ := strings.Cut(spec.auth, ":")
+		req.SetBasicAuth(user, password)
+	}
+
+	transport, err := newHTTPTransport(httpOptions{netrc: true, headerTimeout: spec.timeout, getenv: ctx.Getenv})
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	client := &http.Client{Transport: transport, CheckRedirect: checkRedirect}
+	if !spec.follow {
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	if spec.verbose {
+		printHTTPRequest(ctx, req, body)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("http: %v", err)
+	}
+
+	if spec.printHeader {
+		printHTTPResponseHeader(ctx, resp)
+	}
+	if spec.printBody && len(respBody) > 0 {
+		if spec.printHeader {
+			fmt.Fprintln(ctx.Stdout)
+		}
+		fmt.Fprintln(ctx.Stdout, formatHTTPBody(respBody, resp.Header.Get("Content-Type")))
+	}
+
+	if sess != nil && !spec.readOnly {
+		sess.update(spec, resp)
+		if err := saveHTTPSession(sessionPath, sess); err != nil {
+			fmt.Fprintf(ctx.Stderr, "http: cannot save session: %v\n", err)
+		}
+	}
+
+	if spec.checkStatus && resp.StatusCode >= 300 {
+		return ExitStatus(resp.StatusCode / 100)
+	}
+	return nil
+}
+
+// printHTTPRequest shows the request line, headers and body for -v
+func printHTTPRequest(ctx *Context, req *http.Request, body []byte) {
+	fmt.Fprintf(ctx.Stdout, "%s %s HTTP/1.1\n", ui.Colorize(req.Method, ui.Bold+ui.Green), req.URL.RequestURI())
+	fmt.Fprintf(ctx.Stdout, "%s: %s\n", ui.Colorize("Host", ui.Cyan), req.URL.Host)
+	printHTTPHeaders(ctx, req.Header)
+	if len(body) > 0 {
+		fmt.Fprintln(ctx.Stdout)
+		fmt.Fprintln(ctx.Stdout, formatHTTPBody(body, req.Header.Get("Content-Type")))
+	}
+	fmt.Fprintln(ctx.Stdout)
+}
+
+func printHTTPResponseHeader(ctx *Context, resp *http.Response) {
+	color := ui.Green
+	switch {
+	case resp.StatusCode >= 400:
+		color = ui.Red
+	case resp.StatusCode >= 300:
+		color = ui.Yellow
+	}
+	fmt.Fprintf(ctx.Stdout, "%s %s\n", resp.Proto, ui.Colorize(resp.Status, ui.Bold+color))
+	printHTTPHeaders(ctx, resp.Header)
+}
+
+func printHTTPHeaders(ctx *Context, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(ctx.Stdout, "%s: %s\n", ui.Colorize(name, ui.Cyan), value)
+		}
+	}
+}
+
+// formatHTTPBody pretty-prints and colors JSON bodies; other text is shown
+// as is and binary data is summarized
+func formatHTTPBody(body []byte, contentType string) string {
+	trimmed := bytes.TrimSpace(body)
+	if strings.Contains(contentType, "json") || (len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[')) {
+		var indented bytes.Buffer
+		if json.Indent(&indented, trimmed, "", "    ") == nil {
+			return colorizeJSON(indented.String())
+		}
+	}
+
+	if bytes.IndexByte(body, 0) != -1 {
+		return fmt.Sprintf("NOTE: binary data not shown (%s)", formatHumanReadable(int64(len(body))))
+	}
+	return strings.TrimRight(string(body), "\n")
+}
+
+// colorizeJSON colors keys, strings, numbers and literals of indented JSON
+func colorizeJSON(text string) string {
+	if !ui.IsColorSupported() {
+		return text
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		ch := text[i]
+		switch {
+		case ch == '"':
+			end := i + 1
+			for end < len(text) && text[end] != '"' {
+				if text[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++
+			if end > len(text) {
+				end = len(text)
+			}
+			color := ui.Yellow
+			if strings.HasPrefix(text[end:], ":") {
+				color = ui.Blue
+			}
+			b.WriteString(color + text[i:end] + ui.Reset)
+			i = end
+		case ch == '-' || (ch >= '0' && ch <= '9'):
+			end := i + 1
+			for end < len(text) && strings.IndexByte("0123456789.eE+-", text[end]) != -1 {
+				end++
+			}
+			b.WriteString(ui.Cyan + text[i:end] + ui.Reset)
+			i = end
+		case strings.HasPrefix(text[i:], "true"), strings.HasPrefix(text[i:], "null"):
+			b.WriteString(ui.Magenta + text[i:i+4] + ui.Reset)
+			i += 4
+		case strings.HasPrefix(text[i:], "false"):
+			b.WriteString(ui.Magenta + text[i:i+5] + ui.Reset)
+			i += 5
+		default:
+			b.WriteByte(ch)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// httpSessionPath returns ~/.gex/http-sessions/HOST/NAME.json; a name
+// containing a slash is used as a path
+func httpSessionPath(host, name string) string {
+	if strings.ContainsRune(name, os.PathSeparator) {
+		return name
+	}
+	host = strings.ReplaceAll(host, ":", "_")
+	return filepath.Join(config.GetDataDir(), "http-sessions", host, name+".json")
+}
+
+func loadHTTPSession(path string) *httpSession {
+	sess := &httpSession{}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, sess)
+	}
+	if sess.Headers == nil {
+		sess.Headers = make(map[string]string)
+	}
+	if sess.Cookies == nil {
+		sess.Cookies = make(map[string]string)
+	}
+	return sess
+}
+
+// update stores the custom headers and credentials of the request and the
+// cookies the server set
+func (sess *httpSession) update(spec *httpRequestSpec, resp *http.Response) {
+	for name, values := range spec.headers {
+		switch name {
+		case "Content-Type", "Content-Length", "If-None-Match", "If-Modified-Since":
+			// Request-specific headers don't belong in a session
+			continue
+		}
+		if len(values) > 0 {
+			sess.Headers[name] = values[len(values)-1]
+		}
+	}
+	if spec.auth != "" {
+		sess.Auth = spec.auth
+	}
+
+	for _, cookie := range resp.Cookies() {
+		expired := cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && cookie.Expires.Before(time.Now()))
+		if expired {
+			delete(sess.Cookies, cookie.Name)
+			continue
+		}
+		sess.Cookies[cookie.Name] = cookie.Value
+	}
+}
+
+func saveHTTPSession(path string, sess *httpSession) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Sessions hold credentials, so keep them private
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,401 @@
+package builtin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"gex/internal/readline"
+)
+
+// Image protocols imgcat can render with
+const (
+	imageKitty  = "kitty"
+	imageSixel  = "sixel"
+	imageBlocks = "blocks"
+)
+
+// Imgcat displays images in the terminal with the kitty graphics protocol,
+// sixel, or unicode half blocks when the terminal supports neither
+func Imgcat(ctx *Context, args []string) error {
+	protocol := ""
+	width := 0
+	var files []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-p" || arg == "--protocol":
+			if i+1 >= len(args) {
+				return fmt.Errorf("imgcat: %s requires an argument", arg)
+			}
+			i++
+			protocol = args[i]
+		case strings.HasPrefix(arg, "--protocol="):
+			protocol = strings.TrimPrefix(arg, "--protocol=")
+		case arg == "-w" || arg == "--width":
+			if i+1 >= len(args) {
+				return fmt.Errorf("imgcat: %s requires an argument", arg)
+			}
+			i++
+			if _, err := fmt.Sscanf(args[i], "%d", &width); err != nil || width <= 0 {
+				return fmt.Errorf("imgcat: invalid width: %s", args[i])
+			}
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			files = append(files, arg)
+		default:
+			return fmt.Errorf("imgcat: invalid option: %s", arg)
+		}
+	}
+
+	switch protocol {
+	case "":
+		protocol = detectImageProtocol(ctx)
+	case imageKitty, imageSixel, imageBlocks:
+	default:
+		return fmt.Errorf("imgcat: unknown protocol: %s (kitty, sixel or blocks)", protocol)
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	// Fit the terminal unless a width in columns is given
+	cols, _ := readline.TerminalSize()
+	if width == 0 || width > cols {
+		width = cols
+	}
+
+	out := bufio.NewWriter(ctx.Stdout)
+	defer out.Flush()
+
+	failed := false
+	for _, file := range files {
+		if err := showImage(ctx, out, file, protocol, width); err != nil {
+			fmt.Fprintf(out, "imgcat: %s: %v\n", file, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// showImage decodes an image file (or stdin for -) and renders it
+func showImage(ctx *Context, out *bufio.Writer, file, protocol string, cols int) error {
+	var reader io.Reader = ctx.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("cannot decode image: %v", err)
+	}
+
+	switch protocol {
+	case imageKitty:
+		// kitty decodes PNG itself; other formats are converted
+		if format != "png" {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				return err
+			}
+			data = buf.Bytes()
+		}
+		writeKitty(out, data, img.Bounds(), cols)
+	case imageSixel:
+		writeSixel(out, fitImage(img, columnsToPixels(cols)))
+	default:
+		writeBlocks(out, fitImage(img, cols))
+	}
+	return nil
+}
+
+// detectImageProtocol picks the best protocol the terminal supports
+func detectImageProtocol(ctx *Context) string {
+	if !IsTerminal(ctx.Stdout) {
+		return imageBlocks
+	}
+
+	term := ctx.Getenv("TERM")
+	program := ctx.Getenv("TERM_PROGRAM")
+	if ctx.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" ||
+		program == "WezTerm" || program == "ghostty" {
+		return imageKitty
+	}
+	if strings.Contains(term, "sixel") || term == "mlterm" || term == "foot" || terminalReportsSixel(ctx) {
+		return imageSixel
+	}
+	return imageBlocks
+}
+
+// terminalReportsSixel asks the terminal for its primary device attributes;
+// attribute 4 in the reply means sixel graphics
+func terminalReportsSixel(ctx *Context) bool {
+	if !readline.IsTerminal() {
+		return false
+	}
+	oldState, err := readline.SetRawMode()
+	if err != nil {
+		return false
+	}
+	defer readline.RestoreTerminal(oldState)
+
+	fmt.Fprint(ctx.Stdout, "\x1b[c")
+
+	// The reply looks like ESC [ ? 62 ; 4 ; 22 c
+	var reply []byte
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for !bytes.HasSuffix(reply, []byte("c")) {
+		remaining := time.Until(deadline)
+		if remaining <= 0 || !waitReadable(syscall.Stdin, remaining) {
+			return false
+		}
+		buf := make([]byte, 64)
+		n, err := syscall.Read(syscall.Stdin, buf)
+		if err != nil || n == 0 {
+			return false
+		}
+		reply = append(reply, buf[:n]...)
+	}
+
+	start := bytes.Index(reply, []byte("\x1b[?"))
+	if start == -1 {
+		return false
+	}
+	attributes := strings.TrimSuffix(string(reply[start+3:]), "c")
+	for _, attribute := range strings.Split(attributes, ";") {
+		if attribute == "4" {
+			return true
+		}
+	}
+	return false
+}
+
+// waitReadable waits up to timeout for fd to have input
+func waitReadable(fd int, timeout time.Duration) bool {
+	var set syscall.FdSet
+	set.Bits[fd/64] |= 1 << (uint(fd) % 64)
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	n, err := syscall.Select(fd+1, &set, nil, nil, &tv)
+	return err == nil && n > 0
+}
+
+// writeKitty transmits PNG data with the kitty graphics protocol in base64
+// chunks, letting the terminal scale it to at most cols cells
+func writeKitty(out *bufio.Writer, data []byte, bounds image.Rectangle, cols int) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	const chunkSize = 4096
+
+	params := "a=T,f=100"
+	if bounds.Dx() > columnsToPixels(cols) {
+		params += fmt.Sprintf(",c=%d", cols)
+	}
+
+	for i := 0; i < len(encoded); i += chunkSize {
+		end := min(i+chunkSize, len(encoded))
+		more := 0
+		if end < len(encoded) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(out, "\x1b_G%s,m=%d;%s\x1b\\", params, more, encoded[i:end])
+		} else {
+			fmt.Fprintf(out, "\x1b_Gm=%d;%s\x1b\\", more, encoded[i:end])
+		}
+	}
+	out.WriteString("\n")
+}
+
+// columnsToPixels converts a width in terminal columns to pixels
+func columnsToPixels(cols int) int {
+	pxWidth, _ := readline.TerminalPixelSize()
+	termCols, _ := readline.TerminalSize()
+	if pxWidth > 0 && termCols > 0 {
+		return pxWidth * cols / termCols
+	}
+	// Assume a common cell width when the terminal does not tell
+	return cols * 8
+}
+
+// fitImage scales an image down to at most width pixels wide, averaging
+// the source pixels each target pixel covers
+func fitImage(src image.Image, width int) *image.NRGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > width {
+		h = max(1, h*width/w)
+		w = width
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/h
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/w
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/w)
+
+			var r, g, b, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBAModel.Convert(src.At(sx, sy)).(color.NRGBA)
+					r += uint32(c.R)
+					g += uint32(c.G)
+					b += uint32(c.B)
+					a += uint32(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{uint8(r / n), uint8(g / n), uint8(b / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// writeBlocks draws two pixel rows per line with upper half blocks, the
+// top pixel as foreground and the bottom one as background. Past the last
+// row of an odd height, NRGBAAt returns a transparent pixel.
+func writeBlocks(out *bufio.Writer, img *image.NRGBA) {
+	trueColor := os.Getenv("COLORTERM") == "truecolor" || os.Getenv("COLORTERM") == "24bit"
+	colorCode := func(c color.NRGBA, layer int) string {
+		if trueColor {
+			return fmt.Sprintf("\x1b[%d;2;%d;%d;%dm", layer, c.R, c.G, c.B)
+		}
+		return fmt.Sprintf("\x1b[%d;5;%dm", layer, ansi256(c))
+	}
+
+	bounds := img.Bounds()
+	for y := 0; y < bounds.Dy(); y += 2 {
+		for x := 0; x < bounds.Dx(); x++ {
+			top := img.NRGBAAt(x, y)
+			bottom := img.NRGBAAt(x, y+1)
+			switch {
+			case top.A < 128 && bottom.A < 128:
+				out.WriteString("\x1b[0m ")
+			case top.A < 128:
+				out.WriteString("\x1b[0m" + colorCode(bottom, 38) + "▄")
+			case bottom.A < 128:
+				out.WriteString("\x1b[0m" + colorCode(top, 38) + "▀")
+			default:
+				out.WriteString(colorCode(top, 38) + colorCode(bottom, 48) + "▀")
+			}
+		}
+		out.WriteString("\x1b[0m\n")
+	}
+}
+
+// ansi256 maps a color to the nearest entry of the 6x6x6 color cube
+func ansi256(c color.NRGBA) int {
+	return 16 + 36*cubeLevel(c.R) + 6*cubeLevel(c.G) + cubeLevel(c.B)
+}
+
+// cubeLevel maps a color channel to one of the six cube levels
+func cubeLevel(v uint8) int {
+	return (int(v)*5 + 127) / 255
+}
+
+// writeSixel encodes an image as sixel graphics with the 216 colors of the
+// color cube; transparent pixels are left out
+func writeSixel(out *bufio.Writer, img *image.NRGBA) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	fmt.Fprintf(out, "\x1bPq\"1;1;%d;%d", w, h)
+	for i := 0; i < 216; i++ {
+		r, g, b := i/36, i/6%6, i%6
+		fmt.Fprintf(out, "#%d;2;%d;%d;%d", i, r*20, g*20, b*20)
+	}
+
+	index := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A < 128 {
+				index[y*w+x] = -1
+			} else {
+				index[y*w+x] = ansi256(c) - 16
+			}
+		}
+	}
+
+	// Each band covers six pixel rows; every color in it is drawn in its
+	// own pass over the band
+	row := make([]byte, w)
+	for band := 0; band < h; band += 6 {
+		used := make(map[int]bool)
+		for y := band; y < min(band+6, h); y++ {
+			for x := 0; x < w; x++ {
+				if i := index[y*w+x]; i >= 0 {
+					used[i] = true
+				}
+			}
+		}
+
+		first := true
+		for c := 0; c < 216; c++ {
+			if !used[c] {
+				continue
+			}
+			for x := 0; x < w; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < h; dy++ {
+					if index[(band+dy)*w+x] == c {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = 63 + bits
+			}
+
+			if !first {
+				out.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(out, "#%d", c)
+			writeSixelRun(out, row)
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\\n")
+}
+
+// writeSixelRun writes sixel characters with run-length encoding
+func writeSixelRun(out *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(out, "!%d%c", n, row[i])
+		} else {
+			for ; i < j; i++ {
+				out.WriteByte(row[i])
+			}
+		}
+		i = j
+	}
+}
@@ -0,0 +1,295 @@
+package builtin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gex/internal/shell"
+)
+
+// Jobs lists background jobs or shows a job's output log
+func Jobs(ctx *Context, args []string, session *shell.Session) error {
+	var showPids bool
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--log" || arg == "-L":
+			if i+1 >= len(args) {
+				return fmt.Errorf("jobs: %s requires a job number", arg)
+			}
+			return showJobLog(ctx, args[i+1], session)
+		case strings.HasPrefix(arg, "--log="):
+			return showJobLog(ctx, strings.TrimPrefix(arg, "--log="), session)
+		case arg == "-l" || arg == "-p":
+			showPids = true
+		default:
+			return fmt.Errorf("jobs: invalid option: %s", arg)
+		}
+	}
+
+	jobs := session.GetJobs()
+	for _, job := range jobs {
+		line := FormatJob(job, jobs, showPids)
+		if job.LogPath != "" {
+			line += fmt.Sprintf("  (log: %s)", job.LogPath)
+		}
+		fmt.Fprintln(ctx.Stdout, line)
+
+		// Finished jobs leave the table once they have been listed
+		if job.State == shell.JobDone {
+			session.RemoveJob(job.ID)
+		}
+	}
+
+	return nil
+}
+
+// FormatJob formats a job as the jobs listing shows it, marking the
+// current job with + and the previous one with -
+func FormatJob(job shell.Job, jobs []shell.Job, showPid bool) string {
+	marker := " "
+	current, previous := currentJobs(jobs)
+	switch job.ID {
+	case current:
+		marker = "+"
+	case previous:
+		marker = "-"
+	}
+
+	line := fmt.Sprintf("[%d]%s ", job.ID, marker)
+	if showPid {
+		line += fmt.Sprintf(" %d", job.PID)
+	}
+	return fmt.Sprintf("%s %-22s %s", line, jobStateText(job), job.Command)
+}
+
+// jobStateText describes the state of a job and how it ended
+func jobStateText(job shell.Job) string {
+	switch job.State {
+	case shell.JobStopped:
+		return "Stopped"
+	case shell.JobDone:
+		if job.Status > 128 {
+			if sig := syscall.Signal(job.Status - 128); SignalName(sig) != strconv.Itoa(int(sig)) {
+				return "Killed (SIG" + SignalName(sig) + ")"
+			}
+		}
+		if job.Status != 0 {
+			return fmt.Sprintf("Exit %d", job.Status)
+		}
+		return "Done"
+	default:
+		return "Running"
+	}
+}
+
+// currentJobs returns the IDs of the current and previous jobs: the most
+// recently stopped ones, then the most recently started
+func currentJobs(jobs []shell.Job) (int, int) {
+	var order []int
+	for _, state := range []shell.JobState{shell.JobStopped, shell.JobRunning, shell.JobDone} {
+		for i := len(jobs) - 1; i >= 0; i-- {
+			if jobs[i].State == state {
+				order = append(order, jobs[i].ID)
+			}
+		}
+	}
+
+	current, previous := 0, 0
+	if len(order) > 0 {
+		current = order[0]
+	}
+	if len(order) > 1 {
+		previous = order[1]
+	}
+	return current, previous
+}
+
+// showJobLog prints the output log of a job given as N or %N
+func showJobLog(ctx *Context, spec string, session *shell.Session) error {
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err != nil {
+		return fmt.Errorf("jobs: invalid job number: %s", spec)
+	}
+
+	job, exists := session.GetJob(id)
+	if !exists {
+		return fmt.Errorf("jobs: %%%d: no such job", id)
+	}
+	if job.LogPath == "" {
+		return fmt.Errorf("jobs: %%%d: output was not logged", id)
+	}
+
+	file, err := os.Open(job.LogPath)
+	if err != nil {
+		return fmt.Errorf("jobs: %v", err)
+	}
+	defer file.Close()
+
+	return catReader(ctx, file)
+}
+
+// Wait blocks until the given jobs (%N or PID), or all background jobs,
+// are done and returns the exit status of the last one
+func Wait(ctx *Context, args []string, session *shell.Session) error {
+	if len(args) == 0 {
+		for _, job := range session.GetJobs() {
+			if _, interrupted := waitJob(ctx, job.ID, session); interrupted {
+				return ExitStatus(130)
+			}
+		}
+		return nil
+	}
+
+	status := 0
+	for _, spec := range args {
+		job, err := findJob(spec, session)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "wait: %v\n", err)
+			status = 127
+			continue
+		}
+		var interrupted bool
+		if status, interrupted = waitJob(ctx, job.ID, session); interrupted {
+			return ExitStatus(130)
+		}
+	}
+
+	if status != 0 {
+		return ExitStatus(status)
+	}
+	return nil
+}
+
+// waitJob waits until a job is done and returns its exit status, unless
+// Ctrl+C interrupts the wait
+func waitJob(ctx *Context, id int, session *shell.Session) (int, bool) {
+	done, exists := session.JobDone(id)
+	if !exists {
+		return 0, false
+	}
+	select {
+	case <-done:
+	case <-ctx.Interrupt:
+		return 130, true
+	}
+	status, _ := session.WaitJob(id)
+	return status, false
+}
+
+// findJob resolves a job given as %N, %% or %+ (the current job), %- (the
+// previous job), or as the PID of its process
+func findJob(spec string, session *shell.Session) (shell.Job, error) {
+	if spec == "%%" || spec == "%+" || spec == "%-" {
+		current, previous := currentJobs(session.GetJobs())
+		id := current
+		if spec == "%-" {
+			id = previous
+		}
+		if job, exists := session.GetJob(id); exists {
+			return job, nil
+		}
+		return shell.Job{}, fmt.Errorf("%s: no such job", spec)
+	}
+
+	if strings.HasPrefix(spec, "%") {
+		id, err := strconv.Atoi(spec[1:])
+		if err != nil {
+			return shell.Job{}, fmt.Errorf("%s: invalid job specification", spec)
+		}
+		job, exists := session.GetJob(id)
+		if !exists {
+			return shell.Job{}, fmt.Errorf("%s: no such job", spec)
+		}
+		return job, nil
+	}
+
+	pid, err := strconv.Atoi(spec)
+	if err != nil {
+		return shell.Job{}, fmt.Errorf("%s: not a pid or valid job spec", spec)
+	}
+	job, exists := session.FindJobByPID(pid)
+	if !exists {
+		return shell.Job{}, fmt.Errorf("pid %d is not a child of this shell", pid)
+	}
+	return job, nil
+}
+
+// Disown removes jobs from the job table, so the shell no longer reports
+// or waits for them and does not pass them a hangup. Without arguments it
+// removes the most recent job. With -h the jobs stay in the table and are
+// only kept from the hangup.
+func Disown(ctx *Context, args []string, session *shell.Session) error {
+	forget := func(job shell.Job) { session.RemoveJob(job.ID) }
+	all := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'a':
+				all = true
+			case 'h':
+				forget = func(job shell.Job) { session.SetJobNoHangup(job.ID) }
+			default:
+				return fmt.Errorf("disown: -%c: invalid option", flag)
+			}
+		}
+		args = args[1:]
+	}
+
+	if all {
+		for _, job := range session.GetJobs() {
+			forget(job)
+		}
+		return nil
+	}
+	if len(args) == 0 {
+		args = []string{"%%"}
+	}
+
+	failed := false
+	for _, spec := range args {
+		job, err := findJob(spec, session)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "disown: %v\n", err)
+			failed = true
+			continue
+		}
+		forget(job)
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// OpenNohupOutput opens nohup.out for appending in the current directory,
+// or in the home directory when that is not writable
+func OpenNohupOutput(stderr io.Writer) (*os.File, error) {
+	const flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+
+	file, err := os.OpenFile("nohup.out", flags, 0600)
+	if err == nil {
+		fmt.Fprintln(stderr, "nohup: ignoring input and appending output to 'nohup.out'")
+		return file, nil
+	}
+
+	home, homeErr := os.UserHomeDir()
+	if homeErr != nil {
+		return nil, fmt.Errorf("nohup: cannot open nohup.out: %v", err)
+	}
+	path := filepath.Join(home, "nohup.out")
+	file, err = os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("nohup: cannot open %s: %v", path, err)
+	}
+	fmt.Fprintf(stderr, "nohup: ignoring input and appending output to '%s'\n", path)
+	return file, nil
+}
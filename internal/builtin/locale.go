@@ -0,0 +1,145 @@
+package builtin
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// locale holds what sort, ls and wc take from the locale: how names and
+// lines collate (LC_COLLATE) and whether text is UTF-8 (LC_CTYPE)
+type locale struct {
+	bytewise bool
+	utf8     bool
+}
+
+// currentLocale reads the locale from the environment the way the C
+// library does: LC_ALL, then the category variable, then LANG
+func currentLocale(ctx *Context) locale {
+	collate, ctype := localeName(ctx, "LC_COLLATE"), localeName(ctx, "LC_CTYPE")
+	return locale{bytewise: isCLocale(collate), utf8: isUTF8Locale(ctype)}
+}
+
+// namedLocale is the locale given with --locale, for every category
+func namedLocale(name string) locale {
+	return locale{bytewise: isCLocale(name), utf8: isUTF8Locale(name)}
+}
+
+// localeOption removes --locale=NAME from args and returns the locale it
+// names, or the one from the environment. --locale=C gives byte order
+// and byte counts whatever the environment says.
+func localeOption(ctx *Context, args []string) (locale, []string) {
+	loc := currentLocale(ctx)
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if name, ok := strings.CutPrefix(arg, "--locale="); ok {
+			loc = namedLocale(name)
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return loc, rest
+}
+
+func localeName(ctx *Context, category string) string {
+	for _, name := range []string{"LC_ALL", category, "LANG"} {
+		if value := ctx.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return "C"
+}
+
+// isCLocale reports whether a locale collates by byte value. C.UTF-8
+// orders by code point, which is the same thing for UTF-8 text.
+func isCLocale(name string) bool {
+	language, _, _ := strings.Cut(name, ".")
+	return language == "C" || language == "POSIX"
+}
+
+func isUTF8Locale(name string) bool {
+	_, codeset, _ := strings.Cut(name, ".")
+	codeset, _, _ = strings.Cut(codeset, "@")
+	codeset = strings.ToLower(codeset)
+	return codeset == "utf-8" || codeset == "utf8"
+}
+
+// latin1Base holds the unaccented letter for each of U+00C0 to U+00FF
+var latin1Base = []rune("AAAAAAACEEEEIIIIDNOOOOO\u00d7OUUUUYTsaaaaaaaceeeeiiiidnooooo\u00f7ouuuuyty")
+
+// compare orders two strings in the locale. Outside C the order follows
+// the usual dictionary rules of the C library: letters and digits are
+// compared first, ignoring accents, case and punctuation, then accented
+// letters go after plain ones and lowercase before uppercase, and only
+// then do the bytes decide.
+func (l locale) compare(a, b string) int {
+	if l.bytewise {
+		return strings.Compare(a, b)
+	}
+	if c := compareAlphanumeric(a, b, baseLetter); c != 0 {
+		return c
+	}
+	if c := compareAlphanumeric(a, b, unicode.ToLower); c != 0 {
+		return c
+	}
+	if c := compareAlphanumeric(a, b, caseRank); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// baseLetter maps a letter to its lowercase form without accents; only
+// the Latin-1 letters are known
+func baseLetter(r rune) rune {
+	if r >= 0xc0 && r <= 0xff {
+		r = latin1Base[r-0xc0]
+	}
+	return unicode.ToLower(r)
+}
+
+// caseRank maps a letter to 0 if lowercase and 1 otherwise, so comparing
+// ranks puts lowercase first
+func caseRank(r rune) rune {
+	if unicode.IsUpper(r) {
+		return 1
+	}
+	return 0
+}
+
+// compareAlphanumeric compares the letters and digits of two strings
+// after mapping each through key
+func compareAlphanumeric(a, b string, key func(rune) rune) int {
+	for {
+		ra, sizeA := nextAlphanumeric(a)
+		rb, sizeB := nextAlphanumeric(b)
+		a, b = a[sizeA:], b[sizeB:]
+
+		switch {
+		case ra < 0 && rb < 0:
+			return 0
+		case ra < 0:
+			return -1
+		case rb < 0:
+			return 1
+		}
+		if ka, kb := key(ra), key(rb); ka != kb {
+			if ka < kb {
+				return -1
+			}
+			return 1
+		}
+	}
+}
+
+// nextAlphanumeric returns the first letter or digit of s and the bytes
+// up to and including it, or -1 when there is none
+func nextAlphanumeric(s string) (rune, int) {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r, i
+		}
+	}
+	return -1, len(s)
+}
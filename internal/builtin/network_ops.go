@@ -1,6 +1,7 @@
 package builtin
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -9,10 +10,13 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"gex/internal/shell"
+	"gex/internal/ui"
 )
 
 // Ping sends ICMP ping packets (simplified implementation using TCP connect)
-func Ping(args []string) error {
+func Ping(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("ping: missing host")
 	}
@@ -54,12 +58,14 @@ func Ping(args []string) error {
 		return fmt.Errorf("ping: missing host")
 	}
 
-	fmt.Printf("PING %s\n", host)
+	fmt.Fprintf(ctx.Stdout, "PING %s\n", host)
 
-	var successful, failed int
+	var successful, failed, transmitted int
 	var totalTime time.Duration
 
-	for i := 0; i < count; i++ {
+	// Ctrl+C ends the pings early but still shows the statistics
+	for i := 0; i < count && !ctx.Interrupted(); i++ {
+		transmitted++
 		start := time.Now()
 
 		// Use TCP connect as a simple ping alternative
@@ -72,28 +78,31 @@ func Ping(args []string) error {
 		elapsed := time.Since(start)
 
 		if err != nil {
-			fmt.Printf("Request timeout for icmp_seq=%d\n", i+1)
+			fmt.Fprintf(ctx.Stdout, "Request timeout for icmp_seq=%d\n", i+1)
 			failed++
 		} else {
 			conn.Close()
-			fmt.Printf("64 bytes from %s: icmp_seq=%d time=%.1fms\n",
+			fmt.Fprintf(ctx.Stdout, "64 bytes from %s: icmp_seq=%d time=%.1fms\n",
 				host, i+1, float64(elapsed.Nanoseconds())/1000000)
 			successful++
 			totalTime += elapsed
 		}
 
 		if i < count-1 {
-			time.Sleep(interval)
+			select {
+			case <-time.After(interval):
+			case <-ctx.Interrupt:
+			}
 		}
 	}
 
-	fmt.Printf("\n--- %s ping statistics ---\n", host)
-	fmt.Printf("%d packets transmitted, %d received, %.1f%% packet loss\n",
-		count, successful, float64(failed)/float64(count)*100)
+	fmt.Fprintf(ctx.Stdout, "\n--- %s ping statistics ---\n", host)
+	fmt.Fprintf(ctx.Stdout, "%d packets transmitted, %d received, %.1f%% packet loss\n",
+		transmitted, successful, float64(failed)/float64(max(transmitted, 1))*100)
 
 	if successful > 0 {
 		avgTime := totalTime / time.Duration(successful)
-		fmt.Printf("round-trip min/avg/max = %.1f/%.1f/%.1f ms\n",
+		fmt.Fprintf(ctx.Stdout, "round-trip min/avg/max = %.1f/%.1f/%.1f ms\n",
 			float64(avgTime.Nanoseconds())/1000000,
 			float64(avgTime.Nanoseconds())/1000000,
 			float64(avgTime.Nanoseconds())/1000000)
@@ -103,7 +112,7 @@ func Ping(args []string) error {
 }
 
 // Wget downloads files from web (simplified implementation)
-func Wget(args []string) error {
+func Wget(ctx *Context, args []string, session *shell.Session) error {
 	if len(args) == 0 {
 		return fmt.Errorf("wget: missing URL")
 	}
@@ -112,26 +121,62 @@ func Wget(args []string) error {
 	var output string
 	var quiet bool
 	var continue_ bool
+	var noCache bool
+	var parallel int
 	var timeout time.Duration = 30 * time.Second
+	opts := httpOptions{netrc: true, getenv: ctx.Getenv}
 
 	// Parse arguments
-	for i, arg := range args {
-		if strings.HasPrefix(arg, "-") {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "--parallel=") {
+			n, err := parseParallel(strings.TrimPrefix(arg, "--parallel="))
+			if err != nil {
+				return fmt.Errorf("wget: %v", err)
+			}
+			parallel = n
+		} else if strings.HasPrefix(arg, "--proxy=") {
+			opts.proxy = strings.TrimPrefix(arg, "--proxy=")
+		} else if strings.HasPrefix(arg, "-") {
 			switch arg {
 			case "-O":
 				if i+1 < len(args) {
-					output = args[i+1]
+					i++
+					output = args[i]
 				}
 			case "-q", "--quiet":
 				quiet = true
 			case "-c", "--continue":
 				continue_ = true
+			case "--no-cache":
+				noCache = true
+			case "--proxy":
+				if i+1 >= len(args) {
+					return fmt.Errorf("wget: --proxy requires a URL")
+				}
+				i++
+				opts.proxy = args[i]
+			case "--no-proxy":
+				opts.noProxy = true
+			case "--no-netrc":
+				opts.netrc = false
 			case "-T", "--timeout":
 				if i+1 < len(args) {
-					if d, err := time.ParseDuration(args[i+1] + "s"); err == nil {
+					i++
+					if d, err := time.ParseDuration(args[i] + "s"); err == nil {
 						timeout = d
 					}
 				}
+			case "--parallel":
+				if i+1 >= len(args) {
+					return fmt.Errorf("wget: --parallel requires a segment count")
+				}
+				i++
+				n, err := parseParallel(args[i])
+				if err != nil {
+					return fmt.Errorf("wget: %v", err)
+				}
+				parallel = n
 			}
 		} else if url == "" {
 			url = arg
@@ -147,34 +192,84 @@ func Wget(args []string) error {
 		url = "http://" + url
 	}
 
-	if !quiet {
-		fmt.Printf("Connecting to %s...\n", url)
+	// Determine output file
+	if output == "" {
+		parts := strings.Split(url, "/")
+		if len(parts) > 0 && parts[len(parts)-1] != "" {
+			output = parts[len(parts)-1]
+		} else {
+			output = "index.html"
+		}
+	}
+
+	// Segmented download over several connections
+	if parallel > 1 {
+		if !quiet {
+			fmt.Fprintf(ctx.Stdout, "Connecting to %s...\n", url)
+			fmt.Fprintf(ctx.Stdout, "Saving to: '%s'\n", output)
+		}
+		opts.headerTimeout = timeout
+		transport, err := newHTTPTransport(opts)
+		if err != nil {
+			return fmt.Errorf("wget: %v", err)
+		}
+		err = downloadParallel(ctx, &http.Client{Transport: transport, CheckRedirect: checkRedirect}, url, output, parallel, quiet)
+		if err == nil {
+			if !quiet {
+				fmt.Fprintf(ctx.Stdout, "'%s' saved\n", output)
+			}
+			return nil
+		}
+		if !errors.Is(err, errNoRangeSupport) {
+			return fmt.Errorf("wget: %v", err)
+		}
+		if !quiet {
+			fmt.Fprintln(ctx.Stdout, "Server does not support ranges, downloading with a single connection")
+		}
 	}
 
 	// Create HTTP client with timeout
+	transport, err := newHTTPTransport(opts)
+	if err != nil {
+		return fmt.Errorf("wget: %v", err)
+	}
 	client := &http.Client{
-		Timeout: timeout,
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("wget: %v", err)
 	}
 
-	// Make request
-	resp, err := client.Get(url)
+	var connecting *ui.Spinner
+	if !quiet {
+		connecting = ui.NewSpinner(ctx.Stderr, fmt.Sprintf("Connecting to %s...", url)).Start()
+	}
+
+	// Make request, revalidating a cached copy when caching is enabled
+	var resp *http.Response
+	var fromCache bool
+	if session.Config().HTTPCache && !noCache && !continue_ {
+		resp, fromCache, err = doCachedRequest(client, req)
+	} else {
+		resp, err = client.Do(req)
+	}
 	if err != nil {
+		connecting.Stop()
 		return fmt.Errorf("wget: %v", err)
 	}
 	defer resp.Body.Close()
+	connecting.Success(fmt.Sprintf("Connected to %s: %s", req.URL.Host, resp.Status))
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("wget: server returned %d %s", resp.StatusCode, resp.Status)
+	if fromCache && !quiet {
+		fmt.Fprintln(ctx.Stdout, "Not modified, using cached copy")
 	}
 
-	// Determine output file
-	if output == "" {
-		parts := strings.Split(url, "/")
-		if len(parts) > 0 && parts[len(parts)-1] != "" {
-			output = parts[len(parts)-1]
-		} else {
-			output = "index.html"
-		}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("wget: server returned %d %s", resp.StatusCode, resp.Status)
 	}
 
 	// Handle continue option
@@ -191,7 +286,7 @@ func Wget(args []string) error {
 	defer outFile.Close()
 
 	if !quiet {
-		fmt.Printf("Saving to: '%s'\n", output)
+		fmt.Fprintf(ctx.Stdout, "Saving to: '%s'\n", output)
 	}
 
 	// Copy data
@@ -201,15 +296,15 @@ func Wget(args []string) error {
 	}
 
 	if !quiet {
-		fmt.Printf("Downloaded %d bytes\n", written)
-		fmt.Printf("'%s' saved\n", output)
+		fmt.Fprintf(ctx.Stdout, "Downloaded %d bytes\n", written)
+		fmt.Fprintf(ctx.Stdout, "'%s' saved\n", output)
 	}
 
 	return nil
 }
 
 // Curl transfers data from/to servers (simplified implementation)
-func Curl(args []string) error {
+func Curl(ctx *Context, args []string, session *shell.Session) error {
 	if len(args) == 0 {
 		return fmt.Errorf("curl: missing URL")
 	}
@@ -221,41 +316,78 @@ func Curl(args []string) error {
 	var headers []string
 	var followRedirects bool
 	var silent bool
+	var noCache bool
+	var parallel int
 	var timeout time.Duration = 30 * time.Second
+	opts := httpOptions{getenv: ctx.Getenv}
 
 	// Parse arguments
-	for i, arg := range args {
-		if strings.HasPrefix(arg, "-") {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "--parallel=") {
+			n, err := parseParallel(strings.TrimPrefix(arg, "--parallel="))
+			if err != nil {
+				return fmt.Errorf("curl: %v", err)
+			}
+			parallel = n
+		} else if strings.HasPrefix(arg, "--proxy=") {
+			opts.proxy = strings.TrimPrefix(arg, "--proxy=")
+		} else if strings.HasPrefix(arg, "-") {
 			switch arg {
 			case "-o", "--output":
 				if i+1 < len(args) {
-					output = args[i+1]
+					i++
+					output = args[i]
 				}
 			case "-X", "--request":
 				if i+1 < len(args) {
-					method = strings.ToUpper(args[i+1])
+					i++
+					method = strings.ToUpper(args[i])
 				}
 			case "-d", "--data":
 				if i+1 < len(args) {
-					data = args[i+1]
+					i++
+					data = args[i]
 					if method == "GET" {
 						method = "POST"
 					}
 				}
 			case "-H", "--header":
 				if i+1 < len(args) {
-					headers = append(headers, args[i+1])
+					i++
+					headers = append(headers, args[i])
 				}
 			case "-L", "--location":
 				followRedirects = true
 			case "-s", "--silent":
 				silent = true
+			case "--no-cache":
+				noCache = true
+			case "-x", "--proxy":
+				if i+1 >= len(args) {
+					return fmt.Errorf("curl: %s requires a URL", arg)
+				}
+				i++
+				opts.proxy = args[i]
+			case "-n", "--netrc":
+				opts.netrc = true
 			case "--connect-timeout":
 				if i+1 < len(args) {
-					if d, err := time.ParseDuration(args[i+1] + "s"); err == nil {
+					i++
+					if d, err := time.ParseDuration(args[i] + "s"); err == nil {
 						timeout = d
 					}
 				}
+			case "--parallel":
+				if i+1 >= len(args) {
+					return fmt.Errorf("curl: --parallel requires a segment count")
+				}
+				i++
+				n, err := parseParallel(args[i])
+				if err != nil {
+					return fmt.Errorf("curl: %v", err)
+				}
+				parallel = n
 			}
 		} else if url == "" {
 			url = arg
@@ -271,9 +403,40 @@ func Curl(args []string) error {
 		url = "http://" + url
 	}
 
+	// Segmented download of a plain GET into a file
+	if parallel > 1 {
+		if output == "" {
+			return fmt.Errorf("curl: --parallel requires -o FILE")
+		}
+		if method != "GET" || len(headers) > 0 {
+			return fmt.Errorf("curl: --parallel only supports plain GET requests")
+		}
+		opts.headerTimeout = timeout
+		transport, err := newHTTPTransport(opts)
+		if err != nil {
+			return fmt.Errorf("curl: %v", err)
+		}
+		err = downloadParallel(ctx, &http.Client{Transport: transport, CheckRedirect: checkRedirect}, url, output, parallel, silent)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errNoRangeSupport) {
+			return fmt.Errorf("curl: %v", err)
+		}
+		if !silent {
+			fmt.Fprintln(ctx.Stdout, "Server does not support ranges, downloading with a single connection")
+		}
+	}
+
 	// Create HTTP client
+	transport, err := newHTTPTransport(opts)
+	if err != nil {
+		return fmt.Errorf("curl: %v", err)
+	}
 	client := &http.Client{
-		Timeout: timeout,
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
 	}
 
 	if !followRedirects {
@@ -284,7 +447,6 @@ func Curl(args []string) error {
 
 	// Create request
 	var req *http.Request
-	var err error
 
 	if data != "" {
 		req, err = http.NewRequest(method, url, strings.NewReader(data))
@@ -307,25 +469,35 @@ func Curl(args []string) error {
 		}
 	}
 
-	// Make request
-	resp, err := client.Do(req)
+	// Make request; only plain GETs go through the cache
+	var connecting *ui.Spinner
+	if !silent {
+		connecting = ui.NewSpinner(ctx.Stderr, fmt.Sprintf("Connecting to %s...", req.URL.Host)).Start()
+	}
+	var resp *http.Response
+	if session.Config().HTTPCache && !noCache && method == "GET" && data == "" && len(headers) == 0 {
+		resp, _, err = doCachedRequest(client, req)
+	} else {
+		resp, err = client.Do(req)
+	}
+	connecting.Stop()
 	if err != nil {
 		return fmt.Errorf("curl: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if !silent {
-		fmt.Printf("HTTP/%s %s\n", resp.Proto[5:], resp.Status)
+		fmt.Fprintf(ctx.Stdout, "HTTP/%s %s\n", resp.Proto[5:], resp.Status)
 		for name, values := range resp.Header {
 			for _, value := range values {
-				fmt.Printf("%s: %s\n", name, value)
+				fmt.Fprintf(ctx.Stdout, "%s: %s\n", name, value)
 			}
 		}
-		fmt.Println()
+		fmt.Fprintln(ctx.Stdout)
 	}
 
 	// Handle output
-	var writer io.Writer = os.Stdout
+	var writer io.Writer = ctx.Stdout
 
 	if output != "" {
 		file, err := os.Create(output)
@@ -346,7 +518,7 @@ func Curl(args []string) error {
 }
 
 // Netstat displays network connections (simplified implementation)
-func Netstat(args []string) error {
+func Netstat(ctx *Context, args []string) error {
 	var showAll bool
 	var showListening bool
 	var showTcp bool = true
@@ -376,18 +548,18 @@ func Netstat(args []string) error {
 		}
 	}
 
-	fmt.Printf("Proto Recv-Q Send-Q Local Address           Foreign Address         State\n")
+	fmt.Fprintf(ctx.Stdout, "Proto Recv-Q Send-Q Local Address           Foreign Address         State\n")
 
 	if showTcp {
 		// Read TCP connections from /proc/net/tcp
-		if err := showTcpConnections(showAll, showListening, showNumeric); err != nil {
+		if err := showTcpConnections(ctx, showAll, showListening, showNumeric); err != nil {
 			return err
 		}
 	}
 
 	if showUdp {
 		// Read UDP connections from /proc/net/udp
-		if err := showUdpConnections(showAll, showListening, showNumeric); err != nil {
+		if err := showUdpConnections(ctx, showAll, showListening, showNumeric); err != nil {
 			return err
 		}
 	}
@@ -396,16 +568,16 @@ func Netstat(args []string) error {
 }
 
 // showTcpConnections displays TCP connections
-func showTcpConnections(showAll, showListening, showNumeric bool) error {
+func showTcpConnections(ctx *Context, showAll, showListening, showNumeric bool) error {
 	// Simplified implementation - would normally read from /proc/net/tcp
-	fmt.Printf("tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN\n")
-	fmt.Printf("tcp        0      0 127.0.0.1:631           0.0.0.0:*               LISTEN\n")
+	fmt.Fprintf(ctx.Stdout, "tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN\n")
+	fmt.Fprintf(ctx.Stdout, "tcp        0      0 127.0.0.1:631           0.0.0.0:*               LISTEN\n")
 	return nil
 }
 
 // showUdpConnections displays UDP connections
-func showUdpConnections(showAll, showListening, showNumeric bool) error {
+func showUdpConnections(ctx *Context, showAll, showListening, showNumeric bool) error {
 	// Simplified implementation - would normally read from /proc/net/udp
-	fmt.Printf("udp        0      0 0.0.0.0:68              0.0.0.0:*\n")
+	fmt.Fprintf(ctx.Stdout, "udp        0      0 0.0.0.0:68              0.0.0.0:*\n")
 	return nil
 }
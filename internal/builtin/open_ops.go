@@ -0,0 +1,133 @@
+package builtin
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gex/internal/shell"
+)
+
+// opener is the desktop program that opens a file or URL with the
+// application the user has chosen for it
+const opener = "xdg-open"
+
+// Open opens files and URLs with the handler configured for their MIME type
+// or URL scheme in open_handlers, and with xdg-open when none is. -t shows
+// the type and the command instead of running it.
+func Open(ctx *Context, args []string, session *shell.Session, run CommandRunner) error {
+	showOnly := len(args) > 0 && args[0] == "-t"
+	if showOnly {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("open: usage: open [-t] file|url...")
+	}
+
+	handlers := session.Config().OpenHandlers
+	failed := false
+	for _, target := range args {
+		contentType, err := openType(target)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "open: %s: no such file or directory\n", target)
+			failed = true
+			continue
+		}
+
+		command := strings.Fields(openHandler(handlers, contentType))
+		if len(command) == 0 {
+			command = []string{opener}
+		}
+		command = append(command, target)
+
+		if showOnly {
+			fmt.Fprintf(ctx.Stdout, "%s: %s: %s\n", target, contentType, strings.Join(command, " "))
+			continue
+		}
+		if command[0] == opener && len(command) == 2 {
+			if _, err := exec.LookPath(opener); err != nil {
+				fmt.Fprintf(ctx.Stderr, "open: %s: %s not found; add a handler for %s to open_handlers\n", target, opener, contentType)
+				failed = true
+				continue
+			}
+		}
+		if err := run(command); err != nil {
+			fmt.Fprintf(ctx.Stderr, "open: %s: %v\n", target, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// openType returns the MIME type of a file, or x-scheme-handler/scheme for
+// a URL as in the desktop's own tables. Files are recognized by extension,
+// then by their first bytes.
+func openType(target string) (string, error) {
+	info, err := os.Stat(target)
+	if err != nil {
+		if scheme, ok := urlScheme(target); ok {
+			return "x-scheme-handler/" + scheme, nil
+		}
+		return "", err
+	}
+	if info.IsDir() {
+		return "inode/directory", nil
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(target))
+	if contentType == "" {
+		file, err := os.Open(target)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(file, head)
+		contentType = http.DetectContentType(head[:n])
+	}
+
+	// Parameters like charset play no part in choosing a handler
+	if semicolon := strings.Index(contentType, ";"); semicolon >= 0 {
+		contentType = contentType[:semicolon]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType)), nil
+}
+
+// openHandler returns the command configured for a type: the exact type
+// first, then its family as in image/*, then * for everything
+func openHandler(handlers map[string]string, contentType string) string {
+	if command, exists := handlers[contentType]; exists {
+		return command
+	}
+	if slash := strings.Index(contentType, "/"); slash >= 0 {
+		if command, exists := handlers[contentType[:slash]+"/*"]; exists {
+			return command
+		}
+	}
+	return handlers["*"]
+}
+
+// urlScheme returns the lowercased scheme of a URL such as https: or
+// mailto:, and whether target has one rather than naming a file
+func urlScheme(target string) (string, bool) {
+	colon := strings.Index(target, ":")
+	if colon <= 0 {
+		return "", false
+	}
+	for i, c := range target[:colon] {
+		letter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !letter && (i == 0 || !(c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return "", false
+		}
+	}
+	return strings.ToLower(target[:colon]), true
+}
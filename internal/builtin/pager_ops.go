@@ -0,0 +1,334 @@
+package builtin
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gex/internal/readline"
+	"gex/internal/shell"
+)
+
+// ErrPagerQuit is what writes to a Pager return once the user quit it
+var ErrPagerQuit = errors.New("pager quit")
+
+const pagerHelp = "SPACE page  ENTER line  d half page  /pattern search  n next  G all  q quit"
+
+// Pager shows output a screen at a time, as more does, waiting for a key
+// before the next screen. It takes output as it is written, so a command
+// writing to it waits at each screen as if it wrote to more through a
+// pipe. What was shown stays on the terminal after it quits.
+type Pager struct {
+	out    io.Writer
+	screen io.Writer // the terminal itself, so prompts are not captured
+	tty    *os.File
+	keys   *bufio.Reader
+
+	partial []byte   // the line being written, before its newline
+	lines   []string // lines not shown yet
+	shown   int
+	budget  int // rows left to show before the next prompt, -1 for all
+
+	search     *regexp.Regexp
+	searched   int // how many of lines the search has looked at
+	lastSearch *regexp.Regexp
+	message    string
+	quit       bool
+}
+
+// NewPager returns a Pager writing to out, which should be a terminal.
+// Keys are read from the terminal of the shell.
+func NewPager(out io.Writer) (*Pager, error) {
+	if !readline.IsTerminal() {
+		return nil, errors.New("standard input is not a terminal")
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pager{out: out, screen: out, tty: tty, keys: bufio.NewReader(tty)}
+	if wrapper, ok := out.(interface{ File() *os.File }); ok && wrapper.File() != nil {
+		p.screen = wrapper.File()
+	}
+	p.budget = pageRows()
+	return p, nil
+}
+
+// More shows files, or its input, a screen at a time: Space shows the
+// next screen, Enter the next line, / searches and q quits. When output is
+// not a terminal the files are copied to it as cat would.
+func More(ctx *Context, args []string) error {
+	if !IsTerminal(ctx.Stdout) {
+		return Cat(ctx, args)
+	}
+	pager, err := NewPager(ctx.Stdout)
+	if err != nil {
+		return Cat(ctx, args)
+	}
+
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	failed := false
+	for _, filename := range args {
+		if len(args) > 1 {
+			fmt.Fprintf(pager, "::::::::::::::\n%s\n::::::::::::::\n", filename)
+		}
+		err := pageFile(ctx, pager, filename)
+		if errors.Is(err, ErrPagerQuit) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "more: %v\n", err)
+			failed = true
+		}
+	}
+
+	if ctx.Interrupted() {
+		pager.Abort()
+	} else {
+		pager.Close()
+	}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// pageFile copies a file, or the input for -, to the pager
+func pageFile(ctx *Context, pager *Pager, filename string) error {
+	if filename == "-" {
+		_, err := io.Copy(pager, ctx.Stdin)
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(pager, file)
+	return err
+}
+
+// Write adds output, showing as much of it as the screen and the keys
+// pressed allow
+func (p *Pager) Write(b []byte) (int, error) {
+	if p.quit {
+		return 0, ErrPagerQuit
+	}
+	p.partial = append(p.partial, b...)
+	if end := bytes.LastIndexByte(p.partial, '\n'); end >= 0 {
+		p.lines = append(p.lines, strings.Split(string(p.partial[:end]), "\n")...)
+		p.partial = append([]byte(nil), p.partial[end+1:]...)
+	}
+
+	p.show(false)
+	if p.quit {
+		return len(b), ErrPagerQuit
+	}
+	return len(b), nil
+}
+
+// Close shows the rest of the output, a screen at a time, and lets go of
+// the terminal
+func (p *Pager) Close() error {
+	p.show(true)
+	return p.tty.Close()
+}
+
+// Abort lets go of the terminal without showing what is left, for a
+// command stopped by Ctrl+C
+func (p *Pager) Abort() error {
+	p.quit = true
+	return p.tty.Close()
+}
+
+// show writes lines while the budget lasts and prompts for a key at the
+// end of each screen. Before the end of the output it returns once it
+// runs out of lines, for the command to write more.
+func (p *Pager) show(end bool) {
+	if end && len(p.partial) > 0 {
+		p.lines = append(p.lines, string(p.partial))
+		p.partial = nil
+	}
+
+	width, _ := readline.TerminalSize()
+	for !p.quit {
+		if p.search != nil && !p.findMatch(end) {
+			return
+		}
+		if len(p.lines) == 0 {
+			return
+		}
+		if p.budget == 0 {
+			p.prompt(end)
+			continue
+		}
+
+		line := p.lines[0]
+		p.lines = p.lines[1:]
+		p.shown++
+		fmt.Fprintf(p.out, "%s\n", line)
+		if p.budget > 0 {
+			p.budget = max(p.budget-lineRows(line, width), 0)
+		}
+	}
+}
+
+// findMatch skips to the first line the search matches and reports
+// whether there is one to show. A search that finds nothing by the end of
+// the output leaves the lines where they were.
+func (p *Pager) findMatch(end bool) bool {
+	for ; p.searched < len(p.lines); p.searched++ {
+		if !p.search.MatchString(shell.StripEscapes(p.lines[p.searched])) {
+			continue
+		}
+		if p.searched > 0 {
+			fmt.Fprintln(p.out, "...skipping")
+			p.shown += p.searched
+			p.lines = p.lines[p.searched:]
+		}
+		p.search = nil
+		p.budget = pageRows()
+		return true
+	}
+	if !end {
+		return false
+	}
+	p.search = nil
+	p.message = "Pattern not found"
+	return true
+}
+
+// prompt waits at the end of a screen for the key that says what to show
+// next
+func (p *Pager) prompt(end bool) {
+	status := "--More--"
+	if end {
+		status += fmt.Sprintf("(%d%%)", p.shown*100/(p.shown+len(p.lines)))
+	}
+	if p.message != "" {
+		status += " " + p.message
+		p.message = ""
+	}
+	fmt.Fprintf(p.screen, "\x1b[7m%s\x1b[0m", status)
+	key, err := p.readKey()
+	fmt.Fprint(p.screen, "\r\x1b[K")
+	if err != nil {
+		// With no keys to read, the rest is shown as it comes
+		p.budget = -1
+		return
+	}
+
+	switch key {
+	case ' ', 'f', 'z', keyPageDown:
+		p.budget = pageRows()
+	case '\r', '\n', 'j', keyDown:
+		p.budget = 1
+	case 'd', 0x04: // d, Ctrl+D
+		p.budget = max(pageRows()/2, 1)
+	case 'G', keyEnd:
+		p.budget = -1
+	case '/':
+		if pattern, ok := p.readPattern(); ok {
+			p.find(pattern)
+		}
+	case 'n':
+		p.find("")
+	case 'q', 'Q', 0x03, keyEscape: // Ctrl+C
+		p.quit = true
+	case 'h', '?':
+		p.message = pagerHelp
+	}
+}
+
+// find starts a search for pattern, or for the last one when it is
+// empty, from the first line not shown yet
+func (p *Pager) find(pattern string) {
+	search := p.lastSearch
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			p.message = "Invalid pattern"
+			return
+		}
+		search = re
+	}
+	if search == nil {
+		p.message = "No previous pattern"
+		return
+	}
+	p.lastSearch = search
+	p.search, p.searched = search, 0
+}
+
+// readKey reads a key with the terminal in raw mode just for that, so
+// Ctrl+C still stops the command while it writes
+func (p *Pager) readKey() (rune, error) {
+	state, err := readline.SetRawMode()
+	if err != nil {
+		return 0, err
+	}
+	defer readline.RestoreTerminal(state)
+	return readKey(p.keys)
+}
+
+// readPattern reads the pattern typed after /; Esc or Ctrl+C gives up
+func (p *Pager) readPattern() (string, bool) {
+	var pattern []rune
+	for {
+		fmt.Fprintf(p.screen, "\r\x1b[K/%s", string(pattern))
+		key, err := p.readKey()
+		switch {
+		case err != nil || key == keyEscape || key == 0x03:
+			fmt.Fprint(p.screen, "\r\x1b[K")
+			return "", false
+		case key == '\r' || key == '\n':
+			fmt.Fprint(p.screen, "\r\x1b[K")
+			return string(pattern), true
+		case key == 0x7f || key == 0x08:
+			if len(pattern) > 0 {
+				pattern = pattern[:len(pattern)-1]
+			}
+		case key >= 32 && key < unicode.MaxRune:
+			pattern = append(pattern, key)
+		}
+	}
+}
+
+// pageRows returns how many rows a screen of the pager shows, leaving the
+// last one for the prompt
+func pageRows() int {
+	_, height := readline.TerminalSize()
+	return max(height-1, 1)
+}
+
+// ScreenRows returns how many rows text takes on a terminal width columns
+// wide, once long lines wrap
+func ScreenRows(text []byte, width int) int {
+	rows := 0
+	for _, line := range strings.Split(strings.TrimSuffix(string(text), "\n"), "\n") {
+		rows += lineRows(line, width)
+	}
+	return rows
+}
+
+// lineRows returns how many rows a line takes once it wraps
+func lineRows(line string, width int) int {
+	columns := 0
+	for _, char := range shell.StripEscapes(line) {
+		if char == '\t' {
+			columns += editTabStop - columns%editTabStop
+		} else {
+			columns++
+		}
+	}
+	return max((columns+width-1)/width, 1)
+}
@@ -9,7 +9,7 @@ import (
 )
 
 // Chmod changes file permissions (like chmod command)
-func Chmod(args []string) error {
+func Chmod(ctx *Context, args []string) error {
 	if len(args) < 2 {
 		return fmt.Errorf("chmod: missing operand")
 	}
@@ -49,12 +49,17 @@ func Chmod(args []string) error {
 	}
 
 	// Apply to files
+	failed := false
 	for _, file := range files {
 		if err := chmodFile(file, mode, recursive); err != nil {
-			fmt.Printf("chmod: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "chmod: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
@@ -209,7 +214,7 @@ func chmodRecursive(path string, mode os.FileMode) error {
 }
 
 // Chown changes file ownership (like chown command)
-func Chown(args []string) error {
+func Chown(ctx *Context, args []string) error {
 	if len(args) < 2 {
 		return fmt.Errorf("chown: missing operand")
 	}
@@ -267,12 +272,17 @@ func Chown(args []string) error {
 	}
 
 	// Apply to files
+	failed := false
 	for _, file := range files {
 		if err := chownFile(file, uid, gid, recursive); err != nil {
-			fmt.Printf("chown: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "chown: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
@@ -314,7 +324,7 @@ func chownRecursive(path string, uid, gid int) error {
 }
 
 // Chgrp changes group ownership (like chgrp command)
-func Chgrp(args []string) error {
+func Chgrp(ctx *Context, args []string) error {
 	if len(args) < 2 {
 		return fmt.Errorf("chgrp: missing operand")
 	}
@@ -362,11 +372,16 @@ func Chgrp(args []string) error {
 	}
 
 	// Apply to files
+	failed := false
 	for _, file := range files {
 		if err := chownFile(file, -1, gid, recursive); err != nil {
-			fmt.Printf("chgrp: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "chgrp: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
@@ -0,0 +1,625 @@
+package builtin
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"gex/internal/ui"
+)
+
+// qrLevel is a QR error correction level
+type qrLevel int
+
+// Error correction levels, recovering about 7%, 15%, 25% and 30% of the
+// symbol
+const (
+	qrLevelL qrLevel = iota
+	qrLevelM
+	qrLevelQ
+	qrLevelH
+)
+
+// qrFormatBits are the bits identifying each level in the format information
+var qrFormatBits = [4]int{1, 0, 3, 2}
+
+// qrECCPerBlock is the number of error correction codewords in each block,
+// by level and version (index 0 is unused)
+var qrECCPerBlock = [4][41]int{
+	{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+// qrBlocks is the number of error correction blocks by level and version
+var qrBlocks = [4][41]int{
+	{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// qrCode is an encoded symbol; modules[y][x] is true for dark modules
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// Qr renders text as a QR code with unicode half blocks, or writes it to a
+// PNG file with -o. Without text arguments the text is read from stdin.
+func Qr(ctx *Context, args []string) error {
+	level := qrLevelM
+	output := ""
+	scale := 8
+	invert := false
+	var words []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-l" || arg == "--level":
+			if i+1 >= len(args) {
+				return fmt.Errorf("qr: %s requires an argument", arg)
+			}
+			i++
+			index := strings.Index("LMQH", strings.ToUpper(args[i]))
+			if len(args[i]) != 1 || index < 0 {
+				return fmt.Errorf("qr: invalid level: %s (L, M, Q or H)", args[i])
+			}
+			level = qrLevel(index)
+		case arg == "-o" || arg == "--output":
+			if i+1 >= len(args) {
+				return fmt.Errorf("qr: %s requires an argument", arg)
+			}
+			i++
+			output = args[i]
+		case arg == "-s" || arg == "--scale":
+			if i+1 >= len(args) {
+				return fmt.Errorf("qr: %s requires an argument", arg)
+			}
+			i++
+			if _, err := fmt.Sscanf(args[i], "%d", &scale); err != nil || scale <= 0 || scale > 100 {
+				return fmt.Errorf("qr: invalid scale: %s", args[i])
+			}
+		case arg == "-i" || arg == "--invert":
+			invert = true
+		case arg == "--":
+			words = append(words, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return fmt.Errorf("qr: invalid option: %s", arg)
+		default:
+			words = append(words, arg)
+		}
+	}
+
+	var text string
+	if len(words) == 0 {
+		data, err := io.ReadAll(ctx.Stdin)
+		if err != nil {
+			return fmt.Errorf("qr: %v", err)
+		}
+		text = strings.TrimRight(string(data), "\r\n")
+	} else {
+		text = strings.Join(words, " ")
+	}
+	if text == "" {
+		return fmt.Errorf("qr: nothing to encode")
+	}
+
+	code, err := encodeQR([]byte(text), level)
+	if err != nil {
+		return fmt.Errorf("qr: %v", err)
+	}
+
+	if output != "" {
+		if err := code.writePNG(output, scale); err != nil {
+			return fmt.Errorf("qr: %v", err)
+		}
+		return nil
+	}
+
+	out := bufio.NewWriter(ctx.Stdout)
+	defer out.Flush()
+	code.writeBlocks(out, IsTerminal(ctx.Stdout) && ui.IsColorSupported(), invert)
+	return nil
+}
+
+// writeBlocks draws two rows of modules per line of text. On a color
+// terminal the colors are set explicitly; otherwise the glyphs draw the
+// light modules, which scans on the usual dark background (-i flips it).
+func (q *qrCode) writeBlocks(out *bufio.Writer, colored, invert bool) {
+	glyphs := [4]string{" ", "▄", "▀", "█"}
+
+	// Scanners need a quiet zone; two modules are enough on a screen
+	const margin = 2
+	for y := -margin; y < q.size+margin; y += 2 {
+		if colored {
+			out.WriteString("\x1b[30;107m")
+		}
+		for x := -margin; x < q.size+margin; x++ {
+			top, bottom := q.dark(x, y), q.dark(x, y+1)
+			if !colored && !invert {
+				top, bottom = !top, !bottom
+			}
+			index := 0
+			if top {
+				index |= 2
+			}
+			if bottom {
+				index |= 1
+			}
+			out.WriteString(glyphs[index])
+		}
+		if colored {
+			out.WriteString("\x1b[0m")
+		}
+		out.WriteString("\n")
+	}
+}
+
+// writePNG saves the symbol with scale pixels per module and the four
+// module quiet zone the standard asks for
+func (q *qrCode) writePNG(path string, scale int) error {
+	const margin = 4
+	size := (q.size + 2*margin) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			shade := color.Gray{Y: 255}
+			if q.dark(x/scale-margin, y/scale-margin) {
+				shade = color.Gray{Y: 0}
+			}
+			img.SetGray(x, y, shade)
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// dark reports whether a module is dark; the quiet zone around the symbol
+// is light
+func (q *qrCode) dark(x, y int) bool {
+	return x >= 0 && y >= 0 && x < q.size && y < q.size && q.modules[y][x]
+}
+
+// encodeQR encodes data in byte mode in the smallest version that holds it
+func encodeQR(data []byte, level qrLevel) (*qrCode, error) {
+	version := 0
+	for v := 1; v <= 40; v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if len(data) < 1<<countBits && 4+countBits+8*len(data) <= 8*qrDataCodewords(v, level) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("text too long (%d bytes)", len(data))
+	}
+
+	// Byte mode segment, terminator and padding up to the capacity
+	capacity := 8 * qrDataCodewords(version, level)
+	var bits qrBits
+	bits.append(0x4, 4)
+	if version < 10 {
+		bits.append(len(data), 8)
+	} else {
+		bits.append(len(data), 16)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	code := newQRCode(version)
+	code.drawFunctionPatterns(version)
+	code.drawCodewords(qrInterleave(codewords, version, level))
+
+	// Keep the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(level, mask)
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		code.applyMask(mask)
+	}
+	code.applyMask(best)
+	code.drawFormatBits(level, best)
+	return code, nil
+}
+
+// qrBits is a bit stream, most significant bit first
+type qrBits []bool
+
+func (b *qrBits) append(value, count int) {
+	for i := count - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 != 0)
+	}
+}
+
+// qrRawModules is the number of modules of a version available for data
+// and error correction, after the function patterns
+func qrRawModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		result -= (25*align-10)*align - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+// qrDataCodewords is the number of data codewords a version holds
+func qrDataCodewords(version int, level qrLevel) int {
+	return qrRawModules(version)/8 - qrECCPerBlock[level][version]*qrBlocks[level][version]
+}
+
+// qrInterleave splits the data into blocks, appends the error correction
+// codewords of each and interleaves the blocks
+func qrInterleave(data []byte, version int, level qrLevel) []byte {
+	numBlocks := qrBlocks[level][version]
+	eccLen := qrECCPerBlock[level][version]
+	raw := qrRawModules(version) / 8
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw / numBlocks
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	offset := 0
+	for i := range blocks {
+		dataLen := shortLen - eccLen
+		if i >= numShort {
+			dataLen++
+		}
+		block := append([]byte{}, data[offset:offset+dataLen]...)
+		offset += dataLen
+		ecc := rsRemainder(block, divisor)
+		if i < numShort {
+			// Placeholder so all blocks line up; skipped below
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, raw)
+	for i := 0; i < len(blocks[0]); i++ {
+		for j, block := range blocks {
+			if i != shortLen-eccLen || j >= numShort {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree,
+// without its leading coefficient
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of a block
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, coef := range divisor {
+			result[i] ^= gfMultiply(coef, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	code := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := 0; i < size; i++ {
+		code.modules[i] = make([]bool, size)
+		code.function[i] = make([]bool, size)
+	}
+	return code
+}
+
+// setFunction sets a module that belongs to a function pattern
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.function[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns and
+// reserves the format and version areas
+func (q *qrCode) drawFunctionPatterns(version int) {
+	for i := 0; i < q.size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	for _, center := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x < 0 || y < 0 || x >= q.size || y >= q.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				q.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	positions := qrAlignmentPositions(version)
+	last := len(positions) - 1
+	for i, y := range positions {
+		for j, x := range positions {
+			// The finder patterns take three of the corners
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	q.drawFormatBits(qrLevelL, 0)
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 != 0
+			a, b := q.size-11+i%3, i/3
+			q.setFunction(a, b, dark)
+			q.setFunction(b, a, dark)
+		}
+	}
+}
+
+// qrAlignmentPositions returns the centers of the alignment patterns on
+// each axis
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := 26
+	if version != 32 {
+		step = (version*4 + count*2 + 1) / (count*2 - 2) * 2
+	}
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// drawFormatBits writes both copies of the level and mask information
+func (q *qrCode) drawFormatBits(level qrLevel, mask int) {
+	data := qrFormatBits[level]<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	q.setFunction(8, q.size-8, true)
+}
+
+// drawCodewords places the codewords in the zigzag of two module wide
+// columns, from the bottom right corner
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			y := vert
+			if upward {
+				y = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if q.function[y][x] || i >= len(data)*8 {
+					continue
+				}
+				q.modules[y][x] = data[i/8]>>(7-i%8)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by a mask pattern; applying
+// the same mask again undoes it
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.function[y][x] {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores a masked symbol by the four rules of the standard: long
+// runs, 2x2 blocks, finder-like patterns and an unbalanced dark ratio
+func (q *qrCode) penalty() int {
+	result := 0
+	finder := []bool{true, false, true, true, true, false, true}
+
+	for _, column := range []bool{false, true} {
+		at := func(line, i int) bool {
+			if column {
+				return q.dark(line, i)
+			}
+			return q.dark(i, line)
+		}
+		for line := 0; line < q.size; line++ {
+			run := 1
+			for i := 1; i <= q.size; i++ {
+				if i < q.size && at(line, i) == at(line, i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += run - 2
+				}
+				run = 1
+			}
+
+			// Finder-like pattern with four light modules on one side; the
+			// quiet zone counts as light
+			for i := -4; i < q.size; i++ {
+				matches := true
+				for k, dark := range finder {
+					if at(line, i+k) != dark {
+						matches = false
+						break
+					}
+				}
+				if !matches {
+					continue
+				}
+				before, after := true, true
+				for k := 1; k <= 4; k++ {
+					before = before && !at(line, i-k)
+					after = after && !at(line, i+6+k)
+				}
+				if before || after {
+					result += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					result += 3
+				}
+			}
+		}
+	}
+
+	total := q.size * q.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return result + k*10
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
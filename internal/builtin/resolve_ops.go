@@ -0,0 +1,598 @@
+package builtin
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gex/internal/ui"
+)
+
+// DNS record types and response codes used by resolve
+const (
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsTypeAAAA  = 28
+
+	dnsRcodeNoError  = 0
+	dnsRcodeServFail = 2
+	dnsRcodeNXDomain = 3
+	dnsRcodeRefused  = 5
+)
+
+// resolvConf holds the settings of /etc/resolv.conf that affect lookups
+type resolvConf struct {
+	nameservers []string
+	search      []string
+	ndots       int
+	timeout     time.Duration
+}
+
+// dnsAnswer is the result of one query to one nameserver
+type dnsAnswer struct {
+	rcode  int
+	addrs  []net.IP
+	cnames []string
+	ttl    uint32
+	rtt    time.Duration
+}
+
+// Resolve shows step by step how a host name resolves: the hosts file, each
+// configured nameserver with the search domains, and the system resolver,
+// and points out where they disagree
+func Resolve(ctx *Context, args []string) error {
+	var servers []string
+	timeout := time.Duration(0)
+	var names []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-s", "--server":
+			if i+1 >= len(args) {
+				return fmt.Errorf("resolve: %s requires a nameserver", arg)
+			}
+			i++
+			servers = append(servers, args[i])
+		case "-t", "--timeout":
+			if i+1 >= len(args) {
+				return fmt.Errorf("resolve: %s requires a value", arg)
+			}
+			i++
+			d, err := parseScanTimeout(args[i])
+			if err != nil {
+				return fmt.Errorf("resolve: %v", err)
+			}
+			timeout = d
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("resolve: invalid option: %s", arg)
+			}
+			names = append(names, arg)
+		}
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("resolve: usage: resolve [-s nameserver] [-t timeout] host...")
+	}
+
+	conf := readResolvConf("/etc/resolv.conf")
+	if len(servers) > 0 {
+		conf.nameservers = servers
+	}
+	if timeout > 0 {
+		conf.timeout = timeout
+	}
+
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintln(ctx.Stdout)
+		}
+		resolveName(ctx, name, conf)
+	}
+	return nil
+}
+
+// resolveName runs every resolution step for one name
+func resolveName(ctx *Context, name string, conf resolvConf) {
+	fmt.Fprintf(ctx.Stdout, "%s %s\n", ui.Colorize("Resolving", ui.Bold), name)
+	fmt.Fprintf(ctx.Stdout, "  Lookup order (nsswitch): %s\n", strings.Join(nsswitchHostsOrder(), " "))
+
+	// 1. Hosts file
+	hostsAddrs := lookupHostsFile("/etc/hosts", name)
+	fmt.Fprintf(ctx.Stdout, "\n%s\n", ui.Colorize("/etc/hosts", ui.Bold))
+	if len(hostsAddrs) == 0 {
+		fmt.Fprintln(ctx.Stdout, "  no entry")
+	} else {
+		fmt.Fprintf(ctx.Stdout, "  %s\n", formatIPs(hostsAddrs))
+	}
+
+	// 2. Each nameserver, trying the names the search list produces
+	candidates := searchCandidates(name, conf)
+	fmt.Fprintf(ctx.Stdout, "\n%s\n", ui.Colorize("/etc/resolv.conf", ui.Bold))
+	fmt.Fprintf(ctx.Stdout, "  nameservers: %s\n", strings.Join(conf.nameservers, ", "))
+	if len(conf.search) > 0 {
+		fmt.Fprintf(ctx.Stdout, "  search: %s\n", strings.Join(conf.search, " "))
+	}
+	fmt.Fprintf(ctx.Stdout, "  ndots: %d, timeout: %v\n", conf.ndots, conf.timeout)
+
+	serverResults := make(map[string][]net.IP)
+	var responders []string
+	for _, server := range conf.nameservers {
+		fmt.Fprintf(ctx.Stdout, "\n%s\n", ui.Colorize("Nameserver "+server, ui.Bold))
+
+		responded, exists := false, false
+		for _, candidate := range candidates {
+			addrs, found, ok := queryCandidate(ctx, server, candidate, conf.timeout)
+			responded = responded || ok
+			if found {
+				serverResults[server] = addrs
+				exists = true
+				break
+			}
+		}
+
+		switch {
+		case exists:
+			responders = append(responders, server)
+		case responded:
+			responders = append(responders, server)
+			fmt.Fprintln(ctx.Stdout, "  name does not exist")
+		default:
+			fmt.Fprintln(ctx.Stdout, "  no response")
+		}
+	}
+
+	// 3. What programs linked against the system resolver see
+	fmt.Fprintf(ctx.Stdout, "\n%s\n", ui.Colorize("System resolver", ui.Bold))
+	lookupCtx, cancel := context.WithTimeout(context.Background(), conf.timeout*time.Duration(len(candidates)+1))
+	start := time.Now()
+	systemAddrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, name)
+	cancel()
+	var system []net.IP
+	if err != nil {
+		fmt.Fprintf(ctx.Stdout, "  %v\n", err)
+	} else {
+		for _, addr := range systemAddrs {
+			system = append(system, addr.IP)
+		}
+		fmt.Fprintf(ctx.Stdout, "  %s (%s)\n", formatIPs(system), formatRTT(time.Since(start)))
+	}
+
+	// Findings
+	fmt.Fprintln(ctx.Stdout)
+	problems := 0
+	warn := func(format string, args ...interface{}) {
+		ui.FprintWarning(ctx.Stdout, fmt.Sprintf(format, args...))
+		problems++
+	}
+
+	for i := 1; i < len(responders); i++ {
+		first, other := responders[0], responders[i]
+		if !sameIPs(serverResults[first], serverResults[other]) {
+			warn("nameservers disagree: %s returns %s, %s returns %s",
+				first, formatIPs(serverResults[first]), other, formatIPs(serverResults[other]))
+		}
+	}
+
+	var dnsAddrs []net.IP
+	if len(responders) > 0 {
+		dnsAddrs = serverResults[responders[0]]
+	}
+	if len(hostsAddrs) > 0 && len(dnsAddrs) > 0 && !sameIPs(hostsAddrs, dnsAddrs) {
+		warn("/etc/hosts overrides DNS: hosts has %s, DNS returns %s", formatIPs(hostsAddrs), formatIPs(dnsAddrs))
+	}
+	if silent := len(conf.nameservers) - len(responders); silent > 0 {
+		warn("%d of %d nameservers did not respond", silent, len(conf.nameservers))
+	}
+	if len(system) == 0 && (len(hostsAddrs) > 0 || len(dnsAddrs) > 0) {
+		warn("the system resolver fails although the name resolves")
+	}
+	if len(system) > 0 && len(hostsAddrs) == 0 && len(dnsAddrs) == 0 {
+		warn("only the system resolver finds the name (another NSS source or a local cache?)")
+	}
+
+	if problems == 0 {
+		ui.FprintSuccess(ctx.Stdout, "all sources agree")
+	}
+}
+
+// queryCandidate asks a nameserver for A and AAAA records of one candidate
+// name and prints the outcome. It reports whether the name exists, which
+// ends the search, and whether the server responded at all.
+func queryCandidate(ctx *Context, server, fqdn string, timeout time.Duration) ([]net.IP, bool, bool) {
+	var addrs []net.IP
+	var notes []string
+	exists, responded := false, false
+
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		answer, err := dnsQuery(server, fqdn, qtype, timeout)
+		if err != nil {
+			fmt.Fprintf(ctx.Stdout, "  %-40s %-5s %v\n", fqdn, dnsTypeName(qtype), err)
+			continue
+		}
+
+		responded = true
+		status := dnsRcodeName(answer.rcode)
+		if answer.rcode == dnsRcodeNoError {
+			exists = true
+			if len(answer.addrs) == 0 {
+				status = "no records"
+			} else {
+				status = formatIPs(answer.addrs) + fmt.Sprintf(" ttl %d", answer.ttl)
+			}
+		}
+		addrs = append(addrs, answer.addrs...)
+
+		if len(answer.cnames) > 0 {
+			notes = append(notes, "via "+strings.Join(answer.cnames, " -> "))
+		}
+		fmt.Fprintf(ctx.Stdout, "  %-40s %-5s %s (%s)\n", fqdn, dnsTypeName(qtype), status, formatRTT(answer.rtt))
+	}
+
+	if len(notes) > 0 {
+		fmt.Fprintf(ctx.Stdout, "  %s\n", notes[0])
+	}
+	return addrs, exists, responded
+}
+
+// readResolvConf parses nameserver, search/domain and options lines
+func readResolvConf(path string) resolvConf {
+	conf := resolvConf{ndots: 1, timeout: 5 * time.Second}
+
+	file, err := os.Open(path)
+	if err != nil {
+		conf.nameservers = []string{"127.0.0.1"}
+		return conf
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			conf.nameservers = append(conf.nameservers, fields[1])
+		case "search", "domain":
+			// The last search or domain line wins
+			conf.search = fields[1:]
+		case "options":
+			for _, option := range fields[1:] {
+				key, value, _ := strings.Cut(option, ":")
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					continue
+				}
+				switch key {
+				case "ndots":
+					conf.ndots = n
+				case "timeout":
+					conf.timeout = time.Duration(n) * time.Second
+				}
+			}
+		}
+	}
+
+	if len(conf.nameservers) == 0 {
+		conf.nameservers = []string{"127.0.0.1"}
+	}
+	return conf
+}
+
+// nsswitchHostsOrder returns the sources of the hosts line of
+// /etc/nsswitch.conf
+func nsswitchHostsOrder() []string {
+	file, err := os.Open("/etc/nsswitch.conf")
+	if err != nil {
+		return []string{"files", "dns"}
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, found := strings.Cut(line, ":")
+		if found && strings.TrimSpace(key) == "hosts" {
+			return strings.Fields(value)
+		}
+	}
+	return []string{"files", "dns"}
+}
+
+// lookupHostsFile returns the addresses a hosts file lists for name
+func lookupHostsFile(path, name string) []net.IP {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	name = strings.TrimSuffix(name, ".")
+	var addrs []net.IP
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			continue
+		}
+		for _, alias := range fields[1:] {
+			if strings.EqualFold(alias, name) {
+				addrs = append(addrs, ip)
+				break
+			}
+		}
+	}
+	return addrs
+}
+
+// searchCandidates lists the fully qualified names the resolver tries, in
+// order: names with at least ndots dots are tried as given first
+func searchCandidates(name string, conf resolvConf) []string {
+	if strings.HasSuffix(name, ".") {
+		return []string{name}
+	}
+
+	var searched []string
+	for _, domain := range conf.search {
+		searched = append(searched, name+"."+strings.TrimSuffix(domain, ".")+".")
+	}
+
+	if strings.Count(name, ".") >= conf.ndots {
+		return append([]string{name + "."}, searched...)
+	}
+	return append(searched, name+".")
+}
+
+// dnsQuery sends one recursive query over UDP, retrying over TCP when the
+// answer is truncated
+func dnsQuery(server, name string, qtype uint16, timeout time.Duration) (*dnsAnswer, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+
+	id := uint16(rand.Intn(1 << 16))
+	query, err := buildDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	response, err := exchangeDNS("udp", server, query, timeout)
+	if err == nil && len(response) > 3 && response[2]&0x02 != 0 {
+		response, err = exchangeDNS("tcp", server, query, timeout)
+	}
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, errors.New("timed out")
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, errors.New("connection refused")
+		}
+		return nil, err
+	}
+
+	answer, err := parseDNSResponse(response, id, qtype)
+	if err != nil {
+		return nil, err
+	}
+	answer.rtt = time.Since(start)
+	return answer, nil
+}
+
+// exchangeDNS sends a query and reads the reply; TCP messages carry a
+// two-byte length prefix
+func exchangeDNS(network, server string, query []byte, timeout time.Duration) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if network == "tcp" {
+		framed := make([]byte, 2, 2+len(query))
+		binary.BigEndian.PutUint16(framed, uint16(len(query)))
+		if _, err := conn.Write(append(framed, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		response := make([]byte, binary.BigEndian.Uint16(length[:]))
+		_, err := io.ReadFull(conn, response)
+		return response, err
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// buildDNSQuery encodes a query with recursion desired
+func buildDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)      // QDCOUNT
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid name: %s", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // class IN
+
+	return msg, nil
+}
+
+// parseDNSResponse extracts the response code, addresses and CNAMEs
+func parseDNSResponse(msg []byte, id uint16, qtype uint16) (*dnsAnswer, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("short response")
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, errors.New("response ID mismatch")
+	}
+
+	answer := &dnsAnswer{rcode: int(msg[3] & 0x0f)}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next + 4
+	}
+
+	for i := 0; i < ancount; i++ {
+		_, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errors.New("truncated record")
+		}
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		ttl := binary.BigEndian.Uint32(msg[next+4:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		data := next + 10
+		if data+length > len(msg) {
+			return nil, errors.New("truncated record")
+		}
+
+		switch {
+		case rtype == qtype && (rtype == dnsTypeA && length == 4 || rtype == dnsTypeAAAA && length == 16):
+			answer.addrs = append(answer.addrs, net.IP(append([]byte(nil), msg[data:data+length]...)))
+			answer.ttl = ttl
+		case rtype == dnsTypeCNAME:
+			target, _, err := readDNSName(msg, data)
+			if err == nil {
+				answer.cnames = append(answer.cnames, target)
+			}
+		}
+		offset = data + length
+	}
+
+	return answer, nil
+}
+
+// readDNSName decodes a possibly compressed name and returns the offset
+// after it in the record
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("truncated name")
+		}
+		length := int(msg[offset])
+
+		switch {
+		case length == 0:
+			if end == -1 {
+				end = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case length&0xc0 == 0xc0:
+			if offset+1 >= len(msg) || jumps > 16 {
+				return "", 0, errors.New("invalid name pointer")
+			}
+			if end == -1 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3fff)
+			jumps++
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("truncated name")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+func dnsTypeName(qtype uint16) string {
+	if qtype == dnsTypeAAAA {
+		return "AAAA"
+	}
+	return "A"
+}
+
+func dnsRcodeName(rcode int) string {
+	switch rcode {
+	case dnsRcodeNoError:
+		return "NOERROR"
+	case dnsRcodeServFail:
+		return "SERVFAIL"
+	case dnsRcodeNXDomain:
+		return "NXDOMAIN"
+	case dnsRcodeRefused:
+		return "REFUSED"
+	}
+	return "rcode " + strconv.Itoa(rcode)
+}
+
+// sameIPs compares address sets regardless of order
+func sameIPs(a, b []net.IP) bool {
+	set := func(ips []net.IP) string {
+		var s []string
+		for _, ip := range ips {
+			s = append(s, ip.String())
+		}
+		sort.Strings(s)
+		return strings.Join(s, ",")
+	}
+	return set(a) == set(b)
+}
+
+func formatIPs(ips []net.IP) string {
+	if len(ips) == 0 {
+		return "(none)"
+	}
+	var s []string
+	for _, ip := range ips {
+		s = append(s, ip.String())
+	}
+	return strings.Join(s, " ")
+}
+
+func formatRTT(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d.Microseconds())/1000)
+}
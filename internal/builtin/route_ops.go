@@ -0,0 +1,597 @@
+package builtin
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Route flags from /proc/net/route
+const (
+	routeFlagUp      = 0x1
+	routeFlagGateway = 0x2
+	routeFlagHost    = 0x4
+	routeFlagReject  = 0x200
+)
+
+// ARP flags from /proc/net/arp
+const (
+	arpFlagComplete  = 0x2
+	arpFlagPermanent = 0x4
+)
+
+// Neighbor (NUD) states reported over netlink
+var neighborStates = []struct {
+	bit  uint16
+	name string
+}{
+	{0x01, "INCOMPLETE"},
+	{0x02, "REACHABLE"},
+	{0x04, "STALE"},
+	{0x08, "DELAY"},
+	{0x10, "PROBE"},
+	{0x20, "FAILED"},
+	{0x40, "NOARP"},
+	{0x80, "PERMANENT"},
+}
+
+// Netlink attribute types used below
+const (
+	ndaDst      = 1
+	ndaLLAddr   = 2
+	rtaDst      = 1
+	rtaOif      = 4
+	rtaGateway  = 5
+	rtaPrio     = 6
+	rtaTable    = 15
+	nudNoARP    = 0x40
+	rtTableMain = 254
+)
+
+// neighbor is one entry of the ARP or NDP table
+type neighbor struct {
+	ip     net.IP
+	mac    string
+	device string
+	state  string
+}
+
+// route is one entry of a routing table
+type route struct {
+	dst     *net.IPNet
+	gateway net.IP
+	device  string
+	metric  uint32
+	flags   uint32
+	reject  bool
+}
+
+// Arp displays the IPv4 neighbor (ARP) table
+func Arp(ctx *Context, args []string) error {
+	numeric, bsdStyle := false, false
+	device := ""
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-n":
+			numeric = true
+		case "-a":
+			bsdStyle = true
+		case "-an", "-na":
+			numeric, bsdStyle = true, true
+		case "-i":
+			if i+1 >= len(args) {
+				return fmt.Errorf("arp: -i requires an interface")
+			}
+			i++
+			device = args[i]
+		default:
+			return fmt.Errorf("arp: invalid option: %s", arg)
+		}
+	}
+
+	neighbors, err := readARPTable()
+	if err != nil {
+		return fmt.Errorf("arp: %v", err)
+	}
+
+	if !bsdStyle {
+		fmt.Fprintf(ctx.Stdout, "%-24s %-8s %-20s %-6s %s\n", "Address", "HWtype", "HWaddress", "Flags", "Iface")
+	}
+
+	for _, n := range neighbors {
+		if device != "" && n.device != device {
+			continue
+		}
+
+		name := n.ip.String()
+		if !numeric {
+			if names, err := net.LookupAddr(name); err == nil && len(names) > 0 {
+				name = strings.TrimSuffix(names[0], ".")
+			}
+		}
+
+		if bsdStyle {
+			if n.mac == "" {
+				fmt.Fprintf(ctx.Stdout, "%s (%s) at <incomplete> on %s\n", name, n.ip, n.device)
+				continue
+			}
+			fmt.Fprintf(ctx.Stdout, "%s (%s) at %s [ether] on %s\n", name, n.ip, n.mac, n.device)
+			continue
+		}
+
+		mac, hwType, flags := n.mac, "ether", "C"
+		if mac == "" {
+			mac, hwType, flags = "(incomplete)", "", ""
+		} else if n.state == "PERMANENT" {
+			flags = "CM"
+		}
+		fmt.Fprintf(ctx.Stdout, "%-24s %-8s %-20s %-6s %s\n", name, hwType, mac, flags, n.device)
+	}
+
+	return nil
+}
+
+// Route displays the kernel routing table in net-tools format
+func Route(ctx *Context, args []string) error {
+	numeric, ipv6 := false, false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-n":
+			numeric = true
+		case "-6":
+			ipv6 = true
+		case "-4":
+			ipv6 = false
+		case "-A":
+			if i+1 >= len(args) {
+				return fmt.Errorf("route: -A requires an address family")
+			}
+			i++
+			ipv6 = args[i] == "inet6"
+		default:
+			return fmt.Errorf("route: invalid option: %s", arg)
+		}
+	}
+
+	if ipv6 {
+		routes, err := readIPv6Routes()
+		if err != nil {
+			return fmt.Errorf("route: %v", err)
+		}
+		fmt.Fprintln(ctx.Stdout, "Kernel IPv6 routing table")
+		fmt.Fprintf(ctx.Stdout, "%-32s %-26s %-5s %-6s %s\n", "Destination", "Next Hop", "Flag", "Met", "Iface")
+		for _, r := range routes {
+			gateway := "::"
+			if r.gateway != nil {
+				gateway = r.gateway.String()
+			}
+			fmt.Fprintf(ctx.Stdout, "%-32s %-26s %-5s %-6d %s\n", r.dst, gateway, routeFlagString(r), r.metric, r.device)
+		}
+		return nil
+	}
+
+	routes, err := readIPv4Routes()
+	if err != nil {
+		return fmt.Errorf("route: %v", err)
+	}
+
+	fmt.Fprintln(ctx.Stdout, "Kernel IP routing table")
+	fmt.Fprintf(ctx.Stdout, "%-16s %-16s %-16s %-5s %-6s %s\n", "Destination", "Gateway", "Genmask", "Flags", "Metric", "Iface")
+	for _, r := range routes {
+		dst := r.dst.IP.String()
+		if !numeric && r.dst.IP.Equal(net.IPv4zero) {
+			dst = "default"
+		}
+		gateway := net.IPv4zero.String()
+		if r.gateway != nil {
+			gateway = r.gateway.String()
+		}
+		fmt.Fprintf(ctx.Stdout, "%-16s %-16s %-16s %-5s %-6d %s\n", dst, gateway,
+			net.IP(r.dst.Mask).String(), routeFlagString(r), r.metric, r.device)
+	}
+
+	return nil
+}
+
+// IsIPBuiltin reports whether an ip command line is handled by IP; other
+// objects (addr, link, ...) and changes such as ip route add are left to
+// the system ip command
+func IsIPBuiltin(args []string) bool {
+	object, _, rest := splitIPArgs(args)
+	return ipObject(object) != "" && isIPShow(rest)
+}
+
+// isIPShow reports whether the action is the default show/list
+func isIPShow(rest []string) bool {
+	return len(rest) == 0 || rest[0] == "show" || rest[0] == "list" || rest[0] == "ls"
+}
+
+// IP implements the neigh and route objects of iproute2's ip command
+func IP(ctx *Context, args []string) error {
+	object, family, rest := splitIPArgs(args)
+
+	// Only the default "show"/"list" action is supported
+	if !isIPShow(rest) {
+		return fmt.Errorf("ip: %s %s is not supported by the builtin", object, rest[0])
+	}
+
+	switch ipObject(object) {
+	case "neigh":
+		var neighbors []neighbor
+		if family != 6 {
+			arp, err := readARPTable()
+			if err != nil {
+				return fmt.Errorf("ip: %v", err)
+			}
+			neighbors = append(neighbors, arp...)
+		}
+		if family != 4 {
+			ndp, err := readIPv6Neighbors()
+			if err != nil {
+				return fmt.Errorf("ip: %v", err)
+			}
+			neighbors = append(neighbors, ndp...)
+		}
+		for _, n := range neighbors {
+			if n.mac == "" {
+				fmt.Fprintf(ctx.Stdout, "%s dev %s %s\n", n.ip, n.device, n.state)
+				continue
+			}
+			fmt.Fprintf(ctx.Stdout, "%s dev %s lladdr %s %s\n", n.ip, n.device, n.mac, n.state)
+		}
+		return nil
+
+	case "route":
+		var routes []route
+		var err error
+		if family == 6 {
+			routes, err = readIPv6Routes()
+		} else {
+			routes, err = readIPv4Routes()
+		}
+		if err != nil {
+			return fmt.Errorf("ip: %v", err)
+		}
+		for _, r := range routes {
+			fmt.Fprintln(ctx.Stdout, formatIPRoute(r))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("ip: unknown object: %s", object)
+}
+
+// splitIPArgs separates the -4/-6 family options from the object and the
+// remaining arguments
+func splitIPArgs(args []string) (string, int, []string) {
+	family := 0
+	var rest []string
+	for _, arg := range args {
+		switch arg {
+		case "-4":
+			family = 4
+		case "-6":
+			family = 6
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if len(rest) == 0 {
+		return "", family, nil
+	}
+	return rest[0], family, rest[1:]
+}
+
+// ipObject expands the abbreviations ip accepts for neigh and route
+func ipObject(object string) string {
+	if object == "" {
+		return ""
+	}
+	if strings.HasPrefix("neighbour", object) || strings.HasPrefix("neighbor", object) {
+		return "neigh"
+	}
+	if strings.HasPrefix("route", object) {
+		return "route"
+	}
+	return ""
+}
+
+// formatIPRoute renders a route the way ip route prints it
+func formatIPRoute(r route) string {
+	var b strings.Builder
+
+	ones, bits := r.dst.Mask.Size()
+	switch {
+	case r.reject:
+		fmt.Fprintf(&b, "unreachable %s", r.dst)
+	case ones == 0:
+		b.WriteString("default")
+	case ones == bits:
+		b.WriteString(r.dst.IP.String())
+	default:
+		b.WriteString(r.dst.String())
+	}
+
+	if r.gateway != nil {
+		fmt.Fprintf(&b, " via %s", r.gateway)
+	}
+	if r.device != "" {
+		fmt.Fprintf(&b, " dev %s", r.device)
+	}
+	if r.gateway == nil && !r.reject {
+		b.WriteString(" scope link")
+	}
+	if r.metric != 0 {
+		fmt.Fprintf(&b, " metric %d", r.metric)
+	}
+
+	return b.String()
+}
+
+// routeFlagString renders route flags like route(8): U, G, H and !
+func routeFlagString(r route) string {
+	flags := ""
+	if r.flags&routeFlagUp != 0 {
+		flags += "U"
+	}
+	if r.flags&routeFlagGateway != 0 {
+		flags += "G"
+	}
+	if r.flags&routeFlagHost != 0 {
+		flags += "H"
+	}
+	if r.reject {
+		flags += "!"
+	}
+	return flags
+}
+
+// readARPTable parses /proc/net/arp
+func readARPTable() ([]neighbor, error) {
+	file, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var neighbors []neighbor
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		flags, _ := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 32)
+		n := neighbor{ip: net.ParseIP(fields[0]), device: fields[5]}
+
+		switch {
+		case flags&arpFlagPermanent != 0:
+			n.state = "PERMANENT"
+		case flags&arpFlagComplete != 0:
+			n.state = "REACHABLE"
+		default:
+			n.state = "INCOMPLETE"
+		}
+		if flags&arpFlagComplete != 0 {
+			n.mac = fields[3]
+		}
+
+		neighbors = append(neighbors, n)
+	}
+
+	return neighbors, scanner.Err()
+}
+
+// readIPv4Routes parses /proc/net/route, whose addresses are hex in host
+// (little-endian) byte order
+func readIPv4Routes() ([]route, error) {
+	file, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var routes []route
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+
+		dst, err1 := parseProcIPv4(fields[1])
+		gateway, err2 := parseProcIPv4(fields[2])
+		mask, err3 := parseProcIPv4(fields[7])
+		flags, err4 := strconv.ParseUint(fields[3], 16, 32)
+		metric, _ := strconv.ParseUint(fields[6], 10, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			continue
+		}
+
+		r := route{
+			dst:    &net.IPNet{IP: dst, Mask: net.IPMask(mask)},
+			device: fields[0],
+			metric: uint32(metric),
+			flags:  uint32(flags),
+			reject: flags&routeFlagReject != 0,
+		}
+		if flags&routeFlagGateway != 0 {
+			r.gateway = gateway
+		}
+		routes = append(routes, r)
+	}
+
+	return routes, scanner.Err()
+}
+
+// parseProcIPv4 decodes an address such as 0100A8C0 (192.168.0.1)
+func parseProcIPv4(value string) (net.IP, error) {
+	raw, err := hex.DecodeString(value)
+	if err != nil || len(raw) != 4 {
+		return nil, fmt.Errorf("invalid address: %s", value)
+	}
+	return net.IPv4(raw[3], raw[2], raw[1], raw[0]).To4(), nil
+}
+
+// readIPv6Neighbors dumps the NDP table over netlink
+func readIPv6Neighbors() ([]neighbor, error) {
+	messages, err := netlinkDump(syscall.RTM_GETNEIGH, syscall.AF_INET6)
+	if err != nil {
+		return nil, err
+	}
+
+	var neighbors []neighbor
+	for _, msg := range messages {
+		// struct ndmsg: family, pad, pad, ifindex, state, flags, type
+		if msg.Header.Type != syscall.RTM_NEWNEIGH || len(msg.Data) < 12 {
+			continue
+		}
+		index := int(int32(binary.LittleEndian.Uint32(msg.Data[4:8])))
+		state := binary.LittleEndian.Uint16(msg.Data[8:10])
+		if state&nudNoARP != 0 {
+			continue
+		}
+
+		attrs := parseNetlinkAttrs(msg.Data[12:])
+		if len(attrs[ndaDst]) != net.IPv6len {
+			continue
+		}
+
+		n := neighbor{
+			ip:     net.IP(attrs[ndaDst]),
+			device: interfaceName(index),
+			state:  neighborStateString(state),
+		}
+		if lladdr := attrs[ndaLLAddr]; len(lladdr) > 0 {
+			n.mac = net.HardwareAddr(lladdr).String()
+		}
+		neighbors = append(neighbors, n)
+	}
+
+	return neighbors, nil
+}
+
+// readIPv6Routes dumps the main IPv6 routing table over netlink
+func readIPv6Routes() ([]route, error) {
+	messages, err := netlinkDump(syscall.RTM_GETROUTE, syscall.AF_INET6)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []route
+	for _, msg := range messages {
+		// struct rtmsg: family, dst_len, src_len, tos, table, protocol,
+		// scope, type, flags
+		if msg.Header.Type != syscall.RTM_NEWROUTE || len(msg.Data) < syscall.SizeofRtMsg {
+			continue
+		}
+		dstLen := int(msg.Data[1])
+		table := uint32(msg.Data[4])
+		kind := msg.Data[7]
+
+		attrs := parseNetlinkAttrs(msg.Data[syscall.SizeofRtMsg:])
+		if value := attrs[rtaTable]; len(value) == 4 {
+			table = binary.LittleEndian.Uint32(value)
+		}
+		if table != rtTableMain {
+			continue
+		}
+
+		r := route{
+			dst:    &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(dstLen, 128)},
+			flags:  routeFlagUp,
+			reject: kind == syscall.RTN_UNREACHABLE,
+		}
+		if dst := attrs[rtaDst]; len(dst) == net.IPv6len {
+			r.dst.IP = net.IP(dst)
+		}
+		if gateway := attrs[rtaGateway]; len(gateway) == net.IPv6len {
+			r.gateway = net.IP(gateway)
+			r.flags |= routeFlagGateway
+		}
+		if dstLen == 128 {
+			r.flags |= routeFlagHost
+		}
+		if oif := attrs[rtaOif]; len(oif) == 4 {
+			r.device = interfaceName(int(binary.LittleEndian.Uint32(oif)))
+		}
+		if prio := attrs[rtaPrio]; len(prio) == 4 {
+			r.metric = binary.LittleEndian.Uint32(prio)
+		}
+		routes = append(routes, r)
+	}
+
+	return routes, nil
+}
+
+// netlinkDump requests a routing netlink table and parses the replies
+func netlinkDump(proto, family int) ([]syscall.NetlinkMessage, error) {
+	data, err := syscall.NetlinkRIB(proto, family)
+	if err != nil {
+		return nil, fmt.Errorf("netlink: %v", err)
+	}
+	messages, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return nil, fmt.Errorf("netlink: %v", err)
+	}
+	return messages, nil
+}
+
+// parseNetlinkAttrs splits a buffer of route attributes (struct rtattr)
+// by type
+func parseNetlinkAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= syscall.SizeofRtAttr {
+		length := int(binary.LittleEndian.Uint16(b[0:2]))
+		kind := binary.LittleEndian.Uint16(b[2:4])
+		if length < syscall.SizeofRtAttr || length > len(b) {
+			break
+		}
+		attrs[kind] = b[syscall.SizeofRtAttr:length]
+
+		// Attributes are aligned to 4 bytes
+		aligned := (length + 3) &^ 3
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return attrs
+}
+
+// neighborStateString names the NUD state bits of a neighbor
+func neighborStateString(state uint16) string {
+	var names []string
+	for _, s := range neighborStates {
+		if state&s.bit != 0 {
+			names = append(names, s.name)
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, " ")
+}
+
+// interfaceName resolves an interface index, falling back to the number
+func interfaceName(index int) string {
+	if iface, err := net.InterfaceByIndex(index); err == nil {
+		return iface.Name
+	}
+	return "if" + strconv.Itoa(index)
+}
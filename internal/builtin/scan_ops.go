@@ -0,0 +1,327 @@
+package builtin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// wellKnownServices names common ports for scan output
+var wellKnownServices = map[int]string{
+	20: "ftp-data", 21: "ftp", 22: "ssh", 23: "telnet", 25: "smtp",
+	53: "domain", 80: "http", 110: "pop3", 111: "rpcbind", 123: "ntp",
+	135: "msrpc", 139: "netbios-ssn", 143: "imap", 161: "snmp", 389: "ldap",
+	443: "https", 445: "microsoft-ds", 465: "smtps", 514: "syslog",
+	587: "submission", 631: "ipp", 636: "ldaps", 873: "rsync", 993: "imaps",
+	995: "pop3s", 1080: "socks", 1433: "ms-sql", 1521: "oracle",
+	2049: "nfs", 2375: "docker", 2376: "docker-tls", 3000: "dev-http",
+	3306: "mysql", 3389: "rdp", 5000: "upnp", 5432: "postgresql",
+	5672: "amqp", 5900: "vnc", 6379: "redis", 6443: "kubernetes",
+	8000: "http-alt", 8080: "http-proxy", 8443: "https-alt",
+	9000: "cslistener", 9090: "prometheus", 9200: "elasticsearch",
+	11211: "memcached", 27017: "mongodb",
+}
+
+// portState is the result of probing one port
+type portState int
+
+const (
+	portOpen portState = iota
+	portClosed
+	portFiltered
+)
+
+func (s portState) String() string {
+	switch s {
+	case portOpen:
+		return "open"
+	case portClosed:
+		return "closed"
+	}
+	return "filtered"
+}
+
+// portResult holds the state of a probed port
+type portResult struct {
+	port    int
+	state   portState
+	latency time.Duration
+}
+
+// scanOptions holds the flags shared by portcheck and port-scan
+type scanOptions struct {
+	timeout     time.Duration
+	concurrency int
+	openOnly    bool
+}
+
+// Portcheck checks whether specific ports accept TCP connections. The exit
+// status is 1 if any port is not open, so it can gate scripts.
+func Portcheck(ctx *Context, args []string) error {
+	opts := scanOptions{timeout: 2 * time.Second, concurrency: 16}
+
+	rest, err := parseScanFlags("portcheck", args, &opts)
+	if err != nil {
+		return err
+	}
+	if len(rest) < 2 {
+		return fmt.Errorf("portcheck: usage: portcheck [-t timeout] host port[,port...]")
+	}
+
+	ports, err := parsePortList(strings.Join(rest[1:], ","))
+	if err != nil {
+		return fmt.Errorf("portcheck: %v", err)
+	}
+
+	results := scanPorts(rest[0], ports, opts)
+
+	allOpen := true
+	for _, result := range results {
+		detail := ""
+		if result.state == portOpen {
+			detail = fmt.Sprintf(" (%.1fms)", float64(result.latency.Microseconds())/1000)
+		} else {
+			allOpen = false
+		}
+		fmt.Fprintf(ctx.Stdout, "%s:%d %s %s%s\n", rest[0], result.port, serviceName(result.port), result.state, detail)
+	}
+
+	if !allOpen {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// PortScan scans a range of TCP ports (like a minimal nmap -sT)
+func PortScan(ctx *Context, args []string) error {
+	opts := scanOptions{timeout: time.Second, concurrency: 100}
+
+	rest, err := parseScanFlags("port-scan", args, &opts)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("port-scan: usage: port-scan [-t timeout] [-c workers] [--open] host [ports]")
+	}
+
+	spec := "1-1024"
+	if len(rest) > 1 {
+		spec = strings.Join(rest[1:], ",")
+	}
+
+	ports, err := parsePortList(spec)
+	if err != nil {
+		return fmt.Errorf("port-scan: %v", err)
+	}
+
+	host := rest[0]
+	fmt.Fprintf(ctx.Stdout, "Scanning %s (%d ports, %d workers, timeout %v)\n", host, len(ports), opts.concurrency, opts.timeout)
+
+	start := time.Now()
+	results := scanPorts(host, ports, opts)
+
+	counts := make(map[portState]int)
+	fmt.Fprintf(ctx.Stdout, "%-8s %-10s %s\n", "PORT", "STATE", "SERVICE")
+	for _, result := range results {
+		counts[result.state]++
+		// Like nmap, long scans only list the open ports
+		if result.state != portOpen && (opts.openOnly || len(ports) > 32) {
+			continue
+		}
+		fmt.Fprintf(ctx.Stdout, "%-8s %-10s %s\n", fmt.Sprintf("%d/tcp", result.port), result.state, serviceName(result.port))
+	}
+
+	fmt.Fprintf(ctx.Stdout, "\n%d open, %d closed, %d filtered in %v\n",
+		counts[portOpen], counts[portClosed], counts[portFiltered],
+		time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
+
+// parseScanFlags parses the options shared by the scan builtins and returns
+// the remaining arguments
+func parseScanFlags(name string, args []string, opts *scanOptions) ([]string, error) {
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-t", "--timeout":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s: %s requires a value", name, arg)
+			}
+			i++
+			timeout, err := parseScanTimeout(args[i])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			opts.timeout = timeout
+		case "-c", "--concurrency":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s: %s requires a value", name, arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%s: invalid concurrency: %s", name, args[i])
+			}
+			opts.concurrency = n
+		case "--open":
+			opts.openOnly = true
+		default:
+			if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+				return nil, fmt.Errorf("%s: invalid option: %s", name, arg)
+			}
+			rest = append(rest, arg)
+		}
+	}
+
+	return rest, nil
+}
+
+// parseScanTimeout accepts a Go duration ("500ms", "2s") or plain seconds
+func parseScanTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid timeout: %s", value)
+}
+
+// parsePortList parses "22,80,8000-8100" and service names like "ssh"
+func parsePortList(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+
+	add := func(port int) {
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if low, high, isRange := strings.Cut(part, "-"); isRange {
+			first, err1 := strconv.Atoi(low)
+			last, err2 := strconv.Atoi(high)
+			if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+				return nil, fmt.Errorf("invalid port range: %s", part)
+			}
+			for port := first; port <= last; port++ {
+				add(port)
+			}
+			continue
+		}
+
+		port, err := strconv.Atoi(part)
+		if err != nil {
+			// Allow service names such as ssh or https
+			port, err = net.LookupPort("tcp", part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port: %s", part)
+			}
+		}
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port: %s", part)
+		}
+		add(port)
+	}
+
+	if len(ports) == 0 {
+		return nil, errors.New("no ports given")
+	}
+
+	sort.Ints(ports)
+	return ports, nil
+}
+
+// scanPorts probes ports concurrently with a bounded number of workers and
+// returns the results sorted by port
+func scanPorts(host string, ports []int, opts scanOptions) []portResult {
+	jobs := make(chan int)
+	results := make([]portResult, 0, len(ports))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	workers := limitScanWorkers(opts.concurrency)
+	if workers > len(ports) {
+		workers = len(ports)
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range jobs {
+				result := probePort(host, port, opts.timeout)
+				mutex.Lock()
+				results = append(results, result)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, port := range ports {
+		jobs <- port
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].port < results[j].port
+	})
+	return results
+}
+
+// probePort classifies a port: a completed handshake is open, a refused
+// connection (RST) is closed and no answer within the timeout is filtered
+func probePort(host string, port int, timeout time.Duration) portResult {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	result := portResult{port: port, latency: time.Since(start)}
+
+	switch {
+	case err == nil:
+		conn.Close()
+		result.state = portOpen
+	case errors.Is(err, syscall.ECONNREFUSED):
+		result.state = portClosed
+	default:
+		result.state = portFiltered
+	}
+
+	return result
+}
+
+// serviceName returns the conventional service for a port
+func serviceName(port int) string {
+	if name, ok := wellKnownServices[port]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// limitScanWorkers keeps the worker count within the open file limit, since
+// every worker holds a socket
+func limitScanWorkers(requested int) int {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil {
+		if max := int(limit.Cur) / 2; max > 0 && requested > max {
+			return max
+		}
+	}
+	return requested
+}
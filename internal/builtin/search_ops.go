@@ -9,10 +9,12 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"gex/internal/ui"
 )
 
 // Find searches for files and directories (like find command)
-func Find(args []string) error {
+func Find(ctx *Context, args []string) error {
 	var paths []string
 	var name string
 	var fileType string
@@ -80,17 +82,22 @@ func Find(args []string) error {
 		paths = []string{"."}
 	}
 
+	failed := false
 	for _, path := range paths {
-		if err := findInPath(path, name, fileType, maxDepth, minDepth, exec, size, mtime, 0); err != nil {
-			fmt.Printf("find: %v\n", err)
+		if err := findInPath(ctx, path, name, fileType, maxDepth, minDepth, exec, size, mtime, 0); err != nil {
+			fmt.Fprintf(ctx.Stderr, "find: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // findInPath recursively searches in a path
-func findInPath(path, name, fileType string, maxDepth, minDepth int, exec, size, mtime string, currentDepth int) error {
+func findInPath(ctx *Context, path, name, fileType string, maxDepth, minDepth int, exec, size, mtime string, currentDepth int) error {
 	// Check depth limits
 	if maxDepth >= 0 && currentDepth > maxDepth {
 		return nil
@@ -106,9 +113,9 @@ func findInPath(path, name, fileType string, maxDepth, minDepth int, exec, size,
 		if matchesCriteria(path, info, name, fileType, size, mtime) {
 			if exec != "" {
 				// Execute command on found file
-				fmt.Printf("Executing: %s %s\n", exec, path)
+				fmt.Fprintf(ctx.Stdout, "Executing: %s %s\n", exec, path)
 			} else {
-				fmt.Println(path)
+				fmt.Fprintln(ctx.Stdout, path)
 			}
 		}
 	}
@@ -122,7 +129,7 @@ func findInPath(path, name, fileType string, maxDepth, minDepth int, exec, size,
 
 		for _, entry := range entries {
 			subPath := filepath.Join(path, entry.Name())
-			findInPath(subPath, name, fileType, maxDepth, minDepth, exec, size, mtime, currentDepth+1)
+			findInPath(ctx, subPath, name, fileType, maxDepth, minDepth, exec, size, mtime, currentDepth+1)
 		}
 	}
 
@@ -266,7 +273,7 @@ func matchesMtime(modTime time.Time, mtimeSpec string) bool {
 }
 
 // Locate finds files by name in database (simplified implementation)
-func Locate(args []string) error {
+func Locate(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("locate: missing pattern")
 	}
@@ -286,19 +293,28 @@ func Locate(args []string) error {
 		"/etc",
 	}
 
+	// Matches are printed once the walk is over so they do not fight
+	// with the status line
+	searching := ui.NewSpinner(ctx.Stderr, "Searching...").Start()
+	var matches []string
 	for _, dir := range searchDirs {
+		searching.Update(fmt.Sprintf("Searching %s (%d found)", dir, len(matches)))
 		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return nil // Skip errors
 			}
 
 			if strings.Contains(strings.ToLower(d.Name()), strings.ToLower(pattern)) {
-				fmt.Println(path)
+				matches = append(matches, path)
 			}
 
 			return nil
 		})
 	}
+	searching.Stop()
 
+	for _, path := range matches {
+		fmt.Fprintln(ctx.Stdout, path)
+	}
 	return nil
 }
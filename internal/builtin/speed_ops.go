@@ -0,0 +1,299 @@
+package builtin
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gex/internal/shell"
+)
+
+// maxSpeedTestBytes caps the transfer size a netspeed server will send
+const maxSpeedTestBytes = 1 << 30
+
+// Netspeed measures latency and download/upload throughput against an HTTP
+// endpoint that serves GET /__down?bytes=N and accepts POST /__up, or runs
+// such an endpoint with --serve for peer-to-peer tests
+func Netspeed(ctx *Context, args []string, session *shell.Session) error {
+	endpoint := session.Config().SpeedtestURL
+	size := int64(25 << 20)
+	pings := 5
+	download, upload := true, true
+	var serveAddr string
+	var serve bool
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-u", "--url":
+			if i+1 >= len(args) {
+				return fmt.Errorf("netspeed: %s requires a URL", arg)
+			}
+			i++
+			endpoint = args[i]
+		case "-s", "--size":
+			if i+1 >= len(args) {
+				return fmt.Errorf("netspeed: %s requires a size", arg)
+			}
+			i++
+			n, err := ParseByteSize(args[i])
+			if err != nil || n <= 0 || n > maxSpeedTestBytes {
+				return fmt.Errorf("netspeed: invalid size: %s", args[i])
+			}
+			size = n
+		case "-n", "--pings":
+			if i+1 >= len(args) {
+				return fmt.Errorf("netspeed: %s requires a count", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return fmt.Errorf("netspeed: invalid ping count: %s", args[i])
+			}
+			pings = n
+		case "--download":
+			upload = false
+		case "--upload":
+			download = false
+		case "--serve":
+			serve = true
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				serveAddr = args[i]
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("netspeed: invalid option: %s", arg)
+			}
+			endpoint = arg
+		}
+	}
+
+	if serve {
+		return serveNetspeed(ctx, serveAddr)
+	}
+
+	if endpoint == "" {
+		return fmt.Errorf("netspeed: no endpoint configured (use -u URL or set speedtest_url)")
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "http://" + endpoint
+	}
+	endpoint = strings.TrimRight(endpoint, "/")
+
+	transport, err := newHTTPTransport(httpOptions{headerTimeout: 15 * time.Second, getenv: ctx.Getenv})
+	if err != nil {
+		return fmt.Errorf("netspeed: %v", err)
+	}
+	client := &http.Client{Transport: transport}
+
+	fmt.Fprintf(ctx.Stdout, "Testing against %s\n", endpoint)
+
+	// Latency: small requests over a warmed-up connection
+	latencies, err := measureLatency(client, endpoint, pings)
+	if err != nil {
+		return fmt.Errorf("netspeed: %v", err)
+	}
+	avg, jitter := latencyStats(latencies)
+	fmt.Fprintf(ctx.Stdout, "Latency:  %.2f ms (jitter %.2f ms, %d samples)\n", msec(avg), msec(jitter), len(latencies))
+
+	if download {
+		elapsed, received, err := measureDownload(client, endpoint, size)
+		if err != nil {
+			return fmt.Errorf("netspeed: download: %v", err)
+		}
+		fmt.Fprintf(ctx.Stdout, "Download: %.2f Mbps (%s in %.2fs)\n", mbps(received, elapsed), formatHumanReadable(received), elapsed.Seconds())
+	}
+
+	if upload {
+		elapsed, err := measureUpload(client, endpoint, size)
+		if err != nil {
+			return fmt.Errorf("netspeed: upload: %v", err)
+		}
+		fmt.Fprintf(ctx.Stdout, "Upload:   %.2f Mbps (%s in %.2fs)\n", mbps(size, elapsed), formatHumanReadable(size), elapsed.Seconds())
+	}
+
+	return nil
+}
+
+// measureLatency times empty downloads after one warm-up request, so
+// connection setup isn't counted
+func measureLatency(client *http.Client, endpoint string, count int) ([]time.Duration, error) {
+	var samples []time.Duration
+
+	for i := 0; i <= count; i++ {
+		start := time.Now()
+		resp, err := client.Get(endpoint + "/__down?bytes=0")
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("server returned %s", resp.Status)
+		}
+		if i > 0 {
+			samples = append(samples, time.Since(start))
+		}
+	}
+
+	return samples, nil
+}
+
+// measureDownload fetches size bytes and returns the time from the first
+// response byte to the last
+func measureDownload(client *http.Client, endpoint string, size int64) (time.Duration, int64, error) {
+	resp, err := client.Get(fmt.Sprintf("%s/__down?bytes=%d", endpoint, size))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	start := time.Now()
+	received, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return 0, 0, err
+	}
+	return time.Since(start), received, nil
+}
+
+// measureUpload posts size bytes and returns the elapsed time
+func measureUpload(client *http.Client, endpoint string, size int64) (time.Duration, error) {
+	body := io.LimitReader(zeroReader{}, size)
+
+	req, err := http.NewRequest("POST", endpoint+"/__up", body)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return time.Since(start), nil
+}
+
+// serveNetspeed runs a netspeed endpoint for peer-to-peer tests until the
+// shell is interrupted
+func serveNetspeed(ctx *Context, addr string) error {
+	if addr == "" {
+		addr = ":8088"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/__down", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+		if err != nil || n < 0 || n > maxSpeedTestBytes {
+			http.Error(w, "invalid byte count", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+		io.Copy(w, io.LimitReader(zeroReader{}, n))
+	})
+	mux.HandleFunc("/__up", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		received, _ := io.Copy(io.Discard, r.Body)
+		fmt.Fprintf(w, "%d\n", received)
+	})
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("netspeed: %v", err)
+	}
+
+	fmt.Fprintf(ctx.Stdout, "Serving netspeed on %s; on the peer run: netspeed -u http://<this host>:%d\n",
+		listener.Addr(), listener.Addr().(*net.TCPAddr).Port)
+
+	return http.Serve(listener, mux)
+}
+
+// zeroReader is an endless source of zero bytes
+type zeroReader struct{}
+
+var zeroBlock = make([]byte, 64<<10)
+
+func (zeroReader) Read(p []byte) (int, error) {
+	n := copy(p, zeroBlock)
+	for n < len(p) {
+		n += copy(p[n:], zeroBlock)
+	}
+	return n, nil
+}
+
+// ParseByteSize parses sizes like 512K, 25M or 1G
+func ParseByteSize(value string) (int64, error) {
+	multiplier := int64(1)
+	upper := strings.TrimSuffix(strings.ToUpper(value), "B")
+	if upper != "" {
+		switch upper[len(upper)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			upper = upper[:len(upper)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// latencyStats returns the mean and the mean deviation between samples
+func latencyStats(samples []time.Duration) (time.Duration, time.Duration) {
+	var total time.Duration
+	for _, sample := range samples {
+		total += sample
+	}
+	avg := total / time.Duration(len(samples))
+
+	var jitter float64
+	for i := 1; i < len(samples); i++ {
+		jitter += math.Abs(float64(samples[i] - samples[i-1]))
+	}
+	if len(samples) > 1 {
+		jitter /= float64(len(samples) - 1)
+	}
+
+	return avg, time.Duration(jitter)
+}
+
+func msec(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func mbps(n int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(n) * 8 / elapsed.Seconds() / 1e6
+}
@@ -11,10 +11,12 @@ import (
 	"strings"
 	"syscall"
 	"time"
+
+	"gex/internal/shell"
 )
 
 // Ps shows running processes (simplified version)
-func Ps(args []string) error {
+func Ps(ctx *Context, args []string) error {
 	var showAll bool
 	var showUser bool
 
@@ -43,15 +45,15 @@ func Ps(args []string) error {
 	}
 
 	if showUser {
-		fmt.Printf("%-8s %-8s %-8s %-8s %-8s %s\n", "USER", "PID", "CPU%", "MEM%", "TIME", "COMMAND")
+		fmt.Fprintf(ctx.Stdout, "%-8s %-8s %-8s %-8s %-8s %s\n", "USER", "PID", "CPU%", "MEM%", "TIME", "COMMAND")
 	} else {
-		fmt.Printf("%-8s %-8s %s\n", "PID", "TTY", "CMD")
+		fmt.Fprintf(ctx.Stdout, "%-8s %-8s %s\n", "PID", "TTY", "CMD")
 	}
 
 	for _, entry := range entries {
 		// Check if directory name is a number (PID)
 		if pid, err := strconv.Atoi(entry.Name()); err == nil {
-			if err := showProcess(pid, showAll, showUser); err == nil {
+			if err := showProcess(ctx, pid, showAll, showUser); err == nil {
 				// Process shown successfully
 			}
 		}
@@ -61,7 +63,7 @@ func Ps(args []string) error {
 }
 
 // showProcess displays information about a process
-func showProcess(pid int, showAll, showUser bool) error {
+func showProcess(ctx *Context, pid int, showAll, showUser bool) error {
 	procPath := fmt.Sprintf("/proc/%d", pid)
 
 	// Read command line
@@ -78,47 +80,59 @@ func showProcess(pid int, showAll, showUser bool) error {
 
 	if showUser {
 		// Simplified user format
-		fmt.Printf("%-8s %-8d %-8s %-8s %-8s %s\n",
+		fmt.Fprintf(ctx.Stdout, "%-8s %-8d %-8s %-8s %-8s %s\n",
 			"user", pid, "0.0", "0.0", "00:00:00", cmdline)
 	} else {
-		fmt.Printf("%-8d %-8s %s\n", pid, "?", cmdline)
+		fmt.Fprintf(ctx.Stdout, "%-8d %-8s %s\n", pid, "?", cmdline)
 	}
 
 	return nil
 }
 
 // Kill sends signals to processes (like kill command)
-func Kill(args []string) error {
+func Kill(ctx *Context, args []string, session *shell.Session) error {
 	if len(args) == 0 {
 		return fmt.Errorf("kill: missing operand")
 	}
 
 	signal := syscall.SIGTERM // default signal
 	var pids []int
+	var jobs []shell.Job
 
 	// Parse arguments
-	for i, arg := range args {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-s" {
+			// The signal is the next argument
+			if i+1 == len(args) {
+				return fmt.Errorf("kill: -s: option requires an argument")
+			}
+			i++
+			arg = "-" + args[i]
+		}
 		if strings.HasPrefix(arg, "-") {
 			// Parse signal
 			sigStr := arg[1:]
-			switch sigStr {
-			case "9", "KILL":
-				signal = syscall.SIGKILL
-			case "15", "TERM":
-				signal = syscall.SIGTERM
-			case "1", "HUP":
-				signal = syscall.SIGHUP
-			case "2", "INT":
-				signal = syscall.SIGINT
-			default:
+			sig, ok := ParseSignal(sigStr)
+			if !ok {
 				return fmt.Errorf("kill: invalid signal: %s", sigStr)
 			}
+			signal = sig
 		} else {
 			// Parse PIDs
 			for _, pidStr := range args[i:] {
+				if strings.HasPrefix(pidStr, "%") {
+					job, err := findJob(pidStr, session)
+					if err != nil {
+						fmt.Fprintf(ctx.Stderr, "kill: %v\n", err)
+						continue
+					}
+					jobs = append(jobs, job)
+					continue
+				}
 				pid, err := strconv.Atoi(pidStr)
 				if err != nil {
-					fmt.Printf("kill: invalid PID: %s\n", pidStr)
+					fmt.Fprintf(ctx.Stderr, "kill: invalid PID: %s\n", pidStr)
 					continue
 				}
 				pids = append(pids, pid)
@@ -127,13 +141,32 @@ func Kill(args []string) error {
 		}
 	}
 
-	if len(pids) == 0 {
+	if len(pids) == 0 && len(jobs) == 0 {
 		return fmt.Errorf("kill: missing PID")
 	}
 
 	for _, pid := range pids {
 		if err := syscall.Kill(pid, signal); err != nil {
-			fmt.Printf("kill: cannot kill %d: %v\n", pid, err)
+			fmt.Fprintf(ctx.Stderr, "kill: cannot kill %d: %v\n", pid, err)
+		}
+	}
+
+	for _, job := range jobs {
+		// Signal the job's process group, or its process without job control
+		err := syscall.Kill(-job.PID, signal)
+		if err != nil {
+			err = syscall.Kill(job.PID, signal)
+		}
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "kill: cannot kill %%%d: %v\n", job.ID, err)
+			continue
+		}
+
+		// A stopped job only acts on the signal once it runs again
+		if job.State == shell.JobStopped && signal != syscall.SIGKILL && signal != syscall.SIGSTOP && signal != syscall.SIGTSTP {
+			if syscall.Kill(-job.PID, syscall.SIGCONT) != nil {
+				syscall.Kill(job.PID, syscall.SIGCONT)
+			}
 		}
 	}
 
@@ -141,7 +174,7 @@ func Kill(args []string) error {
 }
 
 // Df shows filesystem disk space usage (like df command)
-func Df(args []string) error {
+func Df(ctx *Context, args []string) error {
 	var humanReadable bool
 	var paths []string
 
@@ -166,16 +199,16 @@ func Df(args []string) error {
 	}
 
 	if humanReadable {
-		fmt.Printf("%-20s %-8s %-8s %-8s %-5s %s\n",
+		fmt.Fprintf(ctx.Stdout, "%-20s %-8s %-8s %-8s %-5s %s\n",
 			"Filesystem", "Size", "Used", "Avail", "Use%", "Mounted on")
 	} else {
-		fmt.Printf("%-20s %-12s %-12s %-12s %-5s %s\n",
+		fmt.Fprintf(ctx.Stdout, "%-20s %-12s %-12s %-12s %-5s %s\n",
 			"Filesystem", "1K-blocks", "Used", "Available", "Use%", "Mounted on")
 	}
 
 	for _, path := range paths {
-		if err := showDiskUsage(path, humanReadable); err != nil {
-			fmt.Printf("df: %v\n", err)
+		if err := showDiskUsage(ctx, path, humanReadable); err != nil {
+			fmt.Fprintf(ctx.Stderr, "df: %v\n", err)
 		}
 	}
 
@@ -183,7 +216,7 @@ func Df(args []string) error {
 }
 
 // showDiskUsage displays disk usage for a path
-func showDiskUsage(path string, humanReadable bool) error {
+func showDiskUsage(ctx *Context, path string, humanReadable bool) error {
 	var stat syscall.Statfs_t
 	if err := syscall.Statfs(path, &stat); err != nil {
 		return err
@@ -204,7 +237,7 @@ func showDiskUsage(path string, humanReadable bool) error {
 	}
 
 	if humanReadable {
-		fmt.Printf("%-20s %-8s %-8s %-8s %4d%% %s\n",
+		fmt.Fprintf(ctx.Stdout, "%-20s %-8s %-8s %-8s %4d%% %s\n",
 			"filesystem",
 			formatHumanReadable(int64(total)),
 			formatHumanReadable(int64(used)),
@@ -212,7 +245,7 @@ func showDiskUsage(path string, humanReadable bool) error {
 			usePercent,
 			path)
 	} else {
-		fmt.Printf("%-20s %-12d %-12d %-12d %4d%% %s\n",
+		fmt.Fprintf(ctx.Stdout, "%-20s %-12d %-12d %-12d %4d%% %s\n",
 			"filesystem",
 			total/1024,
 			used/1024,
@@ -225,7 +258,7 @@ func showDiskUsage(path string, humanReadable bool) error {
 }
 
 // Du shows directory disk usage (like du command)
-func Du(args []string) error {
+func Du(ctx *Context, args []string) error {
 	var humanReadable bool
 	var summarize bool
 	var paths []string
@@ -253,8 +286,8 @@ func Du(args []string) error {
 	}
 
 	for _, path := range paths {
-		if err := showDirectoryUsage(path, humanReadable, summarize); err != nil {
-			fmt.Printf("du: %v\n", err)
+		if err := showDirectoryUsage(ctx, path, humanReadable, summarize); err != nil {
+			fmt.Fprintf(ctx.Stderr, "du: %v\n", err)
 		}
 	}
 
@@ -262,16 +295,16 @@ func Du(args []string) error {
 }
 
 // showDirectoryUsage displays directory usage
-func showDirectoryUsage(path string, humanReadable, summarize bool) error {
+func showDirectoryUsage(ctx *Context, path string, humanReadable, summarize bool) error {
 	totalSize, err := calculateDirSize(path)
 	if err != nil {
 		return err
 	}
 
 	if humanReadable {
-		fmt.Printf("%s\t%s\n", formatHumanReadable(totalSize), path)
+		fmt.Fprintf(ctx.Stdout, "%s\t%s\n", formatHumanReadable(totalSize), path)
 	} else {
-		fmt.Printf("%d\t%s\n", totalSize/1024, path) // in KB
+		fmt.Fprintf(ctx.Stdout, "%d\t%s\n", totalSize/1024, path) // in KB
 	}
 
 	return nil
@@ -301,7 +334,7 @@ func calculateDirSize(path string) (int64, error) {
 }
 
 // Free shows memory usage (like free command)
-func Free(args []string) error {
+func Free(ctx *Context, args []string) error {
 	var humanReadable bool
 
 	// Parse flags
@@ -317,11 +350,11 @@ func Free(args []string) error {
 		}
 	}
 
-	return showMemoryUsage(humanReadable)
+	return showMemoryUsage(ctx, humanReadable)
 }
 
 // showMemoryUsage displays memory usage information
-func showMemoryUsage(humanReadable bool) error {
+func showMemoryUsage(ctx *Context, humanReadable bool) error {
 	// Read /proc/meminfo
 	file, err := os.Open("/proc/meminfo")
 	if err != nil {
@@ -352,14 +385,14 @@ func showMemoryUsage(humanReadable bool) error {
 	used := total - free
 
 	if humanReadable {
-		fmt.Printf("%-12s %-8s %-8s %-8s\n", "", "total", "used", "free")
-		fmt.Printf("%-12s %-8s %-8s %-8s\n", "Mem:",
+		fmt.Fprintf(ctx.Stdout, "%-12s %-8s %-8s %-8s\n", "", "total", "used", "free")
+		fmt.Fprintf(ctx.Stdout, "%-12s %-8s %-8s %-8s\n", "Mem:",
 			formatHumanReadable(total),
 			formatHumanReadable(used),
 			formatHumanReadable(free))
 	} else {
-		fmt.Printf("%-12s %-12s %-12s %-12s\n", "", "total", "used", "free")
-		fmt.Printf("%-12s %-12d %-12d %-12d\n", "Mem:",
+		fmt.Fprintf(ctx.Stdout, "%-12s %-12s %-12s %-12s\n", "", "total", "used", "free")
+		fmt.Fprintf(ctx.Stdout, "%-12s %-12d %-12d %-12d\n", "Mem:",
 			total/1024, used/1024, free/1024) // in KB
 	}
 
@@ -367,7 +400,7 @@ func showMemoryUsage(humanReadable bool) error {
 }
 
 // Uptime shows system uptime (like uptime command)
-func Uptime(args []string) error {
+func Uptime(ctx *Context, args []string) error {
 	// Read /proc/uptime
 	data, err := os.ReadFile("/proc/uptime")
 	if err != nil {
@@ -391,20 +424,20 @@ func Uptime(args []string) error {
 	hours := int(duration.Hours()) % 24
 	minutes := int(duration.Minutes()) % 60
 
-	fmt.Printf(" %s up ", now.Format("15:04:05"))
+	fmt.Fprintf(ctx.Stdout, " %s up ", now.Format("15:04:05"))
 
 	if days > 0 {
-		fmt.Printf("%d day", days)
+		fmt.Fprintf(ctx.Stdout, "%d day", days)
 		if days > 1 {
-			fmt.Print("s")
+			fmt.Fprint(ctx.Stdout, "s")
 		}
-		fmt.Print(", ")
+		fmt.Fprint(ctx.Stdout, ", ")
 	}
 
 	if hours > 0 {
-		fmt.Printf("%d:%02d, ", hours, minutes)
+		fmt.Fprintf(ctx.Stdout, "%d:%02d, ", hours, minutes)
 	} else {
-		fmt.Printf("%d min, ", minutes)
+		fmt.Fprintf(ctx.Stdout, "%d min, ", minutes)
 	}
 
 	// Get load average (simplified)
@@ -412,16 +445,16 @@ func Uptime(args []string) error {
 	if err == nil {
 		loadParts := strings.Fields(string(loadData))
 		if len(loadParts) >= 3 {
-			fmt.Printf("load average: %s, %s, %s", loadParts[0], loadParts[1], loadParts[2])
+			fmt.Fprintf(ctx.Stdout, "load average: %s, %s, %s", loadParts[0], loadParts[1], loadParts[2])
 		}
 	}
 
-	fmt.Println()
+	fmt.Fprintln(ctx.Stdout)
 	return nil
 }
 
 // Uname shows system information (like uname command)
-func Uname(args []string) error {
+func Uname(ctx *Context, args []string) error {
 	var showAll bool
 	var showKernel bool
 	var showNode bool
@@ -496,6 +529,92 @@ func Uname(args []string) error {
 		parts = append(parts, runtime.GOARCH)
 	}
 
-	fmt.Println(strings.Join(parts, " "))
+	fmt.Fprintln(ctx.Stdout, strings.Join(parts, " "))
 	return nil
 }
+
+// CommandRunner executes a command given as an argument vector. Builtins that
+// wrap another command (like withlock) receive one from the executor.
+type CommandRunner func(args []string) error
+
+// WithLock runs a command while holding an exclusive flock on a lock file
+func WithLock(ctx *Context, args []string, run CommandRunner) error {
+	var timeout time.Duration = -1 // wait forever
+	var nonBlock bool
+	var lockFile string
+	var command []string
+
+	// Parse arguments
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if lockFile == "" && strings.HasPrefix(arg, "-") {
+			switch arg {
+			case "-n", "--nonblock":
+				nonBlock = true
+			case "-w", "--timeout":
+				if i+1 >= len(args) {
+					return fmt.Errorf("withlock: %s requires an argument", arg)
+				}
+				i++
+				seconds, err := strconv.ParseFloat(args[i], 64)
+				if err != nil || seconds < 0 {
+					return fmt.Errorf("withlock: invalid timeout: %s", args[i])
+				}
+				timeout = time.Duration(seconds * float64(time.Second))
+			default:
+				return fmt.Errorf("withlock: invalid option: %s", arg)
+			}
+		} else {
+			lockFile = arg
+			command = args[i+1:]
+			break
+		}
+	}
+
+	if lockFile == "" || len(command) == 0 {
+		return fmt.Errorf("withlock: usage: withlock [-n] [-w seconds] lockfile command [args...]")
+	}
+
+	file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("withlock: %v", err)
+	}
+	defer file.Close()
+
+	if nonBlock {
+		timeout = 0
+	}
+
+	if err := acquireLock(ctx, file, timeout); err != nil {
+		return fmt.Errorf("withlock: %s: %v", lockFile, err)
+	}
+	defer syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+
+	return run(command)
+}
+
+// acquireLock takes an exclusive flock, giving up after timeout (negative
+// waits forever) or on Ctrl+C
+func acquireLock(ctx *Context, file *os.File, timeout time.Duration) error {
+	fd := int(file.Fd())
+
+	// flock can be neither timed out nor interrupted, so poll with LOCK_NB
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			return err
+		}
+		if timeout >= 0 && !time.Now().Before(deadline) {
+			return fmt.Errorf("lock is held by another process")
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Interrupt:
+			return fmt.Errorf("interrupted")
+		}
+	}
+}
@@ -6,50 +6,59 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // Cat displays file contents (like cat command)
-func Cat(args []string) error {
+func Cat(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		// Read from stdin
-		return catReader(os.Stdin)
+		return catReader(ctx, ctx.Stdin)
 	}
 
+	failed := false
 	for _, filename := range args {
 		if filename == "-" {
-			if err := catReader(os.Stdin); err != nil {
-				fmt.Printf("cat: %v\n", err)
+			if err := catReader(ctx, ctx.Stdin); err != nil {
+				fmt.Fprintf(ctx.Stderr, "cat: %v\n", err)
+				failed = true
 			}
 			continue
 		}
 
 		file, err := os.Open(filename)
 		if err != nil {
-			fmt.Printf("cat: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "cat: %v\n", err)
+			failed = true
 			continue
 		}
 
-		err = catReader(file)
+		err = catReader(ctx, file)
 		file.Close()
 
 		if err != nil {
-			fmt.Printf("cat: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "cat: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // catReader reads from a reader and outputs to stdout
-func catReader(reader io.Reader) error {
-	_, err := io.Copy(os.Stdout, reader)
+func catReader(ctx *Context, reader io.Reader) error {
+	_, err := io.Copy(ctx.Stdout, reader)
 	return err
 }
 
 // Head displays first lines of files (like head command)
-func Head(args []string) error {
+func Head(ctx *Context, args []string) error {
 	lines := 10 // default
 	var files []string
 
@@ -76,48 +85,55 @@ func Head(args []string) error {
 	}
 
 	if len(files) == 0 {
-		return headReader(os.Stdin, lines)
+		return headReader(ctx, ctx.Stdin, lines)
 	}
 
+	failed := false
 	for i, filename := range files {
 		if len(files) > 1 {
 			if i > 0 {
-				fmt.Println()
+				fmt.Fprintln(ctx.Stdout)
 			}
-			fmt.Printf("==> %s <==\n", filename)
+			fmt.Fprintf(ctx.Stdout, "==> %s <==\n", filename)
 		}
 
 		if filename == "-" {
-			if err := headReader(os.Stdin, lines); err != nil {
-				fmt.Printf("head: %v\n", err)
+			if err := headReader(ctx, ctx.Stdin, lines); err != nil {
+				fmt.Fprintf(ctx.Stderr, "head: %v\n", err)
+				failed = true
 			}
 			continue
 		}
 
 		file, err := os.Open(filename)
 		if err != nil {
-			fmt.Printf("head: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "head: %v\n", err)
+			failed = true
 			continue
 		}
 
-		err = headReader(file, lines)
+		err = headReader(ctx, file, lines)
 		file.Close()
 
 		if err != nil {
-			fmt.Printf("head: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "head: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // headReader reads first n lines from a reader
-func headReader(reader io.Reader, lines int) error {
+func headReader(ctx *Context, reader io.Reader, lines int) error {
 	scanner := bufio.NewScanner(reader)
 	count := 0
 
 	for scanner.Scan() && count < lines {
-		fmt.Println(scanner.Text())
+		fmt.Fprintln(ctx.Stdout, scanner.Text())
 		count++
 	}
 
@@ -125,7 +141,7 @@ func headReader(reader io.Reader, lines int) error {
 }
 
 // Tail displays last lines of files (like tail command)
-func Tail(args []string) error {
+func Tail(ctx *Context, args []string) error {
 	lines := 10 // default
 	var files []string
 
@@ -151,36 +167,42 @@ func Tail(args []string) error {
 	}
 
 	if len(files) == 0 {
-		return tailReader(os.Stdin, lines)
+		return tailReader(ctx, ctx.Stdin, lines)
 	}
 
+	failed := false
 	for i, filename := range files {
 		if len(files) > 1 {
 			if i > 0 {
-				fmt.Println()
+				fmt.Fprintln(ctx.Stdout)
 			}
-			fmt.Printf("==> %s <==\n", filename)
+			fmt.Fprintf(ctx.Stdout, "==> %s <==\n", filename)
 		}
 
 		file, err := os.Open(filename)
 		if err != nil {
-			fmt.Printf("tail: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "tail: %v\n", err)
+			failed = true
 			continue
 		}
 
-		err = tailFile(file, lines)
+		err = tailFile(ctx, file, lines)
 		file.Close()
 
 		if err != nil {
-			fmt.Printf("tail: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "tail: %v\n", err)
+			failed = true
 		}
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
 // tailReader displays last n lines from reader (for stdin)
-func tailReader(reader io.Reader, lines int) error {
+func tailReader(ctx *Context, reader io.Reader, lines int) error {
 	scanner := bufio.NewScanner(reader)
 	buffer := make([]string, 0, lines)
 
@@ -192,14 +214,14 @@ func tailReader(reader io.Reader, lines int) error {
 	}
 
 	for _, line := range buffer {
-		fmt.Println(line)
+		fmt.Fprintln(ctx.Stdout, line)
 	}
 
 	return scanner.Err()
 }
 
 // tailFile displays last n lines from file
-func tailFile(file *os.File, lines int) error {
+func tailFile(ctx *Context, file *os.File, lines int) error {
 	// For simplicity, read all lines and keep last n
 	scanner := bufio.NewScanner(file)
 	buffer := make([]string, 0, lines)
@@ -212,17 +234,20 @@ func tailFile(file *os.File, lines int) error {
 	}
 
 	for _, line := range buffer {
-		fmt.Println(line)
+		fmt.Fprintln(ctx.Stdout, line)
 	}
 
 	return scanner.Err()
 }
 
 // Wc counts lines, words, and characters (like wc command)
-func Wc(args []string) error {
+func Wc(ctx *Context, args []string) error {
 	var showLines, showWords, showChars bool = true, true, true
+	var countRunes bool
 	var files []string
 
+	loc, args := localeOption(ctx, args)
+
 	// Parse flags
 	for i, arg := range args {
 		if strings.HasPrefix(arg, "-") {
@@ -239,7 +264,10 @@ func Wc(args []string) error {
 				case 'w':
 					showWords = true
 				case 'c':
-					showChars = true
+					showChars, countRunes = true, false
+				case 'm':
+					// Characters, which are bytes unless the locale is UTF-8
+					showChars, countRunes = true, loc.utf8
 				}
 			}
 		} else {
@@ -249,32 +277,35 @@ func Wc(args []string) error {
 	}
 
 	if len(files) == 0 {
-		lines, words, chars, err := wcReader(os.Stdin)
+		lines, words, chars, err := wcReader(ctx.Stdin, countRunes)
 		if err != nil {
 			return err
 		}
-		printWcResult(lines, words, chars, "", showLines, showWords, showChars)
+		printWcResult(ctx, lines, words, chars, "", showLines, showWords, showChars)
 		return nil
 	}
 
 	totalLines, totalWords, totalChars := 0, 0, 0
 
+	failed := false
 	for _, filename := range files {
 		file, err := os.Open(filename)
 		if err != nil {
-			fmt.Printf("wc: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "wc: %v\n", err)
+			failed = true
 			continue
 		}
 
-		lines, words, chars, err := wcReader(file)
+		lines, words, chars, err := wcReader(file, countRunes)
 		file.Close()
 
 		if err != nil {
-			fmt.Printf("wc: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "wc: %v\n", err)
+			failed = true
 			continue
 		}
 
-		printWcResult(lines, words, chars, filename, showLines, showWords, showChars)
+		printWcResult(ctx, lines, words, chars, filename, showLines, showWords, showChars)
 
 		totalLines += lines
 		totalWords += words
@@ -282,21 +313,29 @@ func Wc(args []string) error {
 	}
 
 	if len(files) > 1 {
-		printWcResult(totalLines, totalWords, totalChars, "total", showLines, showWords, showChars)
+		printWcResult(ctx, totalLines, totalWords, totalChars, "total", showLines, showWords, showChars)
 	}
 
+	if failed {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
-// wcReader counts lines, words, and characters from reader
-func wcReader(reader io.Reader) (int, int, int, error) {
+// wcReader counts lines, words, and bytes or with runes UTF-8 characters
+// from reader
+func wcReader(reader io.Reader, runes bool) (int, int, int, error) {
 	scanner := bufio.NewScanner(reader)
 	lines, words, chars := 0, 0, 0
 
 	for scanner.Scan() {
 		text := scanner.Text()
 		lines++
-		chars += len(text) + 1 // +1 for newline
+		if runes {
+			chars += utf8.RuneCountInString(text) + 1
+		} else {
+			chars += len(text) + 1 // +1 for newline
+		}
 		words += len(strings.Fields(text))
 	}
 
@@ -304,7 +343,7 @@ func wcReader(reader io.Reader) (int, int, int, error) {
 }
 
 // printWcResult prints wc results in the correct format
-func printWcResult(lines, words, chars int, filename string, showLines, showWords, showChars bool) {
+func printWcResult(ctx *Context, lines, words, chars int, filename string, showLines, showWords, showChars bool) {
 	var result strings.Builder
 
 	if showLines {
@@ -321,11 +360,11 @@ func printWcResult(lines, words, chars int, filename string, showLines, showWord
 		result.WriteString(" " + filename)
 	}
 
-	fmt.Println(result.String())
+	fmt.Fprintln(ctx.Stdout, result.String())
 }
 
 // Grep searches for patterns in files (like grep command)
-func Grep(args []string) error {
+func Grep(ctx *Context, args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("grep: missing pattern")
 	}
@@ -375,43 +414,66 @@ func Grep(args []string) error {
 		return fmt.Errorf("grep: invalid pattern: %v", err)
 	}
 
+	// Like grep, the status is 0 when a line was selected, 1 when none
+	// was and 2 when a file could not be read
 	if len(files) == 0 {
-		return grepReader(os.Stdin, "", regex, lineNumbers, invertMatch, false)
+		selected, err := grepReader(ctx, ctx.Stdin, "", regex, lineNumbers, invertMatch, false)
+		if err != nil {
+			return err
+		}
+		if !selected {
+			return ExitStatus(1)
+		}
+		return nil
 	}
 
 	showFilenames := len(files) > 1
+	anySelected := false
+	failed := false
 
 	for _, filename := range files {
 		file, err := os.Open(filename)
 		if err != nil {
-			fmt.Printf("grep: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "grep: %v\n", err)
+			failed = true
 			continue
 		}
 
-		err = grepReader(file, filename, regex, lineNumbers, invertMatch, showFilenames)
+		selected, err := grepReader(ctx, file, filename, regex, lineNumbers, invertMatch, showFilenames)
 		file.Close()
 
 		if err != nil {
-			fmt.Printf("grep: %v\n", err)
+			fmt.Fprintf(ctx.Stderr, "grep: %v\n", err)
+			failed = true
 		}
+		anySelected = anySelected || selected
 	}
 
+	if failed {
+		return ExitStatus(2)
+	}
+	if !anySelected {
+		return ExitStatus(1)
+	}
 	return nil
 }
 
-// grepReader searches for pattern in reader
-func grepReader(reader io.Reader, filename string, regex *regexp.Regexp, lineNumbers, invertMatch, showFilenames bool) error {
+// grepReader searches for pattern in reader and reports whether any line
+// was selected
+func grepReader(ctx *Context, reader io.Reader, filename string, regex *regexp.Regexp, lineNumbers, invertMatch, showFilenames bool) (bool, error) {
 	scanner := bufio.NewScanner(reader)
 	lineNum := 0
+	selected := false
 
 	for scanner.Scan() {
 		lineNum++
 		text := scanner.Text()
 		matches := regex.MatchString(text)
 
-		if matches != invertMatch { // XOR logic
+		if matches == invertMatch { // XOR logic
 			continue
 		}
+		selected = true
 
 		var output strings.Builder
 
@@ -424,19 +486,21 @@ func grepReader(reader io.Reader, filename string, regex *regexp.Regexp, lineNum
 		}
 
 		output.WriteString(text)
-		fmt.Println(output.String())
+		fmt.Fprintln(ctx.Stdout, output.String())
 	}
 
-	return scanner.Err()
+	return selected, scanner.Err()
 }
 
 // Sort sorts lines in files (like sort command)
-func Sort(args []string) error {
+func Sort(ctx *Context, args []string) error {
 	var reverse bool
 	var numeric bool
 	var unique bool
 	var files []string
 
+	loc, args := localeOption(ctx, args)
+
 	// Parse flags
 	for i, arg := range args {
 		if strings.HasPrefix(arg, "-") {
@@ -458,10 +522,11 @@ func Sort(args []string) error {
 	}
 
 	var lines []string
+	failed := false
 
 	if len(files) == 0 {
 		var err error
-		lines, err = readLines(os.Stdin)
+		lines, err = readLines(ctx.Stdin)
 		if err != nil {
 			return err
 		}
@@ -469,7 +534,8 @@ func Sort(args []string) error {
 		for _, filename := range files {
 			file, err := os.Open(filename)
 			if err != nil {
-				fmt.Printf("sort: %v\n", err)
+				fmt.Fprintf(ctx.Stderr, "sort: %v\n", err)
+				failed = true
 				continue
 			}
 
@@ -477,7 +543,8 @@ func Sort(args []string) error {
 			file.Close()
 
 			if err != nil {
-				fmt.Printf("sort: %v\n", err)
+				fmt.Fprintf(ctx.Stderr, "sort: %v\n", err)
+				failed = true
 				continue
 			}
 
@@ -485,7 +552,13 @@ func Sort(args []string) error {
 		}
 	}
 
-	return sortAndPrint(lines, reverse, numeric, unique)
+	if err := sortAndPrint(ctx, lines, reverse, numeric, unique, loc); err != nil {
+		return err
+	}
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
 }
 
 // readLines reads all lines from a reader
@@ -500,39 +573,57 @@ func readLines(reader io.Reader) ([]string, error) {
 	return lines, scanner.Err()
 }
 
-// sortAndPrint sorts lines and prints them
-func sortAndPrint(lines []string, reverse, numeric, unique bool) error {
+// sortAndPrint sorts lines in the collation order of the locale, or by
+// their leading number, and prints them
+func sortAndPrint(ctx *Context, lines []string, reverse, numeric, unique bool, loc locale) error {
+	compare := loc.compare
 	if numeric {
-		// Numeric sort (simplified)
-		if reverse {
-			for i := len(lines) - 1; i >= 0; i-- {
-				fmt.Println(lines[i])
-			}
-		} else {
-			for _, line := range lines {
-				fmt.Println(line)
+		// Lines with the same number fall back to the collation order
+		compare = func(a, b string) int {
+			x, y := leadingNumber(a), leadingNumber(b)
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
 			}
+			return loc.compare(a, b)
 		}
-	} else {
-		// String sort
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
 		if reverse {
-			for i := len(lines) - 1; i >= 0; i-- {
-				if unique && i > 0 && lines[i] == lines[i-1] {
-					continue
-				}
-				fmt.Println(lines[i])
-			}
-		} else {
-			prev := ""
-			for _, line := range lines {
-				if unique && line == prev {
-					continue
-				}
-				fmt.Println(line)
-				prev = line
+			return compare(lines[j], lines[i]) < 0
+		}
+		return compare(lines[i], lines[j]) < 0
+	})
+
+	for i, line := range lines {
+		if unique && i > 0 {
+			previous := lines[i-1]
+			if line == previous || (numeric && leadingNumber(line) == leadingNumber(previous)) {
+				continue
 			}
 		}
+		fmt.Fprintln(ctx.Stdout, line)
 	}
 
 	return nil
 }
+
+// leadingNumber returns the number a line starts with after any blanks,
+// or 0 when it does not start with one
+func leadingNumber(line string) float64 {
+	line = strings.TrimLeft(line, " \t")
+	end := 0
+	if end < len(line) && (line[end] == '-' || line[end] == '+') {
+		end++
+	}
+	for end < len(line) && (line[end] >= '0' && line[end] <= '9' || line[end] == '.') {
+		end++
+	}
+	value, err := strconv.ParseFloat(line[:end], 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,189 @@
+package builtin
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"gex/internal/shell"
+)
+
+// signalTable lists the signals known by name, in numeric order
+var signalTable = []struct {
+	name string
+	sig  syscall.Signal
+}{
+	{"HUP", syscall.SIGHUP}, {"INT", syscall.SIGINT}, {"QUIT", syscall.SIGQUIT},
+	{"ILL", syscall.SIGILL}, {"TRAP", syscall.SIGTRAP}, {"ABRT", syscall.SIGABRT},
+	{"BUS", syscall.SIGBUS}, {"FPE", syscall.SIGFPE}, {"KILL", syscall.SIGKILL},
+	{"USR1", syscall.SIGUSR1}, {"SEGV", syscall.SIGSEGV}, {"USR2", syscall.SIGUSR2},
+	{"PIPE", syscall.SIGPIPE}, {"ALRM", syscall.SIGALRM}, {"TERM", syscall.SIGTERM},
+	{"CHLD", syscall.SIGCHLD}, {"CONT", syscall.SIGCONT}, {"STOP", syscall.SIGSTOP},
+	{"TSTP", syscall.SIGTSTP}, {"TTIN", syscall.SIGTTIN}, {"TTOU", syscall.SIGTTOU},
+	{"URG", syscall.SIGURG}, {"XCPU", syscall.SIGXCPU}, {"XFSZ", syscall.SIGXFSZ},
+	{"VTALRM", syscall.SIGVTALRM}, {"PROF", syscall.SIGPROF}, {"WINCH", syscall.SIGWINCH},
+	{"IO", syscall.SIGIO}, {"PWR", syscall.SIGPWR}, {"SYS", syscall.SIGSYS},
+}
+
+// ParseSignal accepts a signal as a number or a name with or without the
+// SIG prefix, in any case
+func ParseSignal(spec string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		for _, entry := range signalTable {
+			if int(entry.sig) == n {
+				return entry.sig, true
+			}
+		}
+		return 0, false
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	for _, entry := range signalTable {
+		if entry.name == name {
+			return entry.sig, true
+		}
+	}
+	return 0, false
+}
+
+// CompletionValues returns the values of a kind of flag value named in
+// the command metadata, for completion: signal names, shell options, ssh
+// hosts, as host: for a remote path, or the refs and remotes of the git
+// repository in the current directory
+func CompletionValues(kind string) []string {
+	var values []string
+	switch kind {
+	case "signal":
+		for _, entry := range signalTable {
+			values = append(values, entry.name)
+		}
+	case "option":
+		values = append(values, shell.ShellOptions...)
+	case "host":
+		values = sshHosts()
+	case "remote":
+		for _, host := range sshHosts() {
+			values = append(values, host+":")
+		}
+	case "git-ref", "git-remote":
+		values = gitCompletion(kind)
+	}
+	return values
+}
+
+// SignalName returns the name of a signal without the SIG prefix
+func SignalName(sig syscall.Signal) string {
+	for _, entry := range signalTable {
+		if entry.sig == sig {
+			return entry.name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// Trap sets commands to run when the shell receives a signal or exits.
+// A command of - restores the default action and an empty one ignores the
+// signal.
+func Trap(ctx *Context, args []string, session *shell.Session) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "-l":
+			for i, entry := range signalTable {
+				fmt.Fprintf(ctx.Stdout, "%2d) SIG%-8s", int(entry.sig), entry.name)
+				if i%5 == 4 || i == len(signalTable)-1 {
+					fmt.Fprintln(ctx.Stdout)
+				}
+			}
+			return nil
+		case "-p":
+			return printTraps(ctx, session, args[1:])
+		case "--":
+			args = args[1:]
+		}
+	}
+
+	if len(args) == 0 {
+		return printTraps(ctx, session, nil)
+	}
+
+	// A lone condition, or a leading signal number, resets the conditions
+	action := args[0]
+	conditions := args[1:]
+	if _, err := strconv.Atoi(action); err == nil || len(args) == 1 {
+		action = "-"
+		conditions = args
+	}
+
+	failed := false
+	for _, spec := range conditions {
+		condition, ok := trapCondition(spec)
+		if !ok {
+			fmt.Fprintf(ctx.Stderr, "trap: %s: invalid signal specification\n", spec)
+			failed = true
+			continue
+		}
+		if condition == "KILL" || condition == "STOP" {
+			fmt.Fprintf(ctx.Stderr, "trap: SIG%s cannot be trapped\n", condition)
+			failed = true
+			continue
+		}
+
+		if action == "-" {
+			session.RemoveTrap(condition)
+		} else {
+			session.SetTrap(condition, action)
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// trapCondition normalizes a condition to EXIT or a signal name
+func trapCondition(spec string) (string, bool) {
+	if spec == "0" || strings.EqualFold(spec, "EXIT") {
+		return "EXIT", true
+	}
+	sig, ok := ParseSignal(spec)
+	if !ok {
+		return "", false
+	}
+	return SignalName(sig), true
+}
+
+// printTraps prints traps as commands that recreate them
+func printTraps(ctx *Context, session *shell.Session, specs []string) error {
+	traps := session.GetTraps()
+
+	var conditions []string
+	if len(specs) == 0 {
+		for condition := range traps {
+			conditions = append(conditions, condition)
+		}
+		// EXIT first, then by signal number
+		sort.Slice(conditions, func(i, j int) bool {
+			a, _ := ParseSignal(conditions[i])
+			b, _ := ParseSignal(conditions[j])
+			return a < b
+		})
+	} else {
+		for _, spec := range specs {
+			condition, ok := trapCondition(spec)
+			if !ok {
+				return fmt.Errorf("trap: %s: invalid signal specification", spec)
+			}
+			conditions = append(conditions, condition)
+		}
+	}
+
+	for _, condition := range conditions {
+		if command, exists := traps[condition]; exists {
+			fmt.Fprintf(ctx.Stdout, "trap -- %s %s\n", shellQuote(command), condition)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,663 @@
+package builtin
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gex/internal/cli"
+	"gex/internal/shell"
+)
+
+// Readonly marks variables as read-only (like readonly command)
+func Readonly(ctx *Context, args []string, session *shell.Session) error {
+	var names []string
+
+	// Parse flags
+	for i, arg := range args {
+		if arg == "-p" {
+			continue
+		}
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("readonly: invalid option: %s", arg)
+		}
+		names = append(names, args[i:]...)
+		break
+	}
+
+	if len(names) == 0 {
+		// Display all read-only variables
+		for _, name := range session.GetReadonly() {
+			if value, exists := session.LookupVariable(name); exists {
+				fmt.Fprintf(ctx.Stdout, "readonly %s=%s\n", name, shellQuote(value))
+			} else {
+				fmt.Fprintf(ctx.Stdout, "readonly %s\n", name)
+			}
+		}
+		return nil
+	}
+
+	for _, arg := range names {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !cli.IsValidVariableName(name) {
+			return fmt.Errorf("readonly: `%s': not a valid identifier", arg)
+		}
+
+		if hasValue {
+			if err := session.AssignVariable(name, value); err != nil {
+				return fmt.Errorf("readonly: %v", err)
+			}
+		}
+		session.SetReadonly(name)
+	}
+
+	return nil
+}
+
+// Unset removes variables and functions (like unset command)
+func Unset(ctx *Context, args []string, session *shell.Session) error {
+	var onlyVars, onlyFuncs bool
+	var names []string
+
+	// Parse flags
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			for _, flag := range arg[1:] {
+				switch flag {
+				case 'v':
+					onlyVars = true
+				case 'f':
+					onlyFuncs = true
+				default:
+					return fmt.Errorf("unset: invalid option: -%c", flag)
+				}
+			}
+		} else {
+			names = append(names, args[i:]...)
+			break
+		}
+	}
+
+	if len(names) == 0 {
+		return fmt.Errorf("unset: usage: unset [-f] [-v] name [name ...]")
+	}
+
+	failed := false
+	for _, name := range names {
+		if onlyFuncs {
+			session.RemoveFunction(name)
+			continue
+		}
+
+		// NAME[subscript] removes one element of an array
+		if arrayName, subscript, ok := cli.SplitSubscript(name); ok {
+			if err := unsetElement(session, arrayName, subscript); err != nil {
+				fmt.Fprintf(ctx.Stderr, "unset: %v\n", err)
+				failed = true
+			}
+			continue
+		}
+
+		// Without -v, fall back to a function when no variable has the name
+		if _, isVar := session.LookupVariable(name); !isVar && !onlyVars {
+			if session.RemoveFunction(name) {
+				continue
+			}
+		}
+
+		if err := session.UnsetVariable(name); err != nil {
+			fmt.Fprintf(ctx.Stderr, "unset: %v\n", err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// unsetElement removes an array element. An indexed array's subscript is
+// an arithmetic expression, counting back from the end when negative.
+func unsetElement(session *shell.Session, name, subscript string) error {
+	array, exists := session.GetArray(name)
+	if !exists {
+		return nil
+	}
+	key := subscript
+	if !array.Associative {
+		vars := cli.ArithVars{Get: session.LookupVariable, Set: session.AssignVariable}
+		index, err := cli.EvalArithmetic(subscript, vars)
+		if err != nil {
+			return fmt.Errorf("%s: bad array subscript: %v", subscript, err)
+		}
+		resolved, ok := array.ResolveIndex(int(index))
+		if !ok {
+			return fmt.Errorf("%s[%s]: bad array subscript", name, subscript)
+		}
+		key = strconv.Itoa(resolved)
+	}
+	return session.UnsetArrayElement(name, key)
+}
+
+// Let evaluates arithmetic expressions (like let command). The exit status
+// is 1 when the last expression evaluates to 0.
+func Let(ctx *Context, args []string, session *shell.Session) error {
+	if len(args) == 0 {
+		return fmt.Errorf("let: expression expected")
+	}
+
+	vars := cli.ArithVars{
+		Get: session.LookupVariable,
+		Set: session.AssignVariable,
+	}
+
+	var result int64
+	for _, expr := range args {
+		value, err := cli.EvalArithmetic(expr, vars)
+		if err != nil {
+			return fmt.Errorf("let: %s: %v", expr, err)
+		}
+		result = value
+	}
+
+	if result == 0 {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// Shift drops the first n positional parameters, one without n (like
+// shift command), through set. The status is 1 when there are fewer than
+// n.
+func Shift(ctx *Context, args []string, params []string, set func(params []string) []string) error {
+	n := 1
+	if len(args) > 1 {
+		return fmt.Errorf("shift: too many arguments")
+	}
+	if len(args) == 1 {
+		count, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("shift: %s: numeric argument required", args[0])
+		}
+		if count < 0 {
+			return fmt.Errorf("shift: %s: shift count out of range", args[0])
+		}
+		n = count
+	}
+
+	if n > len(params) {
+		return ExitStatus(1)
+	}
+	set(params[n:])
+	return nil
+}
+
+// Getopts parses the options in params, or in args after the name when
+// given, one per call (like getopts command). The option found goes in
+// name and its argument in OPTARG; OPTIND is the index of the next
+// argument to look at. The status is 1 once the options run out. An
+// unknown option or a missing argument sets name to ?; with a leading :
+// in optstring nothing is printed and they set name to ? and : with the
+// option in OPTARG.
+func Getopts(ctx *Context, args []string, session *shell.Session, params []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("getopts: usage: getopts optstring name [arg ...]")
+	}
+	optstring, name := args[0], args[1]
+	if !cli.IsValidVariableName(name) {
+		return fmt.Errorf("getopts: `%s': not a valid identifier", name)
+	}
+	if len(args) > 2 {
+		params = args[2:]
+	}
+
+	silent := strings.HasPrefix(optstring, ":")
+	if value, _ := session.LookupVariable("OPTERR"); value == "0" {
+		silent = true
+	}
+
+	index := 1
+	if value, exists := session.LookupVariable("OPTIND"); exists {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			index = n
+		}
+	}
+	offset := session.GetoptsPosition(index)
+
+	// The options end at the first argument that is not one, or after --
+	if offset == 0 {
+		if index > len(params) || params[index-1] == "-" || !strings.HasPrefix(params[index-1], "-") {
+			return endOptions(session, name, index)
+		}
+		if params[index-1] == "--" {
+			return endOptions(session, name, index+1)
+		}
+		offset = 1
+	}
+
+	arg := params[index-1]
+	option := arg[offset : offset+1]
+	offset++
+	if offset >= len(arg) {
+		index++
+		offset = 0
+	}
+
+	spec := strings.Index(strings.TrimPrefix(optstring, ":"), option)
+	var err error
+	switch {
+	case option == ":" || spec < 0:
+		if silent {
+			err = setOption(session, name, "?", option, true)
+		} else {
+			fmt.Fprintf(ctx.Stderr, "getopts: illegal option -- %s\n", option)
+			err = setOption(session, name, "?", "", false)
+		}
+
+	case !strings.HasPrefix(strings.TrimPrefix(optstring, ":")[spec+1:], ":"):
+		err = setOption(session, name, option, "", false)
+
+	case offset > 0:
+		// The argument is the rest of this word, as in -ofile
+		err = setOption(session, name, option, arg[offset:], true)
+		index++
+		offset = 0
+
+	case index <= len(params):
+		err = setOption(session, name, option, params[index-1], true)
+		index++
+
+	case silent:
+		err = setOption(session, name, ":", option, true)
+
+	default:
+		fmt.Fprintf(ctx.Stderr, "getopts: option requires an argument -- %s\n", option)
+		err = setOption(session, name, "?", "", false)
+	}
+	if err != nil {
+		return fmt.Errorf("getopts: %v", err)
+	}
+
+	session.SetGetoptsPosition(index, offset)
+	if err := session.AssignVariable("OPTIND", strconv.Itoa(index)); err != nil {
+		return fmt.Errorf("getopts: %v", err)
+	}
+	return nil
+}
+
+// setOption gives getopts' results to name and OPTARG, which is unset
+// when the option has no argument
+func setOption(session *shell.Session, name, option, optarg string, hasOptarg bool) error {
+	if err := session.AssignVariable(name, option); err != nil {
+		return err
+	}
+	if hasOptarg {
+		return session.AssignVariable("OPTARG", optarg)
+	}
+	return session.UnsetVariable("OPTARG")
+}
+
+// endOptions ends getopts' options with OPTIND at the first operand
+func endOptions(session *shell.Session, name string, index int) error {
+	session.SetGetoptsPosition(index, 0)
+	if err := setOption(session, name, "?", "", false); err != nil {
+		return fmt.Errorf("getopts: %v", err)
+	}
+	if err := session.AssignVariable("OPTIND", strconv.Itoa(index)); err != nil {
+		return fmt.Errorf("getopts: %v", err)
+	}
+	return ExitStatus(1)
+}
+
+// Declare sets the attributes of variables and prints them (like declare
+// command): -a makes indexed arrays, -A associative ones, -i integers
+// whose values are evaluated as arithmetic, -x exports and -r makes them
+// read-only; +i and +x take the attribute away again. -p prints variables
+// as declare commands. Words of the form NAME=value reach it unexpanded,
+// for assign to perform after the attributes are set, so NAME=(...) can
+// fill an associative array and an integer gets the value computed.
+func Declare(ctx *Context, args []string, session *shell.Session, assign func(word string) error) error {
+	return declareVariables(ctx, "declare", args, session, assign)
+}
+
+// Local makes variables local to the running function (like local
+// command). It takes the options of declare; the names get their values
+// back when the function returns.
+func Local(ctx *Context, args []string, session *shell.Session, assign func(word string) error) error {
+	if !session.InFunction() {
+		return fmt.Errorf("local: can only be used in a function")
+	}
+	return declareVariables(ctx, "local", args, session, assign)
+}
+
+// declareVariables does the work of declare and local, which command
+// names
+func declareVariables(ctx *Context, command string, args []string, session *shell.Session, assign func(word string) error) error {
+	local := command == "local"
+	var indexed, associative, print bool
+
+	// The i, x and r attributes to set (true) or take away (false)
+	attributes := make(map[rune]bool)
+
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			i++
+			break
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			break
+		}
+		set := arg[0] == '-'
+		for _, flag := range arg[1:] {
+			switch {
+			case flag == 'a' && set:
+				indexed = true
+			case flag == 'A' && set:
+				associative = true
+			case flag == 'p' && set:
+				print = true
+			case flag == 'i' || flag == 'x':
+				attributes[flag] = set
+			case flag == 'r' && set:
+				attributes[flag] = true
+			default:
+				return fmt.Errorf("%s: invalid option: %c%c", command, arg[0], flag)
+			}
+		}
+	}
+	if indexed && associative {
+		return fmt.Errorf("%s: cannot use -a and -A together", command)
+	}
+	names := args[i:]
+
+	failed := false
+	if print || len(names) == 0 {
+		// Listing every variable skips locals that are still unset
+		listing := len(names) == 0
+		if listing && local {
+			names = session.LocalNames()
+		} else if listing {
+			names = declaredNames(session, indexed, associative, attributes)
+		}
+		for _, name := range names {
+			if !printDeclaration(ctx, session, name) && !listing {
+				fmt.Fprintf(ctx.Stdout, "%s: %s: not found\n", command, name)
+				failed = true
+			}
+		}
+		if failed {
+			return ExitStatus(1)
+		}
+		return nil
+	}
+
+	for _, word := range names {
+		assignment, isAssignment := cli.ParseAssignment(word)
+		name := assignment.Name
+		if !isAssignment {
+			if !cli.IsValidVariableName(word) {
+				fmt.Fprintf(ctx.Stdout, "%s: `%s': not a valid identifier\n", command, word)
+				failed = true
+				continue
+			}
+			name = word
+		}
+
+		// A local array or a variable local without a value starts out
+		// unset; local x=$x still sees the outer x
+		if local {
+			if err := session.DeclareLocal(name, !isAssignment || assignment.Compound || indexed || associative); err != nil {
+				fmt.Fprintf(ctx.Stdout, "%s: %v\n", command, err)
+				failed = true
+				continue
+			}
+		}
+		if indexed || associative {
+			if err := session.DeclareArray(name, associative); err != nil {
+				fmt.Fprintf(ctx.Stdout, "%s: %v\n", command, err)
+				failed = true
+				continue
+			}
+		}
+		if integer, ok := attributes['i']; ok {
+			if err := session.SetInteger(name, integer); err != nil {
+				fmt.Fprintf(ctx.Stdout, "%s: %v\n", command, err)
+				failed = true
+				continue
+			}
+		}
+		if isAssignment {
+			if err := assign(word); err != nil {
+				fmt.Fprintf(ctx.Stdout, "%s: %v\n", command, err)
+				failed = true
+				continue
+			}
+		}
+		if export, ok := attributes['x']; ok {
+			session.SetExported(name, export)
+		}
+		if attributes['r'] {
+			session.SetReadonly(name)
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// declaredNames returns the sorted names of the shell's variables and
+// arrays. With indexed or associative only those arrays are listed, and
+// with attributes only the variables that have the attributes set in it.
+func declaredNames(session *shell.Session, indexed, associative bool, attributes map[rune]bool) []string {
+	candidates := make(map[string]bool)
+	if !indexed && !associative {
+		for name := range session.GetVariables() {
+			candidates[name] = true
+		}
+		for _, name := range session.GetReadonly() {
+			candidates[name] = true
+		}
+		if attributes['x'] {
+			for _, env := range os.Environ() {
+				if name, _, _ := strings.Cut(env, "="); cli.IsValidVariableName(name) {
+					candidates[name] = true
+				}
+			}
+		}
+	}
+	for name, array := range session.GetArrays() {
+		if !indexed && !associative || array.Associative == associative {
+			candidates[name] = true
+		}
+	}
+
+	var names []string
+	for name := range candidates {
+		has := variableAttributes(session, name)
+		if attributes['i'] && !strings.ContainsRune(has, 'i') ||
+			attributes['r'] && !strings.ContainsRune(has, 'r') ||
+			attributes['x'] && !strings.ContainsRune(has, 'x') {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// variableAttributes returns the attribute letters of a variable in the
+// order declare prints them
+func variableAttributes(session *shell.Session, name string) string {
+	var attributes strings.Builder
+	if array, isArray := session.GetArray(name); isArray {
+		if array.Associative {
+			attributes.WriteByte('A')
+		} else {
+			attributes.WriteByte('a')
+		}
+	}
+	if session.IsInteger(name) {
+		attributes.WriteByte('i')
+	}
+	if session.IsReadonly(name) {
+		attributes.WriteByte('r')
+	}
+	if session.IsExported(name) {
+		attributes.WriteByte('x')
+	}
+	return attributes.String()
+}
+
+// printDeclaration prints a variable as a declare command that recreates
+// it, reporting whether it exists. A variable with attributes but no
+// value yet is printed without one.
+func printDeclaration(ctx *Context, session *shell.Session, name string) bool {
+	flags := "--"
+	if attributes := variableAttributes(session, name); attributes != "" {
+		flags = "-" + attributes
+	}
+
+	if array, isArray := session.GetArray(name); isArray {
+		fmt.Fprintf(ctx.Stdout, "declare %s %s=%s\n", flags, name, formatArray(array))
+		return true
+	}
+	if value, exists := session.LookupVariable(name); exists {
+		fmt.Fprintf(ctx.Stdout, "declare %s %s=%s\n", flags, name, shellQuote(value))
+		return true
+	}
+	if flags != "--" {
+		fmt.Fprintf(ctx.Stdout, "declare %s %s\n", flags, name)
+		return true
+	}
+	return false
+}
+
+// formatArray writes an array as the (...) of an assignment that
+// recreates it
+func formatArray(array *shell.Array) string {
+	elements := make([]string, 0, len(array.Elements))
+	for _, key := range array.Keys() {
+		subscript := key
+		if array.Associative {
+			subscript = shellQuote(key)
+		}
+		elements = append(elements, "["+subscript+"]="+shellQuote(array.Elements[key]))
+	}
+	return "(" + strings.Join(elements, " ") + ")"
+}
+
+// setFlagOptions maps single-letter set flags to option names
+var setFlagOptions = map[rune]string{
+	'C': "noclobber",
+	'e': "errexit",
+	'u': "nounset",
+	'x': "xtrace",
+}
+
+// Set changes shell options and positional parameters (like set command)
+func Set(ctx *Context, args []string, session *shell.Session) error {
+	if len(args) == 0 {
+		// Display all shell variables, arrays among them
+		values := make(map[string]string)
+		for name, value := range session.GetVariables() {
+			values[name] = shellQuote(value)
+		}
+		for name, array := range session.GetArrays() {
+			values[name] = formatArray(array)
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(ctx.Stdout, "%s=%s\n", name, values[name])
+		}
+		return nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// set -- args and set args replace the positional parameters
+		if arg == "--" {
+			session.SetPositionalParams(args[i+1:])
+			return nil
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			session.SetPositionalParams(args[i:])
+			return nil
+		}
+
+		enable := arg[0] == '-'
+		for _, flag := range arg[1:] {
+			if flag != 'o' {
+				name, ok := setFlagOptions[flag]
+				if !ok {
+					return fmt.Errorf("set: invalid option: %c%c", arg[0], flag)
+				}
+				session.SetOption(name, enable)
+				continue
+			}
+
+			// -o NAME / +o NAME, or list the options without a name
+			if i+1 >= len(args) {
+				printShellOptions(ctx, session, enable)
+				return nil
+			}
+			i++
+			if !shell.IsShellOption(args[i]) {
+				return fmt.Errorf("set: %s: invalid option name", args[i])
+			}
+			session.SetOption(args[i], enable)
+		}
+	}
+
+	return nil
+}
+
+// printShellOptions lists options as a table (set -o) or as commands that
+// restore them (set +o)
+func printShellOptions(ctx *Context, session *shell.Session, table bool) {
+	for _, name := range shell.ShellOptions {
+		enabled := session.Option(name)
+		if table {
+			state := "off"
+			if enabled {
+				state = "on"
+			}
+			fmt.Fprintf(ctx.Stdout, "%-15s %s\n", name, state)
+		} else if enabled {
+			fmt.Fprintf(ctx.Stdout, "set -o %s\n", name)
+		} else {
+			fmt.Fprintf(ctx.Stdout, "set +o %s\n", name)
+		}
+	}
+}
+
+// shellQuote quotes a value so it can be read back by the shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// doubleQuote quotes a value in double quotes, escaping the characters
+// that are special inside them
+func doubleQuote(value string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for _, c := range value {
+		if c == '"' || c == '\\' || c == '$' || c == '`' {
+			quoted.WriteByte('\\')
+		}
+		quoted.WriteRune(c)
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
@@ -0,0 +1,411 @@
+package builtin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gex/internal/config"
+	"gex/internal/shell"
+	"gex/internal/ui"
+)
+
+// weatherCacheTTL is how long a forecast is shown without fetching it again
+const weatherCacheTTL = 30 * time.Minute
+
+// errUnknownLocation is the service's answer for places it cannot find
+var errUnknownLocation = errors.New("unknown location")
+
+// wttrValue is how wttr.in wraps descriptions and names
+type wttrValue []struct {
+	Value string `json:"value"`
+}
+
+func (v wttrValue) String() string {
+	if len(v) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(v[0].Value)
+}
+
+// wttrReport is the part of the wttr.in format=j1 response that is shown
+type wttrReport struct {
+	CurrentCondition []struct {
+		TempC        string    `json:"temp_C"`
+		TempF        string    `json:"temp_F"`
+		FeelsLikeC   string    `json:"FeelsLikeC"`
+		FeelsLikeF   string    `json:"FeelsLikeF"`
+		Humidity     string    `json:"humidity"`
+		PrecipMM     string    `json:"precipMM"`
+		PrecipInches string    `json:"precipInches"`
+		WindKmph     string    `json:"windspeedKmph"`
+		WindMiles    string    `json:"windspeedMiles"`
+		WindDir      string    `json:"winddir16Point"`
+		Description  wttrValue `json:"weatherDesc"`
+		Observed     string    `json:"localObsDateTime"`
+	} `json:"current_condition"`
+	NearestArea []struct {
+		AreaName wttrValue `json:"areaName"`
+		Region   wttrValue `json:"region"`
+		Country  wttrValue `json:"country"`
+	} `json:"nearest_area"`
+	Weather []struct {
+		Date     string `json:"date"`
+		MinTempC string `json:"mintempC"`
+		MaxTempC string `json:"maxtempC"`
+		MinTempF string `json:"mintempF"`
+		MaxTempF string `json:"maxtempF"`
+		Hourly   []struct {
+			Time         string    `json:"time"`
+			Description  wttrValue `json:"weatherDesc"`
+			ChanceOfRain string    `json:"chanceofrain"`
+		} `json:"hourly"`
+		Astronomy []struct {
+			Sunrise string `json:"sunrise"`
+			Sunset  string `json:"sunset"`
+		} `json:"astronomy"`
+	} `json:"weather"`
+}
+
+// Weather shows current conditions and a short forecast from a wttr.in
+// compatible service. Responses are cached for half an hour, and a stale
+// copy is shown when the service cannot be reached.
+func Weather(ctx *Context, args []string, session *shell.Session) error {
+	location := session.Config().WeatherLocation
+	imperial := false
+	refresh := false
+	days := 3
+	var words []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-u", "--us":
+			imperial = true
+		case "-m", "--metric":
+			imperial = false
+		case "-r", "--refresh":
+			refresh = true
+		case "-d", "--days":
+			if i+1 >= len(args) {
+				return fmt.Errorf("weather: %s requires a count", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 || n > 3 {
+				return fmt.Errorf("weather: invalid day count: %s (0 to 3)", args[i])
+			}
+			days = n
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("weather: invalid option: %s", arg)
+			}
+			words = append(words, arg)
+		}
+	}
+	if len(words) > 0 {
+		location = strings.Join(words, " ")
+	}
+
+	endpoint := strings.TrimRight(session.Config().WeatherURL, "/")
+	if endpoint == "" {
+		return fmt.Errorf("weather: no service configured (set weather_url)")
+	}
+	// wttr.in takes spaces in names as +; without a location it uses the
+	// caller's address
+	requestURL := endpoint + "/" + url.PathEscape(strings.ReplaceAll(location, " ", "+")) + "?format=j1"
+
+	data, fetched, err := fetchWeather(ctx, requestURL, refresh)
+	if errors.Is(err, errUnknownLocation) && location != "" {
+		return fmt.Errorf("weather: %s: %v", location, err)
+	}
+	if err != nil {
+		return fmt.Errorf("weather: %v", err)
+	}
+
+	var report wttrReport
+	if err := json.Unmarshal(data, &report); err != nil || len(report.CurrentCondition) == 0 {
+		os.Remove(weatherCachePath(requestURL))
+		if location == "" {
+			return fmt.Errorf("weather: unexpected response from %s", endpoint)
+		}
+		return fmt.Errorf("weather: %s: unknown location", location)
+	}
+
+	printWeather(ctx, &report, imperial, days)
+	if age := time.Since(fetched); age >= time.Minute {
+		fmt.Fprintf(ctx.Stdout, "\n(cached, fetched %s ago)\n", formatAge(age))
+	}
+	return nil
+}
+
+// formatAge formats a cache age in whole minutes, hours or days
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%d min", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%d h", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%d days", int(age.Hours()/24))
+	}
+}
+
+// weatherCachePath returns the cache file of a request
+func weatherCachePath(requestURL string) string {
+	return filepath.Join(config.GetDataDir(), "weather", httpCacheKey(requestURL)+".json")
+}
+
+// fetchWeather returns the response for a request and when it was fetched,
+// from the cache while it is fresh
+func fetchWeather(ctx *Context, requestURL string, refresh bool) ([]byte, time.Time, error) {
+	path := weatherCachePath(requestURL)
+	cached, cacheErr := os.ReadFile(path)
+	var stored time.Time
+	if cacheErr == nil {
+		if info, err := os.Stat(path); err == nil {
+			stored = info.ModTime()
+		}
+		if !refresh && time.Since(stored) < weatherCacheTTL {
+			return cached, stored, nil
+		}
+	}
+
+	fetching := ui.NewSpinner(ctx.Stderr, "Fetching the forecast...").Start()
+	data, err := downloadWeather(requestURL)
+	fetching.Stop()
+	if err != nil {
+		if cacheErr == nil && !errors.Is(err, errUnknownLocation) {
+			fmt.Fprintf(ctx.Stderr, "weather: %v; showing the last forecast\n", err)
+			return cached, stored, nil
+		}
+		return nil, time.Time{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+		os.WriteFile(path, data, 0644)
+	}
+	return data, time.Now(), nil
+}
+
+// downloadWeather performs the request itself
+func downloadWeather(requestURL string) ([]byte, error) {
+	transport, err := newHTTPTransport(httpOptions{headerTimeout: 15 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: transport, Timeout: 30 * time.Second}
+
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// wttr.in picks its output format from the client
+	req.Header.Set("User-Agent", "curl/8 (gex)")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errUnknownLocation
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+}
+
+// printWeather renders a report as plain text
+func printWeather(ctx *Context, report *wttrReport, imperial bool, days int) {
+	current := report.CurrentCondition[0]
+
+	if len(report.NearestArea) > 0 {
+		area := report.NearestArea[0]
+		var parts []string
+		for _, part := range []string{area.AreaName.String(), area.Region.String(), area.Country.String()} {
+			if part != "" && (len(parts) == 0 || parts[len(parts)-1] != part) {
+				parts = append(parts, part)
+			}
+		}
+		fmt.Fprintln(ctx.Stdout, strings.Join(parts, ", "))
+	}
+
+	temp, feels, unit := current.TempC, current.FeelsLikeC, "°C"
+	wind, windUnit := current.WindKmph, "km/h"
+	precip, precipUnit := current.PrecipMM, "mm"
+	if imperial {
+		temp, feels, unit = current.TempF, current.FeelsLikeF, "°F"
+		wind, windUnit = current.WindMiles, "mph"
+		precip, precipUnit = current.PrecipInches, "in"
+	}
+
+	fmt.Fprintf(ctx.Stdout, "  %s, %s%s (feels like %s%s)\n", current.Description, temp, unit, feels, unit)
+	fmt.Fprintf(ctx.Stdout, "  Wind %s %s %s, humidity %s%%, precipitation %s %s\n",
+		wind, windUnit, current.WindDir, current.Humidity, precip, precipUnit)
+	if current.Observed != "" {
+		fmt.Fprintf(ctx.Stdout, "  Observed %s\n", current.Observed)
+	}
+
+	if days > len(report.Weather) {
+		days = len(report.Weather)
+	}
+	if days > 0 {
+		fmt.Fprintln(ctx.Stdout)
+	}
+	for _, day := range report.Weather[:days] {
+		label := day.Date
+		if date, err := time.Parse("2006-01-02", day.Date); err == nil {
+			label = date.Format("Mon 02 Jan")
+		}
+
+		low, high := day.MinTempC, day.MaxTempC
+		if imperial {
+			low, high = day.MinTempF, day.MaxTempF
+		}
+
+		// The midday forecast describes the day best
+		description, rain := "", ""
+		for _, hour := range day.Hourly {
+			if description == "" || hour.Time == "1200" {
+				description = hour.Description.String()
+				rain = hour.ChanceOfRain
+			}
+		}
+		if rain != "" && rain != "0" {
+			description += fmt.Sprintf(" (%s%% rain)", rain)
+		}
+
+		sun := ""
+		if len(day.Astronomy) > 0 {
+			sun = fmt.Sprintf("  ↑%s ↓%s", day.Astronomy[0].Sunrise, day.Astronomy[0].Sunset)
+		}
+
+		fmt.Fprintf(ctx.Stdout, "  %-11s %4s%s %4s%s  %-28s%s\n", label, low, unit, high, unit, description, sun)
+	}
+}
+
+// Worldclock shows the current time in time zones, given by their tz
+// names or just a city ("tokyo", "new york")
+func Worldclock(ctx *Context, args []string, session *shell.Session) error {
+	zones := args
+	if len(zones) == 0 {
+		zones = session.Config().WorldclockZones
+	}
+	if len(zones) == 0 {
+		zones = []string{"Local", "UTC"}
+	}
+
+	now := time.Now()
+	_, localOffset := now.Zone()
+
+	type clockRow struct {
+		name string
+		when time.Time
+	}
+	var rows []clockRow
+	width := 0
+	failed := false
+	for _, zone := range zones {
+		location, err := findTimeZone(zone)
+		if err != nil {
+			fmt.Fprintf(ctx.Stderr, "worldclock: %s: unknown time zone\n", zone)
+			failed = true
+			continue
+		}
+
+		name := location.String()
+		if location == time.Local {
+			name = "Local"
+		}
+		rows = append(rows, clockRow{name, now.In(location)})
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	for _, row := range rows {
+		abbrev, offset := row.when.Zone()
+		relative := "local time"
+		if diff := offset - localOffset; diff != 0 {
+			relative = formatZoneOffset(diff)
+		}
+		fmt.Fprintf(ctx.Stdout, "%-*s  %s  %-5s UTC%s  %s\n", width, row.name, row.when.Format("Mon 02 Jan 15:04"),
+			abbrev, row.when.Format("-07:00"), relative)
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// formatZoneOffset formats an offset in seconds as +5h, -3:30h or +0h
+func formatZoneOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	hours, minutes := seconds/3600, seconds%3600/60
+	if minutes != 0 {
+		return fmt.Sprintf("%s%d:%02dh", sign, hours, minutes)
+	}
+	return fmt.Sprintf("%s%dh", sign, hours)
+}
+
+// findTimeZone loads a zone by its tz name, ignoring case and accepting
+// spaces for underscores, or by its city alone
+func findTimeZone(name string) (*time.Location, error) {
+	if strings.EqualFold(name, "local") {
+		return time.Local, nil
+	}
+	if location, err := time.LoadLocation(name); err == nil {
+		return location, nil
+	}
+
+	wanted := strings.ReplaceAll(strings.TrimSpace(name), " ", "_")
+	dirs := []string{"/usr/share/zoneinfo", "/usr/lib/zoneinfo", "/usr/share/lib/zoneinfo"}
+	if dir := os.Getenv("ZONEINFO"); dir != "" {
+		dirs = append([]string{dir}, dirs...)
+	}
+
+	for _, dir := range dirs {
+		match := ""
+		filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			rel, _ := filepath.Rel(dir, path)
+			if entry.IsDir() {
+				// Duplicate trees with leap seconds or POSIX names
+				if rel == "posix" || rel == "right" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.EqualFold(rel, wanted) || (match == "" && strings.EqualFold(entry.Name(), wanted)) {
+				match = rel
+			}
+			if strings.EqualFold(rel, wanted) {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		if match != "" {
+			return time.LoadLocation(match)
+		}
+	}
+	return nil, fmt.Errorf("unknown time zone %s", name)
+}
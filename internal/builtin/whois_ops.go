@@ -0,0 +1,206 @@
+package builtin
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// whoisRootServer knows which registry serves every TLD and address block
+const whoisRootServer = "whois.iana.org"
+
+// maxWhoisReferrals stops referral chains that loop between servers
+const maxWhoisReferrals = 5
+
+// whoisReferralKeys are the response fields that name a more specific server
+var whoisReferralKeys = []string{
+	"refer",
+	"whois",
+	"referralserver",
+	"registrar whois server",
+	"whois server",
+}
+
+// Whois queries WHOIS servers for a domain or IP address, starting at IANA
+// and following referrals to the registry and registrar
+func Whois(ctx *Context, args []string) error {
+	server := ""
+	port := 43
+	follow := true
+	showAll := false
+	var queries []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch arg {
+		case "-h", "--host":
+			if i+1 >= len(args) {
+				return fmt.Errorf("whois: %s requires a server", arg)
+			}
+			i++
+			server = args[i]
+		case "-p", "--port":
+			if i+1 >= len(args) {
+				return fmt.Errorf("whois: %s requires a port", arg)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("whois: invalid port: %s", args[i])
+			}
+			port = n
+		case "-r", "--no-follow":
+			follow = false
+		case "-a", "--all":
+			showAll = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("whois: invalid option: %s", arg)
+			}
+			queries = append(queries, arg)
+		}
+	}
+
+	if len(queries) == 0 {
+		return fmt.Errorf("whois: usage: whois [-h server] [-p port] [-r] [-a] domain|ip...")
+	}
+
+	failed := false
+	for _, query := range queries {
+		start := server
+		if start == "" {
+			start = whoisRootServer
+		}
+		if err := whoisChain(ctx, query, net.JoinHostPort(start, strconv.Itoa(port)), follow, showAll); err != nil {
+			fmt.Fprintf(ctx.Stderr, "whois: %s: %v\n", query, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return ExitStatus(1)
+	}
+	return nil
+}
+
+// whoisChain queries addr and each server it refers to, printing the most
+// specific answer (or every answer with showAll)
+func whoisChain(ctx *Context, query, addr string, follow, showAll bool) error {
+	visited := make(map[string]bool)
+	var last string
+
+	for hop := 0; hop <= maxWhoisReferrals; hop++ {
+		visited[strings.ToLower(addr)] = true
+
+		response, err := whoisQuery(addr, whoisRequest(addr, query))
+		if err != nil {
+			if last != "" {
+				// Registrars are often flaky; the registry answer still helps
+				fmt.Fprintf(ctx.Stderr, "whois: %s: %v\n", addr, err)
+				break
+			}
+			return err
+		}
+		last = response
+
+		if showAll {
+			fmt.Fprintf(ctx.Stdout, "[%s]\n%s\n", addr, strings.TrimRight(response, "\n"))
+		}
+
+		if !follow {
+			break
+		}
+		next := whoisReferral(response)
+		if next == "" || visited[strings.ToLower(next)] {
+			break
+		}
+		addr = next
+	}
+
+	if !showAll {
+		fmt.Fprintln(ctx.Stdout, strings.TrimRight(last, "\n"))
+	}
+	return nil
+}
+
+// whoisRequest formats a query for servers that need special syntax
+func whoisRequest(addr, query string) string {
+	host, _, _ := net.SplitHostPort(addr)
+	switch strings.ToLower(host) {
+	case "whois.verisign-grs.com":
+		// Without the keyword, name servers matching the query are listed too
+		return "domain " + query
+	case "whois.arin.net":
+		// Ask for networks only, not the organizations and contacts
+		if net.ParseIP(query) != nil {
+			return "n + " + query
+		}
+	}
+	return query
+}
+
+// whoisQuery sends one query and reads the response until the server closes
+// the connection
+func whoisQuery(addr, query string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(20 * time.Second))
+
+	if _, err := io.WriteString(conn, query+"\r\n"); err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(conn, 1<<20))
+	if err != nil && len(data) == 0 {
+		return "", err
+	}
+
+	return strings.ReplaceAll(string(data), "\r\n", "\n"), nil
+}
+
+// whoisReferral extracts the next server from a response as host:port
+func whoisReferral(response string) string {
+	for _, line := range strings.Split(response, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		isReferral := false
+		for _, referralKey := range whoisReferralKeys {
+			if key == referralKey {
+				isReferral = true
+				break
+			}
+		}
+		if !isReferral {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "rwhois://") || strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://") {
+			// Referral WHOIS and web forms speak other protocols
+			continue
+		}
+		value = strings.TrimPrefix(value, "whois://")
+		value = strings.TrimSuffix(value, "/")
+		if value == "" || strings.ContainsAny(value, " \t") {
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			value = net.JoinHostPort(value, "43")
+		}
+		return value
+	}
+
+	return ""
+}
@@ -0,0 +1,494 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ArithVars gives the arithmetic evaluator access to shell variables
+type ArithVars struct {
+	Get func(name string) (string, bool)
+	Set func(name, value string) error
+}
+
+// EvalArithmetic evaluates a shell arithmetic expression such as those used
+// by let and (( )). Variables are read and assigned through vars.
+func EvalArithmetic(expr string, vars ArithVars) (int64, error) {
+	tokens, err := tokenizeArith(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	a := &arithParser{tokens: tokens, vars: vars}
+	if len(tokens) == 0 {
+		return 0, nil
+	}
+
+	value, err := a.parseComma()
+	if err != nil {
+		return 0, err
+	}
+	if a.pos < len(a.tokens) {
+		return 0, fmt.Errorf("syntax error in expression (error token is \"%s\")", a.tokens[a.pos].text)
+	}
+	return value, nil
+}
+
+type arithTokenKind int
+
+const (
+	arithNumber arithTokenKind = iota
+	arithName
+	arithOp
+)
+
+type arithToken struct {
+	kind arithTokenKind
+	text string
+}
+
+// arithOperators lists operators longest first so tokenizing is greedy
+var arithOperators = []string{
+	"<<=", ">>=", "**",
+	"++", "--", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"+=", "-=", "*=", "/=", "%=", "&=", "^=", "|=",
+	"+", "-", "*", "/", "%", "<", ">", "=", "!", "~", "&", "^", "|",
+	"?", ":", ",", "(", ")",
+}
+
+// tokenizeArith splits an arithmetic expression into tokens
+func tokenizeArith(expr string) ([]arithToken, error) {
+	var tokens []arithToken
+	i := 0
+
+	for i < len(expr) {
+		ch := expr[i]
+
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+
+		case ch >= '0' && ch <= '9':
+			start := i
+			for i < len(expr) && isNameChar(expr[i]) {
+				i++
+			}
+			tokens = append(tokens, arithToken{arithNumber, expr[start:i]})
+
+		case isNameChar(ch):
+			start := i
+			for i < len(expr) && isNameChar(expr[i]) {
+				i++
+			}
+			tokens = append(tokens, arithToken{arithName, expr[start:i]})
+
+		default:
+			matched := false
+			for _, op := range arithOperators {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, arithToken{arithOp, op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("syntax error: invalid arithmetic operator (error token is \"%s\")", expr[i:])
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// arithParser is a recursive descent evaluator following C precedence.
+// skip > 0 means the current branch is not taken (short-circuit or the
+// unused arm of ?:), so side effects like assignments are suppressed.
+type arithParser struct {
+	tokens []arithToken
+	pos    int
+	vars   ArithVars
+	skip   int
+}
+
+func (a *arithParser) peekOp(ops ...string) string {
+	if a.pos >= len(a.tokens) || a.tokens[a.pos].kind != arithOp {
+		return ""
+	}
+	for _, op := range ops {
+		if a.tokens[a.pos].text == op {
+			return op
+		}
+	}
+	return ""
+}
+
+func (a *arithParser) expectOp(op string) error {
+	if a.peekOp(op) == "" {
+		return fmt.Errorf("syntax error: expected '%s'", op)
+	}
+	a.pos++
+	return nil
+}
+
+// parseComma handles expr, expr
+func (a *arithParser) parseComma() (int64, error) {
+	value, err := a.parseAssign()
+	for err == nil && a.peekOp(",") != "" {
+		a.pos++
+		value, err = a.parseAssign()
+	}
+	return value, err
+}
+
+// parseAssign handles name = expr and compound assignments
+func (a *arithParser) parseAssign() (int64, error) {
+	if a.pos+1 < len(a.tokens) && a.tokens[a.pos].kind == arithName && a.tokens[a.pos+1].kind == arithOp {
+		op := a.tokens[a.pos+1].text
+		if op == "=" || (strings.HasSuffix(op, "=") && op != "==" && op != "!=" && op != "<=" && op != ">=") {
+			name := a.tokens[a.pos].text
+			a.pos += 2
+
+			value, err := a.parseAssign()
+			if err != nil {
+				return 0, err
+			}
+
+			if op != "=" {
+				current, err := a.variable(name)
+				if err != nil {
+					return 0, err
+				}
+				value, err = applyBinary(strings.TrimSuffix(op, "="), current, value)
+				if err != nil {
+					return 0, err
+				}
+			}
+
+			return value, a.assign(name, value)
+		}
+	}
+
+	return a.parseTernary()
+}
+
+// parseTernary handles cond ? a : b
+func (a *arithParser) parseTernary() (int64, error) {
+	cond, err := a.parseBinary(0)
+	if err != nil || a.peekOp("?") == "" {
+		return cond, err
+	}
+	a.pos++
+
+	if cond == 0 {
+		a.skip++
+	}
+	first, err := a.parseAssign()
+	if cond == 0 {
+		a.skip--
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := a.expectOp(":"); err != nil {
+		return 0, err
+	}
+
+	if cond != 0 {
+		a.skip++
+	}
+	second, err := a.parseAssign()
+	if cond != 0 {
+		a.skip--
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if cond != 0 {
+		return first, nil
+	}
+	return second, nil
+}
+
+// binaryLevels lists binary operators from lowest to highest precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parseBinary handles left-associative binary operators by precedence level
+func (a *arithParser) parseBinary(level int) (int64, error) {
+	if level >= len(binaryLevels) {
+		return a.parsePower()
+	}
+
+	left, err := a.parseBinary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := a.peekOp(binaryLevels[level]...)
+		if op == "" {
+			return left, nil
+		}
+		a.pos++
+
+		// Short-circuit: evaluate the right side without side effects
+		shortCircuit := (op == "&&" && left == 0) || (op == "||" && left != 0)
+		if shortCircuit {
+			a.skip++
+		}
+		right, err := a.parseBinary(level + 1)
+		if shortCircuit {
+			a.skip--
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if shortCircuit {
+			left = boolToInt(op == "||")
+			continue
+		}
+
+		left, err = applyBinary(op, left, right)
+		if err != nil {
+			// Errors like division by zero don't count in skipped branches
+			if a.skip > 0 {
+				left = 0
+				continue
+			}
+			return 0, err
+		}
+	}
+}
+
+// parsePower handles right-associative exponentiation
+func (a *arithParser) parsePower() (int64, error) {
+	base, err := a.parseUnary()
+	if err != nil || a.peekOp("**") == "" {
+		return base, err
+	}
+	a.pos++
+
+	exponent, err := a.parsePower()
+	if err != nil {
+		return 0, err
+	}
+	return applyBinary("**", base, exponent)
+}
+
+// parseUnary handles prefix operators
+func (a *arithParser) parseUnary() (int64, error) {
+	switch op := a.peekOp("+", "-", "!", "~", "++", "--"); op {
+	case "+", "-", "!", "~":
+		a.pos++
+		value, err := a.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case "-":
+			return -value, nil
+		case "!":
+			return boolToInt(value == 0), nil
+		case "~":
+			return ^value, nil
+		}
+		return value, nil
+
+	case "++", "--":
+		a.pos++
+		if a.pos >= len(a.tokens) || a.tokens[a.pos].kind != arithName {
+			return 0, fmt.Errorf("syntax error: operand expected after %s", op)
+		}
+		name := a.tokens[a.pos].text
+		a.pos++
+
+		value, err := a.variable(name)
+		if err != nil {
+			return 0, err
+		}
+		if op == "++" {
+			value++
+		} else {
+			value--
+		}
+		return value, a.assign(name, value)
+	}
+
+	return a.parsePostfix()
+}
+
+// parsePostfix handles name++ and name--
+func (a *arithParser) parsePostfix() (int64, error) {
+	if a.pos+1 < len(a.tokens) && a.tokens[a.pos].kind == arithName {
+		if op := a.tokens[a.pos+1]; op.kind == arithOp && (op.text == "++" || op.text == "--") {
+			name := a.tokens[a.pos].text
+			a.pos += 2
+
+			value, err := a.variable(name)
+			if err != nil {
+				return 0, err
+			}
+			next := value + 1
+			if op.text == "--" {
+				next = value - 1
+			}
+			return value, a.assign(name, next)
+		}
+	}
+
+	return a.parsePrimary()
+}
+
+// parsePrimary handles numbers, variables and parentheses
+func (a *arithParser) parsePrimary() (int64, error) {
+	if a.pos >= len(a.tokens) {
+		return 0, errors.New("syntax error: operand expected")
+	}
+
+	token := a.tokens[a.pos]
+	switch token.kind {
+	case arithNumber:
+		a.pos++
+		return parseArithNumber(token.text)
+
+	case arithName:
+		a.pos++
+		return a.variable(token.text)
+	}
+
+	if token.text == "(" {
+		a.pos++
+		value, err := a.parseComma()
+		if err != nil {
+			return 0, err
+		}
+		return value, a.expectOp(")")
+	}
+
+	return 0, fmt.Errorf("syntax error: operand expected (error token is \"%s\")", token.text)
+}
+
+// variable reads a variable as an integer; unset and empty variables are 0
+func (a *arithParser) variable(name string) (int64, error) {
+	if a.vars.Get == nil {
+		return 0, nil
+	}
+
+	value, exists := a.vars.Get(name)
+	value = strings.TrimSpace(value)
+	if !exists || value == "" {
+		return 0, nil
+	}
+
+	n, err := parseArithNumber(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid integer value: %s", name, value)
+	}
+	return n, nil
+}
+
+// assign stores an integer in a variable unless evaluation is being skipped
+func (a *arithParser) assign(name string, value int64) error {
+	if a.skip > 0 || a.vars.Set == nil {
+		return nil
+	}
+	return a.vars.Set(name, strconv.FormatInt(value, 10))
+}
+
+// parseArithNumber parses decimal, hex (0x) and octal (leading 0) constants
+func parseArithNumber(text string) (int64, error) {
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+
+	n, err := strconv.ParseInt(text, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number: %s", text)
+	}
+	if negative {
+		n = -n
+	}
+	return n, nil
+}
+
+// applyBinary applies a binary operator
+func applyBinary(op string, left, right int64) (int64, error) {
+	switch op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, errors.New("division by 0")
+		}
+		if op == "/" {
+			return left / right, nil
+		}
+		return left % right, nil
+	case "**":
+		if right < 0 {
+			return 0, errors.New("exponent less than 0")
+		}
+		result := int64(1)
+		for right > 0 {
+			if right&1 == 1 {
+				result *= left
+			}
+			left *= left
+			right >>= 1
+		}
+		return result, nil
+	case "<<":
+		return left << uint64(right), nil
+	case ">>":
+		return left >> uint64(right), nil
+	case "&":
+		return left & right, nil
+	case "|":
+		return left | right, nil
+	case "^":
+		return left ^ right, nil
+	case "&&":
+		return boolToInt(left != 0 && right != 0), nil
+	case "||":
+		return boolToInt(left != 0 || right != 0), nil
+	case "==":
+		return boolToInt(left == right), nil
+	case "!=":
+		return boolToInt(left != right), nil
+	case "<":
+		return boolToInt(left < right), nil
+	case "<=":
+		return boolToInt(left <= right), nil
+	case ">":
+		return boolToInt(left > right), nil
+	case ">=":
+		return boolToInt(left >= right), nil
+	}
+	return 0, fmt.Errorf("unknown operator: %s", op)
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
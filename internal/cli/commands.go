@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"sort"
 	"strings"
 )
 
@@ -19,6 +20,35 @@ type CommandInfo struct {
 	Type        CommandType
 	Description string
 	Usage       string
+
+	// Flags lists the flags that take a value, such as -f or --exclude,
+	// with what the value completes to. Args says what the other
+	// arguments complete to, files when nil.
+	Flags map[string]*FlagValues
+	Args  *FlagValues
+
+	// Subcommands lists the subcommands of a program such as git, which
+	// its first argument other than flags completes to, with what the
+	// arguments after each complete to
+	Subcommands map[string]*FlagValues
+
+	// RunsCommand is set for commands such as nohup whose first argument
+	// after their flags is a command to run, completed as one
+	RunsCommand bool
+}
+
+// FlagValues says what the value of a flag completes to: one of Words,
+// the values of a Kind the shell knows, such as signal or option, or
+// otherwise files, only those ending in one of Extensions when any are
+// given. Files adds the files to the words and values. For the arguments
+// of a subcommand, Then says what those after the first complete to when
+// they differ, as in git push remote branch.
+type FlagValues struct {
+	Words      []string
+	Kind       string
+	Extensions []string
+	Files      bool
+	Then       *FlagValues
 }
 
 // IsBuiltin checks if a command is a built-in command
@@ -27,6 +57,80 @@ func IsBuiltin(name string) bool {
 	return exists
 }
 
+// completionInfo returns the metadata completion uses for a builtin or a
+// program the shell knows the arguments of
+func completionInfo(command string) (*CommandInfo, bool) {
+	if info, exists := builtinCommands[command]; exists {
+		return info, true
+	}
+	info, exists := knownPrograms[command]
+	return info, exists
+}
+
+// GetFlagValues returns what the value of a command's flag completes to,
+// or nil. flag is the word before the value, where a group of short flags
+// such as -xzf gives the value to its last one, or the start of a word
+// such as --exclude= holding the value after the =.
+func GetFlagValues(command, flag string) *FlagValues {
+	info, exists := completionInfo(command)
+	if !exists || len(flag) < 2 || (flag[0] != '-' && flag[0] != '+') {
+		return nil
+	}
+	flag = strings.TrimSuffix(flag, "=")
+	if values, ok := info.Flags[flag]; ok {
+		return values
+	}
+	if flag[1] != '-' {
+		return info.Flags["-"+flag[len(flag)-1:]]
+	}
+	return nil
+}
+
+// GetArgValues returns what an argument of a command completes to, given
+// the arguments before it, or nil for files
+func GetArgValues(command string, args []string) *FlagValues {
+	info, exists := completionInfo(command)
+	if !exists {
+		return nil
+	}
+	if info.Subcommands == nil {
+		return info.Args
+	}
+
+	// Leave out the flags, and the values of those before the subcommand
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		if strings.HasPrefix(args[i], "-") {
+			if _, takesValue := info.Flags[args[i]]; takesValue && len(positional) == 0 {
+				i++
+			}
+			continue
+		}
+		positional = append(positional, args[i])
+	}
+
+	if len(positional) == 0 {
+		names := make([]string, 0, len(info.Subcommands))
+		for name := range info.Subcommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return &FlagValues{Words: names}
+	}
+	values := info.Subcommands[positional[0]]
+	if values != nil && values.Then != nil && len(positional) > 1 {
+		values = values.Then
+	}
+	return values
+}
+
+// RunsCommand reports whether a builtin runs the command given as its
+// first argument
+func RunsCommand(command string) bool {
+	info, exists := builtinCommands[command]
+	return exists && info.RunsCommand
+}
+
 // GetCommandInfo returns information about a command
 func GetCommandInfo(name string) *CommandInfo {
 	if info, exists := builtinCommands[name]; exists {
@@ -83,24 +187,73 @@ var builtinCommands = map[string]*CommandInfo{
 		Type:        CommandBuiltin,
 		Description: "Display help information",
 		Usage:       "help [command]",
+		Args:        &FlagValues{Kind: "builtin"},
 	},
 	"history": {
 		Name:        "history",
 		Type:        CommandBuiltin,
 		Description: "Display command history",
-		Usage:       "history [n]",
+		Usage:       "history [-tv] [n] | history export [--json] [file] | history import file",
+	},
+	"exec": {
+		Name:        "exec",
+		Type:        CommandBuiltin,
+		Description: "Replace the shell with a command, or keep redirections open",
+		Usage:       "exec [command [args...]] [n<file] [n>file] [n<>file] [n>&m] [n>&-]",
+		RunsCommand: true,
+	},
+	"command": {
+		Name:        "command",
+		Type:        CommandBuiltin,
+		Description: "Run a builtin or program, passing over functions and aliases",
+		Usage:       "command [-v|-V] name [args...]",
+		RunsCommand: true,
+	},
+	"builtin": {
+		Name:        "builtin",
+		Type:        CommandBuiltin,
+		Description: "Run a builtin, passing over functions of the same name",
+		Usage:       "builtin name [args...]",
+		RunsCommand: true,
+	},
+	"enable": {
+		Name:        "enable",
+		Type:        CommandBuiltin,
+		Description: "Enable builtins, or disable them so programs of the same name run",
+		Usage:       "enable [-n] [-a] [name...]",
+		Args:        &FlagValues{Kind: "builtin"},
+	},
+	"last-output": {
+		Name:        "last-output",
+		Type:        CommandBuiltin,
+		Description: "Show or search the output of the last command",
+		Usage:       "last-output [-a] [pattern]",
+	},
+	"fc": {
+		Name:        "fc",
+		Type:        CommandBuiltin,
+		Description: "List or edit and re-run history entries",
+		Usage:       "fc [-e editor] [first [last]] | fc -l [-nr] [first [last]]",
 	},
 	"alias": {
 		Name:        "alias",
 		Type:        CommandBuiltin,
 		Description: "Create or display aliases",
-		Usage:       "alias [name[=value]...]",
+		Usage:       "alias [-g|-s] [name[=value]...]",
+		Args:        &FlagValues{Kind: "alias"},
+	},
+	"open": {
+		Name:        "open",
+		Type:        CommandBuiltin,
+		Description: "Open files and URLs with their default application",
+		Usage:       "open [-t] file|url...",
 	},
 	"unalias": {
 		Name:        "unalias",
 		Type:        CommandBuiltin,
 		Description: "Remove aliases",
-		Usage:       "unalias name...",
+		Usage:       "unalias [-s] name...",
+		Args:        &FlagValues{Kind: "alias"},
 	},
 	"env": {
 		Name:        "env",
@@ -112,19 +265,107 @@ var builtinCommands = map[string]*CommandInfo{
 		Name:        "export",
 		Type:        CommandBuiltin,
 		Description: "Export environment variables",
-		Usage:       "export name[=value]...",
+		Usage:       "export [-p] [name[=value]...]",
+	},
+	"printenv": {
+		Name:        "printenv",
+		Type:        CommandBuiltin,
+		Description: "Print environment variables",
+		Usage:       "printenv [name...]",
+	},
+	"declare": {
+		Name:        "declare",
+		Type:        CommandBuiltin,
+		Description: "Set variable attributes (arrays, integer, export, read-only), or print them",
+		Usage:       "declare [-aAirxp] [+ix] [name[=value]...]",
+	},
+	"local": {
+		Name:        "local",
+		Type:        CommandBuiltin,
+		Description: "Make variables local to the running function",
+		Usage:       "local [-aAirx] [name[=value]...]",
+	},
+	"readonly": {
+		Name:        "readonly",
+		Type:        CommandBuiltin,
+		Description: "Mark variables as read-only",
+		Usage:       "readonly [-p] [name[=value]...]",
+	},
+	"set": {
+		Name:        "set",
+		Type:        CommandBuiltin,
+		Description: "Set shell options and positional parameters",
+		Usage:       "set [-euxo option] [+euxo option] [--] [arg...]",
+		Flags: map[string]*FlagValues{
+			"-o": {Kind: "option"},
+			"+o": {Kind: "option"},
+		},
+	},
+	"trap": {
+		Name:        "trap",
+		Type:        CommandBuiltin,
+		Description: "Run commands on signals or when the shell exits",
+		Usage:       "trap [-lp] [[command|-] signal|EXIT...]",
+	},
+	"unset": {
+		Name:        "unset",
+		Type:        CommandBuiltin,
+		Description: "Remove variables and functions",
+		Usage:       "unset [-f] [-v] name...",
+		Flags: map[string]*FlagValues{
+			"-f": {Kind: "function"},
+		},
+	},
+	"let": {
+		Name:        "let",
+		Type:        CommandBuiltin,
+		Description: "Evaluate arithmetic expressions",
+		Usage:       "let expression...",
+	},
+	"shift": {
+		Name:        "shift",
+		Type:        CommandBuiltin,
+		Description: "Drop the first positional parameters",
+		Usage:       "shift [n]",
+	},
+	"getopts": {
+		Name:        "getopts",
+		Type:        CommandBuiltin,
+		Description: "Parse the options given to a script or function",
+		Usage:       "getopts optstring name [arg...]",
 	},
 	"which": {
 		Name:        "which",
 		Type:        CommandBuiltin,
 		Description: "Locate a command",
 		Usage:       "which command...",
+		Args:        &FlagValues{Kind: "command"},
 	},
 	"type": {
 		Name:        "type",
 		Type:        CommandBuiltin,
 		Description: "Display information about command type",
-		Usage:       "type command...",
+		Usage:       "type [-t] command...",
+		Args:        &FlagValues{Kind: "command"},
+	},
+	"hash": {
+		Name:        "hash",
+		Type:        CommandBuiltin,
+		Description: "Show, add or forget remembered command locations",
+		Usage:       "hash [-r] [-d] [command...]",
+		Args:        &FlagValues{Kind: "command"},
+	},
+	"rehash": {
+		Name:        "rehash",
+		Type:        CommandBuiltin,
+		Description: "Forget all remembered command locations",
+		Usage:       "rehash",
+	},
+	"gex-cache": {
+		Name:        "gex-cache",
+		Type:        CommandBuiltin,
+		Description: "Show hit, miss and eviction counts of the shell's caches",
+		Usage:       "gex-cache [stats]",
 	},
 
 	// File operations
@@ -132,7 +373,7 @@ var builtinCommands = map[string]*CommandInfo{
 		Name:        "ls",
 		Type:        CommandBuiltin,
 		Description: "List directory contents",
-		Usage:       "ls [options] [files...]",
+		Usage:       "ls [--locale=C] [options] [files...]",
 	},
 	"mkdir": {
 		Name:        "mkdir",
@@ -194,7 +435,7 @@ var builtinCommands = map[string]*CommandInfo{
 		Name:        "wc",
 		Type:        CommandBuiltin,
 		Description: "Count lines, words, and characters",
-		Usage:       "wc [options] [file...]",
+		Usage:       "wc [--locale=C] [options] [file...]",
 	},
 	"grep": {
 		Name:        "grep",
@@ -206,7 +447,49 @@ var builtinCommands = map[string]*CommandInfo{
 		Name:        "sort",
 		Type:        CommandBuiltin,
 		Description: "Sort lines in files",
-		Usage:       "sort [options] [file...]",
+		Usage:       "sort [--locale=C] [options] [file...]",
+	},
+	"imgcat": {
+		Name:        "imgcat",
+		Type:        CommandBuiltin,
+		Description: "Display images in the terminal",
+		Usage:       "imgcat [-p kitty|sixel|blocks] [-w cols] [file...]",
+		Flags: map[string]*FlagValues{
+			"-p": {Words: []string{"kitty", "sixel", "blocks"}},
+		},
+	},
+	"weather": {
+		Name:        "weather",
+		Type:        CommandBuiltin,
+		Description: "Show the weather and a short forecast",
+		Usage:       "weather [-u] [-r] [-d days] [location]",
+	},
+	"worldclock": {
+		Name:        "worldclock",
+		Type:        CommandBuiltin,
+		Description: "Show the time in other time zones",
+		Usage:       "worldclock [zone|city...]",
+	},
+	"qr": {
+		Name:        "qr",
+		Type:        CommandBuiltin,
+		Description: "Render text as a QR code",
+		Usage:       "qr [-l L|M|Q|H] [-i] [-o file.png] [-s scale] [text...]",
+		Flags: map[string]*FlagValues{
+			"-l": {Words: []string{"L", "M", "Q", "H"}},
+		},
+	},
+	"edit": {
+		Name:        "edit",
+		Type:        CommandBuiltin,
+		Description: "Edit a file in a minimal full-screen editor",
+		Usage:       "edit [+LINE] file",
+	},
+	"more": {
+		Name:        "more",
+		Type:        CommandBuiltin,
+		Description: "Show files or input a screen at a time",
+		Usage:       "more [file...]",
 	},
 
 	// System operations
@@ -220,7 +503,10 @@ var builtinCommands = map[string]*CommandInfo{
 		Name:        "kill",
 		Type:        CommandBuiltin,
 		Description: "Send signals to processes",
-		Usage:       "kill [signal] pid...",
+		Usage:       "kill [-signal|-s signal] pid|%job...",
+		Flags: map[string]*FlagValues{
+			"-s": {Kind: "signal"},
+		},
 	},
 	"df": {
 		Name:        "df",
@@ -252,6 +538,46 @@ var builtinCommands = map[string]*CommandInfo{
 		Description: "Display system information",
 		Usage:       "uname [options]",
 	},
+	"jobs": {
+		Name:        "jobs",
+		Type:        CommandBuiltin,
+		Description: "List background jobs or show their output",
+		Usage:       "jobs [-l] [--log N]",
+	},
+	"wait": {
+		Name:        "wait",
+		Type:        CommandBuiltin,
+		Description: "Wait for background jobs and return their exit status",
+		Usage:       "wait [%job|pid...]",
+	},
+	"disown": {
+		Name:        "disown",
+		Type:        CommandBuiltin,
+		Description: "Remove jobs from the job table, or with -h keep them from SIGHUP",
+		Usage:       "disown [-ah] [%job|pid...]",
+	},
+	"nohup": {
+		Name:        "nohup",
+		Type:        CommandBuiltin,
+		Description: "Run a command immune to hangups, with output to nohup.out",
+		Usage:       "nohup command [args...]",
+		RunsCommand: true,
+	},
+	"withlock": {
+		Name:        "withlock",
+		Type:        CommandBuiltin,
+		Description: "Run a command while holding a file lock",
+		Usage:       "withlock [-n] [-w seconds] lockfile command [args...]",
+	},
+	"timeout": {
+		Name:        "timeout",
+		Type:        CommandBuiltin,
+		Description: "Run a command with a time limit",
+		Usage:       "timeout [-s signal] [-k duration] duration command [args...]",
+		Flags: map[string]*FlagValues{
+			"-s": {Kind: "signal"},
+		},
+	},
 
 	// Search operations
 	"find": {
@@ -293,18 +619,88 @@ var builtinCommands = map[string]*CommandInfo{
 		Type:        CommandBuiltin,
 		Description: "Send ICMP echo requests",
 		Usage:       "ping [options] host",
+		Args:        &FlagValues{Kind: "host"},
 	},
 	"wget": {
 		Name:        "wget",
 		Type:        CommandBuiltin,
 		Description: "Download files from web",
-		Usage:       "wget [options] URL",
+		Usage:       "wget [-q] [-c] [-O file] [-T secs] [--parallel N] [--no-cache] [--proxy URL|--no-proxy] [--no-netrc] URL",
 	},
 	"curl": {
 		Name:        "curl",
 		Type:        CommandBuiltin,
 		Description: "Transfer data from/to servers",
-		Usage:       "curl [options] URL",
+		Usage:       "curl [options] [-o file] [-x proxy] [-n] [--parallel N] [--no-cache] URL",
+	},
+	"portcheck": {
+		Name:        "portcheck",
+		Type:        CommandBuiltin,
+		Description: "Check whether TCP ports are open",
+		Usage:       "portcheck [-t timeout] host port[,port...]",
+	},
+	"port-scan": {
+		Name:        "port-scan",
+		Type:        CommandBuiltin,
+		Description: "Scan a range of TCP ports",
+		Usage:       "port-scan [-t timeout] [-c workers] [--open] host [ports]",
+	},
+	"netspeed": {
+		Name:        "netspeed",
+		Type:        CommandBuiltin,
+		Description: "Measure latency and bandwidth",
+		Usage:       "netspeed [-u URL] [-s size] [-n pings] [--download|--upload] | netspeed --serve [addr]",
+	},
+	"http": {
+		Name:        "http",
+		Type:        CommandBuiltin,
+		Description: "Send HTTP API requests with httpie syntax",
+		Usage:       "http [-f] [-v] [-h|-b] [--session NAME] [METHOD] URL [Header:value] [field=value] [field:=json] [param==value]",
+	},
+	"resolve": {
+		Name:        "resolve",
+		Type:        CommandBuiltin,
+		Description: "Show step by step how a host name resolves",
+		Usage:       "resolve [-s nameserver] [-t timeout] host...",
+	},
+	"whois": {
+		Name:        "whois",
+		Type:        CommandBuiltin,
+		Description: "Query WHOIS servers for a domain or IP",
+		Usage:       "whois [-h server] [-p port] [-r] [-a] domain|ip...",
+	},
+	"geoip": {
+		Name:        "geoip",
+		Type:        CommandBuiltin,
+		Description: "Locate IP addresses using a local GeoIP database",
+		Usage:       "geoip [-d database.mmdb] [-j] ip|host...",
+		Flags: map[string]*FlagValues{
+			"-d": {Extensions: []string{".mmdb"}},
+		},
+	},
+	"arp": {
+		Name:        "arp",
+		Type:        CommandBuiltin,
+		Description: "Show the ARP (IPv4 neighbor) table",
+		Usage:       "arp [-a] [-n] [-i iface]",
+	},
+	"route": {
+		Name:        "route",
+		Type:        CommandBuiltin,
+		Description: "Show the kernel routing table",
+		Usage:       "route [-n] [-4|-6]",
+	},
+	"ip": {
+		Name:        "ip",
+		Type:        CommandBuiltin,
+		Description: "Show neighbors and routes (other objects run the system ip)",
+		Usage:       "ip [-4|-6] neigh|route [show]",
+	},
+	"http-cache": {
+		Name:        "http-cache",
+		Type:        CommandBuiltin,
+		Description: "Manage the HTTP cache used by wget and curl",
+		Usage:       "http-cache [list|info|rm URL...|clear]",
 	},
 	"netstat": {
 		Name:        "netstat",
@@ -318,13 +714,17 @@ var builtinCommands = map[string]*CommandInfo{
 		Name:        "tar",
 		Type:        CommandBuiltin,
 		Description: "Archive files",
-		Usage:       "tar [options] archive files...",
+		Usage:       "tar [-cxtvz] -f archive [--exclude=GLOB] [--exclude-vcs] [-T filelist] [files...]",
+		Flags: map[string]*FlagValues{
+			"-f": {Extensions: []string{".tar", ".tar.gz", ".tgz"}},
+			"-T": {},
+		},
 	},
 	"gzip": {
 		Name:        "gzip",
 		Type:        CommandBuiltin,
 		Description: "Compress files",
-		Usage:       "gzip [options] file...",
+		Usage:       "gzip [-d] [-k] [-1..-9] [-p workers] file...",
 	},
 	"gunzip": {
 		Name:        "gunzip",
@@ -344,10 +744,18 @@ var builtinCommands = map[string]*CommandInfo{
 		Description: "Extract zip archives",
 		Usage:       "unzip [options] archive",
 	},
+	"backup": {
+		Name:        "backup",
+		Type:        CommandBuiltin,
+		Description: "Create incremental hard-link snapshots",
+		Usage:       "backup [-v] [--exclude=GLOB] SRC DEST | backup list DEST | backup prune [--keep N] DEST",
+	},
 }
 
-// ExpandAliases expands aliases in the command
-func ExpandAliases(cmd *Command, aliases map[string]string) {
+// ExpandAliases expands aliases in the command. When the name has no alias
+// but ends in an extension with a suffix alias, the file is opened with the
+// program it names: with md=glow, notes.md -x runs glow notes.md -x.
+func ExpandAliases(cmd *Command, aliases, suffixAliases map[string]string) {
 	if alias, exists := aliases[cmd.Name]; exists {
 		// Simple alias expansion - split on whitespace
 		parts := strings.Fields(alias)
@@ -358,5 +766,116 @@ func ExpandAliases(cmd *Command, aliases map[string]string) {
 				cmd.Args = append(parts[1:], cmd.Args...)
 			}
 		}
+		return
 	}
+
+	if program, exists := suffixAliases[fileSuffix(cmd.Name)]; exists {
+		parts := strings.Fields(program)
+		if len(parts) > 0 {
+			args := append(parts[1:], cmd.Name)
+			cmd.Args = append(args, cmd.Args...)
+			cmd.Name = parts[0]
+		}
+	}
+}
+
+// fileSuffix returns the extension of a file name without the dot, or ""
+// when there is none. A leading dot marks a hidden file, not an extension.
+func fileSuffix(name string) string {
+	base := name[strings.LastIndex(name, "/")+1:]
+	dot := strings.LastIndex(base, ".")
+	if dot <= 0 {
+		return ""
+	}
+	return base[dot+1:]
+}
+
+// knownPrograms describes the arguments of external programs that
+// complete to more than files, in the terms of builtinCommands. A host
+// completes after user@ too, and a remote one as host: for its path.
+var knownPrograms = map[string]*CommandInfo{
+	"ssh": {
+		Name:        "ssh",
+		Type:        CommandExternal,
+		Description: "OpenSSH remote login client",
+		Usage:       "ssh [options] [user@]host [command]",
+		Flags: map[string]*FlagValues{
+			"-i": {},
+			"-F": {},
+			"-J": {Kind: "host"},
+		},
+		Args: &FlagValues{Kind: "host"},
+	},
+	"scp": {
+		Name:        "scp",
+		Type:        CommandExternal,
+		Description: "OpenSSH secure file copy",
+		Usage:       "scp [options] source... target",
+		Flags: map[string]*FlagValues{
+			"-i": {},
+			"-F": {},
+			"-J": {Kind: "host"},
+		},
+		Args: &FlagValues{Kind: "remote", Files: true},
+	},
+	"git": {
+		Name:        "git",
+		Type:        CommandExternal,
+		Description: "Git version control",
+		Usage:       "git [options] command [args]",
+		Flags: map[string]*FlagValues{
+			"-C": {},
+			"-c": {Words: []string{"user.name=", "user.email=", "core.editor=", "color.ui="}},
+		},
+		Subcommands: gitSubcommands,
+	},
+}
+
+// gitRefs completes to the branches and tags of the repository in the
+// current directory, gitRefsAndFiles to those and files, and gitRemotes to
+// its remotes
+var (
+	gitRefs         = &FlagValues{Kind: "git-ref"}
+	gitRefsAndFiles = &FlagValues{Kind: "git-ref", Files: true}
+	gitRemotes      = &FlagValues{Kind: "git-remote"}
+)
+
+// gitSubcommands are the git commands used most, with what their
+// arguments complete to; nil is files
+var gitSubcommands = map[string]*FlagValues{
+	"add":          nil,
+	"bisect":       {Words: []string{"start", "bad", "good", "skip", "reset", "log", "run"}, Then: gitRefs},
+	"blame":        nil,
+	"branch":       gitRefs,
+	"checkout":     gitRefsAndFiles,
+	"cherry-pick":  gitRefs,
+	"clean":        nil,
+	"clone":        nil,
+	"commit":       nil,
+	"config":       {Words: []string{"--global", "--local", "--list", "--get", "--unset"}},
+	"describe":     gitRefs,
+	"diff":         gitRefsAndFiles,
+	"fetch":        {Kind: "git-remote", Then: gitRefs},
+	"format-patch": gitRefs,
+	"grep":         nil,
+	"init":         nil,
+	"log":          gitRefsAndFiles,
+	"merge":        gitRefs,
+	"mv":           nil,
+	"pull":         {Kind: "git-remote", Then: gitRefs},
+	"push":         {Kind: "git-remote", Then: gitRefs},
+	"rebase":       gitRefs,
+	"reflog":       gitRefs,
+	"remote":       {Words: []string{"add", "remove", "rename", "set-url", "get-url", "show", "prune"}, Then: gitRemotes},
+	"reset":        gitRefsAndFiles,
+	"restore":      nil,
+	"revert":       gitRefs,
+	"rm":           nil,
+	"show":         gitRefsAndFiles,
+	"stash":        {Words: []string{"push", "list", "show", "pop", "apply", "drop", "clear", "branch"}},
+	"status":       nil,
+	"submodule":    {Words: []string{"add", "init", "update", "status", "sync", "foreach", "deinit"}},
+	"switch":       gitRefs,
+	"tag":          gitRefs,
+	"worktree":     {Words: []string{"add", "list", "remove", "prune", "move", "lock", "unlock"}},
 }
@@ -0,0 +1,488 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Expander performs word expansion on raw command words: tilde expansion,
+// parameter expansion, quote removal and, with Glob set, pathname
+// expansion
+type Expander struct {
+	// Lookup resolves a variable or special parameter name to its value
+	Lookup func(name string) (string, bool)
+	// LookupArray resolves a name to the elements of an array; a scalar
+	// is an array of one element
+	LookupArray func(name string) (ArrayValue, bool)
+	// NoUnset makes references to unset variables an error (set -u)
+	NoUnset bool
+	// Assign sets a variable for ${NAME:=word}
+	Assign func(name, value string) error
+	// Glob enables pathname expansion in ExpandFields
+	Glob *GlobOptions
+}
+
+// ArrayValue is what an Expander sees of an array variable: its keys and
+// values in order, and whether the keys are strings or indices
+type ArrayValue struct {
+	Keys        []string
+	Values      []string
+	Associative bool
+}
+
+// expandedField is one word expansion produced, along with the word as a
+// glob pattern, in which quoted wildcards are escaped, and whether the
+// pattern has unquoted ones
+type expandedField struct {
+	text    string
+	pattern string
+	glob    bool
+}
+
+// Expand expands a single raw word. Array elements that would become
+// words of their own are joined by spaces.
+func (x *Expander) Expand(word string) (string, error) {
+	fields, err := x.expandWord(word)
+	if err != nil {
+		return "", err
+	}
+	texts := make([]string, len(fields))
+	for i, field := range fields {
+		texts[i] = field.text
+	}
+	return strings.Join(texts, " "), nil
+}
+
+// expandWord expands a raw word. It is usually one field, but each
+// element of ${name[@]} is a field of its own, and an empty array alone
+// leaves none.
+func (x *Expander) expandWord(word string) ([]expandedField, error) {
+	var fields []expandedField
+	var result, pattern strings.Builder
+	result.Grow(len(word))
+	glob := false
+	previous := byte(0)
+	emptyArray := false
+
+	// literal adds a character that came out of expansion; only an
+	// unquoted wildcard or extglob group takes part in pathname expansion
+	literal := func(ch byte, quoted bool) {
+		result.WriteByte(ch)
+		if strings.IndexByte("*?[]\\()|+@!", ch) != -1 {
+			if quoted {
+				pattern.WriteByte('\\')
+			} else if ch == '*' || ch == '?' || ch == '[' || ch == '(' && strings.IndexByte("+@!", previous) != -1 {
+				glob = true
+			}
+		}
+		pattern.WriteByte(ch)
+		previous = ch
+		if quoted {
+			previous = 0
+		}
+	}
+	literals := func(s string, quoted bool) {
+		for i := 0; i < len(s); i++ {
+			literal(s[i], quoted)
+		}
+	}
+	endField := func() {
+		fields = append(fields, expandedField{result.String(), pattern.String(), glob})
+		result.Reset()
+		pattern.Reset()
+		glob = false
+		previous = 0
+	}
+
+	i := 0
+
+	// Tilde expansion applies only to an unquoted leading ~
+	if strings.HasPrefix(word, "~") && (len(word) == 1 || word[1] == '/') {
+		if home := os.Getenv("HOME"); home != "" {
+			literals(home, true)
+			i = 1
+		}
+	}
+
+	quoteChar := byte(0)
+	for i < len(word) {
+		ch := word[i]
+
+		switch {
+		case quoteChar == '\'':
+			// Single quotes: everything is literal
+			if ch == '\'' {
+				quoteChar = 0
+			} else {
+				literal(ch, true)
+			}
+			i++
+
+		case ch == '\\' && i+1 < len(word):
+			next := word[i+1]
+			// Inside double quotes backslash only escapes a few characters
+			if quoteChar == '"' && next != '$' && next != '"' && next != '\\' && next != '`' {
+				literal(ch, true)
+			}
+			literal(next, true)
+			i += 2
+
+		case ch == '"':
+			if quoteChar == '"' {
+				quoteChar = 0
+			} else {
+				quoteChar = '"'
+			}
+			i++
+
+		case ch == '\'' && quoteChar == 0:
+			quoteChar = '\''
+			i++
+
+		case ch == '$':
+			values, split, consumed, err := x.expandReference(word[i:])
+			if err != nil {
+				return nil, err
+			}
+			if consumed == 0 {
+				literal(ch, quoteChar != 0)
+				i++
+				continue
+			}
+			i += consumed
+			if !split {
+				literals(values[0], quoteChar != 0)
+				continue
+			}
+			if len(values) == 0 {
+				emptyArray = true
+			}
+			for k, value := range values {
+				if k > 0 {
+					endField()
+				}
+				literals(value, quoteChar != 0)
+			}
+
+		default:
+			literal(ch, quoteChar != 0)
+			i++
+		}
+	}
+
+	endField()
+	if emptyArray && len(fields) == 1 && fields[0].text == "" {
+		return nil, nil
+	}
+	return fields, nil
+}
+
+// ExpandParams expands $ references in s without quote removal, for text
+// that is not made of shell words (such as arithmetic expressions)
+func (x *Expander) ExpandParams(s string) (string, error) {
+	var result strings.Builder
+	result.Grow(len(s))
+
+	for i := 0; i < len(s); {
+		if s[i] == '$' {
+			value, consumed, err := x.expandParameter(s[i:])
+			if err != nil {
+				return "", err
+			}
+			if consumed > 0 {
+				result.WriteString(value)
+				i += consumed
+				continue
+			}
+		}
+		result.WriteByte(s[i])
+		i++
+	}
+
+	return result.String(), nil
+}
+
+// ExpandHeredoc expands the body of a here-document whose delimiter was
+// not quoted. Quotes are ordinary characters there; a backslash only
+// escapes $, `, another backslash or a newline, which it removes.
+func (x *Expander) ExpandHeredoc(body string) (string, error) {
+	var result strings.Builder
+	result.Grow(len(body))
+
+	for i := 0; i < len(body); {
+		switch ch := body[i]; {
+		case ch == '\\' && i+1 < len(body) && strings.IndexByte("$`\\\n", body[i+1]) != -1:
+			if body[i+1] != '\n' {
+				result.WriteByte(body[i+1])
+			}
+			i += 2
+			continue
+		case ch == '$':
+			value, consumed, err := x.expandParameter(body[i:])
+			if err != nil {
+				return "", err
+			}
+			if consumed > 0 {
+				result.WriteString(value)
+				i += consumed
+				continue
+			}
+		}
+		result.WriteByte(body[i])
+		i++
+	}
+
+	return result.String(), nil
+}
+
+// ExpandAll expands every word in a list
+func (x *Expander) ExpandAll(words []string) ([]string, error) {
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		expanded, err := x.Expand(word)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expanded)
+	}
+	return result, nil
+}
+
+// ExpandFields expands words like ExpandAll, except that ${name[@]} gives
+// a word for each element. With Glob set, a word with unquoted wildcards
+// is then replaced by the paths it matches. One that matches nothing is
+// kept as it is, dropped with NullGlob or an error with FailGlob.
+func (x *Expander) ExpandFields(words []string) ([]string, error) {
+	result := make([]string, 0, len(words))
+	for _, word := range words {
+		fields, err := x.expandWord(word)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			if field.glob && x.Glob != nil {
+				matches := Glob(field.pattern, *x.Glob)
+				switch {
+				case len(matches) > 0:
+					result = append(result, matches...)
+					continue
+				case x.Glob.FailGlob:
+					return nil, fmt.Errorf("no match: %s", field.text)
+				case x.Glob.NullGlob:
+					continue
+				}
+			}
+			result = append(result, field.text)
+		}
+	}
+	return result, nil
+}
+
+// ExpandRedirectTarget expands the target of a redirection like an
+// argument. It must come out as one word: a pattern that matches several
+// paths, or none under NullGlob, is ambiguous, as is an array of several
+// elements.
+func (x *Expander) ExpandRedirectTarget(word string) (string, error) {
+	fields, err := x.ExpandFields([]string{word})
+	if err != nil {
+		return "", err
+	}
+	if len(fields) != 1 {
+		return "", fmt.Errorf("%s: ambiguous redirect", word)
+	}
+	return fields[0], nil
+}
+
+// expandParameter expands a $ reference at the start of s and returns the
+// value and the number of bytes consumed (0 if s is not a reference). The
+// elements of ${name[@]} are joined by spaces.
+func (x *Expander) expandParameter(s string) (string, int, error) {
+	values, _, consumed, err := x.expandReference(s)
+	return strings.Join(values, " "), consumed, err
+}
+
+// expandReference expands a $ reference at the start of s. It returns the
+// values, which are several only with split set, for ${name[@]} and
+// ${!name[@]}, and the number of bytes consumed (0 if s is not a
+// reference).
+func (x *Expander) expandReference(s string) ([]string, bool, int, error) {
+	if len(s) < 2 {
+		return nil, false, 0, nil
+	}
+
+	// ${NAME}, ${NAME[subscript]}, ${#NAME...} and ${!NAME[@]}
+	if s[1] == '{' {
+		end := braceEnd(s)
+		if end == -1 {
+			return nil, false, 0, nil
+		}
+		values, split, err := x.expandBraced(s[2:end])
+		return values, split, end + 1, err
+	}
+
+	// Special single-character parameters: $?, $$, $#, $@, $*, $0-$9
+	if strings.IndexByte("?$#@*!-0123456789", s[1]) != -1 {
+		value, err := x.lookupParameter(s[1:2])
+		return []string{value}, false, 2, err
+	}
+
+	// $NAME
+	end := 1
+	for end < len(s) && isNameChar(s[end]) {
+		end++
+	}
+	if end == 1 {
+		return nil, false, 0, nil
+	}
+
+	value, err := x.lookupParameter(s[1:end])
+	return []string{value}, false, end, err
+}
+
+// SplitSubscript splits NAME[subscript] into its parts, reporting whether
+// there is a subscript
+func SplitSubscript(s string) (string, string, bool) {
+	open := strings.IndexByte(s, '[')
+	if open <= 0 || !strings.HasSuffix(s, "]") || !IsValidVariableName(s[:open]) {
+		return s, "", false
+	}
+	return s[:open], s[open+1 : len(s)-1], true
+}
+
+// ElementKey turns a raw subscript into the key of an array element: the
+// value of an arithmetic expression for an indexed array, or the expanded
+// word for an associative one
+func (x *Expander) ElementKey(subscript string, associative bool) (string, error) {
+	if associative {
+		return x.Expand(subscript)
+	}
+	index, err := x.arithmetic(subscript)
+	if err != nil {
+		return "", fmt.Errorf("%s: bad array subscript: %v", subscript, err)
+	}
+	return strconv.FormatInt(index, 10), nil
+}
+
+// arithmetic evaluates an arithmetic expression inside a parameter
+// expansion, such as a subscript or a substring offset. Only Lookup is
+// at hand, so the expression cannot assign.
+func (x *Expander) arithmetic(expr string) (int64, error) {
+	expr, err := x.ExpandParams(expr)
+	if err != nil {
+		return 0, err
+	}
+	vars := ArithVars{
+		Get: x.lookup,
+		Set: func(name, value string) error {
+			return fmt.Errorf("%s: cannot assign here", name)
+		},
+	}
+	return EvalArithmetic(expr, vars)
+}
+
+// lookupArray resolves a name to an array through LookupArray
+func (x *Expander) lookupArray(name string) (ArrayValue, bool) {
+	if x.LookupArray == nil {
+		if value, ok := x.lookup(name); ok {
+			return ArrayValue{Keys: []string{"0"}, Values: []string{value}}, true
+		}
+		return ArrayValue{}, false
+	}
+	return x.LookupArray(name)
+}
+
+// lookupParameter resolves a referenced parameter, failing for unset
+// variables and positional parameters when NoUnset is set
+func (x *Expander) lookupParameter(name string) (string, error) {
+	value, ok := x.lookup(name)
+	if !ok && x.NoUnset {
+		return "", x.unbound(name)
+	}
+	return value, nil
+}
+
+// lookup resolves a name through the configured lookup function
+func (x *Expander) lookup(name string) (string, bool) {
+	if x.Lookup == nil {
+		return os.LookupEnv(name)
+	}
+	return x.Lookup(name)
+}
+
+// Assignment is a raw NAME=value word split into its parts. Subscript is
+// set for NAME[subscript]=value, Append for NAME+=value, and Compound for
+// an array value NAME=(...), whose Value is then the text between the
+// parentheses.
+type Assignment struct {
+	Name         string
+	Subscript    string
+	HasSubscript bool
+	Append       bool
+	Compound     bool
+	Value        string
+}
+
+// ParseAssignment splits a raw word of the form NAME=value,
+// NAME[subscript]=value, NAME+=value or NAME=(...)
+func ParseAssignment(word string) (Assignment, bool) {
+	var a Assignment
+	end := 0
+	for end < len(word) && isNameChar(word[end]) {
+		end++
+	}
+	a.Name = word[:end]
+	if !IsValidVariableName(a.Name) {
+		return a, false
+	}
+
+	rest := word[end:]
+	if strings.HasPrefix(rest, "[") {
+		close := strings.Index(rest, "]")
+		if close == -1 {
+			return a, false
+		}
+		a.Subscript, a.HasSubscript = rest[1:close], true
+		rest = rest[close+1:]
+	}
+	if strings.HasPrefix(rest, "+=") {
+		a.Append = true
+		rest = rest[1:]
+	}
+	if !strings.HasPrefix(rest, "=") {
+		return a, false
+	}
+
+	a.Value = rest[1:]
+	if !a.HasSubscript && strings.HasPrefix(a.Value, "(") && strings.HasSuffix(a.Value, ")") {
+		a.Compound = true
+		a.Value = a.Value[1 : len(a.Value)-1]
+	}
+	return a, true
+}
+
+// IsAssignment reports whether a raw word is an assignment that
+// ParseAssignment accepts
+func IsAssignment(word string) bool {
+	_, ok := ParseAssignment(word)
+	return ok
+}
+
+// IsValidVariableName checks whether name can be used as a shell variable
+func IsValidVariableName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isNameChar checks if a character is valid in a variable name
+func isNameChar(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_'
+}
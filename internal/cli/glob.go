@@ -0,0 +1,452 @@
+package cli
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GlobOptions control pathname expansion
+type GlobOptions struct {
+	// FoldCase matches letters regardless of case
+	FoldCase bool
+	// DotGlob lets wildcards match names starting with a dot
+	DotGlob bool
+	// NullGlob removes a pattern that matches nothing instead of keeping it
+	NullGlob bool
+	// FailGlob makes a pattern that matches nothing an error
+	FailGlob bool
+	// MaxDepth bounds how many directories ** descends; 0 is no limit
+	MaxDepth int
+}
+
+// Glob returns the paths that match a pattern, sorted. *, ? and [...]
+// match within one path component, ** as a whole component matches any
+// number of directories, and ?(...), *(...), +(...), @(...) and !(...)
+// match alternatives separated by |. A backslash quotes the next
+// character, and a name starting with a dot is only matched by a pattern
+// that starts with one unless DotGlob is set.
+func Glob(pattern string, opts GlobOptions) []string {
+	g := &globber{opts: opts, listings: make(map[string][]os.DirEntry)}
+
+	parts := strings.Split(pattern, "/")
+	paths := []string{""}
+	if parts[0] == "" {
+		paths, parts = []string{"/"}, parts[1:]
+	}
+
+	for i, part := range parts {
+		last := i == len(parts)-1
+		if part == "" {
+			// A trailing slash only matches directories
+			if last {
+				paths = onlyDirectories(paths)
+			}
+			continue
+		}
+
+		if !hasGlobMeta(part) {
+			name := unescapeGlob(part)
+			for j := range paths {
+				paths[j] = joinGlobPath(paths[j], name)
+			}
+			continue
+		}
+
+		var next []string
+		for _, dir := range paths {
+			if part == "**" {
+				// The directory itself, then everything below it; a
+				// final ** takes files as well
+				next = append(next, dir)
+				g.descend(dir, 0, last, &next)
+				continue
+			}
+			for _, entry := range g.list(dir) {
+				name := entry.Name()
+				if g.hidden(name) && !strings.HasPrefix(part, ".") && !strings.HasPrefix(part, `\.`) {
+					continue
+				}
+				if MatchPattern(part, name, opts.FoldCase) {
+					next = append(next, joinGlobPath(dir, name))
+				}
+			}
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		paths = next
+	}
+
+	// Components without wildcards were joined without looking at the
+	// disk, and ** can reach a path more than once
+	matches := paths[:0]
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+		if _, err := os.Lstat(path); err == nil {
+			matches = append(matches, path)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// globber holds the state of one expansion. Directories are listed once
+// however many components or ** levels visit them.
+type globber struct {
+	opts     GlobOptions
+	listings map[string][]os.DirEntry
+}
+
+// list returns the entries of dir, where "" is the current directory
+func (g *globber) list(dir string) []os.DirEntry {
+	if entries, cached := g.listings[dir]; cached {
+		return entries
+	}
+	listed := dir
+	if listed == "" {
+		listed = "."
+	}
+	entries, _ := os.ReadDir(listed)
+	g.listings[dir] = entries
+	return entries
+}
+
+// hidden reports whether wildcards skip a name
+func (g *globber) hidden(name string) bool {
+	return strings.HasPrefix(name, ".") && !g.opts.DotGlob
+}
+
+// descend adds the directories below dir, and the files too when files is
+// set, stopping MaxDepth levels down. Symbolic links to directories are
+// not followed, so a link cycle cannot recurse forever.
+func (g *globber) descend(dir string, depth int, files bool, paths *[]string) {
+	if g.opts.MaxDepth > 0 && depth >= g.opts.MaxDepth {
+		return
+	}
+	for _, entry := range g.list(dir) {
+		if g.hidden(entry.Name()) {
+			continue
+		}
+		path := joinGlobPath(dir, entry.Name())
+		if entry.IsDir() {
+			*paths = append(*paths, path)
+			g.descend(path, depth+1, files, paths)
+		} else if files {
+			*paths = append(*paths, path)
+		}
+	}
+}
+
+// onlyDirectories keeps the paths that are directories, with a slash added
+func onlyDirectories(paths []string) []string {
+	var dirs []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs = append(dirs, strings.TrimSuffix(path, "/")+"/")
+		}
+	}
+	return dirs
+}
+
+func joinGlobPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+// hasGlobMeta reports whether a pattern has an unquoted wildcard
+func hasGlobMeta(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		case '+', '@', '!':
+			if i+1 < len(pattern) && pattern[i+1] == '(' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// unescapeGlob removes the backslashes quoting characters of a pattern
+func unescapeGlob(pattern string) string {
+	if !strings.Contains(pattern, `\`) {
+		return pattern
+	}
+	var result strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		result.WriteByte(pattern[i])
+	}
+	return result.String()
+}
+
+// MatchPattern reports whether name matches a shell pattern as a whole
+func MatchPattern(pattern, name string, foldCase bool) bool {
+	if hasExtglob(pattern) {
+		return matchExtended(pattern, name, foldCase)
+	}
+
+	px, nx := 0, 0
+	star, starNx := -1, 0
+	for px < len(pattern) || nx < len(name) {
+		if px < len(pattern) {
+			if pattern[px] == '*' {
+				// Let the star match nothing first, and more on a mismatch
+				star, starNx = px, nx
+				px++
+				continue
+			}
+			if nx < len(name) {
+				r, size := utf8.DecodeRuneInString(name[nx:])
+				if matched, width := matchElement(pattern[px:], r, foldCase); matched {
+					px += width
+					nx += size
+					continue
+				}
+			}
+		}
+		if star >= 0 && starNx < len(name) {
+			_, size := utf8.DecodeRuneInString(name[starNx:])
+			starNx += size
+			px, nx = star+1, starNx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// hasExtglob reports whether a pattern has a group such as @(a|b)
+func hasExtglob(pattern string) bool {
+	for i := 0; i+1 < len(pattern); i++ {
+		if pattern[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("?*+@!", pattern[i]) != -1 && pattern[i+1] == '(' {
+			return true
+		}
+	}
+	return false
+}
+
+// matchExtended matches patterns with extglob groups by trying every way
+// of splitting the name, which is fine for file names:
+//
+//	?(a|b)  zero or one of the alternatives
+//	*(a|b)  zero or more
+//	+(a|b)  one or more
+//	@(a|b)  exactly one
+//	!(a|b)  anything but one of them
+func matchExtended(pattern, name string, foldCase bool) bool {
+	if pattern == "" {
+		return name == ""
+	}
+
+	if op, alternatives, rest, ok := extglobGroup(pattern); ok {
+		one := func(part string) bool {
+			for _, alternative := range alternatives {
+				if matchExtended(alternative, part, foldCase) {
+					return true
+				}
+			}
+			return false
+		}
+		switch op {
+		case '?':
+			if matchExtended(rest, name, foldCase) {
+				return true
+			}
+			fallthrough
+		case '@':
+			return splitName(name, func(head, tail string) bool {
+				return one(head) && matchExtended(rest, tail, foldCase)
+			})
+		case '*':
+			return matchRepeated(one, rest, name, foldCase)
+		case '+':
+			return splitName(name, func(head, tail string) bool {
+				return one(head) && matchRepeated(one, rest, tail, foldCase)
+			})
+		case '!':
+			return splitName(name, func(head, tail string) bool {
+				return !one(head) && matchExtended(rest, tail, foldCase)
+			})
+		}
+	}
+
+	if pattern[0] == '*' {
+		return splitName(name, func(_, tail string) bool {
+			return matchExtended(pattern[1:], tail, foldCase)
+		})
+	}
+	if name == "" {
+		return false
+	}
+	r, size := utf8.DecodeRuneInString(name)
+	matched, width := matchElement(pattern, r, foldCase)
+	return matched && matchExtended(pattern[width:], name[size:], foldCase)
+}
+
+// matchRepeated matches any number of repetitions of a group followed by
+// rest. Each repetition takes at least one character so it cannot loop.
+func matchRepeated(one func(string) bool, rest, name string, foldCase bool) bool {
+	if matchExtended(rest, name, foldCase) {
+		return true
+	}
+	return splitName(name, func(head, tail string) bool {
+		return head != "" && one(head) && matchRepeated(one, rest, tail, foldCase)
+	})
+}
+
+// splitName calls try with every split of name at a character boundary
+// until it returns true
+func splitName(name string, try func(head, tail string) bool) bool {
+	for i := 0; i <= len(name); i++ {
+		if (i == len(name) || utf8.RuneStart(name[i])) && try(name[:i], name[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// extglobGroup splits a pattern starting with an extglob group into the
+// operator, the alternatives and the rest of the pattern. ok is false
+// when there is no group or its parenthesis is not closed.
+func extglobGroup(pattern string) (op byte, alternatives []string, rest string, ok bool) {
+	if len(pattern) < 2 || strings.IndexByte("?*+@!", pattern[0]) == -1 || pattern[1] != '(' {
+		return 0, nil, "", false
+	}
+
+	depth, start := 0, 2
+	for i := 2; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case '|':
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[start:i])
+				start = i + 1
+			}
+		case ')':
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[start:i])
+				return pattern[0], alternatives, pattern[i+1:], true
+			}
+			depth--
+		}
+	}
+	return 0, nil, "", false
+}
+
+// matchElement matches the pattern element at the start of pattern, a
+// character, ? or a bracket expression, against r. It returns whether it
+// matched and how many bytes of the pattern the element takes.
+func matchElement(pattern string, r rune, foldCase bool) (bool, int) {
+	switch pattern[0] {
+	case '?':
+		return true, 1
+	case '[':
+		if matched, width, ok := matchBracket(pattern, r, foldCase); ok {
+			return matched, width
+		}
+	case '\\':
+		if len(pattern) > 1 {
+			c, size := utf8.DecodeRuneInString(pattern[1:])
+			return sameRune(c, r, foldCase), 1 + size
+		}
+	}
+	c, size := utf8.DecodeRuneInString(pattern)
+	return sameRune(c, r, foldCase), size
+}
+
+// characterClasses are the [:name:] classes of bracket expressions
+var characterClasses = map[string]func(rune) bool{
+	"alnum":  func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"alpha":  unicode.IsLetter,
+	"blank":  func(r rune) bool { return r == ' ' || r == '\t' },
+	"digit":  unicode.IsDigit,
+	"lower":  unicode.IsLower,
+	"punct":  unicode.IsPunct,
+	"space":  unicode.IsSpace,
+	"upper":  unicode.IsUpper,
+	"xdigit": func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) },
+}
+
+// matchBracket matches a bracket expression such as [a-z], [!.] or
+// [[:digit:]] against r. ok is false when the [ is not closed, and so
+// stands for itself.
+func matchBracket(pattern string, r rune, foldCase bool) (matched bool, width int, ok bool) {
+	i := 1
+	negate := i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^')
+	if negate {
+		i++
+	}
+
+	first := true
+	for i < len(pattern) {
+		if pattern[i] == ']' && !first {
+			return matched != negate, i + 1, true
+		}
+		first = false
+
+		if strings.HasPrefix(pattern[i:], "[:") {
+			if end := strings.Index(pattern[i+2:], ":]"); end >= 0 {
+				if class, exists := characterClasses[pattern[i+2:i+2+end]]; exists {
+					matched = matched || class(r)
+					i += end + 4
+					continue
+				}
+			}
+		}
+
+		lo, size := bracketRune(pattern[i:])
+		i += size
+		hi := lo
+		if i+1 < len(pattern) && pattern[i] == '-' && pattern[i+1] != ']' {
+			hi, size = bracketRune(pattern[i+1:])
+			i += 1 + size
+		}
+		if inRange(r, lo, hi) || (foldCase && (inRange(unicode.ToLower(r), lo, hi) || inRange(unicode.ToUpper(r), lo, hi))) {
+			matched = true
+		}
+	}
+	return false, 0, false
+}
+
+// bracketRune reads one character of a bracket expression, which a
+// backslash may quote
+func bracketRune(pattern string) (rune, int) {
+	if pattern[0] == '\\' && len(pattern) > 1 {
+		r, size := utf8.DecodeRuneInString(pattern[1:])
+		return r, 1 + size
+	}
+	return utf8.DecodeRuneInString(pattern)
+}
+
+func inRange(r, lo, hi rune) bool {
+	return lo <= r && r <= hi
+}
+
+func sameRune(a, b rune, foldCase bool) bool {
+	return a == b || (foldCase && unicode.ToLower(a) == unicode.ToLower(b))
+}
@@ -0,0 +1,452 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// braceEnd returns the index of the } closing the ${ at the start of s,
+// or -1. Nested references, quotes and escaped characters are skipped.
+func braceEnd(s string) int {
+	depth := 0
+	quoteChar := byte(0)
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quoteChar == '\'':
+			if ch == '\'' {
+				quoteChar = 0
+			}
+		case ch == '\\':
+			i++
+		case ch == '"' || ch == '\'':
+			if quoteChar == ch {
+				quoteChar = 0
+			} else if quoteChar == 0 {
+				quoteChar = ch
+			}
+		case ch == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case ch == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitParameter splits the inside of ${...} into the parameter, with
+// any subscript, and the operator that follows it
+func splitParameter(inner string) (string, string) {
+	if inner == "" {
+		return "", ""
+	}
+	end := 1
+	switch ch := inner[0]; {
+	case ch >= '0' && ch <= '9':
+		for end < len(inner) && inner[end] >= '0' && inner[end] <= '9' {
+			end++
+		}
+	case isNameChar(ch):
+		for end < len(inner) && isNameChar(inner[end]) {
+			end++
+		}
+		if end < len(inner) && inner[end] == '[' {
+			if close := strings.IndexByte(inner[end:], ']'); close != -1 {
+				end += close + 1
+			}
+		}
+	}
+	return inner[:end], inner[end:]
+}
+
+// expandBraced expands the inside of ${...}: a parameter, its length with
+// ${#NAME}, the keys of an array with ${!NAME[@]}, or a parameter followed
+// by an operator
+func (x *Expander) expandBraced(inner string) ([]string, bool, error) {
+	// ${#NAME} is the length of the value, ${#NAME[@]} how many
+	// elements the array has
+	if strings.HasPrefix(inner, "#") && len(inner) > 1 {
+		name, rest := splitParameter(inner[1:])
+		if rest != "" {
+			return nil, false, fmt.Errorf("${%s}: bad substitution", inner)
+		}
+		values, split, set, err := x.parameterValues(name)
+		if err == nil && !set && x.NoUnset {
+			err = x.unbound(name)
+		}
+		if split {
+			return []string{strconv.Itoa(len(values))}, false, err
+		}
+		return []string{strconv.Itoa(utf8.RuneCountInString(values[0]))}, false, err
+	}
+
+	// ${!NAME[@]} lists the keys of an array
+	if strings.HasPrefix(inner, "!") {
+		if name, subscript, indexed := SplitSubscript(inner[1:]); indexed && (subscript == "@" || subscript == "*") {
+			array, _ := x.lookupArray(name)
+			if subscript == "*" {
+				return []string{strings.Join(array.Keys, " ")}, false, nil
+			}
+			return array.Keys, true, nil
+		}
+	}
+
+	name, operator := splitParameter(inner)
+	if name == "" {
+		return nil, false, fmt.Errorf("${%s}: bad substitution", inner)
+	}
+	values, split, set, err := x.parameterValues(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if operator == "" {
+		if !set && x.NoUnset {
+			err = x.unbound(name)
+		}
+		return values, split, err
+	}
+	return x.applyOperator(name, operator, values, split, set)
+}
+
+// parameterValues looks up a parameter, an array element or all of an
+// array's elements with NAME[@], which sets split. It reports whether the
+// parameter is set; values always has an element unless split is set.
+func (x *Expander) parameterValues(name string) ([]string, bool, bool, error) {
+	base, subscript, indexed := SplitSubscript(name)
+	if !indexed {
+		value, set := x.lookup(name)
+		return []string{value}, false, set, nil
+	}
+
+	array, set := x.lookupArray(base)
+	switch subscript {
+	case "@":
+		return array.Values, true, set && len(array.Values) > 0, nil
+	case "*":
+		return []string{strings.Join(array.Values, " ")}, false, set && len(array.Values) > 0, nil
+	}
+
+	key, err := x.ElementKey(subscript, array.Associative)
+	if err != nil {
+		return nil, false, false, err
+	}
+	if !array.Associative {
+		if index, _ := strconv.Atoi(key); index < 0 && len(array.Keys) > 0 {
+			last, _ := strconv.Atoi(array.Keys[len(array.Keys)-1])
+			key = strconv.Itoa(last + 1 + index)
+		}
+	}
+	for i, k := range array.Keys {
+		if k == key {
+			return []string{array.Values[i]}, false, true, nil
+		}
+	}
+	return []string{""}, false, false, nil
+}
+
+// unbound is the error for a reference to an unset parameter under set -u.
+// Special parameters maintained by the shell are always set.
+func (x *Expander) unbound(name string) error {
+	if name == "" || !isNameChar(name[0]) || name == "0" {
+		return nil
+	}
+	return fmt.Errorf("%s: unbound variable", name)
+}
+
+// applyOperator applies what follows the parameter in ${...}:
+//
+//	:-word  :=word  :+word  :?word   default, assigned default, alternate
+//	                                 and error for unset or empty values;
+//	                                 without the colon only unset counts
+//	#pat ##pat  %pat %%pat           remove the shortest or longest
+//	                                 matching prefix or suffix
+//	/pat/str //pat/str               replace the first or every match,
+//	/#pat/str /%pat/str              or one at the start or end
+//	:offset  :offset:length          substring, or slice of NAME[@]
+//
+// Pattern and replacement operators apply to each element of NAME[@],
+// and a substring of NAME[@] is a slice of its elements.
+func (x *Expander) applyOperator(name, operator string, values []string, split, set bool) ([]string, bool, error) {
+	op := operator[:1]
+	if strings.HasPrefix(operator, ":") && len(operator) > 1 && strings.IndexByte("-=+?", operator[1]) != -1 {
+		op = operator[:2]
+	}
+
+	// Default, assign, alternate and error operators
+	if strings.IndexByte("-=+?", op[len(op)-1]) != -1 {
+		word := operator[len(op):]
+		empty := !set || (len(op) == 2 && strings.Join(values, "") == "")
+		switch op[len(op)-1] {
+		case '-':
+			if empty {
+				value, err := x.Expand(word)
+				return []string{value}, false, err
+			}
+		case '=':
+			if empty {
+				value, err := x.Expand(word)
+				if err != nil {
+					return nil, false, err
+				}
+				if !IsValidVariableName(name) || x.Assign == nil {
+					return nil, false, fmt.Errorf("$%s: cannot assign in this way", name)
+				}
+				if err := x.Assign(name, value); err != nil {
+					return nil, false, err
+				}
+				return []string{value}, false, nil
+			}
+		case '+':
+			if empty {
+				return []string{""}, false, nil
+			}
+			value, err := x.Expand(word)
+			return []string{value}, false, err
+		case '?':
+			if empty {
+				message, err := x.Expand(word)
+				if err != nil {
+					return nil, false, err
+				}
+				if message == "" {
+					message = "parameter null or not set"
+				}
+				return nil, false, fmt.Errorf("%s: %s", name, message)
+			}
+		}
+		return values, split, nil
+	}
+
+	if !set && x.NoUnset {
+		if err := x.unbound(name); err != nil {
+			return nil, false, err
+		}
+	}
+
+	switch op {
+	case "#", "%":
+		longest := len(operator) > 1 && operator[1] == operator[0]
+		skip := 1
+		if longest {
+			skip = 2
+		}
+		pattern, err := x.expandPattern(operator[skip:])
+		if err != nil {
+			return nil, false, err
+		}
+		for i, value := range values {
+			values[i] = removeAffix(value, pattern, op == "#", longest)
+		}
+		return values, split, nil
+
+	case "/":
+		mode := byte(0)
+		spec := operator[1:]
+		if spec != "" && strings.IndexByte("/#%", spec[0]) != -1 {
+			mode, spec = spec[0], spec[1:]
+		}
+		raw, replacement, _ := cutUnquoted(spec, '/')
+		pattern, err := x.expandPattern(raw)
+		if err != nil {
+			return nil, false, err
+		}
+		if replacement, err = x.Expand(replacement); err != nil {
+			return nil, false, err
+		}
+		for i, value := range values {
+			values[i] = replacePattern(value, pattern, replacement, mode)
+		}
+		return values, split, nil
+
+	case ":":
+		offsetExpr, lengthExpr, hasLength := cutUnquoted(operator[1:], ':')
+		offset, err := x.arithmetic(offsetExpr)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %v", offsetExpr, err)
+		}
+		length := int64(-1)
+		if hasLength {
+			if length, err = x.arithmetic(lengthExpr); err != nil {
+				return nil, false, fmt.Errorf("%s: %v", lengthExpr, err)
+			}
+		}
+		if split {
+			start, end, err := sliceBounds(len(values), offset, length, hasLength)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %v", lengthExpr, err)
+			}
+			return values[start:end], true, nil
+		}
+		runes := []rune(values[0])
+		start, end, err := sliceBounds(len(runes), offset, length, hasLength)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %v", lengthExpr, err)
+		}
+		return []string{string(runes[start:end])}, false, nil
+	}
+
+	return nil, false, fmt.Errorf("${%s%s}: bad substitution", name, operator)
+}
+
+// expandPattern expands the pattern of a # % or / operator, keeping
+// quoted wildcards literal
+func (x *Expander) expandPattern(word string) (string, error) {
+	fields, err := x.expandWord(word)
+	if err != nil {
+		return "", err
+	}
+	patterns := make([]string, len(fields))
+	for i, field := range fields {
+		patterns[i] = field.pattern
+	}
+	return strings.Join(patterns, " "), nil
+}
+
+// cutUnquoted cuts s around the first sep that is not quoted or escaped
+func cutUnquoted(s string, sep byte) (string, string, bool) {
+	quoteChar := byte(0)
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case quoteChar != 0:
+			if ch == quoteChar {
+				quoteChar = 0
+			}
+		case ch == '\\':
+			i++
+		case ch == '"' || ch == '\'':
+			quoteChar = ch
+		case ch == sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// removeAffix removes the shortest or longest prefix or suffix of value
+// that matches pattern
+func removeAffix(value, pattern string, prefix, longest bool) string {
+	cuts := runeBoundaries(value)
+	if prefix {
+		if longest {
+			for i := len(cuts) - 1; i >= 0; i-- {
+				if MatchPattern(pattern, value[:cuts[i]], false) {
+					return value[cuts[i]:]
+				}
+			}
+			return value
+		}
+		for _, cut := range cuts {
+			if MatchPattern(pattern, value[:cut], false) {
+				return value[cut:]
+			}
+		}
+		return value
+	}
+
+	if longest {
+		for _, cut := range cuts {
+			if MatchPattern(pattern, value[cut:], false) {
+				return value[:cut]
+			}
+		}
+		return value
+	}
+	for i := len(cuts) - 1; i >= 0; i-- {
+		if MatchPattern(pattern, value[cuts[i]:], false) {
+			return value[:cuts[i]]
+		}
+	}
+	return value
+}
+
+// replacePattern replaces the longest match of pattern in value: the
+// first, every one with mode /, or only one at the start (#) or end (%)
+func replacePattern(value, pattern, replacement string, mode byte) string {
+	if pattern == "" && mode != '#' && mode != '%' {
+		return value
+	}
+	cuts := runeBoundaries(value)
+
+	switch mode {
+	case '#':
+		for i := len(cuts) - 1; i >= 0; i-- {
+			if MatchPattern(pattern, value[:cuts[i]], false) {
+				return replacement + value[cuts[i]:]
+			}
+		}
+		return value
+	case '%':
+		for _, cut := range cuts {
+			if MatchPattern(pattern, value[cut:], false) {
+				return value[:cut] + replacement
+			}
+		}
+		return value
+	}
+
+	var result strings.Builder
+	for start := 0; start < len(cuts); start++ {
+		from := cuts[start]
+		matched := -1
+		for end := len(cuts) - 1; end > start; end-- {
+			if MatchPattern(pattern, value[from:cuts[end]], false) {
+				matched = end
+				break
+			}
+		}
+		if matched == -1 {
+			if start < len(cuts)-1 {
+				result.WriteString(value[from:cuts[start+1]])
+			}
+			continue
+		}
+		result.WriteString(replacement)
+		if mode != '/' {
+			result.WriteString(value[cuts[matched]:])
+			return result.String()
+		}
+		start = matched - 1
+	}
+	return result.String()
+}
+
+// runeBoundaries returns the byte offsets at which a string can be cut
+// without splitting a character, from 0 to its length
+func runeBoundaries(s string) []int {
+	cuts := make([]int, 0, len(s)+1)
+	for i := range s {
+		cuts = append(cuts, i)
+	}
+	return append(cuts, len(s))
+}
+
+// sliceBounds turns a substring offset and length into bounds within n
+// items. A negative offset counts from the end, and a negative length
+// leaves that many items off the end.
+func sliceBounds(n int, offset, length int64, hasLength bool) (int, int, error) {
+	if offset < 0 {
+		offset += int64(n)
+		if offset < 0 {
+			return 0, 0, nil
+		}
+	}
+	start := min(offset, int64(n))
+	end := int64(n)
+	if hasLength {
+		if length < 0 {
+			end = int64(n) + length
+			if end < start {
+				return 0, 0, fmt.Errorf("substring expression < 0")
+			}
+		} else {
+			end = min(start+length, int64(n))
+		}
+	}
+	return int(start), int(end), nil
+}
@@ -2,34 +2,66 @@ package cli
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 )
 
-// Command represents a parsed command with arguments
+// Command represents a parsed command with arguments.
+// Name and Args hold raw words; quotes and $ references are kept intact
+// until the executor runs them through an Expander.
 type Command struct {
 	Name       string
 	Args       []string
 	Pipes      []*Command
-	Redirect   *Redirect
+	Redirects  []*Redirect // applied in order
 	Background bool
+	Function   *FunctionDef // set when the command defines a function
+	Next       *Command     // next pipeline in a command list
+	NextOp     ListOp       // how Next is joined to this pipeline
 }
 
-// Redirect represents input/output redirection
+// FunctionDef represents a shell function definition: name() { body; }
+type FunctionDef struct {
+	Name string
+	Body string
+}
+
+// ListOp joins pipelines in a command list
+type ListOp int
+
+const (
+	ListSeq ListOp = iota // ; or newline
+	ListAnd               // &&
+	ListOr                // ||
+)
+
+// Redirect represents one redirection: file descriptor FD opened on the
+// file Target, or for RedirectDup made a copy of descriptor Target, which
+// is - to close FD instead. A here-document holds its body in Target;
+// Quoted is set when its delimiter was quoted, so the body is taken
+// literally instead of expanded.
 type Redirect struct {
+	FD     int
 	Type   RedirectType
 	Target string
+	Quoted bool
 }
 
 type RedirectType int
 
 const (
-	RedirectNone   RedirectType = iota
-	RedirectOut                 // >
-	RedirectAppend              // >>
-	RedirectIn                  // <
-	RedirectErr                 // 2>
-	RedirectBoth                // &>
+	RedirectNone       RedirectType = iota
+	RedirectOut                     // [n]>
+	RedirectAppend                  // [n]>>
+	RedirectIn                      // [n]<
+	RedirectDup                     // [n]>&m, [n]<&m, [n]>&-
+	RedirectBoth                    // &> and >&file, both stdout and stderr
+	RedirectBothAppend              // &>>, both appended
+	RedirectHeredoc                 // [n]<<word and [n]<<-word
+	RedirectClobber                 // [n]>|, truncates even with noclobber
+	RedirectReadWrite               // [n]<>, opened for reading and writing
 )
 
 // Parser provides high-performance command parsing
@@ -37,12 +69,53 @@ type Parser struct {
 	input  string
 	pos    int
 	length int
+
+	// Global aliases expand wherever a word may start; text up to
+	// aliasEnd came from one and is not expanded again
+	globalAliases map[string]string
+	aliasEnd      int
+
+	// Here-documents whose body starts on the line after their operator,
+	// and a syntax error found while reading one
+	heredocs []heredoc
+	err      error
+
+	// subscripts lets a word start with an array subscript [key] that
+	// may hold blanks, as in NAME=([key]=value)
+	subscripts bool
+}
+
+// ErrEmptyCommand is returned for input with no commands, such as a blank
+// line or a comment
+var ErrEmptyCommand = errors.New("empty command")
+
+// heredoc is a here-document waiting for its body
+type heredoc struct {
+	redirect  *Redirect
+	delimiter string
+	strip     bool // <<- removes leading tabs from each line
+}
+
+// incompleteError reports input that ended in the middle of a command, so
+// further lines could complete it
+type incompleteError string
+
+func (e incompleteError) Error() string {
+	return string(e)
+}
+
+var errUnexpectedEnd = incompleteError("unexpected end of input")
+
+// IsIncomplete reports whether a parse error means more input is needed
+func IsIncomplete(err error) bool {
+	var incomplete incompleteError
+	return errors.As(err, &incomplete)
 }
 
 // Parse parses a command line input into a Command structure
 func Parse(input string) (*Command, error) {
 	if input == "" {
-		return nil, errors.New("empty command")
+		return nil, ErrEmptyCommand
 	}
 
 	p := &Parser{
@@ -51,7 +124,116 @@ func Parse(input string) (*Command, error) {
 		length: len(input),
 	}
 
-	return p.parseCommand()
+	return p.parseList()
+}
+
+// ParseWithAliases parses input like Parse, replacing every unquoted word
+// that is a global alias with its value first, so an alias such as
+// L='| less' can add to the pipeline. Regular aliases are expanded later,
+// in command position only; a name is either kind, never both.
+func ParseWithAliases(input string, globalAliases map[string]string) (*Command, error) {
+	if input == "" {
+		return nil, ErrEmptyCommand
+	}
+
+	p := &Parser{
+		input:         input,
+		pos:           0,
+		length:        len(input),
+		globalAliases: globalAliases,
+	}
+
+	return p.parseList()
+}
+
+// SplitWords splits the inside of an array assignment NAME=(...) into its
+// raw words, which may be on several lines and between comments
+func SplitWords(text string) ([]string, error) {
+	p := &Parser{input: text, length: len(text), subscripts: true}
+
+	var words []string
+	for {
+		p.skipWhitespaceAndComments()
+		if p.pos >= p.length {
+			return words, nil
+		}
+		word, err := p.parseToken()
+		if err != nil {
+			return nil, fmt.Errorf("syntax error near '%c'", p.current())
+		}
+		words = append(words, word)
+	}
+}
+
+// parseList parses pipelines joined by ;, &&, || or newlines
+func (p *Parser) parseList() (*Command, error) {
+	p.skipWhitespaceAndComments()
+	if p.pos >= p.length {
+		return nil, ErrEmptyCommand
+	}
+
+	first, err := p.parseCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	current := first
+	for {
+		p.skipBlanks()
+		if p.current() == '#' {
+			p.skipComment()
+		}
+		if p.pos >= p.length {
+			break
+		}
+
+		var op ListOp
+		switch {
+		case p.hasPrefix("&&"):
+			op = ListAnd
+			p.pos += 2
+		case p.hasPrefix("||"):
+			op = ListOr
+			p.pos += 2
+		case p.current() == ';':
+			op = ListSeq
+			p.advance()
+		case p.current() == '\n':
+			op = ListSeq
+			p.advance()
+			p.readHeredocs()
+		case current.Background:
+			// "cmd & next" - the & already terminated the pipeline
+			op = ListSeq
+		default:
+			return nil, fmt.Errorf("syntax error near '%c'", p.current())
+		}
+
+		p.skipWhitespaceAndComments()
+		if p.pos >= p.length {
+			if op != ListSeq {
+				return nil, errUnexpectedEnd
+			}
+			break
+		}
+
+		next, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+
+		current.Next = next
+		current.NextOp = op
+		current = next
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	if len(p.heredocs) > 0 {
+		return nil, incompleteError("unterminated here-document")
+	}
+	return first, nil
 }
 
 // parseCommand parses the main command and handles pipes
@@ -62,7 +244,7 @@ func (p *Parser) parseCommand() (*Command, error) {
 	}
 
 	// Handle pipes
-	for p.pos < p.length && p.peek() == '|' {
+	for p.pos < p.length && p.peek() == '|' && !p.hasPrefix("||") {
 		p.advance() // consume '|'
 		p.skipWhitespace()
 
@@ -74,6 +256,11 @@ func (p *Parser) parseCommand() (*Command, error) {
 		cmd.Pipes = append(cmd.Pipes, nextCmd)
 	}
 
+	// A trailing & applies to the whole pipeline
+	if len(cmd.Pipes) > 0 && cmd.Pipes[len(cmd.Pipes)-1].Background {
+		cmd.Background = true
+	}
+
 	return cmd, nil
 }
 
@@ -82,21 +269,45 @@ func (p *Parser) parseSimpleCommand() (*Command, error) {
 	p.skipWhitespace()
 
 	if p.pos >= p.length {
-		return nil, errors.New("unexpected end of input")
+		return nil, errUnexpectedEnd
 	}
 
 	cmd := &Command{}
 
+	// Handle arithmetic commands: (( expr ))
+	if p.hasPrefix("((") {
+		expr, err := p.parseArithmeticCommand()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Name = "(("
+		cmd.Args = []string{expr}
+		return cmd, nil
+	}
+
 	// Parse command name
+	if p.expandGlobalAlias() {
+		return p.parseSimpleCommand()
+	}
 	name, err := p.parseToken()
 	if err != nil {
 		return nil, err
 	}
 	cmd.Name = name
 
+	// Handle function definitions: name() { ... } or function name { ... }
+	if def, ok, err := p.parseFunctionDef(name); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		cmd.Name = def.Name
+		cmd.Function = def
+		return cmd, nil
+	}
+
 	// Parse arguments and redirections
 	for p.pos < p.length {
-		p.skipWhitespace()
+		p.skipBlanks()
 
 		if p.pos >= p.length {
 			break
@@ -104,8 +315,19 @@ func (p *Parser) parseSimpleCommand() (*Command, error) {
 
 		ch := p.peek()
 
+		// A # at the start of a word begins a comment
+		if ch == '#' {
+			p.skipComment()
+			continue
+		}
+
+		// Handle list operators - return to parent
+		if ch == ';' || ch == '\n' || p.hasPrefix("&&") {
+			break
+		}
+
 		// Handle background execution
-		if ch == '&' && p.pos == p.length-1 {
+		if ch == '&' && !p.hasPrefix("&>") {
 			cmd.Background = true
 			p.advance()
 			break
@@ -116,9 +338,16 @@ func (p *Parser) parseSimpleCommand() (*Command, error) {
 			break
 		}
 
+		if p.expandGlobalAlias() {
+			continue
+		}
+
 		// Handle redirections
 		if redirect := p.parseRedirect(); redirect != nil {
-			cmd.Redirect = redirect
+			if p.err != nil {
+				return nil, p.err
+			}
+			cmd.Redirects = append(cmd.Redirects, redirect)
 			continue
 		}
 
@@ -133,25 +362,49 @@ func (p *Parser) parseSimpleCommand() (*Command, error) {
 	return cmd, nil
 }
 
-// parseToken parses a single token (command name or argument)
+// parseToken parses a single token (command name or argument).
+// Quotes and escapes are kept in the token so expansion can honor them.
 func (p *Parser) parseToken() (string, error) {
-	p.skipWhitespace()
+	p.skipBlanks()
 
 	if p.pos >= p.length {
-		return "", errors.New("unexpected end of input")
+		return "", errUnexpectedEnd
 	}
 
 	var result strings.Builder
 	quoted := false
 	quoteChar := byte(0)
+	// groups counts the open extglob groups such as @(a|b), where | is
+	// part of the word, and the parentheses of an array assignment
+	// NAME=(a b), where blanks are too
+	groups := 0
+	array := false
+	// braces counts the open ${...} references, which blanks do not end,
+	// and subscript is set inside the [key] of an array element
+	braces := 0
+	subscript := false
 
 	for p.pos < p.length {
 		ch := p.current()
 
+		if quoteChar != '\'' {
+			switch {
+			case ch == '$' && p.pos+1 < p.length && p.input[p.pos+1] == '{':
+				braces++
+			case ch == '}' && braces > 0:
+				braces--
+			case ch == '[' && p.subscripts && result.Len() == 0:
+				subscript = true
+			case ch == ']' && subscript:
+				subscript = false
+			}
+		}
+
 		// Handle quotes
 		if !quoted && (ch == '"' || ch == '\'') {
 			quoted = true
 			quoteChar = ch
+			result.WriteByte(ch)
 			p.advance()
 			continue
 		}
@@ -159,22 +412,40 @@ func (p *Parser) parseToken() (string, error) {
 		if quoted && ch == quoteChar {
 			quoted = false
 			quoteChar = 0
+			result.WriteByte(ch)
 			p.advance()
 			continue
 		}
 
-		// Handle escape sequences
-		if ch == '\\' && p.pos+1 < p.length {
+		// A backslash at the end of a line joins the next one to the word
+		if ch == '\\' && p.hasPrefix("\\\n") && quoteChar != '\'' {
+			p.pos += 2
+			continue
+		}
+
+		// Handle escape sequences (literal inside single quotes)
+		if ch == '\\' && p.pos+1 < p.length && quoteChar != '\'' {
+			result.WriteByte(ch)
 			p.advance()
-			next := p.current()
-			result.WriteByte(next)
+			result.WriteByte(p.current())
 			p.advance()
 			continue
 		}
 
 		// Break on whitespace or special characters if not quoted
 		if !quoted {
-			if unicode.IsSpace(rune(ch)) || ch == '|' || ch == '>' || ch == '<' || ch == '&' {
+			// The name of a definition such as f(){ ends at its ()
+			if ch == '(' && groups == 0 && p.hasPrefix("()") && isValidName(result.String()) {
+				break
+			}
+			if ch == '(' && (groups > 0 || result.Len() > 0 && strings.IndexByte("?*+@!", result.String()[result.Len()-1]) != -1) {
+				groups++
+			} else if ch == '(' && strings.HasSuffix(result.String(), "=") && IsAssignment(result.String()) {
+				groups++
+				array = true
+			} else if ch == ')' && groups > 0 {
+				groups--
+			} else if groups == 0 && braces == 0 && !subscript && (unicode.IsSpace(rune(ch)) || ch == '|' || ch == '>' || ch == '<' || ch == '&' || ch == ';') {
 				break
 			}
 		}
@@ -184,7 +455,10 @@ func (p *Parser) parseToken() (string, error) {
 	}
 
 	if quoted {
-		return "", errors.New("unterminated quote")
+		return "", incompleteError("unterminated quote")
+	}
+	if array && groups > 0 {
+		return "", incompleteError("unterminated array assignment")
 	}
 
 	token := result.String()
@@ -195,70 +469,354 @@ func (p *Parser) parseToken() (string, error) {
 	return token, nil
 }
 
-// parseRedirect parses redirection operators
-func (p *Parser) parseRedirect() *Redirect {
-	if p.pos >= p.length {
-		return nil
+// parseArithmeticCommand consumes (( expr )) and returns the expression
+func (p *Parser) parseArithmeticCommand() (string, error) {
+	p.pos += 2 // consume '(('
+	start := p.pos
+	depth := 0
+
+	for p.pos < p.length {
+		switch p.current() {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				if !p.hasPrefix("))") {
+					return "", errors.New("syntax error: expected '))'")
+				}
+				expr := strings.TrimSpace(p.input[start:p.pos])
+				p.pos += 2
+				return expr, nil
+			}
+			depth--
+		}
+		p.advance()
 	}
 
-	ch := p.current()
+	return "", incompleteError("unterminated arithmetic command")
+}
 
-	switch ch {
-	case '>':
-		p.advance()
-		if p.pos < p.length && p.current() == '>' {
+// parseFunctionDef recognizes a function definition starting with the given
+// first token. It reports whether a definition was found.
+func (p *Parser) parseFunctionDef(first string) (*FunctionDef, bool, error) {
+	start := p.pos
+	name := ""
+
+	switch {
+	case first == "function":
+		p.skipBlanks()
+		token, err := p.parseToken()
+		if err != nil {
+			p.pos = start
+			return nil, false, nil
+		}
+		name = strings.TrimSuffix(token, "()")
+		p.skipBlanks()
+		if p.hasPrefix("()") {
+			p.pos += 2
+		}
+	case strings.HasSuffix(first, "()") && len(first) > 2 && !IsAssignment(first):
+		name = first[:len(first)-2]
+	default:
+		p.skipBlanks()
+		if !p.hasPrefix("()") {
+			p.pos = start
+			return nil, false, nil
+		}
+		p.pos += 2
+		name = first
+	}
+
+	if !isValidName(name) {
+		return nil, true, fmt.Errorf("invalid function name: %s", name)
+	}
+
+	p.skipWhitespace()
+	if p.current() != '{' {
+		return nil, true, fmt.Errorf("syntax error: expected '{' after %s()", name)
+	}
+	p.advance()
+
+	body, err := p.parseBraceBody()
+	if err != nil {
+		return nil, true, err
+	}
+
+	return &FunctionDef{Name: name, Body: body}, true, nil
+}
+
+// parseBraceBody consumes input up to the matching closing brace and
+// returns the raw text in between
+func (p *Parser) parseBraceBody() (string, error) {
+	start := p.pos
+	depth := 1
+	quoteChar := byte(0)
+	wordStart := true
+
+	for p.pos < p.length {
+		ch := p.current()
+
+		switch {
+		case quoteChar != 0:
+			if ch == '\\' && quoteChar == '"' {
+				p.advance()
+			} else if ch == quoteChar {
+				quoteChar = 0
+			}
+		case ch == '\\':
+			p.advance()
+		case p.hasPrefix("<<") && !p.hasPrefix("<<<"):
+			// Here-document bodies are skipped so their text cannot end
+			// the function; they are parsed when it is called
+			p.pos += 2
+			p.parseHeredoc(0)
+			if p.err != nil {
+				return "", p.err
+			}
+			wordStart = false
+			continue
+		case ch == '\n' && len(p.heredocs) > 0:
 			p.advance()
-			return &Redirect{Type: RedirectAppend, Target: p.parseRedirectTarget()}
+			p.readHeredocs()
+			if p.err != nil {
+				return "", p.err
+			}
+			wordStart = true
+			continue
+		case ch == '"' || ch == '\'':
+			quoteChar = ch
+		case wordStart && ch == '{':
+			depth++
+		case wordStart && ch == '}':
+			depth--
+			if depth == 0 {
+				body := strings.TrimSpace(p.input[start:p.pos])
+				p.advance()
+				return body, nil
+			}
 		}
-		return &Redirect{Type: RedirectOut, Target: p.parseRedirectTarget()}
 
-	case '<':
+		wordStart = unicode.IsSpace(rune(ch)) || ch == ';' || ch == '|' || ch == '&'
 		p.advance()
-		return &Redirect{Type: RedirectIn, Target: p.parseRedirectTarget()}
+	}
 
-	case '2':
-		if p.pos+1 < p.length && p.input[p.pos+1] == '>' {
-			p.advance() // consume '2'
-			p.advance() // consume '>'
-			return &Redirect{Type: RedirectErr, Target: p.parseRedirectTarget()}
+	return "", incompleteError("unterminated function body")
+}
+
+// isValidName checks whether s is a valid variable or function name
+func isValidName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9' && i > 0) {
+			continue
+		}
+		if c == '-' && i > 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// parseRedirect parses a redirection operator, with the file descriptor
+// it applies to when a number comes right before it, and its target
+func (p *Parser) parseRedirect() *Redirect {
+	start := p.pos
+
+	fd := -1
+	digits := p.pos
+	for digits < p.length && p.input[digits] >= '0' && p.input[digits] <= '9' {
+		digits++
+	}
+	if digits > p.pos && digits < p.length && (p.input[digits] == '>' || p.input[digits] == '<') {
+		n, err := strconv.Atoi(p.input[p.pos:digits])
+		if err != nil {
+			return nil
 		}
+		fd = n
+		p.pos = digits
+	}
+	orDefault := func(standard int) int {
+		if fd < 0 {
+			return standard
+		}
+		return fd
+	}
+
+	switch {
+	case fd < 0 && p.hasPrefix("&>>"):
+		p.pos += 3
+		return &Redirect{FD: 1, Type: RedirectBothAppend, Target: p.parseRedirectTarget()}
+
+	case fd < 0 && p.hasPrefix("&>"):
+		p.pos += 2
+		return &Redirect{FD: 1, Type: RedirectBoth, Target: p.parseRedirectTarget()}
+
+	case p.hasPrefix(">>"):
+		p.pos += 2
+		return &Redirect{FD: orDefault(1), Type: RedirectAppend, Target: p.parseRedirectTarget()}
+
+	case p.hasPrefix("<<") && !p.hasPrefix("<<<"):
+		p.pos += 2
+		return p.parseHeredoc(orDefault(0))
 
-	case '&':
-		if p.pos+1 < p.length && p.input[p.pos+1] == '>' {
-			p.advance() // consume '&'
-			p.advance() // consume '>'
-			return &Redirect{Type: RedirectBoth, Target: p.parseRedirectTarget()}
+	case p.hasPrefix("<>"):
+		p.pos += 2
+		return &Redirect{FD: orDefault(0), Type: RedirectReadWrite, Target: p.parseRedirectTarget()}
+
+	case p.hasPrefix(">&"), p.hasPrefix("<&"):
+		standard := 1
+		if p.current() == '<' {
+			standard = 0
+		}
+		p.pos += 2
+		target := p.parseRedirectTarget()
+		if fd < 0 && standard == 1 && !isDescriptor(target) && !strings.Contains(target, "$") {
+			// >&file is another way to write &>file; >&$fd is told
+			// apart once expanded
+			return &Redirect{FD: 1, Type: RedirectBoth, Target: target}
 		}
+		return &Redirect{FD: orDefault(standard), Type: RedirectDup, Target: target}
+
+	case p.hasPrefix(">|"):
+		p.pos += 2
+		return &Redirect{FD: orDefault(1), Type: RedirectClobber, Target: p.parseRedirectTarget()}
+
+	case p.current() == '>':
+		p.advance()
+		return &Redirect{FD: orDefault(1), Type: RedirectOut, Target: p.parseRedirectTarget()}
+
+	case p.current() == '<':
+		p.advance()
+		return &Redirect{FD: orDefault(0), Type: RedirectIn, Target: p.parseRedirectTarget()}
 	}
 
+	p.pos = start
 	return nil
 }
 
-// parseRedirectTarget parses the target of a redirection
-func (p *Parser) parseRedirectTarget() string {
-	p.skipWhitespace()
+// parseHeredoc parses the delimiter of a here-document after << or <<-.
+// The body is read by readHeredocs once the line ends.
+func (p *Parser) parseHeredoc(fd int) *Redirect {
+	strip := p.current() == '-'
+	if strip {
+		p.advance()
+	}
+	p.skipBlanks()
 
-	if p.pos >= p.length {
+	word := ""
+	if p.pos < p.length && p.current() != '\n' {
+		var err error
+		if word, err = p.parseToken(); err != nil {
+			p.err = err
+		}
+	}
+	delimiter := strings.NewReplacer("'", "", "\"", "", "\\", "").Replace(word)
+	if delimiter == "" && p.err == nil {
+		p.err = fmt.Errorf("syntax error: missing here-document delimiter")
+	}
+
+	redirect := &Redirect{FD: fd, Type: RedirectHeredoc, Quoted: delimiter != word}
+	p.heredocs = append(p.heredocs, heredoc{redirect: redirect, delimiter: delimiter, strip: strip})
+	return redirect
+}
+
+// readHeredocs reads the bodies of the pending here-documents, in order,
+// from the lines that follow; the current position is the start of a line
+func (p *Parser) readHeredocs() {
+	for len(p.heredocs) > 0 {
+		doc := p.heredocs[0]
+		var body strings.Builder
+		for {
+			if p.pos >= p.length {
+				p.err = incompleteError("unterminated here-document")
+				return
+			}
+			line := p.input[p.pos:]
+			if end := strings.IndexByte(line, '\n'); end >= 0 {
+				line = line[:end]
+				p.pos += end + 1
+			} else {
+				p.pos = p.length
+			}
+			if doc.strip {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == doc.delimiter {
+				break
+			}
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+		doc.redirect.Target = body.String()
+		p.heredocs = p.heredocs[1:]
+	}
+}
+
+// isDescriptor reports whether the target of >& or <& names a file
+// descriptor, or - to close one
+func isDescriptor(target string) bool {
+	if target == "-" {
+		return true
+	}
+	_, err := strconv.Atoi(target)
+	return err == nil && target != ""
+}
+
+// parseRedirectTarget parses the target of a redirection, which is a word
+// like an argument: quotes and escapes are kept for expansion, so a target
+// can hold spaces
+func (p *Parser) parseRedirectTarget() string {
+	p.skipBlanks()
+	if p.pos >= p.length || strings.IndexByte("\n|&;<>", p.current()) != -1 {
+		if p.err == nil {
+			p.err = fmt.Errorf("syntax error: missing redirection target")
+		}
 		return ""
 	}
 
-	var result strings.Builder
+	target, err := p.parseToken()
+	if err != nil && p.err == nil {
+		p.err = err
+	}
+	return target
+}
 
-	for p.pos < p.length {
-		ch := p.current()
+// Helper methods for efficient parsing
+// expandGlobalAlias replaces the word at the current position with its
+// value if it is a global alias, and reports whether it did. Quoted words
+// and text that came from an alias are left alone, so an alias cannot
+// expand into itself.
+func (p *Parser) expandGlobalAlias() bool {
+	if len(p.globalAliases) == 0 || p.pos < p.aliasEnd {
+		return false
+	}
 
-		if unicode.IsSpace(rune(ch)) || ch == '|' || ch == '&' {
+	end := p.pos
+	for end < p.length {
+		ch := p.input[end]
+		if ch == '"' || ch == '\'' || ch == '\\' {
+			return false
+		}
+		if unicode.IsSpace(rune(ch)) || ch == '|' || ch == '>' || ch == '<' || ch == '&' || ch == ';' {
 			break
 		}
-
-		result.WriteByte(ch)
-		p.advance()
+		end++
 	}
 
-	return result.String()
+	value, ok := p.globalAliases[p.input[p.pos:end]]
+	if !ok || end == p.pos {
+		return false
+	}
+	p.input = p.input[:p.pos] + value + p.input[end:]
+	p.length = len(p.input)
+	p.aliasEnd = p.pos + len(value)
+	return true
 }
 
-// Helper methods for efficient parsing
 func (p *Parser) current() byte {
 	if p.pos >= p.length {
 		return 0
@@ -276,8 +834,51 @@ func (p *Parser) advance() {
 	}
 }
 
+func (p *Parser) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(p.input[p.pos:], prefix)
+}
+
 func (p *Parser) skipWhitespace() {
 	for p.pos < p.length && unicode.IsSpace(rune(p.input[p.pos])) {
+		newline := p.input[p.pos] == '\n'
 		p.pos++
+		// Here-document bodies start on the line after their operator
+		if newline && len(p.heredocs) > 0 {
+			p.readHeredocs()
+		}
+	}
+}
+
+// skipComment skips a # comment up to, but not including, the newline
+func (p *Parser) skipComment() {
+	for p.pos < p.length && p.input[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// skipWhitespaceAndComments skips blank lines and comment lines between commands
+func (p *Parser) skipWhitespaceAndComments() {
+	for {
+		p.skipWhitespace()
+		if p.pos >= p.length || p.input[p.pos] != '#' {
+			return
+		}
+		p.skipComment()
+	}
+}
+
+// skipBlanks skips spaces, tabs and escaped newlines but stops at newlines,
+// which separate commands
+func (p *Parser) skipBlanks() {
+	for p.pos < p.length {
+		switch {
+		case p.input[p.pos] == ' ' || p.input[p.pos] == '\t':
+			p.pos++
+		case p.hasPrefix("\\\n"):
+			// A backslash at the end of a line joins the next one to it
+			p.pos += 2
+		default:
+			return
+		}
 	}
 }
@@ -2,22 +2,74 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 // Config represents shell configuration
 type Config struct {
-	HistoryLimit   int               `json:"history_limit"`
-	Prompt         string            `json:"prompt"`
-	Aliases        map[string]string `json:"aliases"`
-	AutoComplete   bool              `json:"auto_complete"`
-	ColorOutput    bool              `json:"color_output"`
-	TabCompletion  bool              `json:"tab_completion"`
-	HistorySearch  bool              `json:"history_search"`
-	CaseSensitive  bool              `json:"case_sensitive"`
-	MaxJobs        int               `json:"max_jobs"`
-	TimeoutSeconds int               `json:"timeout_seconds"`
+	HistoryLimit    int               `json:"history_limit"`
+	Prompt          string            `json:"prompt"`
+	Aliases         map[string]string `json:"aliases"`
+	AutoComplete    bool              `json:"auto_complete"`
+	ColorOutput     bool              `json:"color_output"`
+	TabCompletion   bool              `json:"tab_completion"`
+	HistorySearch   bool              `json:"history_search"`
+	CaseSensitive   bool              `json:"case_sensitive"`
+	MaxJobs         int               `json:"max_jobs"`
+	TimeoutSeconds  int               `json:"timeout_seconds"`
+	JobLogs         bool              `json:"job_logs"`
+	HTTPCache       bool              `json:"http_cache"`
+	SpeedtestURL    string            `json:"speedtest_url"`
+	GeoIPDatabase   string            `json:"geoip_database"`
+	WeatherURL      string            `json:"weather_url"`
+	WeatherLocation string            `json:"weather_location"`
+	WorldclockZones []string          `json:"worldclock_zones"`
+	OpenHandlers    map[string]string `json:"open_handlers"`
+	GlobMaxDepth    int               `json:"glob_max_depth"`
+
+	// Commands kept out of history: those typed with a leading space,
+	// earlier copies of a command entered again, and any matching one of
+	// the regular expressions
+	HistoryIgnoreSpace bool     `json:"history_ignore_space"`
+	HistoryEraseDups   bool     `json:"history_erase_dups"`
+	HistoryIgnore      []string `json:"history_ignore"`
+
+	// HistoryFile keeps history across sessions, ~/.gex/history when
+	// empty. With ShareHistory each prompt also picks up the commands
+	// other running sessions saved to it.
+	HistoryFile  string `json:"history_file"`
+	ShareHistory bool   `json:"share_history"`
+
+	// HistoryMetadata records with each command typed at the prompt the
+	// directory it ran in, how long it ran and its exit status
+	HistoryMetadata bool `json:"history_metadata"`
+
+	// CompletionIgnoreCase has Tab completion ignore case even with
+	// CaseSensitive on. FuzzyCompletion also offers names holding the
+	// typed characters in order, after those starting with them.
+	CompletionIgnoreCase bool `json:"completion_ignore_case"`
+	FuzzyCompletion      bool `json:"fuzzy_completion"`
+
+	// CaptureOutput keeps the latest output of commands at the prompt for
+	// last-output, ?pattern and $OUT. External commands then write to a
+	// pipe rather than the terminal.
+	CaptureOutput bool `json:"capture_output"`
+
+	// AutoPager shows the output of a builtin typed at the prompt through
+	// more once it is longer than the screen and than PagerThreshold
+	// lines. Commands in PagerExclude are never paged.
+	AutoPager      bool     `json:"auto_pager"`
+	PagerThreshold int      `json:"pager_threshold"`
+	PagerExclude   []string `json:"pager_exclude"`
+
+	// HighlightStderr shows what commands write to stderr at the prompt
+	// in red when ColorOutput is on. External commands then write stderr
+	// to a pipe rather than the terminal.
+	HighlightStderr bool `json:"highlight_stderr"`
 }
 
 // Default configuration
@@ -32,6 +84,11 @@ var defaultConfig = Config{
 	CaseSensitive:  false,
 	MaxJobs:        10,
 	TimeoutSeconds: 30,
+	SpeedtestURL:   "https://speed.cloudflare.com",
+	WeatherURL:     "https://wttr.in",
+	GlobMaxDepth:   16,
+
+	HistoryMetadata: true,
 }
 
 // New creates a new configuration with defaults
@@ -59,7 +116,8 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
-	var cfg Config
+	// Start from defaults so options missing from the file keep them
+	cfg := *New()
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
@@ -83,6 +141,12 @@ func Load(path string) (*Config, error) {
 		cfg.TimeoutSeconds = defaultConfig.TimeoutSeconds
 	}
 
+	for _, pattern := range cfg.HistoryIgnore {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("history_ignore: %v", err)
+		}
+	}
+
 	return &cfg, nil
 }
 
@@ -102,6 +166,28 @@ func (c *Config) Save(path string) error {
 	return os.WriteFile(path, data, 0644)
 }
 
+// GetDataDir returns the directory for gex state files (~/.gex)
+func GetDataDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ".gex"
+	}
+	return filepath.Join(home, ".gex")
+}
+
+// HistoryPath returns the history file, with a leading ~/ expanded
+func (c *Config) HistoryPath() string {
+	if c.HistoryFile == "" {
+		return filepath.Join(GetDataDir(), "history")
+	}
+	if rest, ok := strings.CutPrefix(c.HistoryFile, "~/"); ok {
+		if home := os.Getenv("HOME"); home != "" {
+			return filepath.Join(home, rest)
+		}
+	}
+	return c.HistoryFile
+}
+
 // GetConfigPath returns the default configuration file path
 func GetConfigPath() string {
 	home := os.Getenv("HOME")
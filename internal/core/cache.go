@@ -1,28 +1,57 @@
 package core
 
 import (
+	"container/list"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // CacheEntry represents a cached item
-type CacheEntry struct {
-	Value     interface{}
+type CacheEntry[K comparable, V any] struct {
+	Key       K
+	Value     V
 	ExpiresAt time.Time
 }
 
-// Cache provides high-performance caching for shell operations
-type Cache struct {
-	data  map[string]*CacheEntry
-	mutex sync.RWMutex
-	ttl   time.Duration
+// CacheStats is a snapshot of a cache's counters
+type CacheStats struct {
+	Entries    int
+	MaxEntries int
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+	Expired    uint64
 }
 
-// NewCache creates a new cache with specified TTL
-func NewCache(ttl time.Duration) *Cache {
-	cache := &Cache{
-		data: make(map[string]*CacheEntry),
-		ttl:  ttl,
+// Cache provides high-performance caching for shell operations. Entries
+// expire after the TTL, and past maxEntries the least recently used entry
+// is evicted, so the cache stays bounded between cleanups.
+type Cache[K comparable, V any] struct {
+	data       map[K]*list.Element
+	order      *list.List // most recently used first
+	mutex      sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+	expired   atomic.Uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewCache creates a new cache with specified TTL holding at most
+// maxEntries items; 0 means no limit
+func NewCache[K comparable, V any](ttl time.Duration, maxEntries int) *Cache[K, V] {
+	cache := &Cache[K, V]{
+		data:       make(map[K]*list.Element),
+		order:      list.New(),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		stop:       make(chan struct{}),
 	}
 
 	// Start cleanup goroutine
@@ -32,82 +61,171 @@ func NewCache(ttl time.Duration) *Cache {
 }
 
 // Set stores a value in the cache
-func (c *Cache) Set(key string, value interface{}) {
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetTTL(key, value, c.ttl)
+}
+
+// SetTTL stores a value that expires after ttl rather than the TTL of the
+// cache, for values that go stale sooner
+func (c *Cache[K, V]) SetTTL(key K, value V, ttl time.Duration) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.data[key] = &CacheEntry{
-		Value:     value,
-		ExpiresAt: time.Now().Add(c.ttl),
+	expiresAt := time.Now().Add(ttl)
+	if element, exists := c.data[key]; exists {
+		entry := element.Value.(*CacheEntry[K, V])
+		entry.Value, entry.ExpiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.data[key] = c.order.PushFront(&CacheEntry[K, V]{Key: key, Value: value, ExpiresAt: expiresAt})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+		c.evictions.Add(1)
 	}
 }
 
 // Get retrieves a value from the cache
-func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	entry, exists := c.data[key]
+	element, exists := c.data[key]
 	if !exists {
-		return nil, false
+		c.misses.Add(1)
+		var zero V
+		return zero, false
 	}
 
 	// Check if expired
+	entry := element.Value.(*CacheEntry[K, V])
 	if time.Now().After(entry.ExpiresAt) {
-		return nil, false
+		c.remove(element)
+		c.expired.Add(1)
+		c.misses.Add(1)
+		var zero V
+		return zero, false
 	}
 
+	c.order.MoveToFront(element)
+	c.hits.Add(1)
 	return entry.Value, true
 }
 
 // Delete removes a value from the cache
-func (c *Cache) Delete(key string) {
+func (c *Cache[K, V]) Delete(key K) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	delete(c.data, key)
+	if element, exists := c.data[key]; exists {
+		c.remove(element)
+	}
 }
 
 // Clear removes all values from the cache
-func (c *Cache) Clear() {
+func (c *Cache[K, V]) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.data = make(map[K]*list.Element)
+	c.order.Init()
+}
+
+// Keys returns the keys of the items that have not expired
+func (c *Cache[K, V]) Keys() []K {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.data = make(map[string]*CacheEntry)
+
+	now := time.Now()
+	keys := make([]K, 0, len(c.data))
+	for key, element := range c.data {
+		if !now.After(element.Value.(*CacheEntry[K, V]).ExpiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
 // Size returns the number of items in the cache
-func (c *Cache) Size() int {
-	c.mutex.RLock()
-	defer c.mutex.RUnlock()
+func (c *Cache[K, V]) Size() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return len(c.data)
 }
 
-// cleanup removes expired entries
-func (c *Cache) cleanup() {
+// Stats returns the cache's size and counters
+func (c *Cache[K, V]) Stats() CacheStats {
+	c.mutex.Lock()
+	entries := len(c.data)
+	c.mutex.Unlock()
+
+	return CacheStats{
+		Entries:    entries,
+		MaxEntries: c.maxEntries,
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+		Evictions:  c.evictions.Load(),
+		Expired:    c.expired.Load(),
+	}
+}
+
+// Stop ends the cleanup goroutine. The cache still works afterwards, but
+// expired entries are only dropped when they are looked up or evicted.
+func (c *Cache[K, V]) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// remove drops an entry. The caller holds the mutex.
+func (c *Cache[K, V]) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.data, element.Value.(*CacheEntry[K, V]).Key)
+}
+
+// cleanup removes expired entries until the cache is stopped
+func (c *Cache[K, V]) cleanup() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
 		c.mutex.Lock()
 		now := time.Now()
-		for key, entry := range c.data {
-			if now.After(entry.ExpiresAt) {
-				delete(c.data, key)
+		for _, element := range c.data {
+			if now.After(element.Value.(*CacheEntry[K, V]).ExpiresAt) {
+				c.remove(element)
+				c.expired.Add(1)
 			}
 		}
 		c.mutex.Unlock()
 	}
 }
 
+// HashedCommand is what PathCache holds for a command name: where it was
+// found, and the PATH it was found in, so that changing PATH invalidates it
+type HashedCommand struct {
+	Path       string
+	SearchPath string
+}
+
 // Global caches for different shell components
 var (
-	CommandCache    *Cache
-	CompletionCache *Cache
-	PathCache       *Cache
+	CommandCache    *Cache[string, string]
+	CompletionCache *Cache[string, []string]
+	PathCache       *Cache[string, HashedCommand]
 )
 
-// InitializeCache initializes global caches
+// InitializeCache initializes global caches, stopping any made before
 func InitializeCache() {
-	CommandCache = NewCache(5 * time.Minute)     // Command results cache
-	CompletionCache = NewCache(10 * time.Minute) // Tab completion cache
-	PathCache = NewCache(30 * time.Minute)       // PATH lookup cache
+	if CommandCache != nil {
+		CommandCache.Stop()
+		CompletionCache.Stop()
+		PathCache.Stop()
+	}
+	CommandCache = NewCache[string, string](5*time.Minute, 1024)      // Command results cache
+	CompletionCache = NewCache[string, []string](10*time.Minute, 512) // Tab completion cache
+	PathCache = NewCache[string, HashedCommand](30*time.Minute, 4096) // PATH lookup cache
 }
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func BenchmarkCache(b *testing.B) {
+	const entries = 1024
+	keys := make([]string, 2*entries)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		cache := NewCache[string, string](time.Minute, entries)
+		defer cache.Stop()
+		for _, key := range keys[:entries] {
+			cache.Set(key, key)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			cache.Get(keys[i%entries])
+		}
+	})
+
+	b.Run("Miss", func(b *testing.B) {
+		cache := NewCache[string, string](time.Minute, entries)
+		defer cache.Stop()
+		for i := 0; i < b.N; i++ {
+			cache.Get(keys[i%entries])
+		}
+	})
+
+	// Twice as many keys as the cache holds, so most sets evict an entry
+	b.Run("SetEvict", func(b *testing.B) {
+		cache := NewCache[string, string](time.Minute, entries)
+		defer cache.Stop()
+		for i := 0; i < b.N; i++ {
+			key := keys[i%len(keys)]
+			cache.Set(key, key)
+		}
+	})
+
+	b.Run("GetParallel", func(b *testing.B) {
+		cache := NewCache[string, string](time.Minute, entries)
+		defer cache.Stop()
+		for _, key := range keys[:entries] {
+			cache.Set(key, key)
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				cache.Get(keys[i%entries])
+			}
+		})
+	})
+}
@@ -4,56 +4,60 @@ import (
 	"sync"
 )
 
-// ObjectPool provides object pooling for performance optimization
-type ObjectPool struct {
+// Pool provides object pooling for performance optimization
+type Pool[T any] struct {
 	pool sync.Pool
 }
 
-// NewObjectPool creates a new object pool
-func NewObjectPool(newFunc func() interface{}) *ObjectPool {
-	return &ObjectPool{
+// NewPool creates a new pool whose Get makes objects with newFunc when
+// none are free
+func NewPool[T any](newFunc func() T) *Pool[T] {
+	return &Pool[T]{
 		pool: sync.Pool{
-			New: newFunc,
+			New: func() interface{} { return newFunc() },
 		},
 	}
 }
 
 // Get retrieves an object from the pool
-func (p *ObjectPool) Get() interface{} {
-	return p.pool.Get()
+func (p *Pool[T]) Get() T {
+	return p.pool.Get().(T)
 }
 
 // Put returns an object to the pool
-func (p *ObjectPool) Put(obj interface{}) {
+func (p *Pool[T]) Put(obj T) {
 	p.pool.Put(obj)
 }
 
+// PooledCommand is a reusable buffer for a parsed command
+type PooledCommand struct {
+	Name   string
+	Args   []string
+	Buffer []string
+}
+
 // Global pools for frequently used objects
 var (
-	StringBuilderPool *ObjectPool
-	ByteBufferPool    *ObjectPool
-	CommandPool       *ObjectPool
+	StringBuilderPool *Pool[[]byte]
+	ByteBufferPool    *Pool[[]byte]
+	CommandPool       *Pool[*PooledCommand]
 )
 
 // InitializePool initializes global object pools
 func InitializePool() {
 	// String builder pool for efficient string concatenation
-	StringBuilderPool = NewObjectPool(func() interface{} {
+	StringBuilderPool = NewPool(func() []byte {
 		return make([]byte, 0, 256)
 	})
 
 	// Byte buffer pool for I/O operations
-	ByteBufferPool = NewObjectPool(func() interface{} {
+	ByteBufferPool = NewPool(func() []byte {
 		return make([]byte, 4096)
 	})
 
 	// Command pool for parsed commands
-	CommandPool = NewObjectPool(func() interface{} {
-		return &struct {
-			Name   string
-			Args   []string
-			Buffer []string
-		}{
+	CommandPool = NewPool(func() *PooledCommand {
+		return &PooledCommand{
 			Args:   make([]string, 0, 8),
 			Buffer: make([]string, 0, 8),
 		}
@@ -0,0 +1,47 @@
+package core
+
+import "testing"
+
+func BenchmarkPool(b *testing.B) {
+	pool := NewPool(func() *PooledCommand {
+		return &PooledCommand{
+			Args:   make([]string, 0, 8),
+			Buffer: make([]string, 0, 8),
+		}
+	})
+	args := []string{"ls", "-l", "-a", "/tmp"}
+
+	b.Run("GetPut", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cmd := pool.Get()
+			cmd.Args = append(cmd.Args, args...)
+			cmd.Args = ResetStringSlice(cmd.Args)
+			pool.Put(cmd)
+		}
+	})
+
+	// Without the pool every command allocates its slices anew
+	b.Run("Allocate", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cmd := &PooledCommand{
+				Args:   make([]string, 0, 8),
+				Buffer: make([]string, 0, 8),
+			}
+			cmd.Args = append(cmd.Args, args...)
+		}
+	})
+
+	b.Run("GetPutParallel", func(b *testing.B) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				cmd := pool.Get()
+				cmd.Args = append(cmd.Args, args...)
+				cmd.Args = ResetStringSlice(cmd.Args)
+				pool.Put(cmd)
+			}
+		})
+	})
+}
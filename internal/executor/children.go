@@ -0,0 +1,150 @@
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"gex/internal/shell"
+)
+
+// children follows the processes the shell started. One goroutine collects
+// their stops, continues and exits whenever SIGCHLD arrives, so no
+// goroutine sits in wait for each running job.
+type children struct {
+	mutex     sync.Mutex
+	processes map[int]*child
+	sigchld   chan os.Signal
+}
+
+// child is a followed process. changed is called for each stop and
+// continue, killed when a signal ended it, and done with the result of
+// exec.Cmd.Wait once it has exited and its output has been copied.
+type child struct {
+	cmd     *exec.Cmd
+	changed func(shell.JobState)
+	killed  func(syscall.Signal)
+	done    func(error)
+}
+
+func newChildren() *children {
+	c := &children{
+		processes: make(map[int]*child),
+		sigchld:   make(chan os.Signal, 1),
+	}
+	signal.Notify(c.sigchld, syscall.SIGCHLD)
+	go func() {
+		for range c.sigchld {
+			c.collect()
+		}
+	}()
+	return c
+}
+
+// follow starts following a started command
+func (c *children) follow(cmd *exec.Cmd, changed func(shell.JobState), killed func(syscall.Signal), done func(error)) {
+	c.mutex.Lock()
+	c.processes[cmd.Process.Pid] = &child{cmd: cmd, changed: changed, killed: killed, done: done}
+	c.mutex.Unlock()
+
+	// Its SIGCHLD may have come and gone before it was added
+	select {
+	case c.sigchld <- syscall.SIGCHLD:
+	default:
+	}
+}
+
+// hand replaces the stop and continue callback of a followed process,
+// when a stopped foreground job passes to the job table
+func (c *children) hand(pid int, changed func(shell.JobState)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if child, exists := c.processes[pid]; exists {
+		child.changed = changed
+		child.killed = nil
+	}
+}
+
+// collect passes on every state change of the followed processes that is
+// waiting to be collected
+func (c *children) collect() {
+	c.mutex.Lock()
+	pids := make([]int, 0, len(c.processes))
+	for pid := range c.processes {
+		pids = append(pids, pid)
+	}
+	c.mutex.Unlock()
+
+	for _, pid := range pids {
+		for {
+			state, sig, changed := pollChild(pid)
+			if !changed {
+				break
+			}
+
+			c.mutex.Lock()
+			child := *c.processes[pid]
+			if state == shell.JobDone {
+				delete(c.processes, pid)
+			}
+			c.mutex.Unlock()
+
+			if state != shell.JobDone {
+				child.changed(state)
+				continue
+			}
+			if sig != 0 && child.killed != nil {
+				child.killed(sig)
+			}
+			// Wait reaps the process, but may have to wait for the copying
+			// of its output to finish, which must not hold up the others
+			go func() {
+				child.done(child.cmd.Wait())
+			}()
+			break
+		}
+	}
+}
+
+// pollChild reports a state change of a process without waiting for one.
+// Stops and continues are consumed; an exit is left for exec.Cmd.Wait to
+// collect, along with the signal that killed the process if any.
+func pollChild(pid int) (shell.JobState, syscall.Signal, bool) {
+	var info siginfo
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid), uintptr(unsafe.Pointer(&info)),
+			syscall.WEXITED|syscall.WSTOPPED|wCONTINUED|syscall.WNOHANG|syscall.WNOWAIT, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			// Not a child any more; let Wait report what happened
+			return shell.JobDone, 0, true
+		}
+		break
+	}
+	if info.Pid == 0 {
+		return 0, 0, false
+	}
+
+	var state shell.JobState
+	var flags uintptr
+	switch info.Code {
+	case cldStopped:
+		state, flags = shell.JobStopped, syscall.WSTOPPED
+	case cldContinued:
+		state, flags = shell.JobRunning, wCONTINUED
+	case cldKilled, cldDumped:
+		return shell.JobDone, syscall.Signal(info.Status), true
+	default:
+		return shell.JobDone, 0, true
+	}
+
+	// Only the stop or continue is collected here, never the exit status
+	syscall.Syscall6(syscall.SYS_WAITID, pPID, uintptr(pid), uintptr(unsafe.Pointer(&info)),
+		flags|syscall.WNOHANG, 0, 0)
+	return state, 0, true
+}
@@ -0,0 +1,46 @@
+package executor
+
+import (
+	"fmt"
+
+	"gex/internal/builtin"
+	"gex/internal/cli"
+)
+
+// executeCommand runs command: the builtin or program named, passing over
+// any function or alias of that name, so a function can wrap the command
+// it is named after. -v prints what each name would run and -V describes
+// it like type.
+func (e *Executor) executeCommand(cmd *cli.Command) error {
+	args := cmd.Args
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	} else if len(args) > 0 && (args[0] == "-v" || args[0] == "-V") {
+		if args[0] == "-V" {
+			return builtin.Type(e.streams, args[1:], e.session)
+		}
+		return builtin.CommandPath(e.streams, args[1:], e.session)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	next := &cli.Command{Name: args[0], Args: args[1:], Background: cmd.Background}
+	if e.session.IsBuiltin(next.Name) {
+		return e.executeBuiltin(next)
+	}
+	return e.executeExternal(next)
+}
+
+// executeBuiltinCommand runs builtin: the builtin named, passing over any
+// function of that name, even when it has been disabled with enable -n
+func (e *Executor) executeBuiltinCommand(cmd *cli.Command) error {
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+	next := &cli.Command{Name: cmd.Args[0], Args: cmd.Args[1:], Background: cmd.Background}
+	if !cli.IsBuiltin(next.Name) {
+		return fmt.Errorf("builtin: %s: not a shell builtin", next.Name)
+	}
+	return e.executeBuiltin(next)
+}
@@ -0,0 +1,126 @@
+package executor
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
+
+	"gex/internal/builtin"
+	"gex/internal/cli"
+)
+
+// executeExec runs exec. Without a command its redirections stay in
+// effect for the rest of the session: exec 3< file opens descriptor 3 for
+// every later command, exec > log sends the shell's output to log and
+// exec 3>&- closes 3 again. With a command, the command replaces the
+// shell.
+func (e *Executor) executeExec(cmd *cli.Command) error {
+	if len(cmd.Args) > 0 {
+		return e.execCommand(cmd)
+	}
+
+	fds := e.descriptorTable(e.streams)
+	opened, err := e.redirectTable(fds, cmd.Redirects)
+	if err != nil {
+		return fmt.Errorf("exec: %v", err)
+	}
+
+	streams := *e.streams
+	var ok bool
+	if streams.Stdin, ok = fds[0].(io.Reader); !ok {
+		streams.Stdin = strings.NewReader("")
+	}
+	if streams.Stdout, ok = fds[1].(io.Writer); !ok {
+		streams.Stdout = io.Discard
+	}
+	if streams.Stderr, ok = fds[2].(io.Writer); !ok {
+		streams.Stderr = io.Discard
+	}
+	e.streams = &streams
+
+	descriptors := make(map[int]interface{})
+	for fd, stream := range fds {
+		if fd > 2 && stream != nil {
+			descriptors[fd] = stream
+		}
+	}
+	e.descriptors = descriptors
+
+	// Files opened by exec stay open while a descriptor refers to them
+	for _, file := range opened {
+		e.execFiles[file] = true
+	}
+	for file := range e.execFiles {
+		inUse := false
+		for _, stream := range fds {
+			if streamFile(stream) == file {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			file.Close()
+			delete(e.execFiles, file)
+		}
+	}
+	return nil
+}
+
+// descriptorTable returns the descriptors a command starts with: the
+// standard streams and those the shell opened with exec
+func (e *Executor) descriptorTable(streams *builtin.Context) map[int]interface{} {
+	fds := map[int]interface{}{0: streams.Stdin, 1: streams.Stdout, 2: streams.Stderr}
+	for fd, stream := range e.descriptors {
+		fds[fd] = stream
+	}
+	return fds
+}
+
+// execCommand replaces the shell with an external command, with the
+// shell's descriptors and the command's redirections in place
+func (e *Executor) execCommand(cmd *cli.Command) error {
+	name := cmd.Args[0]
+	path, err := e.findExecutable(name)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCommandNotFound, name)
+	}
+
+	fds := e.descriptorTable(e.streams)
+	if _, err := e.redirectTable(fds, cmd.Redirects); err != nil {
+		return fmt.Errorf("exec: %v", err)
+	}
+
+	// Copy every source out of the way first, so moving one descriptor
+	// into place cannot overwrite another that is still to be moved
+	sources := make(map[int]int, len(fds))
+	for fd, stream := range fds {
+		file := streamFile(stream)
+		if file == nil {
+			if stream == nil {
+				continue
+			}
+			return fmt.Errorf("exec: %d: cannot pass this stream to a command", fd)
+		}
+		copied, err := syscall.Dup(int(file.Fd()))
+		if err != nil {
+			return fmt.Errorf("exec: %v", err)
+		}
+		sources[fd] = copied
+	}
+	for fd := 0; fd <= 2; fd++ {
+		if _, open := sources[fd]; !open {
+			syscall.Close(fd)
+		}
+	}
+	for fd, copied := range sources {
+		if err := syscall.Dup3(copied, fd, 0); err != nil {
+			return fmt.Errorf("exec: %v", err)
+		}
+		syscall.Close(copied)
+	}
+
+	e.ReleaseTerminal()
+	err = syscall.Exec(path, append([]string{name}, cmd.Args[1:]...), e.streams.Environ())
+	return fmt.Errorf("exec: %s: %v", name, err)
+}
@@ -4,36 +4,302 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"gex/internal/builtin"
 	"gex/internal/cli"
+	"gex/internal/config"
+	"gex/internal/core"
 	"gex/internal/shell"
+	"gex/internal/ui"
 )
 
+// ErrCommandNotFound is returned when no builtin, function or executable
+// matches a command name
+var ErrCommandNotFound = errors.New("command not found")
+
 // Executor handles command execution with high performance
 type Executor struct {
 	session *shell.Session
-	mutex   sync.RWMutex
+	// streams are what builtins read and write and what external commands
+	// inherit when not redirected
+	streams       *builtin.Context
+	functionDepth int
+	// conditionDepth counts enclosing && / || tests, where set -e is ignored
+	conditionDepth int
+
+	// Signals waiting for their trap commands to run, and the signals
+	// the shell was started ignoring, as under nohup
+	signals      chan os.Signal
+	caught       map[syscall.Signal]bool
+	ignored      map[syscall.Signal]bool
+	trapMutex    sync.Mutex
+	pendingTraps []string
+	inTrap       bool
+
+	// PID of the most recent background job, for $!
+	lastBackground int
+
+	// Processes started by the shell, shared with pipeline stages
+	children *children
+
+	// Deadline of the enclosing timeout, nil outside one, and what its
+	// external commands are sent when it passes
+	deadline      context.Context
+	timeoutSignal syscall.Signal
+	killAfter     time.Duration
+
+	// An interactive shell interrupts the command line on Ctrl+C rather
+	// than exit; busy and the rest are guarded by commandMutex
+	interactive  bool
+	redraw       func()
+	suspend      func()
+	resume       func()
+	commandMutex sync.Mutex
+	busy         bool
+	interrupt    chan struct{}
+	jobPgid      int
+
+	// Rate limit on output to the terminal, nil without --throttle
+	throttle *ui.Writer
+
+	// Job control of the interactive shell
+	jobControl   bool
+	shellPgid    int
+	originalPgid int
+	stopSignals  chan os.Signal
+
+	// Set for a pipeline stage, which keeps its own $1..$N
+	stage  bool
+	params []string
+
+	// Descriptors above 2 opened with exec, which every command inherits,
+	// and the files exec opened that are still in use
+	descriptors map[int]interface{}
+	execFiles   map[*os.File]bool
 }
 
 // New creates a new executor instance
 func New(session *shell.Session) *Executor {
 	return &Executor{
-		session: session,
+		session:   session,
+		streams:   builtin.StdContext(),
+		children:  newChildren(),
+		execFiles: make(map[*os.File]bool),
 	}
 }
 
-// Execute executes a parsed command
+// forStage returns an executor for a builtin or function running as one
+// stage of a pipeline. It shares the session but has its own streams and
+// positional parameters, and leaves the terminal and signals to the shell,
+// so the stages of a pipeline can run at the same time.
+func (e *Executor) forStage(streams *builtin.Context) *Executor {
+	return &Executor{
+		session:        e.session,
+		streams:        streams,
+		children:       e.children,
+		functionDepth:  e.functionDepth,
+		conditionDepth: e.conditionDepth,
+		lastBackground: e.lastBackground,
+		deadline:       e.deadline,
+		timeoutSignal:  e.timeoutSignal,
+		killAfter:      e.killAfter,
+		stage:          true,
+		params:         e.positionalParams(),
+		descriptors:    e.descriptors,
+		execFiles:      make(map[*os.File]bool),
+	}
+}
+
+// ThrottleOutput limits what commands write to stdout and stderr to
+// bytesPerSecond. External commands then write through a pipe, so they no
+// longer see a terminal.
+func (e *Executor) ThrottleOutput(bytesPerSecond int) {
+	e.throttle = ui.NewThrottledWriter(e.streams.Stdout, bytesPerSecond)
+	e.streams.Stdout = e.throttle
+	e.streams.Stderr = e.throttle
+}
+
+// CaptureOutput copies what commands write to stdout into ring. Builtins
+// still see the terminal, but external commands write through a pipe.
+func (e *Executor) CaptureOutput(ring io.Writer) {
+	e.streams.Stdout = ui.NewTeeWriter(e.streams.Stdout, ring)
+}
+
+// HighlightStderr writes what commands write to stderr in color. External
+// commands then write stderr to a pipe rather than the terminal.
+func (e *Executor) HighlightStderr(color string) {
+	e.streams.Stderr = ui.NewColorWriter(e.streams.Stderr, color)
+}
+
+// Parse parses a command line, expanding the global aliases of the session
+func (e *Executor) Parse(input string) (*cli.Command, error) {
+	return cli.ParseWithAliases(input, e.session.GetGlobalAliases())
+}
+
+// maxFunctionDepth limits function recursion
+const maxFunctionDepth = 100
+
+// Execute executes a parsed command list
 func (e *Executor) Execute(cmd *cli.Command) error {
 	if cmd == nil {
 		return errors.New("nil command")
 	}
 
+	commandLine := e.interactive && !e.busy
+	if commandLine {
+		defer e.beginCommandLine()()
+	}
+
+	var err error
+	for current := cmd; current != nil; current = current.Next {
+		tested := current.Next != nil && current.NextOp != cli.ListSeq
+		if tested {
+			e.conditionDepth++
+		}
+		err = e.executePipelineOrSingle(current)
+		if tested {
+			e.conditionDepth--
+		}
+		if isExitError(err) {
+			return err
+		}
+		e.session.SetLastStatus(ExitCode(err))
+		if len(current.Pipes) == 0 {
+			e.session.SetPipeStatus([]int{ExitCode(err)})
+		}
+
+		if trapErr := e.RunPendingTraps(); trapErr != nil {
+			return trapErr
+		}
+
+		// Ctrl+C abandons the rest of the command line
+		if e.streams.Interrupted() || (e.interactive && killedByInterrupt(err)) {
+			// Put the prompt on a line of its own after the echoed ^C
+			if commandLine && ExitCode(err) == 128+int(syscall.SIGINT) {
+				fmt.Fprintln(e.streams.Stderr)
+			}
+			return err
+		}
+
+		// set -e: a failure that no && or || tests exits the shell
+		if err != nil && !tested && e.conditionDepth == 0 && e.session.Option("errexit") {
+			if ShouldReport(err) {
+				reportError(e.streams.Stderr, err)
+			}
+			return builtin.ShellExit(ExitCode(err))
+		}
+
+		if current.Next == nil {
+			return err
+		}
+
+		// Report failures of commands that are not the last in the list;
+		// a plain non-zero exit status is not worth a message
+		if ShouldReport(err) {
+			reportError(e.streams.Stderr, err)
+		}
+
+		// Skip pipelines whose && / || condition is not met
+		for current.Next != nil && !shouldRun(current.NextOp, err) {
+			current = current.Next
+		}
+	}
+
+	return err
+}
+
+// shouldRun decides whether the next list element runs after err
+func shouldRun(op cli.ListOp, err error) bool {
+	switch op {
+	case cli.ListAnd:
+		return err == nil
+	case cli.ListOr:
+		return err != nil
+	default:
+		return true
+	}
+}
+
+// ShouldReport reports whether an error deserves a message; exit statuses
+// of commands that already explained themselves (or chose to stay silent)
+// do not
+func ShouldReport(err error) bool {
+	if err == nil || isExitError(err) {
+		return false
+	}
+	var status builtin.ExitStatus
+	var exitErr *exec.ExitError
+	return !errors.As(err, &status) && !errors.As(err, &exitErr)
+}
+
+// reportError writes the message of a failed command to stderr, which is
+// the terminal unless a function or builtin redirected it
+func reportError(stderr io.Writer, err error) {
+	if builtin.IsTerminal(stderr) {
+		ui.FprintError(stderr, fmt.Sprintf("%v", err))
+		return
+	}
+	fmt.Fprintf(stderr, "gex: %v\n", err)
+}
+
+// ExitCode converts the result of a command into a process exit status
+func ExitCode(err error) int {
+	var shellExit builtin.ShellExit
+	if errors.As(err, &shellExit) {
+		return int(shellExit)
+	}
+	if err == nil || isExitError(err) {
+		return 0
+	}
+	if errors.Is(err, ErrCommandNotFound) {
+		return 127
+	}
+
+	var status builtin.ExitStatus
+	if errors.As(err, &status) {
+		return int(status)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// Commands killed by a signal report 128+signal like other shells
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+
+	return 1
+}
+
+// killedByInterrupt reports whether a command was killed by SIGINT
+func killedByInterrupt(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	ws, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && ws.Signaled() && ws.Signal() == syscall.SIGINT
+}
+
+// isExitError reports whether err requests the shell to exit
+func isExitError(err error) bool {
+	return err != nil && err.Error() == "exit"
+}
+
+// executePipelineOrSingle executes one element of a command list
+func (e *Executor) executePipelineOrSingle(cmd *cli.Command) error {
 	// Handle pipes
 	if len(cmd.Pipes) > 0 {
 		return e.executePipeline(cmd)
@@ -45,11 +311,99 @@ func (e *Executor) Execute(cmd *cli.Command) error {
 
 // executeSingle executes a single command
 func (e *Executor) executeSingle(cmd *cli.Command) error {
-	// Expand aliases
-	cli.ExpandAliases(cmd, e.session.GetAliases())
+	// Handle function definitions
+	if cmd.Function != nil {
+		e.session.SetFunction(cmd.Function.Name, cmd.Function.Body)
+		return nil
+	}
+
+	// Handle arithmetic commands: only $ references are expanded so that
+	// operators like * and < keep their meaning
+	if cmd.Name == "((" {
+		expr, err := e.newExpander().ExpandParams(strings.Join(cmd.Args, " "))
+		if err != nil {
+			return err
+		}
+		return builtin.Let(e.streams, []string{expr}, e.session)
+	}
+
+	// Handle variable assignments (NAME=value [command])
+	if assignments, rest := splitAssignments(cmd); len(assignments) > 0 {
+		if rest == nil {
+			return e.assignVariables(assignments)
+		}
+		return e.withCommandEnv(assignments, func() error {
+			return e.executeSingle(rest)
+		})
+	}
+
+	// Expand aliases and words
+	expanded, err := e.prepareCommand(cmd)
+	if err != nil {
+		return err
+	}
+	if expanded == nil {
+		// No command is left to run, but redirections still create files
+		redirects, err := e.expandRedirects(e.newExpander(), cmd.Redirects)
+		if err != nil {
+			return err
+		}
+		_, files, err := e.redirectStreams(e.streams, redirects)
+		closeFiles(files)
+		return err
+	}
+	e.trace(expanded)
+
+	return e.dispatch(expanded)
+}
+
+// dispatch runs an already expanded command as a function, builtin or
+// external program
+func (e *Executor) dispatch(cmd *cli.Command) (err error) {
+	// The redirections of exec outlast it, so they are its own to apply
+	if cmd.Name == "exec" {
+		return e.executeExec(cmd)
+	}
+
+	// Builtins and functions get redirected streams; external commands
+	// redirect their own
+	if len(cmd.Redirects) > 0 && e.runsInShell(cmd) {
+		streams, files, redirectErr := e.redirectStreams(e.streams, cmd.Redirects)
+		if redirectErr != nil {
+			return redirectErr
+		}
+		defer closeFiles(files)
+
+		saved := e.streams
+		e.streams = streams
+		defer func() { e.streams = saved }()
+
+		// The error goes where stderr was redirected, leaving the caller
+		// only its status
+		defer func() {
+			var shellExit builtin.ShellExit
+			if ShouldReport(err) && !errors.As(err, &shellExit) {
+				reportError(streams.Stderr, err)
+				err = builtin.ExitStatus(ExitCode(err))
+			}
+		}()
+
+		// Commands the builtin runs itself must not redirect again
+		plain := *cmd
+		plain.Redirects = nil
+		cmd = &plain
+	}
+
+	// Check for shell functions
+	if body, exists := e.session.GetFunction(cmd.Name); exists {
+		return e.callFunction(body, cmd.Args)
+	}
 
 	// Check if it's a built-in command
-	if cli.IsBuiltin(cmd.Name) {
+	if e.session.IsBuiltin(cmd.Name) {
+		if output := e.autoPager(cmd); output != nil {
+			return e.executePaged(cmd, output)
+		}
 		return e.executeBuiltin(cmd)
 	}
 
@@ -57,345 +411,1098 @@ func (e *Executor) executeSingle(cmd *cli.Command) error {
 	return e.executeExternal(cmd)
 }
 
+// prepareCommand expands aliases and then runs every word through the
+// expander, returning a new command with final names and arguments, or nil
+// when nullglob removed every word
+func (e *Executor) prepareCommand(cmd *cli.Command) (*cli.Command, error) {
+	result := *cmd
+	result.Args = append([]string(nil), cmd.Args...)
+	cli.ExpandAliases(&result, e.session.GetAliases(), e.session.GetSuffixAliases())
+
+	expander := e.newExpander()
+
+	// declare and local take NAME=value words unexpanded and assign them
+	// themselves, after setting attributes, so NAME=(...) can make an array
+	declaration := result.Name == "declare" || result.Name == "local"
+	var words []string
+	for _, word := range append([]string{result.Name}, result.Args...) {
+		if declaration && cli.IsAssignment(word) {
+			words = append(words, word)
+			continue
+		}
+		fields, err := expander.ExpandFields([]string{word})
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, fields...)
+	}
+
+	if len(words) == 0 {
+		return nil, nil
+	}
+	result.Name = words[0]
+	result.Args = words[1:]
+
+	redirects, err := e.expandRedirects(expander, result.Redirects)
+	if err != nil {
+		return nil, err
+	}
+	result.Redirects = redirects
+	return &result, nil
+}
+
+// expandRedirects expands the targets of redirections like arguments, and
+// the bodies of here-documents whose delimiter is unquoted. The parsed
+// redirections are left as they are.
+func (e *Executor) expandRedirects(expander *cli.Expander, redirects []*cli.Redirect) ([]*cli.Redirect, error) {
+	result := make([]*cli.Redirect, len(redirects))
+	for i, redirect := range redirects {
+		expanded := *redirect
+		switch {
+		case redirect.Type != cli.RedirectHeredoc:
+			target, err := expander.ExpandRedirectTarget(redirect.Target)
+			if err != nil {
+				return nil, err
+			}
+			expanded.Target = target
+			if _, err := strconv.Atoi(target); redirect.Type == cli.RedirectDup && redirect.FD == 1 && target != "-" && err != nil {
+				// >&$file named a file after all
+				expanded.Type = cli.RedirectBoth
+			}
+		case !redirect.Quoted:
+			body, err := expander.ExpandHeredoc(redirect.Target)
+			if err != nil {
+				return nil, err
+			}
+			expanded.Target = body
+		}
+		result[i] = &expanded
+	}
+	return result, nil
+}
+
+// trace prints an expanded command to stderr when set -x is on
+func (e *Executor) trace(cmd *cli.Command) {
+	if !e.session.Option("xtrace") {
+		return
+	}
+	words := make([]string, 0, len(cmd.Args)+1)
+	for _, word := range append([]string{cmd.Name}, cmd.Args...) {
+		words = append(words, traceQuote(word))
+	}
+	fmt.Fprintf(e.streams.Stderr, "+ %s\n", strings.Join(words, " "))
+}
+
+// traceQuote quotes a word for set -x output if it would not survive being
+// read back
+func traceQuote(word string) string {
+	if word != "" && !strings.ContainsAny(word, " \t\n'\"\\$`*?[]{}()<>|&;#~") {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// newExpander creates an expander bound to the session's variables
+func (e *Executor) newExpander() *cli.Expander {
+	return &cli.Expander{
+		Lookup:      e.lookupVariable,
+		LookupArray: e.lookupArray,
+		Assign:      e.session.AssignVariable,
+		NoUnset:     e.session.Option("nounset"),
+		Glob: &cli.GlobOptions{
+			FoldCase: !e.session.Config().CaseSensitive,
+			DotGlob:  e.session.Option("dotglob"),
+			NullGlob: e.session.Option("nullglob"),
+			FailGlob: e.session.Option("failglob"),
+			MaxDepth: e.session.Config().GlobMaxDepth,
+		},
+	}
+}
+
+// lookupVariable resolves variables and special parameters for expansion
+func (e *Executor) lookupVariable(name string) (string, bool) {
+	params := e.positionalParams()
+
+	switch name {
+	case "0":
+		return e.session.ScriptName(), true
+	case "#":
+		return strconv.Itoa(len(params)), true
+	case "@", "*":
+		return strings.Join(params, " "), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "?":
+		return strconv.Itoa(e.session.LastStatus()), true
+	case "PIPESTATUS":
+		statuses := e.session.PipeStatus()
+		if len(statuses) == 0 {
+			return "", false
+		}
+		return strconv.Itoa(statuses[0]), true
+	case "!":
+		if e.lastBackground == 0 {
+			return "", false
+		}
+		return strconv.Itoa(e.lastBackground), true
+	}
+
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		if n <= len(params) {
+			return params[n-1], true
+		}
+		return "", false
+	}
+
+	if value, assigned := e.streams.Env[name]; assigned {
+		return value, true
+	}
+	return e.session.LookupVariable(name)
+}
+
+// lookupArray resolves an array for expansion: PIPESTATUS, an array
+// variable, or any other variable as an array of one element
+func (e *Executor) lookupArray(name string) (cli.ArrayValue, bool) {
+	if name == "PIPESTATUS" {
+		var value cli.ArrayValue
+		for i, status := range e.session.PipeStatus() {
+			value.Keys = append(value.Keys, strconv.Itoa(i))
+			value.Values = append(value.Values, strconv.Itoa(status))
+		}
+		return value, true
+	}
+	if array, exists := e.session.GetArray(name); exists {
+		return cli.ArrayValue{Keys: array.Keys(), Values: array.Values(), Associative: array.Associative}, true
+	}
+	if value, exists := e.lookupVariable(name); exists {
+		return cli.ArrayValue{Keys: []string{"0"}, Values: []string{value}}, true
+	}
+	return cli.ArrayValue{}, false
+}
+
+// assignVariables performs NAME=value assignments in the session
+func (e *Executor) assignVariables(words []string) error {
+	expander := e.newExpander()
+	for _, word := range words {
+		assignment, _ := cli.ParseAssignment(word)
+		if err := e.assign(assignment, expander); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignWord performs an assignment given as a raw word, for declare and
+// local
+func (e *Executor) assignWord(word string) error {
+	assignment, ok := cli.ParseAssignment(word)
+	if !ok {
+		return fmt.Errorf("`%s': not a valid identifier", word)
+	}
+	return e.assign(assignment, e.newExpander())
+}
+
+// assign performs one assignment: NAME=value, NAME[subscript]=value,
+// NAME=(...) for an array, and += to add to the value instead
+func (e *Executor) assign(a cli.Assignment, expander *cli.Expander) error {
+	if a.Compound {
+		return e.assignArray(a, expander)
+	}
+
+	value, err := expander.Expand(a.Value)
+	if err != nil {
+		return err
+	}
+
+	if a.HasSubscript {
+		array, isArray := e.session.GetArray(a.Name)
+		key, err := expander.ElementKey(a.Subscript, isArray && array.Associative)
+		if err != nil {
+			return err
+		}
+		if !isArray {
+			// A scalar is element 0 of the array it becomes
+			array = shell.NewArray(false)
+			if value, exists := e.session.LookupVariable(a.Name); exists {
+				array.Elements["0"] = value
+			}
+		}
+		if key, err = elementIndex(array, a.Name, a.Subscript, key); err != nil {
+			return err
+		}
+		if a.Append && isArray {
+			value = e.appendValue(a.Name, array.Elements[key], value)
+		}
+		if e.session.Option("xtrace") {
+			fmt.Fprintf(e.streams.Stderr, "+ %s[%s]=%s\n", a.Name, key, traceQuote(value))
+		}
+		return e.session.SetArrayElement(a.Name, key, value)
+	}
+
+	if a.Append {
+		if old, exists := e.session.LookupVariable(a.Name); exists {
+			value = e.appendValue(a.Name, old, value)
+		}
+	}
+	if e.session.Option("xtrace") {
+		fmt.Fprintf(e.streams.Stderr, "+ %s=%s\n", a.Name, traceQuote(value))
+	}
+	return e.session.AssignVariable(a.Name, value)
+}
+
+// elementIndex resolves the key of an element of an indexed array that is
+// a negative index, counting back from the end as references do
+func elementIndex(array *shell.Array, name, subscript, key string) (string, error) {
+	index, err := strconv.Atoi(key)
+	if array.Associative || err != nil || index >= 0 {
+		return key, nil
+	}
+	resolved, ok := array.ResolveIndex(index)
+	if !ok {
+		return "", fmt.Errorf("%s[%s]: bad array subscript", name, subscript)
+	}
+	return strconv.Itoa(resolved), nil
+}
+
+// appendValue returns what NAME+=value stores: old with value added to
+// its end, or for an integer variable the sum of the two
+func (e *Executor) appendValue(name, old, value string) string {
+	if e.session.IsInteger(name) {
+		return old + "+(" + value + ")"
+	}
+	return old + value
+}
+
+// assignArray performs NAME=(...). Each word is the value of the next
+// index, or with [key]=value sets the element it names; an associative
+// array only takes the latter. With += the elements are added to those
+// the array has.
+func (e *Executor) assignArray(a cli.Assignment, expander *cli.Expander) error {
+	words, err := cli.SplitWords(a.Value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", a.Name, err)
+	}
+
+	existing, isArray := e.session.GetArray(a.Name)
+	array := shell.NewArray(isArray && existing.Associative)
+	if a.Append {
+		if isArray {
+			array = existing
+		} else if value, exists := e.session.LookupVariable(a.Name); exists {
+			array.Elements["0"] = value
+		}
+	}
+
+	for _, word := range words {
+		if close := strings.Index(word, "]="); strings.HasPrefix(word, "[") && close > 0 {
+			key, err := expander.ElementKey(word[1:close], array.Associative)
+			if err != nil {
+				return err
+			}
+			if key, err = elementIndex(array, a.Name, word[1:close], key); err != nil {
+				return err
+			}
+			value, err := expander.Expand(word[close+2:])
+			if err != nil {
+				return err
+			}
+			array.Elements[key] = value
+			continue
+		}
+		if array.Associative {
+			return fmt.Errorf("%s: %s: must use subscript when assigning associative array", a.Name, word)
+		}
+		values, err := expander.ExpandFields([]string{word})
+		if err != nil {
+			return err
+		}
+		array.Append(values...)
+	}
+
+	if e.session.Option("xtrace") {
+		values := array.Values()
+		for i, value := range values {
+			values[i] = traceQuote(value)
+		}
+		fmt.Fprintf(e.streams.Stderr, "+ %s=(%s)\n", a.Name, strings.Join(values, " "))
+	}
+	return e.session.SetArray(a.Name, array)
+}
+
+// splitAssignments separates the leading NAME=value words of a command
+// from the command they are for, which is nil when there is none
+func splitAssignments(cmd *cli.Command) ([]string, *cli.Command) {
+	words := append([]string{cmd.Name}, cmd.Args...)
+	count := 0
+	for count < len(words) && cli.IsAssignment(words[count]) {
+		count++
+	}
+	if count == len(words) {
+		return words, nil
+	}
+
+	rest := *cmd
+	rest.Name = words[count]
+	rest.Args = words[count+1:]
+	return words[:count], &rest
+}
+
+// withCommandEnv runs fn with NAME=value prefix assignments in the
+// environment of that command alone. They go into its streams rather than
+// the environment of the shell, which commands running at the same time
+// share.
+func (e *Executor) withCommandEnv(words []string, fn func() error) error {
+	env, err := e.commandEnv(words)
+	if err != nil {
+		return err
+	}
+	return e.withEnv(env, fn)
+}
+
+// withEnv runs fn with env as the variables assigned for the command
+func (e *Executor) withEnv(env map[string]string, fn func() error) error {
+	saved := e.streams
+	streams := *e.streams
+	streams.Env = env
+	e.streams = &streams
+	defer func() {
+		if e.streams == &streams {
+			e.streams = saved
+		} else {
+			// exec replaced the streams for good, but not the assignments
+			e.streams.Env = saved.Env
+		}
+	}()
+
+	return fn()
+}
+
+// commandEnv evaluates prefix assignments on top of those already in
+// effect
+func (e *Executor) commandEnv(words []string) (map[string]string, error) {
+	env := make(map[string]string, len(e.streams.Env)+len(words))
+	for name, value := range e.streams.Env {
+		env[name] = value
+	}
+
+	expander := e.newExpander()
+	for _, word := range words {
+		assignment, _ := cli.ParseAssignment(word)
+		name := assignment.Name
+		if assignment.Compound || assignment.HasSubscript {
+			return nil, fmt.Errorf("%s: arrays cannot be passed in the environment", name)
+		}
+		if e.session.IsReadonly(name) {
+			return nil, fmt.Errorf("%s: readonly variable", name)
+		}
+		value, err := expander.Expand(assignment.Value)
+		if err != nil {
+			return nil, err
+		}
+		if assignment.Append {
+			if old, exists := e.lookupVariable(name); exists {
+				value = old + value
+			}
+		}
+		env[name] = value
+	}
+	return env, nil
+}
+
+// positionalParams returns $1..$N: a pipeline stage's own, or else the
+// session's
+func (e *Executor) positionalParams() []string {
+	if e.stage {
+		return e.params
+	}
+	return e.session.GetPositionalParams()
+}
+
+// setPositionalParams replaces $1..$N and returns the previous values
+func (e *Executor) setPositionalParams(params []string) []string {
+	if e.stage {
+		previous := e.params
+		e.params = append([]string(nil), params...)
+		return previous
+	}
+	return e.session.SetPositionalParams(params)
+}
+
+// callFunction runs a shell function body with the given positional parameters
+func (e *Executor) callFunction(body string, args []string) error {
+	if e.functionDepth >= maxFunctionDepth {
+		return fmt.Errorf("maximum function nesting level exceeded (%d)", maxFunctionDepth)
+	}
+
+	parsed, err := e.Parse(body)
+	if err != nil {
+		return err
+	}
+
+	previous := e.setPositionalParams(args)
+	e.session.PushScope()
+	e.functionDepth++
+	defer func() {
+		e.functionDepth--
+		e.session.PopScope()
+		e.setPositionalParams(previous)
+	}()
+
+	return e.Execute(parsed)
+}
+
 // executeBuiltin executes a built-in command
 func (e *Executor) executeBuiltin(cmd *cli.Command) error {
 	switch cmd.Name {
 	// Basic shell commands
 	case "cd":
-		return builtin.Cd(cmd.Args, e.session)
+		return builtin.Cd(e.streams, cmd.Args, e.session)
 	case "pwd":
-		return builtin.Pwd(cmd.Args)
+		return builtin.Pwd(e.streams, cmd.Args)
 	case "echo":
-		return builtin.Echo(cmd.Args)
+		return builtin.Echo(e.streams, cmd.Args)
 	case "exit":
-		return builtin.Exit(cmd.Args)
+		return builtin.Exit(e.streams, cmd.Args, e.session)
 	case "help":
-		return builtin.Help(cmd.Args)
+		return builtin.Help(e.streams, cmd.Args)
 	case "history":
-		return builtin.History(cmd.Args, e.session)
+		return builtin.History(e.streams, cmd.Args, e.session)
+	case "last-output":
+		return builtin.LastOutput(e.streams, cmd.Args, e.session)
+	case "fc":
+		return e.executeFc(cmd)
+	case "exec":
+		return e.executeExec(cmd)
+	case "command":
+		return e.executeCommand(cmd)
+	case "builtin":
+		return e.executeBuiltinCommand(cmd)
+	case "enable":
+		return builtin.Enable(e.streams, cmd.Args, e.session)
 	case "alias":
-		return builtin.Alias(cmd.Args, e.session)
+		return builtin.Alias(e.streams, cmd.Args, e.session)
 	case "unalias":
-		return builtin.Unalias(cmd.Args, e.session)
+		return builtin.Unalias(e.streams, cmd.Args, e.session)
 	case "env":
-		return builtin.Env(cmd.Args)
+		return builtin.Env(e.streams, cmd.Args, e.session)
 	case "export":
-		return builtin.Export(cmd.Args)
+		return builtin.Export(e.streams, cmd.Args, e.session)
+	case "printenv":
+		return builtin.Printenv(e.streams, cmd.Args)
+	case "declare":
+		return builtin.Declare(e.streams, cmd.Args, e.session, e.assignWord)
+	case "local":
+		return builtin.Local(e.streams, cmd.Args, e.session, e.assignWord)
+	case "readonly":
+		return builtin.Readonly(e.streams, cmd.Args, e.session)
+	case "set":
+		return builtin.Set(e.streams, cmd.Args, e.session)
+	case "trap":
+		err := builtin.Trap(e.streams, cmd.Args, e.session)
+		e.syncTrapSignals()
+		return err
+	case "unset":
+		return builtin.Unset(e.streams, cmd.Args, e.session)
+	case "let":
+		return builtin.Let(e.streams, cmd.Args, e.session)
+	case "shift":
+		return builtin.Shift(e.streams, cmd.Args, e.positionalParams(), e.setPositionalParams)
+	case "getopts":
+		return builtin.Getopts(e.streams, cmd.Args, e.session, e.positionalParams())
 	case "which":
-		return builtin.Which(cmd.Args)
+		return builtin.Which(e.streams, cmd.Args, e.session)
 	case "type":
-		return builtin.Type(cmd.Args, e.session)
+		return builtin.Type(e.streams, cmd.Args, e.session)
+	case "hash":
+		return builtin.Hash(e.streams, cmd.Args, e.findExecutable)
+	case "rehash":
+		return builtin.Hash(e.streams, []string{"-r"}, e.findExecutable)
+	case "gex-cache":
+		return builtin.GexCache(e.streams, cmd.Args)
 
 	// File operations
 	case "ls":
-		return builtin.Ls(cmd.Args)
+		return builtin.Ls(e.streams, cmd.Args)
 	case "mkdir":
-		return builtin.Mkdir(cmd.Args)
+		return builtin.Mkdir(e.streams, cmd.Args)
 	case "rmdir":
-		return builtin.Rmdir(cmd.Args)
+		return builtin.Rmdir(e.streams, cmd.Args)
 	case "rm":
-		return builtin.Rm(cmd.Args)
+		return builtin.Rm(e.streams, cmd.Args)
 	case "cp":
-		return builtin.Cp(cmd.Args)
+		return builtin.Cp(e.streams, cmd.Args)
 	case "mv":
-		return builtin.Mv(cmd.Args)
+		return builtin.Mv(e.streams, cmd.Args)
 	case "touch":
-		return builtin.Touch(cmd.Args)
+		return builtin.Touch(e.streams, cmd.Args)
 
 	// Text operations
 	case "cat":
-		return builtin.Cat(cmd.Args)
+		return builtin.Cat(e.streams, cmd.Args)
 	case "head":
-		return builtin.Head(cmd.Args)
+		return builtin.Head(e.streams, cmd.Args)
 	case "tail":
-		return builtin.Tail(cmd.Args)
+		return builtin.Tail(e.streams, cmd.Args)
 	case "wc":
-		return builtin.Wc(cmd.Args)
+		return builtin.Wc(e.streams, cmd.Args)
 	case "grep":
-		return builtin.Grep(cmd.Args)
+		return builtin.Grep(e.streams, cmd.Args)
 	case "sort":
-		return builtin.Sort(cmd.Args)
+		return builtin.Sort(e.streams, cmd.Args)
+	case "imgcat":
+		return builtin.Imgcat(e.streams, cmd.Args)
+	case "weather":
+		return builtin.Weather(e.streams, cmd.Args, e.session)
+	case "worldclock":
+		return builtin.Worldclock(e.streams, cmd.Args, e.session)
+	case "qr":
+		return builtin.Qr(e.streams, cmd.Args)
+	case "edit":
+		return builtin.Edit(e.streams, cmd.Args)
+	case "more":
+		return builtin.More(e.streams, cmd.Args)
 
 	// System operations
 	case "ps":
-		return builtin.Ps(cmd.Args)
+		return builtin.Ps(e.streams, cmd.Args)
 	case "kill":
-		return builtin.Kill(cmd.Args)
+		err := builtin.Kill(e.streams, cmd.Args, e.session)
+		e.awaitSelfSignal(cmd.Args)
+		return err
 	case "df":
-		return builtin.Df(cmd.Args)
+		return builtin.Df(e.streams, cmd.Args)
 	case "du":
-		return builtin.Du(cmd.Args)
+		return builtin.Du(e.streams, cmd.Args)
 	case "free":
-		return builtin.Free(cmd.Args)
+		return builtin.Free(e.streams, cmd.Args)
 	case "uptime":
-		return builtin.Uptime(cmd.Args)
+		return builtin.Uptime(e.streams, cmd.Args)
 	case "uname":
-		return builtin.Uname(cmd.Args)
+		return builtin.Uname(e.streams, cmd.Args)
+	case "jobs":
+		return builtin.Jobs(e.streams, cmd.Args, e.session)
+	case "wait":
+		return builtin.Wait(e.streams, cmd.Args, e.session)
+	case "nohup":
+		return e.executeNohup(cmd)
+	case "disown":
+		return builtin.Disown(e.streams, cmd.Args, e.session)
+	case "withlock":
+		return builtin.WithLock(e.streams, cmd.Args, e.runArgs)
+	case "timeout":
+		return e.executeTimeout(cmd)
+	case "open":
+		return builtin.Open(e.streams, cmd.Args, e.session, e.runArgs)
 
 	// Search operations
 	case "find":
-		return builtin.Find(cmd.Args)
+		return builtin.Find(e.streams, cmd.Args)
 	case "locate":
-		return builtin.Locate(cmd.Args)
+		return builtin.Locate(e.streams, cmd.Args)
 
 	// Permission operations
 	case "chmod":
-		return builtin.Chmod(cmd.Args)
+		return builtin.Chmod(e.streams, cmd.Args)
 	case "chown":
-		return builtin.Chown(cmd.Args)
+		return builtin.Chown(e.streams, cmd.Args)
 	case "chgrp":
-		return builtin.Chgrp(cmd.Args)
+		return builtin.Chgrp(e.streams, cmd.Args)
 
 	// Network operations
 	case "ping":
-		return builtin.Ping(cmd.Args)
+		return builtin.Ping(e.streams, cmd.Args)
 	case "wget":
-		return builtin.Wget(cmd.Args)
+		return builtin.Wget(e.streams, cmd.Args, e.session)
 	case "curl":
-		return builtin.Curl(cmd.Args)
+		return builtin.Curl(e.streams, cmd.Args, e.session)
+	case "http-cache":
+		return builtin.HTTPCache(e.streams, cmd.Args)
+	case "portcheck":
+		return builtin.Portcheck(e.streams, cmd.Args)
+	case "port-scan":
+		return builtin.PortScan(e.streams, cmd.Args)
+	case "netspeed":
+		return builtin.Netspeed(e.streams, cmd.Args, e.session)
+	case "http":
+		return builtin.Http(e.streams, cmd.Args)
+	case "resolve":
+		return builtin.Resolve(e.streams, cmd.Args)
+	case "whois":
+		return builtin.Whois(e.streams, cmd.Args)
+	case "geoip":
+		return builtin.Geoip(e.streams, cmd.Args, e.session)
+	case "arp":
+		return builtin.Arp(e.streams, cmd.Args)
+	case "route":
+		return builtin.Route(e.streams, cmd.Args)
+	case "ip":
+		if !builtin.IsIPBuiltin(cmd.Args) {
+			return e.executeExternal(cmd)
+		}
+		return builtin.IP(e.streams, cmd.Args)
 	case "netstat":
-		return builtin.Netstat(cmd.Args)
+		return builtin.Netstat(e.streams, cmd.Args)
 
 	// Archive operations
 	case "tar":
-		return builtin.Tar(cmd.Args)
+		return builtin.Tar(e.streams, cmd.Args)
 	case "gzip":
-		return builtin.Gzip(cmd.Args)
+		return builtin.Gzip(e.streams, cmd.Args)
 	case "gunzip":
-		return builtin.Gzip(append([]string{"-d"}, cmd.Args...))
+		return builtin.Gzip(e.streams, append([]string{"-d"}, cmd.Args...))
 	case "zip":
-		return builtin.Zip(cmd.Args)
+		return builtin.Zip(e.streams, cmd.Args)
 	case "unzip":
-		return builtin.Zip(append([]string{"-x"}, cmd.Args...))
+		return builtin.Zip(e.streams, append([]string{"-x"}, cmd.Args...))
+	case "backup":
+		return builtin.Backup(e.streams, cmd.Args)
 
 	default:
 		return fmt.Errorf("unknown built-in command: %s", cmd.Name)
 	}
 }
 
+// runArgs executes an argument vector as a simple command, for builtins
+// that wrap another command
+func (e *Executor) runArgs(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	return e.dispatch(&cli.Command{Name: args[0], Args: args[1:]})
+}
+
 // executeExternal executes an external command
 func (e *Executor) executeExternal(cmd *cli.Command) error {
+	execCmd, err := e.externalCommand(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Execute command
+	if cmd.Background {
+		return e.executeBackground(execCmd, cmd)
+	}
+
+	return e.executeForeground(execCmd, cmd)
+}
+
+// externalCommand prepares an external command with its environment and
+// working directory. Its redirections are applied by redirectCommand once
+// its standard streams are set.
+func (e *Executor) externalCommand(cmd *cli.Command) (*exec.Cmd, error) {
 	// Find the executable
 	execPath, err := e.findExecutable(cmd.Name)
 	if err != nil {
-		return fmt.Errorf("command not found: %s", cmd.Name)
+		return nil, fmt.Errorf("%w: %s", ErrCommandNotFound, cmd.Name)
 	}
 
-	// Create context for cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	// Create the command
-	execCmd := exec.CommandContext(ctx, execPath, cmd.Args...)
+	execCmd := exec.Command(execPath, cmd.Args...)
+	if e.deadline != nil {
+		execCmd = e.commandWithDeadline(execPath, cmd.Args)
+	}
 
-	// Set environment
-	execCmd.Env = os.Environ()
+	// Set environment, with the assignments made for this command
+	execCmd.Env = e.streams.Environ()
 
 	// Set working directory
 	execCmd.Dir = e.session.GetWorkingDir()
 
-	// Handle redirections
-	if err := e.setupRedirections(execCmd, cmd.Redirect); err != nil {
-		return err
-	}
-
-	// Execute command
-	if cmd.Background {
-		return e.executeBackground(execCmd)
-	}
-
-	return e.executeForeground(execCmd)
+	return execCmd, nil
 }
 
 // executeForeground executes a command in the foreground
-func (e *Executor) executeForeground(cmd *exec.Cmd) error {
+func (e *Executor) executeForeground(cmd *exec.Cmd, command *cli.Command) error {
 	// Set up default I/O if not redirected
 	if cmd.Stdin == nil {
-		cmd.Stdin = os.Stdin
+		cmd.Stdin = e.streams.Stdin
 	}
 	if cmd.Stdout == nil {
-		cmd.Stdout = os.Stdout
+		cmd.Stdout = processOutput(e.streams.Stdout)
 	}
 	if cmd.Stderr == nil {
-		cmd.Stderr = os.Stderr
+		cmd.Stderr = e.streams.Stderr
+	}
+	files, err := e.redirectCommand(cmd, command.Redirects)
+	if err != nil {
+		return err
 	}
+	defer closeFiles(files)
 
-	// Start the command
+	// Start the command in a process group of its own
+	e.setProcessGroup(cmd, 0, true)
 	if err := cmd.Start(); err != nil {
 		return err
 	}
 
 	// Wait for completion
-	return cmd.Wait()
+	return e.waitForeground([]*exec.Cmd{cmd}, make([]error, 1), []*cli.Command{command})
 }
 
 // executeBackground executes a command in the background
-func (e *Executor) executeBackground(cmd *exec.Cmd) error {
+func (e *Executor) executeBackground(cmd *exec.Cmd, command *cli.Command) error {
 	// Set up default I/O for background processes
 	if cmd.Stdin == nil {
 		cmd.Stdin = nil // No stdin for background processes
 	}
+
+	// Route unredirected output to a per-job log instead of the terminal
+	var logFile *os.File
+	stdout := cmd.Stdout == nil && !redirects(command.Redirects, 1)
+	stderr := cmd.Stderr == nil && !redirects(command.Redirects, 2)
+	if e.session.Config().JobLogs && (stdout || stderr) {
+		file, err := openJobLog()
+		if err != nil {
+			ui.FprintWarning(e.streams.Stderr, fmt.Sprintf("job log unavailable, writing to terminal: %v", err))
+		} else {
+			logFile = file
+		}
+	}
+
 	if cmd.Stdout == nil {
-		cmd.Stdout = os.Stdout
+		if logFile != nil {
+			cmd.Stdout = logFile
+		} else {
+			cmd.Stdout = processOutput(e.streams.Stdout)
+		}
 	}
 	if cmd.Stderr == nil {
-		cmd.Stderr = os.Stderr
+		if logFile != nil {
+			cmd.Stderr = logFile
+		} else {
+			cmd.Stderr = e.streams.Stderr
+		}
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
+	files, err := e.redirectCommand(cmd, command.Redirects)
+	if err == nil {
+		defer closeFiles(files)
+
+		// Start the command, out of reach of Ctrl+C and Ctrl+Z
+		e.setProcessGroup(cmd, 0, false)
+		err = cmd.Start()
+	}
+	if err != nil {
+		if logFile != nil {
+			logFile.Close()
+			os.Remove(logFile.Name())
+		}
 		return err
 	}
 
-	fmt.Printf("[%d] %d\n", 1, cmd.Process.Pid) // Job number and PID
+	commandLine := strings.Join(append([]string{command.Name}, command.Args...), " ")
+	logPath := ""
+	if logFile != nil {
+		logPath = logFile.Name()
+	}
+	job := e.session.AddJob(cmd.Process.Pid, commandLine, logPath)
+
+	if logPath != "" {
+		fmt.Fprintf(e.streams.Stderr, "[%d] %d (output: %s)\n", job.ID, job.PID, logPath)
+	} else {
+		fmt.Fprintf(e.streams.Stderr, "[%d] %d\n", job.ID, job.PID) // Job number and PID
+	}
 
 	// Don't wait - let it run in background
-	go func() {
-		cmd.Wait()
-		fmt.Printf("[%d] Done\n", 1)
-	}()
+	e.lastBackground = job.PID
+
+	e.children.follow(cmd, func(state shell.JobState) {
+		e.session.SetJobState(job.ID, state)
+	}, nil, func(err error) {
+		if logFile != nil {
+			logFile.Close()
+		}
+		e.session.FinishJob(job.ID, ExitCode(err))
+	})
 
 	return nil
 }
 
-// executePipeline executes a pipeline of commands
-func (e *Executor) executePipeline(cmd *cli.Command) error {
-	commands := []*cli.Command{cmd}
-	commands = append(commands, cmd.Pipes...)
-
-	// Check if pipeline contains built-in commands
-	if hasBuiltinCommand(commands) {
-		return e.executeBuiltinPipeline(commands)
+// openJobLog creates a log file for a background job under ~/.gex/jobs
+func openJobLog() (*os.File, error) {
+	dir := filepath.Join(config.GetDataDir(), "jobs")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
 	}
 
-	// Execute external command pipeline
-	return e.executeExternalPipeline(commands)
+	name := fmt.Sprintf("%s-%d-*.log", time.Now().Format("20060102-150405"), os.Getpid())
+	return os.CreateTemp(dir, name)
 }
 
-// executeExternalPipeline executes a pipeline of external commands
-func (e *Executor) executeExternalPipeline(commands []*cli.Command) error {
-	// Create pipes between commands
-	var pipes []*os.File
-	var readers []*os.File
+// maxRedirectFD is the highest file descriptor a redirection may name
+const maxRedirectFD = 255
 
-	for i := 0; i < len(commands)-1; i++ {
-		r, w, err := os.Pipe()
-		if err != nil {
-			return err
+// redirectCommand applies the redirections of an external command on top
+// of the standard streams it has been given, passing descriptors above 2
+// on as extra files. It returns the files it opened, which the caller
+// closes once the command has started with its own copies.
+func (e *Executor) redirectCommand(cmd *exec.Cmd, redirects []*cli.Redirect) ([]*os.File, error) {
+	if len(redirects) == 0 && len(e.descriptors) == 0 {
+		return nil, nil
+	}
+
+	fds := map[int]interface{}{0: cmd.Stdin, 1: cmd.Stdout, 2: cmd.Stderr}
+	for fd, stream := range e.descriptors {
+		fds[fd] = stream
+	}
+	for i, file := range cmd.ExtraFiles {
+		if file != nil {
+			fds[3+i] = file
 		}
-		pipes = append(pipes, w)
-		readers = append(readers, r)
+	}
+	opened, err := e.redirectTable(fds, redirects)
+	if err != nil {
+		return nil, err
 	}
 
-	// Defer closing all pipes
-	defer func() {
-		for _, p := range pipes {
-			p.Close()
+	cmd.Stdin, _ = fds[0].(io.Reader)
+	cmd.Stdout, _ = fds[1].(io.Writer)
+	cmd.Stderr, _ = fds[2].(io.Writer)
+	cmd.ExtraFiles = nil
+	for fd, stream := range fds {
+		if fd < 3 {
+			continue
 		}
-		for _, r := range readers {
-			r.Close()
+		file := streamFile(stream)
+		if file == nil {
+			closeFiles(opened)
+			return nil, fmt.Errorf("%d: cannot pass this stream to a command", fd)
 		}
-	}()
+		for len(cmd.ExtraFiles) <= fd-3 {
+			cmd.ExtraFiles = append(cmd.ExtraFiles, nil)
+		}
+		cmd.ExtraFiles[fd-3] = file
+	}
+	return opened, nil
+}
+
+// redirectStreams returns a copy of streams with redirections applied,
+// and the files they opened, which the caller closes once the command is
+// done. A builtin only has the standard streams; a closed one reads as
+// empty and discards what is written.
+func (e *Executor) redirectStreams(streams *builtin.Context, redirects []*cli.Redirect) (*builtin.Context, []*os.File, error) {
+	fds := e.descriptorTable(streams)
+	opened, err := e.redirectTable(fds, redirects)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// Start all commands
-	var cmds []*exec.Cmd
-	var wg sync.WaitGroup
+	redirected := *streams
+	var ok bool
+	if redirected.Stdin, ok = fds[0].(io.Reader); !ok {
+		redirected.Stdin = strings.NewReader("")
+	}
+	if redirected.Stdout, ok = fds[1].(io.Writer); !ok {
+		redirected.Stdout = io.Discard
+	}
+	if redirected.Stderr, ok = fds[2].(io.Writer); !ok {
+		redirected.Stderr = io.Discard
+	}
+	return &redirected, opened, nil
+}
 
-	for i, command := range commands {
-		execPath, err := e.findExecutable(command.Name)
-		if err != nil {
-			return fmt.Errorf("command not found: %s", command.Name)
+// redirectTable applies redirections in order to a table of the open
+// descriptors of a command, so 2>&1 copies whatever descriptor 1 is at
+// that point. It returns the files it opened.
+func (e *Executor) redirectTable(fds map[int]interface{}, redirects []*cli.Redirect) ([]*os.File, error) {
+	var opened []*os.File
+	fail := func(err error) ([]*os.File, error) {
+		closeFiles(opened)
+		return nil, err
+	}
+
+	for _, redirect := range redirects {
+		if redirect.FD > maxRedirectFD {
+			return fail(fmt.Errorf("%d: bad file descriptor", redirect.FD))
 		}
 
-		execCmd := exec.Command(execPath, command.Args...)
-		execCmd.Env = os.Environ()
-		execCmd.Dir = e.session.GetWorkingDir()
+		if redirect.Type == cli.RedirectDup {
+			if redirect.Target == "-" {
+				delete(fds, redirect.FD)
+				continue
+			}
+			source, err := strconv.Atoi(redirect.Target)
+			stream, open := fds[source]
+			if err != nil || !open || stream == nil {
+				return fail(fmt.Errorf("%s: bad file descriptor", redirect.Target))
+			}
+			fds[redirect.FD] = stream
+			continue
+		}
 
-		// Set up stdin
-		if i == 0 {
-			// First command - stdin from terminal
-			execCmd.Stdin = os.Stdin
-		} else {
-			// Middle/last commands - stdin from previous pipe
-			execCmd.Stdin = readers[i-1]
+		// /dev/stdin, /dev/stdout, /dev/stderr and /dev/fd/N name the
+		// command's own descriptors, which for a builtin need not be files
+		if source, ok := descriptorPath(redirect.Target); ok && redirect.Type != cli.RedirectHeredoc {
+			stream, open := fds[source]
+			if !open || stream == nil {
+				return fail(fmt.Errorf("%s: bad file descriptor", redirect.Target))
+			}
+			if redirect.Type == cli.RedirectBoth || redirect.Type == cli.RedirectBothAppend {
+				fds[1], fds[2] = stream, stream
+			} else {
+				fds[redirect.FD] = stream
+			}
+			continue
 		}
 
-		// Set up stdout
-		if i == len(commands)-1 {
-			// Last command - stdout to terminal
-			execCmd.Stdout = os.Stdout
+		file, err := e.openRedirection(redirect)
+		if err != nil {
+			return fail(err)
+		}
+		opened = append(opened, file)
+		if redirect.Type == cli.RedirectBoth || redirect.Type == cli.RedirectBothAppend {
+			fds[1], fds[2] = file, file
 		} else {
-			// First/middle commands - stdout to next pipe
-			execCmd.Stdout = pipes[i]
+			fds[redirect.FD] = file
 		}
-
-		execCmd.Stderr = os.Stderr
-		cmds = append(cmds, execCmd)
 	}
+	return opened, nil
+}
 
-	// Start all commands
-	for _, execCmd := range cmds {
-		if err := execCmd.Start(); err != nil {
-			return err
+// redirects reports whether a redirection applies to descriptor fd
+func redirects(list []*cli.Redirect, fd int) bool {
+	for _, redirect := range list {
+		both := redirect.Type == cli.RedirectBoth || redirect.Type == cli.RedirectBothAppend
+		if redirect.FD == fd || (both && fd == 2) {
+			return true
 		}
-		wg.Add(1)
-		go func(c *exec.Cmd) {
-			defer wg.Done()
-			c.Wait()
-		}(execCmd)
 	}
+	return false
+}
 
-	// Wait for all commands to complete
-	wg.Wait()
+// streamFile returns the file behind a stream, if it has one
+func streamFile(stream interface{}) *os.File {
+	if wrapper, ok := stream.(interface{ File() *os.File }); ok {
+		return wrapper.File()
+	}
+	file, _ := stream.(*os.File)
+	return file
+}
 
-	return nil
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
 }
 
-// setupRedirections sets up input/output redirections
-func (e *Executor) setupRedirections(cmd *exec.Cmd, redirect *cli.Redirect) error {
-	if redirect == nil {
-		return nil
+// openRedirection opens the target of a redirection. With noclobber, >
+// and &> only create files and >| is needed to truncate one.
+func (e *Executor) openRedirection(redirect *cli.Redirect) (*os.File, error) {
+	if redirect.Type != cli.RedirectHeredoc {
+		if file, ok, err := dialDevicePath(redirect.Target); ok {
+			return file, err
+		}
 	}
 
 	switch redirect.Type {
-	case cli.RedirectOut:
-		file, err := os.Create(redirect.Target)
-		if err != nil {
-			return err
+	case cli.RedirectIn:
+		return os.Open(redirect.Target)
+	case cli.RedirectAppend, cli.RedirectBothAppend:
+		return os.OpenFile(redirect.Target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	case cli.RedirectHeredoc:
+		return heredocFile(redirect.Target)
+	case cli.RedirectClobber:
+		return os.Create(redirect.Target)
+	case cli.RedirectReadWrite:
+		return os.OpenFile(redirect.Target, os.O_CREATE|os.O_RDWR, 0666)
+	default:
+		if e.session.Option("noclobber") {
+			return createNoClobber(redirect.Target)
 		}
-		cmd.Stdout = file
+		return os.Create(redirect.Target)
+	}
+}
 
-	case cli.RedirectAppend:
-		file, err := os.OpenFile(redirect.Target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err != nil {
-			return err
-		}
-		cmd.Stdout = file
+// createNoClobber creates a file that must not exist yet. O_EXCL makes
+// the check and the creation one step, so a file appearing in between is
+// not truncated. Existing files that are not regular, like /dev/null, can
+// still be written.
+func createNoClobber(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if !errors.Is(err, fs.ErrExist) {
+		return file, err
+	}
+	if info, statErr := os.Stat(path); statErr == nil && !info.Mode().IsRegular() {
+		return os.OpenFile(path, os.O_WRONLY, 0)
+	}
+	return nil, fmt.Errorf("%s: cannot overwrite existing file", path)
+}
 
-	case cli.RedirectIn:
-		file, err := os.Open(redirect.Target)
-		if err != nil {
-			return err
+// descriptorPath returns the descriptor that /dev/stdin, /dev/stdout,
+// /dev/stderr or /dev/fd/N stands for
+func descriptorPath(path string) (int, bool) {
+	switch path {
+	case "/dev/stdin":
+		return 0, true
+	case "/dev/stdout":
+		return 1, true
+	case "/dev/stderr":
+		return 2, true
+	}
+	if n, ok := strings.CutPrefix(path, "/dev/fd/"); ok {
+		if fd, err := strconv.Atoi(n); err == nil && fd >= 0 {
+			return fd, true
 		}
-		cmd.Stdin = file
+	}
+	return 0, false
+}
 
-	case cli.RedirectErr:
-		file, err := os.Create(redirect.Target)
-		if err != nil {
-			return err
-		}
-		cmd.Stderr = file
+// dialDevicePath connects to the host and port named by /dev/tcp/host/port
+// or /dev/udp/host/port, as bash does for redirections. ok is false for
+// any other path. The connection is read and written through the file.
+func dialDevicePath(path string) (file *os.File, ok bool, err error) {
+	var network string
+	switch {
+	case strings.HasPrefix(path, "/dev/tcp/"):
+		network = "tcp"
+	case strings.HasPrefix(path, "/dev/udp/"):
+		network = "udp"
+	default:
+		return nil, false, nil
+	}
 
-	case cli.RedirectBoth:
-		file, err := os.Create(redirect.Target)
-		if err != nil {
-			return err
-		}
-		cmd.Stdout = file
-		cmd.Stderr = file
+	parts := strings.Split(path, "/")
+	if len(parts) != 5 || parts[3] == "" || parts[4] == "" {
+		return nil, true, fmt.Errorf("%s: expected /dev/%s/host/port", path, network)
+	}
+	conn, err := net.Dial(network, net.JoinHostPort(parts[3], parts[4]))
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %v", path, err)
+	}
+	defer conn.Close()
+
+	// File returns a blocking copy of the socket that commands can inherit
+	switch conn := conn.(type) {
+	case *net.TCPConn:
+		file, err = conn.File()
+	case *net.UDPConn:
+		file, err = conn.File()
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %v", path, err)
+	}
+	return file, true, nil
+}
+
+// heredocFile returns a file to read the body of a here-document from. It
+// is a deleted temporary file, so the command can read as much or as
+// little of it as it likes.
+func heredocFile(body string) (*os.File, error) {
+	file, err := os.CreateTemp("", "gex-heredoc-")
+	if err != nil {
+		return nil, err
 	}
+	os.Remove(file.Name())
 
-	return nil
+	if _, err := file.WriteString(body); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
 }
 
 // findExecutable finds an executable in PATH
@@ -416,12 +1523,21 @@ func (e *Executor) findExecutable(name string) (string, error) {
 		return "", errors.New("not found")
 	}
 
-	// Search in PATH
-	path := os.Getenv("PATH")
+	// Search in PATH, remembering where commands were found. An entry is
+	// only trusted for the PATH it was found in and while the file is
+	// still there.
+	path := e.streams.Getenv("PATH")
 	if path == "" {
 		path = "/usr/local/bin:/usr/bin:/bin"
 	}
 
+	if hashed, ok := core.PathCache.Get(name); ok {
+		if hashed.SearchPath == path && e.isExecutable(hashed.Path) {
+			return hashed.Path, nil
+		}
+		core.PathCache.Delete(name)
+	}
+
 	for _, dir := range strings.Split(path, ":") {
 		if dir == "" {
 			continue
@@ -429,6 +1545,7 @@ func (e *Executor) findExecutable(name string) (string, error) {
 
 		fullPath := filepath.Join(dir, name)
 		if e.isExecutable(fullPath) {
+			core.PathCache.Set(name, core.HashedCommand{Path: fullPath, SearchPath: path})
 			return fullPath, nil
 		}
 	}
@@ -451,10 +1568,3 @@ func (e *Executor) isExecutable(path string) bool {
 	// Check execute permission
 	return info.Mode()&0111 != 0
 }
-
-// InterruptRunning interrupts any running foreground process
-func (e *Executor) InterruptRunning() error {
-	// This would be implemented with process group management
-	// For now, just return nil
-	return nil
-}
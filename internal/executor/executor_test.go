@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gex/internal/cli"
+	"gex/internal/shell"
+)
+
+// writeRedirection opens target as a redirection of the given type would
+// and writes text to it
+func writeRedirection(t *testing.T, e *Executor, kind cli.RedirectType, target, text string) error {
+	t.Helper()
+	file, err := e.openRedirection(&cli.Redirect{FD: 1, Type: kind, Target: target})
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(text); err != nil {
+		t.Fatalf("write %s: %v", target, err)
+	}
+	return nil
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestOpenRedirectionTruncatesAndAppends(t *testing.T) {
+	e := New(shell.NewSession(nil))
+	path := filepath.Join(t.TempDir(), "out")
+
+	if err := writeRedirection(t, e, cli.RedirectOut, path, "first\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRedirection(t, e, cli.RedirectOut, path, "second\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "second\n" {
+		t.Errorf("> left %q, want %q", got, "second\n")
+	}
+
+	if err := writeRedirection(t, e, cli.RedirectAppend, path, "third\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "second\nthird\n" {
+		t.Errorf(">> left %q, want %q", got, "second\nthird\n")
+	}
+}
+
+func TestOpenRedirectionNoClobber(t *testing.T) {
+	e := New(shell.NewSession(nil))
+	e.session.SetOption("noclobber", true)
+	path := filepath.Join(t.TempDir(), "out")
+
+	if err := writeRedirection(t, e, cli.RedirectOut, path, "new\n"); err != nil {
+		t.Fatalf("> to a new file with set -C: %v", err)
+	}
+	if err := writeRedirection(t, e, cli.RedirectOut, path, "again\n"); err == nil {
+		t.Error("> overwrote an existing file with set -C")
+	}
+	if got := readFile(t, path); got != "new\n" {
+		t.Errorf("refused > left %q, want %q", got, "new\n")
+	}
+
+	if err := writeRedirection(t, e, cli.RedirectClobber, path, "forced\n"); err != nil {
+		t.Fatalf(">| with set -C: %v", err)
+	}
+	if got := readFile(t, path); got != "forced\n" {
+		t.Errorf(">| left %q, want %q", got, "forced\n")
+	}
+
+	if err := writeRedirection(t, e, cli.RedirectAppend, path, "more\n"); err != nil {
+		t.Errorf(">> with set -C: %v", err)
+	}
+}
+
+func TestCreateNoClobberDevNull(t *testing.T) {
+	file, err := createNoClobber(os.DevNull)
+	if err != nil {
+		t.Fatalf("%s with set -C: %v", os.DevNull, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString("discarded\n"); err != nil {
+		t.Errorf("write %s: %v", os.DevNull, err)
+	}
+}
@@ -0,0 +1,232 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gex/internal/builtin"
+	"gex/internal/cli"
+)
+
+// fcListLength is how many entries fc -l shows without a range
+const fcListLength = 16
+
+// executeFc lists a range of history entries with -l, or opens them in an
+// editor and runs what is saved. The editor comes from -e, FCEDIT or
+// EDITOR, and -e - runs the entries unchanged. A range end is a history
+// number, a negative offset from the newest entry, or the start of a
+// command line.
+func (e *Executor) executeFc(cmd *cli.Command) error {
+	list, numbers, reverse := false, true, false
+	editor := ""
+
+	args := cmd.Args
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if _, err := strconv.Atoi(args[0]); err == nil {
+			break
+		}
+		option := args[0]
+		args = args[1:]
+		if option == "--" {
+			break
+		}
+		for _, flag := range option[1:] {
+			switch flag {
+			case 'l':
+				list = true
+			case 'n':
+				numbers = false
+			case 'r':
+				reverse = true
+			case 'e':
+				if len(args) == 0 {
+					return fmt.Errorf("fc: -e requires an argument")
+				}
+				editor, args = args[0], args[1:]
+			default:
+				return fmt.Errorf("fc: invalid option: -%c", flag)
+			}
+		}
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("fc: usage: %s", cli.GetCommandInfo("fc").Usage)
+	}
+
+	// At the prompt the newest entry is this fc command itself
+	history := e.session.GetHistory()
+	if e.interactive && len(history) > 0 {
+		history = history[:len(history)-1]
+	}
+	if len(history) == 0 {
+		return fmt.Errorf("fc: history is empty")
+	}
+
+	first, last := "-1", ""
+	if list {
+		first, last = strconv.Itoa(-fcListLength), "-1"
+	}
+	if len(args) > 0 {
+		first, last = args[0], ""
+		if list {
+			last = "-1"
+		}
+	}
+	if len(args) > 1 {
+		last = args[1]
+	}
+
+	from, err := fcEntry(history, first)
+	if err != nil {
+		return err
+	}
+	to := from
+	if last != "" {
+		if to, err = fcEntry(history, last); err != nil {
+			return err
+		}
+	}
+
+	// A range given backwards is taken in that order
+	if from > to {
+		from, to = to, from
+		reverse = !reverse
+	}
+	entries := make([]int, 0, to-from+1)
+	for i := from; i <= to; i++ {
+		entries = append(entries, i)
+	}
+	if reverse {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+
+	if list {
+		for _, i := range entries {
+			if numbers {
+				fmt.Fprintln(e.streams.Stdout, builtin.FormatHistoryEntry(fmt.Sprintf("%4d  ", i+1), history[i]))
+			} else {
+				fmt.Fprintln(e.streams.Stdout, history[i])
+			}
+		}
+		return nil
+	}
+
+	lines := make([]string, len(entries))
+	for k, i := range entries {
+		lines[k] = history[i]
+	}
+	script := strings.Join(lines, "\n") + "\n"
+	if editor != "-" {
+		if script, err = e.fcEdit(editor, script); err != nil {
+			return err
+		}
+	}
+
+	// Show what runs, and remember it in place of the fc command
+	fmt.Fprint(e.streams.Stderr, script)
+	if e.interactive {
+		e.session.RemoveLastHistory()
+		for _, command := range historyCommands(script) {
+			e.session.AddHistory(command)
+		}
+	}
+
+	parsed, err := e.Parse(script)
+	if errors.Is(err, cli.ErrEmptyCommand) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("fc: %v", err)
+	}
+	return e.Execute(parsed)
+}
+
+// historyCommands splits a script into its commands for history, which
+// keeps a command that spans several lines as one entry
+func historyCommands(script string) []string {
+	var commands []string
+	command := ""
+	for _, line := range strings.Split(script, "\n") {
+		if command != "" {
+			command += "\n"
+		}
+		command += line
+
+		trailing := len(command) - len(strings.TrimRight(command, "\\"))
+		if _, err := cli.Parse(command); trailing%2 == 1 || cli.IsIncomplete(err) {
+			continue
+		}
+		commands = append(commands, command)
+		command = ""
+	}
+	return append(commands, command)
+}
+
+// fcEntry finds the history entry a range end refers to and returns its
+// index. Numbers past either end of the history stand for that end.
+func fcEntry(history []string, spec string) (int, error) {
+	n, err := strconv.Atoi(spec)
+	if err != nil {
+		for i := len(history) - 1; i >= 0; i-- {
+			if strings.HasPrefix(history[i], spec) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("fc: %s: event not found", spec)
+	}
+
+	index := n - 1
+	if n <= 0 {
+		index = len(history) + n
+		if n == 0 {
+			index = len(history) - 1
+		}
+	}
+	return max(0, min(index, len(history)-1)), nil
+}
+
+// fcEdit writes a script to a temporary file, opens it in an editor and
+// returns the saved text. A failing editor leaves nothing to run.
+func (e *Executor) fcEdit(editor, script string) (string, error) {
+	if editor == "" {
+		editor = e.streams.Getenv("FCEDIT")
+	}
+	if editor == "" {
+		editor = e.streams.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	file, err := os.CreateTemp("", "gex-fc-*.sh")
+	if err != nil {
+		return "", fmt.Errorf("fc: %v", err)
+	}
+	path := file.Name()
+	defer os.Remove(path)
+
+	_, err = file.WriteString(script)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("fc: %v", err)
+	}
+
+	if err := e.runArgs(append(strings.Fields(editor), path)); err != nil {
+		if ShouldReport(err) {
+			return "", fmt.Errorf("fc: %s: %v", editor, err)
+		}
+		return "", builtin.ExitStatus(ExitCode(err))
+	}
+
+	edited, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("fc: %v", err)
+	}
+	return string(edited), nil
+}
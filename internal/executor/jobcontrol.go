@@ -0,0 +1,379 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"gex/internal/builtin"
+	"gex/internal/cli"
+	"gex/internal/shell"
+)
+
+// ttyFd is the terminal the interactive shell reads commands from
+const ttyFd = 0
+
+// waitid(2) arguments and child states not provided by the syscall package
+const (
+	pPID         = 1
+	wCONTINUED   = 8
+	cldKilled    = 2
+	cldDumped    = 3
+	cldStopped   = 5
+	cldContinued = 6
+)
+
+// siginfo is the part of siginfo_t filled in by waitid(2) for SIGCHLD
+type siginfo struct {
+	Signo  int32
+	Errno  int32
+	Code   int32
+	_      int32
+	Pid    int32
+	UID    uint32
+	Status int32
+	_      [100]byte
+}
+
+// EnableJobControl puts the interactive shell in its own process group in
+// the foreground of its terminal. Every foreground pipeline then gets a
+// process group of its own that owns the terminal while it runs, so Ctrl+C
+// and Ctrl+Z reach the pipeline and not the shell.
+func (e *Executor) EnableJobControl() {
+	pgid := syscall.Getpgrp()
+	if foreground, err := tcgetpgrp(ttyFd); err != nil || foreground != pgid {
+		// Started in the background; leave the terminal alone
+		return
+	}
+
+	if pgid != os.Getpid() {
+		if err := syscall.Setpgid(0, 0); err != nil {
+			return
+		}
+	}
+
+	// Stop signals from the terminal must not suspend the shell. They are
+	// caught rather than ignored so children start with the default
+	// action. Ctrl+Z is passed on to the running job, and SIGTSTP at the
+	// prompt suspends the shell; the rest are dropped. SIGCONT tells the
+	// shell it was resumed.
+	e.stopSignals = make(chan os.Signal, 1)
+	signal.Notify(e.stopSignals, syscall.SIGTSTP, syscall.SIGTTIN, syscall.SIGTTOU, syscall.SIGCONT)
+	go func() {
+		for sig := range e.stopSignals {
+			e.commandMutex.Lock()
+			switch {
+			case sig == syscall.SIGTSTP && e.busy:
+				e.signalJob(syscall.SIGTSTP)
+			case sig == syscall.SIGTSTP:
+				e.suspendShell()
+			case sig == syscall.SIGCONT && !e.busy:
+				e.resumeShell()
+			}
+			e.commandMutex.Unlock()
+		}
+	}()
+
+	e.originalPgid = pgid
+	e.shellPgid = os.Getpid()
+	e.jobControl = true
+	e.setForeground(e.shellPgid)
+}
+
+// SetSuspendHandlers sets what is done when the shell is stopped at the
+// prompt and continued: suspend gives the terminal its normal modes back
+// and resume puts the line editor's back and redraws the prompt
+func (e *Executor) SetSuspendHandlers(suspend, resume func()) {
+	e.commandMutex.Lock()
+	defer e.commandMutex.Unlock()
+	e.suspend = suspend
+	e.resume = resume
+}
+
+// suspendShell stops the shell for the shell it was started from, as for
+// SIGTSTP at the prompt. A session leader, such as a login shell, has no
+// shell to return to and is not stopped. The caller holds commandMutex.
+func (e *Executor) suspendShell() {
+	sid, _, errno := syscall.RawSyscall(syscall.SYS_GETSID, 0, 0, 0)
+	if errno != 0 || int(sid) == os.Getpid() {
+		return
+	}
+
+	if e.suspend != nil {
+		e.suspend()
+	}
+	e.ReleaseTerminal()
+
+	// SIGTSTP is caught, so the shell stops itself with SIGSTOP; it goes
+	// on with SIGCONT, handled by resumeShell
+	syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+}
+
+// resumeShell takes the terminal back after the shell was continued in the
+// foreground, whether it stopped itself or was stopped from outside. The
+// caller holds commandMutex.
+func (e *Executor) resumeShell() {
+	foreground, err := tcgetpgrp(ttyFd)
+	if err != nil || (foreground != e.shellPgid && foreground != e.originalPgid) {
+		// Continued in the background
+		return
+	}
+	e.setForeground(e.shellPgid)
+	if e.resume != nil {
+		e.resume()
+	}
+}
+
+// ReleaseTerminal hands the terminal back to the process group that owned
+// it when the shell started
+func (e *Executor) ReleaseTerminal() {
+	if e.jobControl && e.originalPgid != e.shellPgid {
+		e.setForeground(e.originalPgid)
+	}
+}
+
+// setForeground makes pgid the foreground process group of the terminal.
+// The shell may be in the background at that point, where the kernel would
+// stop it with SIGTTOU unless the signal is ignored.
+func (e *Executor) setForeground(pgid int) {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Notify(e.stopSignals, syscall.SIGTTOU)
+
+	syscall.Syscall(syscall.SYS_IOCTL, uintptr(ttyFd), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&pgid)))
+}
+
+func tcgetpgrp(fd int) (int, error) {
+	var pgid int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgid)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgid), nil
+}
+
+// setProcessGroup makes cmd join process group pgid, or start a new one
+// when pgid is 0. A new foreground group takes the terminal before the
+// command runs, so it can read from it straight away.
+func (e *Executor) setProcessGroup(cmd *exec.Cmd, pgid int, foreground bool) {
+	if !e.jobControl {
+		return
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pgid: pgid}
+	if foreground && pgid == 0 {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = ttyFd
+	}
+}
+
+// waitForeground waits for the started processes of a foreground job and
+// returns its status; stages without a process already have theirs in
+// errs. A job stopped with Ctrl+Z stays in the job table and the shell
+// gets the terminal back with status 128+SIGTSTP.
+func (e *Executor) waitForeground(cmds []*exec.Cmd, errs []error, commands []*cli.Command) error {
+	stopped := make(chan struct{}, 1)
+	done := make(chan struct{})
+
+	// remaining counts the processes still running; jobID is set once the
+	// job is stopped and enters the job table
+	var mutex sync.Mutex
+	remaining, jobID := 0, 0
+	running := make([]bool, len(cmds))
+	pid := 0
+	for i, cmd := range cmds {
+		if cmd != nil && cmd.Process != nil {
+			if pid == 0 {
+				pid = cmd.Process.Pid
+			}
+			remaining++
+			running[i] = true
+		}
+	}
+	if remaining == 0 {
+		e.session.SetPipeStatus(stageStatuses(errs, nil))
+		return e.pipelineStatus(errs)
+	}
+
+	for i, cmd := range cmds {
+		if cmd == nil || cmd.Process == nil {
+			continue
+		}
+		i := i
+		e.children.follow(cmd, func(state shell.JobState) {
+			// Without job control the shell cannot take the terminal
+			// back from a stopped job, so it keeps waiting
+			if state == shell.JobStopped && e.jobControl {
+				select {
+				case stopped <- struct{}{}:
+				default:
+				}
+			}
+		}, func(sig syscall.Signal) {
+			// Throttled output of a command stopped with Ctrl+C is not
+			// worth waiting for
+			if sig == syscall.SIGINT && e.throttle != nil {
+				e.throttle.Discard(true)
+			}
+		}, func(err error) {
+			mutex.Lock()
+			errs[i] = err
+			running[i] = false
+			remaining--
+			finished, id := remaining == 0, jobID
+			mutex.Unlock()
+			if finished {
+				close(done)
+				if id != 0 {
+					e.session.FinishJob(id, ExitCode(e.pipelineStatus(errs)))
+				}
+			}
+		})
+	}
+
+	select {
+	case <-done:
+		if e.jobControl {
+			e.setForeground(e.shellPgid)
+		}
+		e.session.SetPipeStatus(stageStatuses(errs, nil))
+		return e.pipelineStatus(errs)
+
+	case <-stopped:
+		e.setForeground(e.shellPgid)
+
+		job := e.session.AddJob(pid, jobCommandLine(commands), "")
+		e.session.SetJobState(job.ID, shell.JobStopped)
+		for _, cmd := range cmds {
+			if cmd != nil && cmd.Process != nil {
+				e.children.hand(cmd.Process.Pid, func(state shell.JobState) {
+					e.session.SetJobState(job.ID, state)
+				})
+			}
+		}
+
+		mutex.Lock()
+		jobID = job.ID
+		finished := remaining == 0
+		e.session.SetPipeStatus(stageStatuses(errs, running))
+		mutex.Unlock()
+		if finished {
+			// It ended before it reached the job table
+			e.session.FinishJob(job.ID, ExitCode(e.pipelineStatus(errs)))
+		}
+
+		if stopped, exists := e.session.GetJob(job.ID); exists {
+			fmt.Fprintln(e.streams.Stderr)
+			fmt.Fprintln(e.streams.Stderr, builtin.FormatJob(stopped, e.session.GetJobs(), false))
+		}
+		return builtin.ExitStatus(128 + int(syscall.SIGTSTP))
+	}
+}
+
+// NotifyJobs reports background jobs that finished since the last call
+func (e *Executor) NotifyJobs() {
+	jobs := e.session.GetJobs()
+	for _, job := range jobs {
+		if job.State == shell.JobDone && !job.Notified {
+			fmt.Fprintln(e.streams.Stderr, builtin.FormatJob(job, jobs, false))
+			e.session.MarkJobNotified(job.ID)
+		}
+	}
+}
+
+// jobCommandLine formats the stages of a job for the job table
+func jobCommandLine(commands []*cli.Command) string {
+	stages := make([]string, len(commands))
+	for i, command := range commands {
+		stages[i] = strings.Join(append([]string{command.Name}, command.Args...), " ")
+	}
+	return strings.Join(stages, " | ")
+}
+
+// executeNohup runs an external command that ignores SIGHUP, so it
+// survives the terminal closing. Output meant for the terminal goes to
+// nohup.out and input from the terminal is replaced by /dev/null.
+func (e *Executor) executeNohup(cmd *cli.Command) error {
+	if len(cmd.Args) == 0 {
+		return fmt.Errorf("nohup: missing command")
+	}
+
+	inner := *cmd
+	inner.Name = cmd.Args[0]
+	inner.Args = cmd.Args[1:]
+	execCmd, err := e.externalCommand(&inner)
+	if err != nil {
+		return err
+	}
+
+	if !redirects(inner.Redirects, 0) && builtin.IsTerminal(e.streams.Stdin) {
+		devNull, err := os.Open(os.DevNull)
+		if err != nil {
+			return fmt.Errorf("nohup: %v", err)
+		}
+		defer devNull.Close()
+		execCmd.Stdin = devNull
+	}
+
+	stdoutTerminal := !redirects(inner.Redirects, 1) && builtin.IsTerminal(e.streams.Stdout)
+	stderrTerminal := !redirects(inner.Redirects, 2) && builtin.IsTerminal(e.streams.Stderr)
+	if stdoutTerminal || stderrTerminal {
+		output, err := builtin.OpenNohupOutput(e.streams.Stderr)
+		if err != nil {
+			return err
+		}
+		defer output.Close()
+		if stdoutTerminal {
+			execCmd.Stdout = output
+		}
+		if stderrTerminal {
+			execCmd.Stderr = output
+		}
+	}
+
+	// Ignored signals stay ignored across exec, so the shell ignores
+	// SIGHUP itself while the command starts and runs in the foreground
+	signal.Ignore(syscall.SIGHUP)
+	defer e.restoreHangup()
+
+	if cmd.Background {
+		return e.executeBackground(execCmd, &inner)
+	}
+	return e.executeForeground(execCmd, &inner)
+}
+
+// HangupJobs sends SIGHUP to the jobs in the job table, as the terminal
+// they ran from is going away, except those marked with disown -h.
+// Stopped jobs are continued so they act on it.
+func (e *Executor) HangupJobs() {
+	for _, job := range e.session.GetJobs() {
+		if job.State == shell.JobDone || job.NoHangup {
+			continue
+		}
+		if syscall.Kill(-job.PID, syscall.SIGHUP) != nil {
+			syscall.Kill(job.PID, syscall.SIGHUP)
+		}
+		if job.State == shell.JobStopped {
+			if syscall.Kill(-job.PID, syscall.SIGCONT) != nil {
+				syscall.Kill(job.PID, syscall.SIGCONT)
+			}
+		}
+	}
+}
+
+// HungUp reports whether the shell's terminal has hung up, which makes an
+// end of input at the prompt the terminal closing rather than Ctrl+D
+func (e *Executor) HungUp() bool {
+	_, err := tcgetpgrp(ttyFd)
+	return err == syscall.EIO
+}
+
+// restoreHangup gives SIGHUP back its trap or default action
+func (e *Executor) restoreHangup() {
+	signal.Reset(syscall.SIGHUP)
+	e.syncTrapSignals()
+}
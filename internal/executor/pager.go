@@ -0,0 +1,186 @@
+package executor
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gex/internal/builtin"
+	"gex/internal/cli"
+	"gex/internal/readline"
+)
+
+// pagerDelay is how long output may take to fill the screen and still be
+// paged; slower output, such as that of ping, is shown as it comes
+const pagerDelay = 200 * time.Millisecond
+
+// unpagedBuiltins take over the terminal themselves
+var unpagedBuiltins = map[string]bool{"edit": true, "imgcat": true, "more": true}
+
+// pagedOutput holds back what a builtin writes to the terminal until it
+// is more than fits on the screen, and then passes it through the built-in
+// pager. Output that stays shorter, or is slower to come, is written as is.
+type pagedOutput struct {
+	mutex     sync.Mutex
+	out       io.Writer
+	threshold int
+	width     int
+	held      []byte
+	timer     *time.Timer
+	pager     *builtin.Pager
+	direct    bool
+}
+
+// autoPager returns the output a builtin at the prompt writes to when
+// auto_pager is on, or nil when it is to write to the terminal directly:
+// in pipelines, with its output redirected, for builtins that run other
+// commands or take over the screen, and for those in pager_exclude
+func (e *Executor) autoPager(cmd *cli.Command) *pagedOutput {
+	cfg := e.session.Config()
+	if !cfg.AutoPager || !e.interactive || e.stage || e.functionDepth > 0 || cmd.Background {
+		return nil
+	}
+	if unpagedBuiltins[cmd.Name] || cli.RunsCommand(cmd.Name) {
+		return nil
+	}
+	for _, name := range cfg.PagerExclude {
+		if name == cmd.Name {
+			return nil
+		}
+	}
+	if _, paged := e.streams.Stdout.(*pagedOutput); paged || !builtin.IsTerminal(e.streams.Stdout) {
+		return nil
+	}
+
+	// Output that fits on the screen is never worth paging
+	width, height := readline.TerminalSize()
+	threshold := max(cfg.PagerThreshold, height-1)
+	return &pagedOutput{out: e.streams.Stdout, threshold: threshold, width: width}
+}
+
+// executePaged runs a builtin with its output going through the pager.
+// Quitting the pager stops the builtin at its next write, as a broken pipe
+// would, but the command line goes on as after cmd | more.
+func (e *Executor) executePaged(cmd *cli.Command, output *pagedOutput) (err error) {
+	saved := e.streams
+	streams := *e.streams
+	streams.Stdout = output
+	e.streams = &streams
+	defer func() {
+		e.streams = saved
+		if recovered := recover(); recovered != nil {
+			if recovered != errBrokenPipe {
+				panic(recovered)
+			}
+			err = nil
+		}
+		output.close(saved.Interrupted())
+	}()
+	return e.executeBuiltin(cmd)
+}
+
+// Write holds p back until the output is too long for the screen, then
+// starts the pager on it
+func (o *pagedOutput) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	switch {
+	case o.direct:
+		return o.out.Write(p)
+	case o.pager != nil:
+		return o.page(p)
+	}
+
+	if o.timer == nil {
+		o.timer = time.AfterFunc(pagerDelay, o.release)
+	}
+	o.held = append(o.held, p...)
+	if builtin.ScreenRows(o.held, o.width) <= o.threshold {
+		return len(p), nil
+	}
+
+	o.timer.Stop()
+	pager, err := builtin.NewPager(o.out)
+	if err != nil {
+		o.flush()
+		return len(p), nil
+	}
+	o.pager = pager
+	held := o.held
+	o.held = nil
+	if _, err := o.page(held); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// page writes to the pager, stopping the builtin once the user quit it
+func (o *pagedOutput) page(p []byte) (int, error) {
+	n, err := o.pager.Write(p)
+	if errors.Is(err, builtin.ErrPagerQuit) {
+		panic(errBrokenPipe)
+	}
+	return n, err
+}
+
+// release writes out the output held back once it took too long to fill
+// the screen
+func (o *pagedOutput) release() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.pager == nil {
+		o.flush()
+	}
+}
+
+// flush writes out the output held back and lets the rest through; the
+// caller holds the mutex
+func (o *pagedOutput) flush() {
+	if len(o.held) > 0 {
+		o.out.Write(o.held)
+		o.held = nil
+	}
+	o.direct = true
+}
+
+// passThrough ends paging for an external command the builtin runs, which
+// gets the terminal itself, and returns the stream it is to write to
+func (o *pagedOutput) passThrough() io.Writer {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	if o.pager != nil {
+		o.pager.Close()
+		o.pager = nil
+	}
+	o.flush()
+	return processOutput(o.out)
+}
+
+// close shows what is left once the builtin is done, or after Ctrl+C
+// just lets go of the terminal
+func (o *pagedOutput) close(interrupted bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	switch {
+	case o.pager != nil && interrupted:
+		o.pager.Abort()
+	case o.pager != nil:
+		o.pager.Close()
+	}
+	o.pager = nil
+	o.flush()
+}
+
+// File returns the terminal, so builtins still color their output
+func (o *pagedOutput) File() *os.File {
+	return streamFile(o.out)
+}
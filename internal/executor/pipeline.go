@@ -0,0 +1,299 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"gex/internal/builtin"
+	"gex/internal/cli"
+)
+
+// executePipeline executes a pipeline of commands. External stages run as
+// processes of one process group while builtins and functions run in the
+// shell, so any mix of them can be piped together.
+func (e *Executor) executePipeline(cmd *cli.Command) error {
+	commands := []*cli.Command{cmd}
+	commands = append(commands, cmd.Pipes...)
+
+	// Expand aliases and words of every stage. NAME=value words before a
+	// stage's command are for that stage alone.
+	envs := make([]map[string]string, len(commands))
+	for i, command := range commands {
+		if assignments, rest := splitAssignments(command); len(assignments) > 0 && rest != nil {
+			env, err := e.commandEnv(assignments)
+			if err != nil {
+				return err
+			}
+			envs[i], command = env, rest
+		}
+
+		expanded, err := e.prepareCommand(command)
+		if err != nil {
+			return err
+		}
+		if expanded == nil {
+			return fmt.Errorf("no command left in pipeline stage %d after nullglob", i+1)
+		}
+		commands[i] = expanded
+		e.trace(expanded)
+	}
+
+	// Create pipes between commands
+	var pipes []*os.File
+	var readers []*os.File
+
+	for i := 0; i < len(commands)-1; i++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return err
+		}
+		pipes = append(pipes, w)
+		readers = append(readers, r)
+	}
+
+	// Defer closing all pipes
+	defer func() {
+		for _, p := range pipes {
+			p.Close()
+		}
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+
+	stdin := func(i int) io.Reader {
+		if i == 0 {
+			return e.streams.Stdin
+		}
+		return readers[i-1]
+	}
+	stdout := func(i int) io.Writer {
+		if i == len(commands)-1 {
+			return e.streams.Stdout
+		}
+		return pipes[i]
+	}
+
+	stageEnv := func(i int) map[string]string {
+		if envs[i] != nil {
+			return envs[i]
+		}
+		return e.streams.Env
+	}
+
+	// Start the external stages first, in one process group led by the
+	// first of them. The group takes the terminal straight away when it
+	// reads from it, or else once the first stage in the shell is done.
+	cmds := make([]*exec.Cmd, len(commands))
+	errs := make([]error, len(commands))
+	foreground := !e.runsInShell(commands[0])
+	pgid := 0
+	for i, command := range commands {
+		if e.runsInShell(command) {
+			continue
+		}
+
+		var execCmd *exec.Cmd
+		err := e.withEnv(stageEnv(i), func() (err error) {
+			execCmd, err = e.externalCommand(command)
+			return err
+		})
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if execCmd.Stdin == nil {
+			execCmd.Stdin = stdin(i)
+		}
+		if execCmd.Stdout == nil {
+			execCmd.Stdout = stdout(i)
+		}
+		if execCmd.Stderr == nil {
+			execCmd.Stderr = e.streams.Stderr
+		}
+		files, err := e.redirectCommand(execCmd, command.Redirects)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		e.setProcessGroup(execCmd, pgid, foreground)
+		err = execCmd.Start()
+		closeFiles(files)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		cmds[i] = execCmd
+		if pgid == 0 {
+			pgid = execCmd.Process.Pid
+		}
+	}
+
+	if pgid != 0 && e.jobControl {
+		defer e.trackJob(pgid)()
+	}
+
+	// The children hold their own copies of the pipe ends; close ours so
+	// readers see end of input once the writer before them exits
+	for i, command := range commands {
+		if e.runsInShell(command) {
+			continue
+		}
+		if i > 0 {
+			readers[i-1].Close()
+		}
+		if i < len(commands)-1 {
+			pipes[i].Close()
+		}
+	}
+
+	// Run the builtins and functions at the same time as the processes,
+	// each with its own streams
+	var wg sync.WaitGroup
+	for i, command := range commands {
+		if !e.runsInShell(command) {
+			continue
+		}
+
+		output := stdout(i)
+		if i < len(commands)-1 {
+			output = &stageOutput{pipe: pipes[i]}
+		}
+		stage := e.forStage(&builtin.Context{
+			Stdin:     stdin(i),
+			Stdout:    output,
+			Stderr:    e.streams.Stderr,
+			Interrupt: e.streams.Interrupt,
+			Env:       stageEnv(i),
+		})
+		wg.Add(1)
+		go func(i int, command *cli.Command) {
+			defer wg.Done()
+			errs[i] = stage.dispatchStage(command)
+
+			// Close the ends this stage used so the stage before it gets a
+			// broken pipe and the one after it sees end of input
+			if i > 0 {
+				readers[i-1].Close()
+			}
+			if i < len(commands)-1 {
+				pipes[i].Close()
+			}
+
+			// Ctrl+C and Ctrl+Z now belong to the processes left running
+			if i == 0 && pgid != 0 && e.jobControl {
+				e.setForeground(pgid)
+			}
+		}(i, command)
+	}
+	wg.Wait()
+
+	if pgid == 0 {
+		e.session.SetPipeStatus(stageStatuses(errs, nil))
+		return e.pipelineStatus(errs)
+	}
+	return e.waitForeground(cmds, errs, commands)
+}
+
+// errBrokenPipe ends a builtin or function stage that writes to a pipe
+// nobody reads any more, as SIGPIPE ends a process
+var errBrokenPipe = errors.New("broken pipe")
+
+// stageOutput is the pipe a builtin or function stage writes to. Builtins
+// do not check every write for errors, so once the next stage has exited
+// a write panics with errBrokenPipe, which dispatchStage turns into the
+// status of a process killed by SIGPIPE.
+type stageOutput struct {
+	pipe *os.File
+}
+
+func (o *stageOutput) Write(p []byte) (int, error) {
+	n, err := o.pipe.Write(p)
+	if errors.Is(err, syscall.EPIPE) {
+		panic(errBrokenPipe)
+	}
+	return n, err
+}
+
+// File returns the pipe, for redirections and IsTerminal
+func (o *stageOutput) File() *os.File {
+	return o.pipe
+}
+
+// processOutput returns the writer an external command gets for a stream:
+// the pipe itself for a stage's output, so the process gets SIGPIPE of
+// its own and no copying goroutine is needed, and the terminal rather than
+// the pager
+func processOutput(stream io.Writer) io.Writer {
+	switch output := stream.(type) {
+	case *stageOutput:
+		return output.pipe
+	case *pagedOutput:
+		return output.passThrough()
+	}
+	return stream
+}
+
+// dispatchStage runs a builtin or function stage of a pipeline, stopping
+// it with status 128+SIGPIPE when it writes to a broken pipe
+func (e *Executor) dispatchStage(cmd *cli.Command) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			if recovered != errBrokenPipe {
+				panic(recovered)
+			}
+			err = builtin.ExitStatus(128 + int(syscall.SIGPIPE))
+		}
+	}()
+	return e.dispatch(cmd)
+}
+
+// stageStatuses returns the exit status of each stage of a pipeline.
+// Stages still running, as in a job stopped with Ctrl+Z, count as stopped.
+func stageStatuses(errs []error, running []bool) []int {
+	statuses := make([]int, len(errs))
+	for i, err := range errs {
+		statuses[i] = ExitCode(err)
+		if running != nil && running[i] {
+			statuses[i] = 128 + int(syscall.SIGTSTP)
+		}
+	}
+	return statuses
+}
+
+// pipelineStatus returns the result of a pipeline from those of its stages:
+// the last stage's, or the rightmost failure with set -o pipefail. Other
+// failures that carry a message are reported.
+func (e *Executor) pipelineStatus(errs []error) error {
+	result := len(errs) - 1
+	if e.session.Option("pipefail") {
+		for i := len(errs) - 1; i >= 0; i-- {
+			if errs[i] != nil {
+				result = i
+				break
+			}
+		}
+	}
+
+	for i, err := range errs {
+		if i != result && ShouldReport(err) {
+			reportError(e.streams.Stderr, err)
+		}
+	}
+	return errs[result]
+}
+
+// runsInShell reports whether a pipeline stage is a function or builtin
+// run by the shell itself rather than an external process
+func (e *Executor) runsInShell(cmd *cli.Command) bool {
+	if _, exists := e.session.GetFunction(cmd.Name); exists {
+		return true
+	}
+	return e.session.IsBuiltin(cmd.Name)
+}
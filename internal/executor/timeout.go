@@ -0,0 +1,153 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gex/internal/builtin"
+	"gex/internal/cli"
+)
+
+// defaultKillAfter is how long a command gets to exit after the timeout
+// signal before it is sent SIGKILL
+const defaultKillAfter = 5 * time.Second
+
+// executeTimeout runs a command with a deadline. When it passes, external
+// commands get SIGTERM or the signal given with -s, then SIGKILL if they
+// are still running after the grace period, and builtins and functions
+// stop as on Ctrl+C. The status is then 124.
+func (e *Executor) executeTimeout(cmd *cli.Command) error {
+	sig := syscall.SIGTERM
+	killAfter := defaultKillAfter
+
+	args := cmd.Args
+	for len(args) > 0 && len(args[0]) > 1 && strings.HasPrefix(args[0], "-") {
+		option := args[0]
+		if option != "-s" && option != "--signal" && option != "-k" && option != "--kill-after" {
+			return fmt.Errorf("timeout: invalid option: %s", option)
+		}
+		if len(args) < 2 {
+			return fmt.Errorf("timeout: %s requires an argument", option)
+		}
+		if option == "-s" || option == "--signal" {
+			parsed, ok := builtin.ParseSignal(args[1])
+			if !ok {
+				return fmt.Errorf("timeout: invalid signal: %s", args[1])
+			}
+			sig = parsed
+		} else {
+			duration, err := parseTimeout(args[1])
+			if err != nil {
+				return err
+			}
+			killAfter = duration
+		}
+		args = args[2:]
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("timeout: usage: timeout [-s signal] [-k duration] duration command [args...]")
+	}
+	duration, err := parseTimeout(args[0])
+	if err != nil {
+		return err
+	}
+
+	inner := *cmd
+	inner.Name = args[1]
+	inner.Args = args[2:]
+
+	// A zero duration runs the command without a deadline
+	if duration == 0 {
+		return e.dispatch(&inner)
+	}
+
+	parent := e.deadline
+	if parent == nil {
+		parent = context.Background()
+	}
+	deadline, cancel := context.WithTimeout(parent, duration)
+
+	savedDeadline, savedSignal, savedKillAfter := e.deadline, e.timeoutSignal, e.killAfter
+	e.deadline, e.timeoutSignal, e.killAfter = deadline, sig, killAfter
+	defer func() {
+		e.deadline, e.timeoutSignal, e.killAfter = savedDeadline, savedSignal, savedKillAfter
+	}()
+
+	if cmd.Background {
+		// The job keeps its deadline after timeout returns; the context is
+		// released when the deadline passes
+		time.AfterFunc(duration, cancel)
+		return e.dispatch(&inner)
+	}
+	defer cancel()
+
+	// Builtins and functions see the deadline as an interrupt
+	saved := e.streams
+	streams := *saved
+	interrupt := make(chan struct{})
+	streams.Interrupt = interrupt
+	e.streams = &streams
+	defer func() { e.streams = saved }()
+	go func() {
+		select {
+		case <-deadline.Done():
+		case <-saved.Interrupt:
+		}
+		close(interrupt)
+	}()
+
+	err = e.dispatch(&inner)
+	if errors.Is(deadline.Err(), context.DeadlineExceeded) {
+		return builtin.ExitStatus(124)
+	}
+	return err
+}
+
+// commandWithDeadline creates an external command that is sent the timeout
+// signal when the deadline passes, and SIGKILL after the grace period
+func (e *Executor) commandWithDeadline(path string, args []string) *exec.Cmd {
+	cmd := exec.CommandContext(e.deadline, path, args...)
+	sig := e.timeoutSignal
+	cmd.Cancel = func() error {
+		// A command leading its own process group takes its children along
+		pid := cmd.Process.Pid
+		if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid {
+			return syscall.Kill(-pid, sig)
+		}
+		return cmd.Process.Signal(sig)
+	}
+	cmd.WaitDelay = e.killAfter
+	return cmd
+}
+
+// parseTimeout parses a duration given as a number of seconds with an
+// optional s, m, h or d suffix
+func parseTimeout(value string) (time.Duration, error) {
+	unit := time.Second
+	number := value
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 's':
+			number = value[:n-1]
+		case 'm':
+			unit, number = time.Minute, value[:n-1]
+		case 'h':
+			unit, number = time.Hour, value[:n-1]
+		case 'd':
+			unit, number = 24*time.Hour, value[:n-1]
+		}
+	}
+
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil || !(amount >= 0) || math.IsInf(amount, 1) {
+		return 0, fmt.Errorf("timeout: invalid duration: %s", value)
+	}
+	return time.Duration(amount * float64(unit)), nil
+}
@@ -0,0 +1,289 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"gex/internal/builtin"
+	"gex/internal/cli"
+)
+
+// defaultSignals are always caught; without a trap they end the shell
+var defaultSignals = []syscall.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// HandleSignals starts dispatching signals to the commands registered with
+// trap. Trap commands run between commands, never in the middle of one.
+func (e *Executor) HandleSignals() {
+	e.signals = make(chan os.Signal, 8)
+	e.caught = make(map[syscall.Signal]bool)
+	e.ignored = make(map[syscall.Signal]bool)
+	for _, sig := range defaultSignals {
+		e.ignored[sig] = signal.Ignored(sig)
+	}
+	e.syncTrapSignals()
+
+	// The shell's own streams; e.streams changes while commands run
+	shellStreams := e.streams
+	go func() {
+		for received := range e.signals {
+			sig := received.(syscall.Signal)
+			command, trapped := e.session.GetTrap(builtin.SignalName(sig))
+			if !trapped && sig == syscall.SIGINT && e.isInteractive() {
+				e.interruptCommandLine()
+				continue
+			}
+			if !trapped && sig == syscall.SIGHUP {
+				// The terminal is gone, so there is no one to tell
+				if e.isInteractive() {
+					e.HangupJobs()
+				}
+				os.Exit(e.RunExitTrap(128 + int(sig)))
+			}
+			if !trapped {
+				fmt.Fprintln(shellStreams.Stderr, "\nInterrupt received, exiting...")
+				status := e.RunExitTrap(128 + int(sig))
+				e.ReleaseTerminal()
+				os.Exit(status)
+			}
+			if command != "" {
+				e.trapMutex.Lock()
+				e.pendingTraps = append(e.pendingTraps, command)
+				e.trapMutex.Unlock()
+			}
+		}
+	}()
+}
+
+// SetInteractive makes Ctrl+C interrupt the running command line instead
+// of exiting the shell. redraw is called for a SIGINT that arrives while
+// no command runs, to start a fresh prompt.
+func (e *Executor) SetInteractive(redraw func()) {
+	e.commandMutex.Lock()
+	defer e.commandMutex.Unlock()
+	e.interactive = true
+	e.redraw = redraw
+}
+
+func (e *Executor) isInteractive() bool {
+	e.commandMutex.Lock()
+	defer e.commandMutex.Unlock()
+	return e.interactive
+}
+
+// beginCommandLine marks an interactive shell busy with a command line and
+// gives its builtins a new interrupt channel. The returned function marks
+// the shell idle again.
+func (e *Executor) beginCommandLine() func() {
+	interrupt := make(chan struct{})
+	e.commandMutex.Lock()
+	e.busy = true
+	e.interrupt = interrupt
+	e.commandMutex.Unlock()
+	e.streams.Interrupt = interrupt
+	if e.throttle != nil {
+		e.throttle.Discard(false)
+	}
+
+	return func() {
+		e.commandMutex.Lock()
+		e.busy = false
+		e.interrupt = nil
+		e.commandMutex.Unlock()
+		e.streams.Interrupt = nil
+	}
+}
+
+// trackJob records the process group of the running pipeline until the
+// returned function is called, so Ctrl+C can be passed on to it
+func (e *Executor) trackJob(pgid int) func() {
+	e.commandMutex.Lock()
+	e.jobPgid = pgid
+	e.commandMutex.Unlock()
+
+	return func() {
+		e.commandMutex.Lock()
+		e.jobPgid = 0
+		e.commandMutex.Unlock()
+	}
+}
+
+// interruptCommandLine handles Ctrl+C in an interactive shell. Builtins
+// watching the interrupt channel stop and the rest of the command line is
+// skipped; with no command running the prompt starts over.
+func (e *Executor) interruptCommandLine() {
+	e.commandMutex.Lock()
+	defer e.commandMutex.Unlock()
+
+	if !e.busy {
+		if e.redraw != nil {
+			e.redraw()
+		}
+		return
+	}
+	if e.interrupt != nil {
+		close(e.interrupt)
+		e.interrupt = nil
+	}
+
+	// Throttled output still on its way is not worth waiting for
+	if e.throttle != nil {
+		e.throttle.Discard(true)
+	}
+
+	e.signalJob(syscall.SIGINT)
+}
+
+// signalJob passes a signal from the terminal on to the running pipeline.
+// The terminal sends Ctrl+C and Ctrl+Z to its foreground process group
+// only, and a pipeline whose first stage runs in the shell does not own the
+// terminal yet. The caller holds commandMutex.
+func (e *Executor) signalJob(sig syscall.Signal) {
+	if e.jobPgid != 0 {
+		if foreground, err := tcgetpgrp(ttyFd); err != nil || foreground != e.jobPgid {
+			syscall.Kill(-e.jobPgid, sig)
+		}
+	}
+}
+
+// syncTrapSignals catches the signals that have traps, ignores those
+// trapped with an empty command (children inherit that) and restores the
+// default action of the rest
+func (e *Executor) syncTrapSignals() {
+	if e.signals == nil {
+		return
+	}
+
+	traps := e.session.GetTraps()
+	for condition := range traps {
+		if sig, ok := builtin.ParseSignal(condition); ok {
+			e.caught[sig] = true
+		}
+	}
+	for _, sig := range defaultSignals {
+		e.caught[sig] = true
+	}
+
+	for sig := range e.caught {
+		command, trapped := traps[builtin.SignalName(sig)]
+		switch {
+		case trapped && command == "":
+			signal.Ignore(sig)
+		case trapped || isDefaultSignal(sig) && !e.ignored[sig]:
+			signal.Notify(e.signals, sig)
+		default:
+			// Reset puts back an action inherited as ignored
+			signal.Reset(sig)
+			delete(e.caught, sig)
+		}
+	}
+}
+
+func isDefaultSignal(sig syscall.Signal) bool {
+	for _, s := range defaultSignals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitSelfSignal gives a signal the shell sent to itself with kill time
+// to arrive, so its trap runs before the next command like in other shells
+func (e *Executor) awaitSelfSignal(args []string) {
+	self := strconv.Itoa(os.Getpid())
+	targeted := false
+	for _, arg := range args {
+		if arg == self {
+			targeted = true
+		}
+	}
+	if !targeted || e.signals == nil {
+		return
+	}
+
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		e.trapMutex.Lock()
+		arrived := len(e.pendingTraps) > 0
+		e.trapMutex.Unlock()
+		if arrived {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// RunPendingTraps runs the trap commands of signals received since the
+// last call. It returns an exit request if a trap command exits the shell.
+func (e *Executor) RunPendingTraps() error {
+	if e.inTrap {
+		return nil
+	}
+
+	e.trapMutex.Lock()
+	pending := e.pendingTraps
+	e.pendingTraps = nil
+	e.trapMutex.Unlock()
+
+	for _, command := range pending {
+		if err := e.runTrap(command); isExitError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunExitTrap runs the EXIT trap once and returns the status the shell
+// should exit with: status, unless the trap exits with its own
+func (e *Executor) RunExitTrap(status int) int {
+	// Signals that arrived during the last command still get handled
+	if err := e.RunPendingTraps(); isExitError(err) {
+		if code := ExitCode(err); code != 0 {
+			status = code
+		}
+	}
+
+	command, exists := e.session.GetTrap("EXIT")
+	if !exists || command == "" {
+		return status
+	}
+	e.session.RemoveTrap("EXIT")
+
+	if err := e.runTrap(command); isExitError(err) {
+		if code := ExitCode(err); code != 0 {
+			return code
+		}
+	}
+	return status
+}
+
+// runTrap parses and runs a trap command, reporting its errors
+func (e *Executor) runTrap(command string) error {
+	parsed, err := e.Parse(command)
+	if errors.Is(err, cli.ErrEmptyCommand) {
+		return nil
+	}
+	if err != nil {
+		reportError(e.streams.Stderr, fmt.Errorf("trap: %v", err))
+		return nil
+	}
+
+	// A trap leaves $? as it found it
+	status := e.session.LastStatus()
+	e.inTrap = true
+	defer func() {
+		e.inTrap = false
+		e.session.SetLastStatus(status)
+	}()
+
+	err = e.Execute(parsed)
+	if ShouldReport(err) {
+		reportError(e.streams.Stderr, err)
+	}
+	return err
+}
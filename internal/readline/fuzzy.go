@@ -0,0 +1,116 @@
+package readline
+
+import (
+	"sort"
+	"strings"
+)
+
+// completionMatcher collects the candidates that match the typed text:
+// those starting with it and, with fuzzy_completion on, those holding its
+// characters in order, as docker holds dckr. Candidates starting with the
+// text come first.
+type completionMatcher struct {
+	r        *Readline
+	typed    string
+	seen     map[string]bool
+	prefixed []string
+	fuzzy    []scoredCompletion
+
+	// extensions limits the files completed to those ending in one of
+	// them, when there are any
+	extensions []string
+}
+
+type scoredCompletion struct {
+	text  string
+	score int
+}
+
+func (r *Readline) newMatcher(typed string) *completionMatcher {
+	return &completionMatcher{r: r, typed: typed, seen: make(map[string]bool)}
+}
+
+// match reports whether name matches the typed text, with a score of -1
+// when it starts with it and otherwise how many characters lie between
+// the typed ones
+func (m *completionMatcher) match(name string) (int, bool) {
+	if m.r.hasPrefix(name, m.typed) {
+		return -1, true
+	}
+	if !m.r.session.Config().FuzzyCompletion {
+		return 0, false
+	}
+	return fuzzyScore(name, m.typed, m.r.ignoreCase())
+}
+
+// wantsFile reports whether a file name has one of the extensions asked
+// for
+func (m *completionMatcher) wantsFile(name string) bool {
+	if len(m.extensions) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, extension := range m.extensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// add records a completion for a name match scored; one already added
+// is skipped
+func (m *completionMatcher) add(completion string, score int) {
+	if m.seen[completion] {
+		return
+	}
+	m.seen[completion] = true
+	if score < 0 {
+		m.prefixed = append(m.prefixed, completion)
+	} else {
+		m.fuzzy = append(m.fuzzy, scoredCompletion{completion, score})
+	}
+}
+
+// results returns the completions starting with the typed text in order,
+// then the fuzzy ones, closest and then shortest first
+func (m *completionMatcher) results() []string {
+	sort.Strings(m.prefixed)
+	sort.Slice(m.fuzzy, func(i, j int) bool {
+		a, b := m.fuzzy[i], m.fuzzy[j]
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		if len(a.text) != len(b.text) {
+			return len(a.text) < len(b.text)
+		}
+		return a.text < b.text
+	})
+
+	results := m.prefixed
+	for _, completion := range m.fuzzy {
+		results = append(results, completion.text)
+	}
+	return results
+}
+
+// fuzzyScore reports whether the characters of typed appear in name in
+// order and counts the characters skipped to find them
+func fuzzyScore(name, typed string, ignoreCase bool) (int, bool) {
+	if ignoreCase {
+		name, typed = strings.ToLower(name), strings.ToLower(typed)
+	}
+	candidate := []rune(name)
+	score, pos := 0, 0
+	for _, char := range typed {
+		for pos < len(candidate) && candidate[pos] != char {
+			pos++
+			score++
+		}
+		if pos == len(candidate) {
+			return 0, false
+		}
+		pos++
+	}
+	return score, true
+}
@@ -0,0 +1,205 @@
+package readline
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"gex/internal/cli"
+)
+
+// Styles of the parts of the line being edited. These are the colors of
+// package ui, which cannot be imported here as it draws on this package.
+const (
+	styleCommand = "\033[32m"
+	styleUnknown = "\033[31m"
+	styleString  = "\033[33m"
+	styleOption  = "\033[36m"
+	styleReset   = "\033[0m"
+)
+
+// cell is a character of the line as drawn, with the style it is drawn in
+type cell struct {
+	char  rune
+	style string
+}
+
+// cells returns the line as it is to be drawn, highlighted when color
+// output is on
+func (r *Readline) cells() []cell {
+	cells := make([]cell, len(r.line))
+	for i, char := range r.line {
+		cells[i].char = char
+	}
+	if term := os.Getenv("TERM"); r.session.Config().ColorOutput && term != "" && term != "dumb" {
+		r.highlight(cells)
+	}
+	return cells
+}
+
+// highlight styles the words of a line: command names green when they
+// name an alias, function, builtin or executable and red otherwise,
+// quoted strings yellow and options cyan. The line is often incomplete,
+// so this only follows quotes, operators and redirections rather than
+// parsing it. The bodies of here-documents are left as they are.
+func (r *Readline) highlight(cells []cell) {
+	commandPosition := true
+	redirectTarget := false
+	hereDocument := false
+	var delimiters []string
+
+	for i := 0; i < len(cells); {
+		char := cells[i].char
+		switch {
+		case char == ' ' || char == '\t':
+			i++
+			continue
+		case char == '#':
+			// A comment runs to the end of the row
+			for i < len(cells) && cells[i].char != '\n' {
+				i++
+			}
+			continue
+		case char == '\n' && len(delimiters) > 0:
+			i = skipHereDocuments(cells, i+1, delimiters)
+			delimiters = delimiters[:0]
+			commandPosition = true
+			redirectTarget = false
+			continue
+		case strings.ContainsRune("|&;()\n", char):
+			commandPosition = true
+			redirectTarget = false
+			i++
+			continue
+		case char == '<' || char == '>':
+			start := i
+			for i < len(cells) && strings.ContainsRune("<>&-", cells[i].char) {
+				i++
+			}
+			redirectTarget = true
+			operator := string(runesOf(cells[start:i]))
+			hereDocument = operator == "<<" || operator == "<<-"
+			continue
+		}
+
+		start := i
+		i = r.highlightWord(cells, i)
+		word := string(runesOf(cells[start:i]))
+
+		switch {
+		case redirectTarget:
+			redirectTarget = false
+			if hereDocument {
+				delimiters = append(delimiters, strings.Trim(word, "'\""))
+			}
+		case commandPosition && (cli.IsAssignment(word) || word == "{" || word == "}" || word == "!"):
+			// A command follows assignments and keywords
+		case commandPosition:
+			commandPosition = false
+			if strings.ContainsAny(word, "'\"\\$`") {
+				break
+			}
+			style := styleUnknown
+			if r.knownCommand(word) {
+				style = styleCommand
+			}
+			styleUnquoted(cells[start:i], style)
+		case strings.HasPrefix(word, "-"):
+			styleUnquoted(cells[start:i], styleOption)
+		}
+	}
+}
+
+// highlightWord styles the quoted strings of the word that starts at
+// start and returns where the word ends
+func (r *Readline) highlightWord(cells []cell, start int) int {
+	i := start
+	for i < len(cells) {
+		char := cells[i].char
+		switch {
+		case char == ' ' || char == '\t' || strings.ContainsRune("|&;()<>\n", char):
+			return i
+		case char == '\\':
+			i += 2
+		case char == '\'' || char == '"':
+			// An unterminated string runs to the end of the line
+			end := i + 1
+			for end < len(cells) && cells[end].char != char {
+				if char == '"' && cells[end].char == '\\' {
+					end++
+				}
+				end++
+			}
+			end = min(end+1, len(cells))
+			for j := i; j < end; j++ {
+				cells[j].style = styleString
+			}
+			i = end
+		default:
+			i++
+		}
+	}
+	return min(i, len(cells))
+}
+
+// skipHereDocuments returns where the rows after a command with
+// here-documents go on, past a body ending in each delimiter in turn.
+// Leading tabs are ignored, as <<- strips them.
+func skipHereDocuments(cells []cell, start int, delimiters []string) int {
+	i := start
+	for _, delimiter := range delimiters {
+		for i < len(cells) {
+			end := i
+			for end < len(cells) && cells[end].char != '\n' {
+				end++
+			}
+			row := strings.TrimLeft(string(runesOf(cells[i:end])), "\t")
+			i = min(end+1, len(cells))
+			if row == delimiter {
+				break
+			}
+		}
+	}
+	return i
+}
+
+// styleUnquoted gives the characters of a word not already styled as a
+// string the style
+func styleUnquoted(cells []cell, style string) {
+	for i := range cells {
+		if cells[i].style == "" {
+			cells[i].style = style
+		}
+	}
+}
+
+// runesOf returns the characters of cells
+func runesOf(cells []cell) []rune {
+	runes := make([]rune, len(cells))
+	for i, c := range cells {
+		runes[i] = c.char
+	}
+	return runes
+}
+
+// knownCommand reports whether name runs something: an alias, function,
+// builtin, an executable in PATH, or given with a slash, an executable
+// file
+func (r *Readline) knownCommand(name string) bool {
+	if strings.Contains(name, "/") {
+		info, err := os.Stat(name)
+		return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+	}
+	if _, isAlias := r.session.GetAliases()[name]; isAlias {
+		return true
+	}
+	if _, isFunction := r.session.GetFunction(name); isFunction {
+		return true
+	}
+	if r.session.IsBuiltin(name) {
+		return true
+	}
+	executables := r.cachedExecutables()
+	i := sort.SearchStrings(executables, name)
+	return i < len(executables) && executables[i] == name
+}
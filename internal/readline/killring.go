@@ -0,0 +1,85 @@
+package readline
+
+// killRingSize is how many kills the kill ring keeps
+const killRingSize = 16
+
+// editAction is what a key did, for the keys whose effect depends on the
+// key before: kills in a row grow one kill ring entry, Alt+Y only follows
+// a yank and characters typed in a row are undone together
+type editAction int
+
+const (
+	actionOther editAction = iota
+	actionKill
+	actionYank
+	actionInsert
+
+	// Undo, redo and starting a new line are not changes undo takes back
+	actionUndo
+)
+
+// kill saves text removed from the line in the kill ring. Right after
+// another kill it is added to that kill's entry instead: after it, or
+// before it when the text was removed backward from the cursor.
+func (r *Readline) kill(text []rune, backward bool) {
+	continued := r.lastAction == actionKill && len(r.killRing) > 0
+	r.action = actionKill
+	if len(text) == 0 {
+		return
+	}
+
+	if continued {
+		last := len(r.killRing) - 1
+		if backward {
+			r.killRing[last] = append(append([]rune(nil), text...), r.killRing[last]...)
+		} else {
+			r.killRing[last] = append(r.killRing[last], text...)
+		}
+		return
+	}
+
+	r.killRing = append(r.killRing, append([]rune(nil), text...))
+	if len(r.killRing) > killRingSize {
+		r.killRing = r.killRing[1:]
+	}
+}
+
+// yank inserts the newest kill at the cursor (Ctrl+Y)
+func (r *Readline) yank() {
+	if len(r.killRing) == 0 {
+		return
+	}
+	r.yankIndex = len(r.killRing) - 1
+	r.insertYank()
+}
+
+// yankPop replaces the text just yanked with the kill before it, going
+// round to the newest after the oldest (Alt+Y)
+func (r *Readline) yankPop() {
+	if r.lastAction != actionYank || len(r.killRing) == 0 {
+		return
+	}
+
+	copy(r.line[r.yankStart:], r.line[r.cursor:])
+	r.line = r.line[:len(r.line)-(r.cursor-r.yankStart)]
+	r.cursor = r.yankStart
+
+	r.yankIndex = (r.yankIndex + len(r.killRing) - 1) % len(r.killRing)
+	r.insertYank()
+}
+
+// insertYank inserts the kill ring entry at yankIndex at the cursor,
+// leaving the cursor after it
+func (r *Readline) insertYank() {
+	text := r.killRing[r.yankIndex]
+	r.yankStart = r.cursor
+
+	line := make([]rune, 0, len(r.line)+len(text))
+	line = append(line, r.line[:r.cursor]...)
+	line = append(line, text...)
+	r.line = append(line, r.line[r.cursor:]...)
+	r.cursor += len(text)
+
+	r.action = actionYank
+	r.redrawLine()
+}
@@ -0,0 +1,172 @@
+package readline
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gex/internal/cli"
+)
+
+// defaultContinuationPrompt starts the lines after the first of a command
+// that spans several, unless PS2 is set
+const defaultContinuationPrompt = "> "
+
+// needsMoreInput reports whether the line is an unfinished command: one
+// ending in a backslash, an unclosed quote, a here-document without its
+// end, an open function body or a trailing operator such as &&
+func (r *Readline) needsMoreInput() bool {
+	text := string(r.line)
+	if strings.TrimSpace(text) == "" {
+		return false
+	}
+	if trailing := len(text) - len(strings.TrimRight(text, "\\")); trailing%2 == 1 {
+		return true
+	}
+	_, err := cli.ParseWithAliases(text, r.session.GetGlobalAliases())
+	return cli.IsIncomplete(err)
+}
+
+// insertNewline breaks the line at the cursor, continuing the command on a
+// new line (Enter on an unfinished command, or Alt+Enter)
+func (r *Readline) insertNewline() {
+	r.insertChar('\n')
+}
+
+// continuationPrompt returns the prompt of the lines after the first
+func (r *Readline) continuationPrompt() string {
+	if prompt, ok := r.session.LookupVariable("PS2"); ok {
+		return prompt
+	}
+	return defaultContinuationPrompt
+}
+
+// rowStart returns where the row that pos is on starts
+func rowStart(line []rune, pos int) int {
+	for pos > 0 && line[pos-1] != '\n' {
+		pos--
+	}
+	return pos
+}
+
+// rowEnd returns where the row that pos is on ends, at its newline or the
+// end of the line
+func rowEnd(line []rune, pos int) int {
+	for pos < len(line) && line[pos] != '\n' {
+		pos++
+	}
+	return pos
+}
+
+// lineUp moves the cursor to the row above, keeping its column where the
+// row is long enough, or on the first row goes back in history
+func (r *Readline) lineUp() {
+	start := rowStart(r.line, r.cursor)
+	if start == 0 {
+		r.prevHistory()
+		return
+	}
+	column := r.cursor - start
+	above := rowStart(r.line, start-1)
+	r.cursor = min(above+column, start-1)
+	r.redrawLine()
+}
+
+// lineDown moves the cursor to the row below, or on the last row goes
+// forward in history
+func (r *Readline) lineDown() {
+	end := rowEnd(r.line, r.cursor)
+	if end == len(r.line) {
+		r.nextHistory()
+		return
+	}
+	column := r.cursor - rowStart(r.line, r.cursor)
+	r.cursor = min(end+1+column, rowEnd(r.line, end+1))
+	r.redrawLine()
+}
+
+// moveBelow moves the terminal cursor to the end of the line, for output
+// to start on the line below it; the cursor in the line stays where it is
+func (r *Readline) moveBelow() {
+	cursor := r.cursor
+	r.cursor = len(r.line)
+	r.redrawLine()
+	r.cursor = cursor
+}
+
+// redrawRows draws a line that takes several rows in full, because it
+// holds newlines or wraps: from the start of the prompt everything is
+// cleared and written out again, each row after a newline behind the
+// continuation prompt, and the cursor put in its place. A line of several
+// rows is short, so this is cheap enough.
+func (r *Readline) redrawRows(cells []cell) {
+	if r.shownRow > 0 {
+		fmt.Fprintf(r.out, "\x1b[%dA", r.shownRow)
+	}
+	// Only the last line of a prompt of several is drawn again
+	prompt := r.prompt[strings.LastIndexByte(r.prompt, '\n')+1:]
+	fmt.Fprint(r.out, "\r\x1b[J", prompt)
+
+	continuation := r.continuationPrompt()
+	start := 0
+	for i := 0; i <= len(cells); i++ {
+		if i < len(cells) && cells[i].char != '\n' {
+			continue
+		}
+		r.writeCells(cells[start:i])
+		if i < len(cells) {
+			fmt.Fprint(r.out, "\r\n", continuation)
+		}
+		start = i + 1
+	}
+
+	// Go on to the next row after a full one, where the cursor can be
+	// put, then back up to the cursor's row and across to its column
+	row, col, endRow, endCol := r.layout(cells, r.cursor)
+	if r.width > 0 && endCol >= r.width {
+		fmt.Fprint(r.out, "\r\n")
+		endRow++
+	}
+	if endRow > row {
+		fmt.Fprintf(r.out, "\x1b[%dA", endRow-row)
+	}
+	fmt.Fprint(r.out, "\r")
+	if col > 0 {
+		fmt.Fprintf(r.out, "\x1b[%dC", col)
+	}
+
+	r.shown = append(r.shown[:0], cells...)
+	r.shownCursor = r.cursor
+	r.shownRow = row
+}
+
+// visibleWidth returns how many columns a prompt takes, leaving out its
+// escape sequences
+func visibleWidth(prompt string) int {
+	width := 0
+	for i := 0; i < len(prompt); {
+		if prompt[i] == '\x1b' && i+1 < len(prompt) && prompt[i+1] == '[' {
+			// A control sequence ends with a letter
+			i += 2
+			for i < len(prompt) && (prompt[i] < '@' || prompt[i] > '~') {
+				i++
+			}
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(prompt[i:])
+		i += size
+		width++
+	}
+	return width
+}
+
+// hasNewline reports whether cells span several rows
+func hasNewline(cells []cell) bool {
+	for _, c := range cells {
+		if c.char == '\n' {
+			return true
+		}
+	}
+	return false
+}
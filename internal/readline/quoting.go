@@ -0,0 +1,103 @@
+package readline
+
+import "strings"
+
+// completionWordStart returns where the word ending at the end of text
+// starts: after the last blank outside quotes, so a quoted path with
+// spaces completes as one word
+func completionWordStart(text []rune) int {
+	start := 0
+	var quote rune
+	for i := 0; i < len(text); i++ {
+		char := text[i]
+		switch {
+		case quote == '\'':
+			if char == '\'' {
+				quote = 0
+			}
+		case char == '\\':
+			i++
+		case quote == '"':
+			if char == '"' {
+				quote = 0
+			}
+		case char == '\'' || char == '"':
+			quote = char
+		case isBlank(char):
+			start = i + 1
+		}
+	}
+	return start
+}
+
+// splitCompletionWord splits a word into the text kept as typed, such as
+// --output= or NAME=, and the path after it without its quotes and
+// backslashes. quote is the first quote the path was typed with, or 0.
+func splitCompletionWord(word string) (kept, path string, quote rune) {
+	if eq := strings.IndexByte(word, '='); eq > 0 && !strings.ContainsAny(word[:eq], "'\"\\/") {
+		kept, word = word[:eq+1], word[eq+1:]
+	}
+
+	var unquoted strings.Builder
+	var open rune
+	runes := []rune(word)
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		switch {
+		case open == '\'':
+			if char == '\'' {
+				open = 0
+				continue
+			}
+		case char == '\\' && (open == 0 || (i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]))):
+			if i+1 < len(runes) {
+				i++
+				char = runes[i]
+			}
+		case open == '"':
+			if char == '"' {
+				open = 0
+				continue
+			}
+		case char == '\'' || char == '"':
+			open = char
+			if quote == 0 {
+				quote = char
+			}
+			continue
+		}
+		unquoted.WriteRune(char)
+	}
+	return kept, unquoted.String(), quote
+}
+
+// quoteCompletion writes a completion back the way its word was typed:
+// inside quote, closed when closed is set, or with backslashes before the
+// characters the shell would otherwise take apart
+func quoteCompletion(text string, quote rune, closed bool) string {
+	var quoted strings.Builder
+	switch quote {
+	case '\'':
+		quoted.WriteString("'" + strings.ReplaceAll(text, "'", `'\''`))
+	case '"':
+		quoted.WriteByte('"')
+		for _, char := range text {
+			if strings.ContainsRune("\"\\$`", char) {
+				quoted.WriteByte('\\')
+			}
+			quoted.WriteRune(char)
+		}
+	default:
+		for _, char := range text {
+			if strings.ContainsRune(" \t\n'\"\\$`&|;<>()*?[]{}!#", char) {
+				quoted.WriteByte('\\')
+			}
+			quoted.WriteRune(char)
+		}
+		return quoted.String()
+	}
+	if closed {
+		quoted.WriteRune(quote)
+	}
+	return quoted.String()
+}
@@ -3,11 +3,16 @@ package readline
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"unsafe"
 
+	"gex/internal/cli"
+	"gex/internal/core"
 	"gex/internal/shell"
 )
 
@@ -20,6 +25,53 @@ type Readline struct {
 	line       []rune
 	cursor     int
 	prompt     string
+	out        io.Writer
+
+	// The line as typed before Up moved into history, whose text Up and
+	// Down match entries against with history_search on
+	typedLine     []rune
+	historyPrefix string
+
+	// Lines put aside with push-line, brought back one per prompt
+	pushed [][]rune
+
+	// Text removed by Ctrl+K, Ctrl+U and Ctrl+W, newest last, and the
+	// entry Ctrl+Y or Alt+Y last inserted, which starts at yankStart and
+	// ends at the cursor
+	killRing  [][]rune
+	yankIndex int
+	yankStart int
+
+	// What the previous key and the one being handled did
+	lastAction editAction
+	action     editAction
+
+	// The line before each change, for undo, and after each change undo
+	// took back, for redo
+	undoStack []lineState
+	redoStack []lineState
+
+	// What the terminal shows after the prompt and where its cursor is,
+	// so a redraw only sends what changed, and for a line of several
+	// rows which row the cursor is on
+	shown       []cell
+	shownCursor int
+	shownRow    int
+
+	// The width of the terminal, 0 when unknown, and the SIGWINCH
+	// notifications that it changed
+	width   int
+	resized chan os.Signal
+
+	// mutex guards the line against Interrupt while a key is handled
+	mutex   sync.Mutex
+	editing bool
+
+	// The terminal modes from before raw mode, for Suspend to put back
+	terminalState *syscall.Termios
+
+	// values returns the values of a kind of flag value, such as signal
+	values func(kind string) []string
 }
 
 // New creates a new readline instance
@@ -32,6 +84,26 @@ func New(session *shell.Session) *Readline {
 		line:       make([]rune, 0),
 		cursor:     0,
 		prompt:     "gex> ",
+		out:        os.Stdout,
+	}
+}
+
+// SetOutput sets where the prompt and the line are drawn. A writer with a
+// Flush method is flushed once per key, so each update is a single write.
+func (r *Readline) SetOutput(out io.Writer) {
+	r.out = out
+}
+
+// SetValueSource sets where the values of a kind of flag value named in
+// the command metadata come from when completing them
+func (r *Readline) SetValueSource(values func(kind string) []string) {
+	r.values = values
+}
+
+// flush sends what has been drawn since the last key to the terminal
+func (r *Readline) flush() {
+	if flusher, ok := r.out.(interface{ Flush() error }); ok {
+		flusher.Flush()
 	}
 }
 
@@ -43,23 +115,28 @@ func (r *Readline) SetPrompt(prompt string) {
 // ReadLine reads a line with advanced editing features
 func (r *Readline) ReadLine() (string, error) {
 	// Check if stdin is a terminal
-	if !isTerminal() {
+	if !IsTerminal() {
 		return r.readSimple()
 	}
 
 	// Set terminal to raw mode for advanced editing
-	oldState, err := setRawMode()
+	oldState, err := SetRawMode()
 	if err != nil {
 		return r.readSimple()
 	}
-	defer restoreTerminal(oldState)
+	defer RestoreTerminal(oldState)
+
+	r.mutex.Lock()
+	r.terminalState = oldState
+	r.mutex.Unlock()
 
 	return r.readAdvanced()
 }
 
 // readSimple reads a line without advanced features (for non-terminals)
 func (r *Readline) readSimple() (string, error) {
-	fmt.Print(r.prompt)
+	fmt.Fprint(r.out, r.prompt)
+	r.flush()
 	line, err := r.reader.ReadString('\n')
 	if err != nil {
 		return "", err
@@ -69,11 +146,29 @@ func (r *Readline) readSimple() (string, error) {
 
 // readAdvanced reads a line with advanced editing features
 func (r *Readline) readAdvanced() (string, error) {
+	r.mutex.Lock()
 	r.line = r.line[:0]
-	r.cursor = 0
+	if n := len(r.pushed); n > 0 {
+		r.line = append(r.line, r.pushed[n-1]...)
+		r.pushed = r.pushed[:n-1]
+	}
+	r.cursor = len(r.line)
 	r.historyPos = -1
-
+	r.action = actionOther
+	r.resetUndo()
+	r.width = terminalWidth()
+	r.watchResize()
+	r.editing = true
 	r.displayPrompt()
+	r.redrawLine()
+	r.flush()
+	r.mutex.Unlock()
+
+	defer func() {
+		r.mutex.Lock()
+		r.editing = false
+		r.mutex.Unlock()
+	}()
 
 	for {
 		char, err := r.readChar()
@@ -81,77 +176,190 @@ func (r *Readline) readAdvanced() (string, error) {
 			return "", err
 		}
 
-		switch char {
-		case '\r', '\n':
-			// Enter - submit line
-			fmt.Print("\r\n")
-			result := string(r.line)
-			if result != "" {
-				r.addToHistory(result)
-			}
-			return result, nil
+		r.mutex.Lock()
+		line, done, err := r.handleKey(char)
+		r.flush()
+		r.mutex.Unlock()
+		if done || err != nil {
+			return line, err
+		}
+	}
+}
 
-		case '\x03': // Ctrl+C
-			fmt.Print("^C\r\n")
-			r.line = r.line[:0]
-			r.cursor = 0
-			r.displayPrompt()
+// handleKey applies a key to the line being edited and reports whether
+// the line is complete
+func (r *Readline) handleKey(char byte) (string, bool, error) {
+	r.lastAction, r.action = r.action, actionOther
+	defer r.recordChange(r.saveState())
+
+	switch char {
+	case '\r', '\n':
+		// Enter - submit line, or continue an unfinished command on a
+		// new row
+		if r.needsMoreInput() {
+			r.insertNewline()
+			return "", false, nil
+		}
+		return r.submitLine(), true, nil
 
-		case '\x04': // Ctrl+D (EOF)
-			if len(r.line) == 0 {
-				return "", fmt.Errorf("EOF")
-			}
-			// Delete character at cursor
-			r.deleteChar()
+	case '\x03': // Ctrl+C
+		r.cancelLine()
 
-		case '\x08', '\x7f': // Backspace or DEL
-			r.backspace()
+	case '\x04': // Ctrl+D (EOF)
+		if len(r.line) == 0 {
+			return "", true, fmt.Errorf("EOF")
+		}
+		// Delete character at cursor
+		r.deleteChar()
 
-		case '\x09': // Tab - autocomplete
-			r.autoComplete()
+	case '\x08', '\x7f': // Backspace or DEL
+		r.backspace()
 
-		case '\x0c': // Ctrl+L - clear screen
-			r.clearScreen()
+	case '\x09': // Tab - autocomplete
+		r.autoComplete()
 
-		case '\x01': // Ctrl+A - beginning of line
-			r.moveToBeginning()
+	case '\x0c': // Ctrl+L - clear screen
+		r.clearScreen()
 
-		case '\x05': // Ctrl+E - end of line
-			r.moveToEnd()
+	case '\x01': // Ctrl+A - beginning of line
+		r.moveToBeginning()
 
-		case '\x02': // Ctrl+B - move left
-			r.moveLeft()
+	case '\x05': // Ctrl+E - end of line
+		r.moveToEnd()
 
-		case '\x06': // Ctrl+F - move right
-			r.moveRight()
+	case '\x02': // Ctrl+B - move left
+		r.moveLeft()
 
-		case '\x0e': // Ctrl+N - next history
-			r.nextHistory()
+	case '\x06': // Ctrl+F - move right
+		r.moveRight()
 
-		case '\x10': // Ctrl+P - previous history
-			r.prevHistory()
+	case '\x0e': // Ctrl+N - next row or history
+		r.lineDown()
 
-		case '\x0b': // Ctrl+K - kill to end of line
-			r.killToEnd()
+	case '\x10': // Ctrl+P - previous row or history
+		r.lineUp()
 
-		case '\x15': // Ctrl+U - kill entire line
-			r.killLine()
+	case '\x0b': // Ctrl+K - kill to end of line
+		r.killToEnd()
 
-		case '\x17': // Ctrl+W - kill word backward
-			r.killWordBackward()
+	case '\x15': // Ctrl+U - kill entire line
+		r.killLine()
 
-		case '\x1b': // ESC - handle escape sequences
-			if err := r.handleEscapeSequence(); err != nil {
-				return "", err
-			}
+	case '\x17': // Ctrl+W - kill word backward
+		r.killWordBackward()
 
-		default:
-			if char >= 32 && char < 127 {
-				// Printable character
-				r.insertChar(rune(char))
-			}
+	case '\x19': // Ctrl+Y - yank the last kill
+		r.yank()
+
+	case '\x1f': // Ctrl+_ - undo
+		r.undo()
+
+	case '\x18': // Ctrl+X - prefix; Ctrl+X Ctrl+U undoes
+		next, err := r.readChar()
+		if err != nil {
+			return "", true, err
+		}
+		if next == '\x15' {
+			r.undo()
 		}
+
+	case '\x14': // Ctrl+T - transpose characters
+		r.transposeChars()
+
+	case '\x1a': // Ctrl+Z - push line
+		r.pushLine()
+
+	case '\x1b': // ESC - handle escape sequences
+		submit, err := r.handleEscapeSequence()
+		if err != nil {
+			return "", true, err
+		}
+		if submit {
+			return r.submitLine(), true, nil
+		}
+
+	default:
+		if char >= 32 && char < 127 {
+			// Printable character
+			r.insertChar(rune(char))
+			r.action = actionInsert
+		}
+	}
+	return "", false, nil
+}
+
+// Interrupt abandons the line being edited and shows a fresh prompt, like
+// Ctrl+C. It is safe to call from another goroutine, for a SIGINT that
+// arrives while the shell waits at the prompt.
+func (r *Readline) Interrupt() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.editing {
+		r.cancelLine()
+		r.flush()
+	}
+}
+
+// Suspend gives the terminal back the modes it had before the line editor
+// took it, for the shell to be stopped while it waits at the prompt. It is
+// safe to call from another goroutine.
+func (r *Readline) Suspend() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.editing && r.terminalState != nil {
+		r.moveBelow()
+		fmt.Fprint(r.out, "\r\n")
+		r.flush()
+		RestoreTerminal(r.terminalState)
+	}
+}
+
+// Resume puts the terminal in raw mode again once the shell is continued,
+// and redraws the prompt and the line on a line of their own, as whatever
+// ran meanwhile may have changed the modes and written over them
+func (r *Readline) Resume() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.editing {
+		SetRawMode()
+		fmt.Fprint(r.out, "\r\n")
+		r.displayPrompt()
+		r.redrawLine()
+		r.flush()
+	}
+}
+
+// pushLine puts the line aside and clears it for another command; the
+// line comes back at the next prompt
+func (r *Readline) pushLine() {
+	if len(r.line) == 0 {
+		return
 	}
+	r.pushed = append(r.pushed, append([]rune(nil), r.line...))
+	r.line = r.line[:0]
+	r.cursor = 0
+	r.historyPos = -1
+	r.redrawLine()
+}
+
+// submitLine ends the line being edited and returns it for the caller to
+// add to history and run
+func (r *Readline) submitLine() string {
+	r.moveBelow()
+	fmt.Fprint(r.out, "\r\n")
+	return string(r.line)
+}
+
+// cancelLine drops the line being edited and starts over on a new line
+func (r *Readline) cancelLine() {
+	r.moveBelow()
+	fmt.Fprint(r.out, "^C\r\n")
+	r.line = r.line[:0]
+	r.cursor = 0
+	r.historyPos = -1
+	r.action = actionUndo
+	r.resetUndo()
+	r.displayPrompt()
 }
 
 // readChar reads a single character
@@ -161,24 +369,73 @@ func (r *Readline) readChar() (byte, error) {
 	return buf[0], err
 }
 
-// handleEscapeSequence handles escape sequences (arrow keys, etc.)
-func (r *Readline) handleEscapeSequence() error {
+// handleEscapeSequence handles escape sequences (arrow keys, etc.) and
+// reports whether the line is to be submitted
+func (r *Readline) handleEscapeSequence() (bool, error) {
 	char, err := r.readChar()
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	if char == '#' {
+		// Alt+# - comment the line out and submit it, so it goes to
+		// history without running
+		r.line = append([]rune{'#'}, r.line...)
+		r.cursor = len(r.line)
+		r.redrawLine()
+		return true, nil
+	}
+
+	if char == 'q' || char == 'Q' {
+		// Alt+Q - push line, as in zsh
+		r.pushLine()
+		return false, nil
+	}
+
+	if char == 'y' || char == 'Y' {
+		// Alt+Y - replace the text just yanked with an older kill
+		r.yankPop()
+		return false, nil
+	}
+
+	if char == '/' {
+		// Alt+/ - redo what undo took back
+		r.redo()
+		return false, nil
+	}
+
+	if char == '\r' || char == '\n' {
+		// Alt+Enter - start a new row without submitting
+		r.insertNewline()
+		return false, nil
+	}
+
+	switch char {
+	case 'b', 'B': // Alt+B - back a word
+		r.moveWordLeft()
+		return false, nil
+	case 'f', 'F': // Alt+F - forward a word
+		r.moveWordRight()
+		return false, nil
+	case 'd', 'D': // Alt+D - kill the next word
+		r.killWordForward()
+		return false, nil
+	case 't', 'T': // Alt+T - transpose words
+		r.transposeWords()
+		return false, nil
 	}
 
 	if char == '[' {
 		char, err = r.readChar()
 		if err != nil {
-			return err
+			return false, err
 		}
 
 		switch char {
-		case 'A': // Up arrow - previous history
-			r.prevHistory()
-		case 'B': // Down arrow - next history
-			r.nextHistory()
+		case 'A': // Up arrow - previous row or history
+			r.lineUp()
+		case 'B': // Down arrow - next row or history
+			r.lineDown()
 		case 'C': // Right arrow
 			r.moveRight()
 		case 'D': // Left arrow
@@ -191,10 +448,38 @@ func (r *Readline) handleEscapeSequence() error {
 			if char, err := r.readChar(); err == nil && char == '~' {
 				r.deleteChar()
 			}
+		case '1': // With modifiers: ESC [ 1 ; m C, as Ctrl+Right sends
+			return false, r.handleModifiedKey()
 		}
 	}
 
-	return nil
+	return false, nil
+}
+
+// handleModifiedKey handles the rest of ESC [ 1 ; m x, which terminals
+// send for keys held with modifiers: Ctrl+Left and Ctrl+Right, or Alt with
+// them, move by words. Other combinations are read and ignored.
+func (r *Readline) handleModifiedKey() error {
+	var params []byte
+	for {
+		char, err := r.readChar()
+		if err != nil {
+			return err
+		}
+		if char < '0' || char > ';' {
+			// Ctrl is modifier 5, Alt 3
+			if modifier := string(params); modifier == ";5" || modifier == ";3" {
+				switch char {
+				case 'C':
+					r.moveWordRight()
+				case 'D':
+					r.moveWordLeft()
+				}
+			}
+			return nil
+		}
+		params = append(params, char)
+	}
 }
 
 // Movement and editing functions
@@ -224,47 +509,75 @@ func (r *Readline) deleteChar() {
 	}
 }
 
+// transposeChars swaps the character before the cursor with the one under
+// it and moves past both; at the end of the line it swaps the last two,
+// so a typo just made is fixed where it stands
+func (r *Readline) transposeChars() {
+	pos := r.cursor
+	if pos == len(r.line) || r.line[pos] == '\n' {
+		pos--
+	}
+	if pos < 1 || r.line[pos-1] == '\n' || r.line[pos] == '\n' {
+		return
+	}
+	r.line[pos-1], r.line[pos] = r.line[pos], r.line[pos-1]
+	r.cursor = pos + 1
+	r.redrawLine()
+}
+
 func (r *Readline) moveLeft() {
 	if r.cursor > 0 {
 		r.cursor--
-		fmt.Print("\x1b[D")
+		r.redrawLine()
 	}
 }
 
 func (r *Readline) moveRight() {
 	if r.cursor < len(r.line) {
 		r.cursor++
-		fmt.Print("\x1b[C")
+		r.redrawLine()
 	}
 }
 
+// moveToBeginning and moveToEnd go to the start and end of the row the
+// cursor is on, which for most lines is the whole line
 func (r *Readline) moveToBeginning() {
-	if r.cursor > 0 {
-		fmt.Printf("\x1b[%dD", r.cursor)
-		r.cursor = 0
-	}
+	r.cursor = rowStart(r.line, r.cursor)
+	r.redrawLine()
 }
 
 func (r *Readline) moveToEnd() {
-	if r.cursor < len(r.line) {
-		fmt.Printf("\x1b[%dC", len(r.line)-r.cursor)
-		r.cursor = len(r.line)
-	}
+	r.cursor = rowEnd(r.line, r.cursor)
+	r.redrawLine()
 }
 
+// killToEnd kills to the end of the row, or at the end of a row joins the
+// next row to it
 func (r *Readline) killToEnd() {
-	if r.cursor < len(r.line) {
-		r.line = r.line[:r.cursor]
+	end := rowEnd(r.line, r.cursor)
+	if end == r.cursor && end < len(r.line) {
+		end++
+	}
+	r.kill(r.line[r.cursor:end], false)
+	if end > r.cursor {
+		r.line = append(r.line[:r.cursor], r.line[end:]...)
 		r.redrawLine()
 	}
 }
 
 func (r *Readline) killLine() {
+	r.kill(r.line, true)
 	r.line = r.line[:0]
 	r.cursor = 0
 	r.redrawLine()
 }
 
+// isBlank reports whether char separates words: a space or the newline
+// between rows
+func isBlank(char rune) bool {
+	return char == ' ' || char == '\n'
+}
+
 func (r *Readline) killWordBackward() {
 	if r.cursor == 0 {
 		return
@@ -272,91 +585,176 @@ func (r *Readline) killWordBackward() {
 
 	// Find start of current word
 	pos := r.cursor - 1
-	for pos > 0 && r.line[pos] == ' ' {
+	for pos > 0 && isBlank(r.line[pos]) {
 		pos--
 	}
-	for pos > 0 && r.line[pos] != ' ' {
+	for pos > 0 && !isBlank(r.line[pos]) {
 		pos--
 	}
-	if r.line[pos] == ' ' {
+	if isBlank(r.line[pos]) {
 		pos++
 	}
 
 	// Remove the word
+	r.kill(r.line[pos:r.cursor], true)
 	copy(r.line[pos:], r.line[r.cursor:])
 	r.line = r.line[:len(r.line)-(r.cursor-pos)]
 	r.cursor = pos
 	r.redrawLine()
 }
 
-// History navigation
+// History navigation. With history_search on, Up and Down only visit
+// entries that start with the text typed before Up was first pressed, and
+// pass over entries equal to the one shown.
 func (r *Readline) prevHistory() {
-	history := r.session.GetHistory()
-	if len(history) == 0 {
-		return
+	before := r.historyPos
+	if before == -1 {
+		before = r.session.GetHistorySize()
+		r.typedLine = append(r.typedLine[:0], r.line...)
+		r.historyPrefix = ""
+		if r.session.Config().HistorySearch {
+			r.historyPrefix = string(r.line)
+		}
 	}
 
-	if r.historyPos == -1 {
-		r.historyPos = len(history) - 1
-	} else if r.historyPos > 0 {
-		r.historyPos--
+	position := before - 1
+	if r.historyPrefix != "" {
+		position = r.session.SearchHistory(r.historyPrefix, before, string(r.line))
 	}
-
-	if r.historyPos >= 0 && r.historyPos < len(history) {
-		r.line = []rune(history[r.historyPos])
-		r.cursor = len(r.line)
-		r.redrawLine()
+	if position < 0 {
+		return
 	}
+	r.showHistory(position)
 }
 
 func (r *Readline) nextHistory() {
-	history := r.session.GetHistory()
-	if len(history) == 0 || r.historyPos == -1 {
+	if r.historyPos == -1 {
 		return
 	}
 
-	r.historyPos++
-	if r.historyPos >= len(history) {
+	position := r.historyPos + 1
+	if r.historyPrefix != "" {
+		position = r.session.SearchHistoryForward(r.historyPrefix, r.historyPos, string(r.line))
+	}
+	if position < 0 || position >= r.session.GetHistorySize() {
+		// Past the newest entry the typed line comes back
 		r.historyPos = -1
-		r.line = r.line[:0]
-		r.cursor = 0
-	} else {
-		r.line = []rune(history[r.historyPos])
+		r.line = append(r.line[:0], r.typedLine...)
 		r.cursor = len(r.line)
+		r.redrawLine()
+		return
 	}
-	r.redrawLine()
+	r.showHistory(position)
 }
 
-func (r *Readline) addToHistory(line string) {
-	r.session.AddHistory(line)
+// showHistory replaces the line with a history entry
+func (r *Readline) showHistory(position int) {
+	r.historyPos = position
+	r.line = []rune(r.session.GetHistoryEntry(position))
+	r.cursor = len(r.line)
+	r.redrawLine()
 }
 
 // Display functions
+
+// displayPrompt draws the prompt on a fresh line; the line being edited is
+// drawn by the next redrawLine
 func (r *Readline) displayPrompt() {
-	fmt.Print(r.prompt)
+	fmt.Fprint(r.out, r.prompt)
+	r.shown = r.shown[:0]
+	r.shownCursor = 0
+	r.shownRow = r.promptRow()
 }
 
+// redrawLine brings the terminal up to date with the line and the cursor.
+// Only the part that changed is rewritten: the text the old and new line
+// share at both ends stays, and the terminal inserts or deletes characters
+// to make room, which keeps typing in the middle of a long line cheap. A
+// character whose highlighting changed counts as changed. A line of
+// several rows, or one that wraps, is drawn again in full.
 func (r *Readline) redrawLine() {
-	// Clear current line
-	fmt.Print("\r\x1b[K")
+	old, line := r.shown, r.cells()
+	if !r.fits(old) || !r.fits(line) || r.shownRow > 0 {
+		r.redrawRows(line)
+		return
+	}
+
+	prefix := 0
+	for prefix < len(old) && prefix < len(line) && old[prefix] == line[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(line)-prefix &&
+		old[len(old)-1-suffix] == line[len(line)-1-suffix] {
+		suffix++
+	}
+	oldMiddle := old[prefix : len(old)-suffix]
+	newMiddle := line[prefix : len(line)-suffix]
+
+	if len(oldMiddle) > 0 || len(newMiddle) > 0 {
+		r.moveCursor(prefix)
+		overwrite := min(len(oldMiddle), len(newMiddle))
+		r.writeCells(newMiddle[:overwrite])
+		r.shownCursor += overwrite
+
+		switch {
+		case suffix == 0:
+			// Nothing to keep after the change
+			r.writeCells(newMiddle[overwrite:])
+			r.shownCursor += len(newMiddle) - overwrite
+			if len(oldMiddle) > len(newMiddle) {
+				fmt.Fprint(r.out, "\x1b[K")
+			}
+		case len(newMiddle) > overwrite:
+			// Open a gap and fill it
+			fmt.Fprintf(r.out, "\x1b[%d@", len(newMiddle)-overwrite)
+			r.writeCells(newMiddle[overwrite:])
+			r.shownCursor += len(newMiddle) - overwrite
+		case len(oldMiddle) > overwrite:
+			fmt.Fprintf(r.out, "\x1b[%dP", len(oldMiddle)-overwrite)
+		}
+		r.shown = append(r.shown[:0], line...)
+	}
 
-	// Print prompt and line
-	fmt.Print(r.prompt)
-	fmt.Print(string(r.line))
+	r.moveCursor(r.cursor)
+}
 
-	// Move cursor to correct position
-	if r.cursor < len(r.line) {
-		fmt.Printf("\x1b[%dD", len(r.line)-r.cursor)
+// writeCells draws characters in their styles, leaving the terminal's
+// style as it was
+func (r *Readline) writeCells(cells []cell) {
+	var text strings.Builder
+	style := ""
+	for _, c := range cells {
+		if c.style != style {
+			if style != "" {
+				text.WriteString(styleReset)
+			}
+			text.WriteString(c.style)
+			style = c.style
+		}
+		text.WriteRune(c.char)
 	}
+	if style != "" {
+		text.WriteString(styleReset)
+	}
+	fmt.Fprint(r.out, text.String())
+}
+
+// moveCursor moves the terminal cursor to a position in the line
+func (r *Readline) moveCursor(pos int) {
+	switch {
+	case pos < r.shownCursor:
+		fmt.Fprintf(r.out, "\x1b[%dD", r.shownCursor-pos)
+	case pos > r.shownCursor:
+		fmt.Fprintf(r.out, "\x1b[%dC", pos-r.shownCursor)
+	}
+	r.shownCursor = pos
 }
 
 func (r *Readline) clearScreen() {
-	fmt.Print("\x1b[2J\x1b[H")
+	fmt.Fprint(r.out, "\x1b[2J\x1b[H")
 	r.displayPrompt()
-	fmt.Print(string(r.line))
-	if r.cursor < len(r.line) {
-		fmt.Printf("\x1b[%dD", len(r.line)-r.cursor)
-	}
+	r.redrawLine()
 }
 
 // Autocompletion
@@ -365,69 +763,388 @@ func (r *Readline) autoComplete() {
 		return
 	}
 
-	// Get current word
-	wordStart := r.cursor
-	for wordStart > 0 && r.line[wordStart-1] != ' ' {
-		wordStart--
+	// Get current word, which may hold quoted blanks
+	row := rowStart(r.line, r.cursor)
+	wordStart := row + completionWordStart(r.line[row:r.cursor])
+	if wordStart >= r.cursor {
+		return
 	}
 
-	if wordStart >= r.cursor {
+	// A variable name after $ or ${ completes to the variables set
+	if start, braced, ok := variableReference(r.line[wordStart:r.cursor]); ok {
+		r.completeVariable(wordStart+start, braced)
 		return
 	}
 
-	word := string(r.line[wordStart:r.cursor])
+	// Complete the path in the word without its quotes, and after an = as
+	// in --output=file
+	kept, word, quote := splitCompletionWord(string(r.line[wordStart:r.cursor]))
+	command, flag := "", kept
+	var args []string
+	fields := strings.Fields(string(r.line[row:wordStart]))
+	// The command nohup or exec runs completes like one starting the line
+	for len(fields) > 0 && cli.RunsCommand(fields[0]) {
+		fields = fields[1:]
+		for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+			fields = fields[1:]
+		}
+	}
+	if len(fields) > 0 {
+		command, args = fields[0], fields[1:]
+		if flag == "" && len(fields) > 1 {
+			flag = fields[len(fields)-1]
+		}
+	}
 
 	// Get completions
-	completions := r.getCompletions(word)
+	var completions []string
+	if values := cli.GetFlagValues(command, flag); values != nil {
+		completions = r.completeFlagValue(values, word)
+	} else if kept != "" {
+		completions = r.completePaths(word, false)
+	} else {
+		completions = r.getCompletions(word, command, args)
+	}
 	if len(completions) == 0 {
 		return
 	}
 
 	if len(completions) == 1 {
-		// Single completion - insert it
-		completion := completions[0]
-		suffix := completion[len(word):]
-		for _, char := range suffix {
-			r.insertChar(char)
+		// Single completion - replace the word, whose case may differ, and
+		// close its quote unless a directory is to be continued
+		closed := quote != 0 && !strings.HasSuffix(completions[0], "/")
+		skip := 0
+		if closed && r.cursor < len(r.line) && r.line[r.cursor] == quote {
+			closed, skip = false, 1
 		}
+		r.replaceWord(wordStart, kept+quoteCompletion(completions[0], quote, closed))
+		r.cursor += skip
+		r.redrawLine()
+	} else if common := r.commonStart(completions, word); len(common) > len([]rune(word)) {
+		// Multiple completions sharing more than was typed - fill that in
+		r.replaceWord(wordStart, kept+quoteCompletion(string(common), quote, false))
+		r.redrawLine()
 	} else {
-		// Multiple completions - show them
-		fmt.Print("\r\n")
-		for _, completion := range completions {
-			fmt.Printf("%s  ", completion)
+		r.listCompletions(completions)
+	}
+}
+
+// listCompletions shows the completions below the line and draws the
+// prompt and the line again after them
+func (r *Readline) listCompletions(completions []string) {
+	r.moveBelow()
+	fmt.Fprint(r.out, "\r\n")
+	for _, completion := range completions {
+		fmt.Fprintf(r.out, "%s  ", completionLabel(completion))
+	}
+	fmt.Fprint(r.out, "\r\n")
+	r.displayPrompt()
+	r.redrawLine()
+}
+
+// replaceWord replaces the text from start to the cursor, leaving the
+// cursor after the new text
+func (r *Readline) replaceWord(start int, text string) {
+	replacement := []rune(text)
+	rest := append([]rune(nil), r.line[r.cursor:]...)
+	r.line = append(append(r.line[:start], replacement...), rest...)
+	r.cursor = start + len(replacement)
+}
+
+// getCompletions returns the completions of the word being typed, which
+// is an argument of command after args or, when command is empty, a
+// command name
+func (r *Readline) getCompletions(prefix, command string, args []string) []string {
+	if command == "cd" {
+		return r.completeDirectories(prefix)
+	}
+	if values := cli.GetArgValues(command, args); values != nil {
+		return r.completeFlagValue(values, prefix)
+	}
+	// Arguments, and commands given by their path, are files, or global
+	// aliases, which expand anywhere
+	if command != "" || strings.Contains(prefix, "/") {
+		matcher := r.newMatcher(pathBase(prefix))
+		r.completePathsIn(matcher, ".", prefix, false)
+		if !strings.Contains(prefix, "/") {
+			for name := range r.session.GetGlobalAliases() {
+				if score, ok := matcher.match(name); ok {
+					matcher.add(name, score)
+				}
+			}
 		}
-		fmt.Print("\r\n")
-		r.displayPrompt()
-		fmt.Print(string(r.line))
-		if r.cursor < len(r.line) {
-			fmt.Printf("\x1b[%dD", len(r.line)-r.cursor)
+		return matcher.results()
+	}
+
+	matcher := r.newMatcher(prefix)
+	for _, name := range r.commandNames() {
+		if score, ok := matcher.match(name); ok {
+			matcher.add(name, score)
 		}
 	}
+	return matcher.results()
 }
 
-func (r *Readline) getCompletions(prefix string) []string {
-	var completions []string
+// completeFlagValue completes the value of a flag as its metadata says:
+// to the words it lists, or to files with one of its extensions and the
+// directories that may hold them. A host keeps the user@ typed before it.
+func (r *Readline) completeFlagValue(values *cli.FlagValues, prefix string) []string {
+	user := ""
+	if values.Kind == "host" || values.Kind == "remote" {
+		if at := strings.LastIndexByte(prefix, '@'); at >= 0 {
+			user, prefix = prefix[:at+1], prefix[at+1:]
+		}
+	}
+
+	matcher := r.newMatcher(pathBase(prefix))
+	if user == "" && (values.Files || len(values.Words) == 0 && values.Kind == "") {
+		matcher.extensions = values.Extensions
+		r.completePathsIn(matcher, ".", prefix, false)
+	}
+	if strings.Contains(prefix, "/") {
+		return matcher.results()
+	}
+
+	words := values.Words
+	if values.Kind != "" {
+		words = append(append([]string(nil), words...), r.kindValues(values.Kind)...)
+	}
+	for _, word := range words {
+		if score, ok := matcher.match(word); ok {
+			matcher.add(user+word, score)
+		}
+	}
+	return matcher.results()
+}
+
+// kindValues returns the names of a kind of value in the command
+// metadata: aliases, functions, builtins and commands are the session's,
+// and other kinds come from the value source
+func (r *Readline) kindValues(kind string) []string {
+	var names []string
+	switch kind {
+	case "alias":
+		for name := range r.session.GetAliases() {
+			names = append(names, name)
+		}
+		for name := range r.session.GetGlobalAliases() {
+			names = append(names, name)
+		}
+	case "function":
+		for name := range r.session.GetFunctions() {
+			names = append(names, name)
+		}
+	case "builtin":
+		for name := range cli.GetAllBuiltins() {
+			names = append(names, name)
+		}
+	case "command":
+		names = r.commandNames()
+	default:
+		if r.values != nil {
+			names = r.values(kind)
+		}
+	}
+	return names
+}
+
+// commandNames returns the sorted names that run as a command: aliases,
+// functions, builtins and the executables in PATH
+func (r *Readline) commandNames() []string {
+	seen := make(map[string]bool)
+	for name := range r.session.GetAliases() {
+		seen[name] = true
+	}
+	for name := range r.session.GetGlobalAliases() {
+		seen[name] = true
+	}
+	for name := range r.session.GetFunctions() {
+		seen[name] = true
+	}
+	for name := range cli.GetAllBuiltins() {
+		seen[name] = true
+	}
+
+	for _, name := range r.cachedExecutables() {
+		seen[name] = true
+	}
 
-	// Add command completions (simple implementation)
-	commands := []string{"cd", "pwd", "echo", "exit", "help", "history", "alias", "unalias", "env", "export", "which", "type"}
-	for _, cmd := range commands {
-		if strings.HasPrefix(cmd, prefix) {
-			completions = append(completions, cmd)
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cachedExecutables returns the executables in PATH. Scanning PATH is
+// slow, so its names are kept in the completion cache for that PATH.
+func (r *Readline) cachedExecutables() []string {
+	path := os.Getenv("PATH")
+	executables, cached := core.CompletionCache.Get("PATH=" + path)
+	if !cached {
+		executables = pathExecutables(path)
+		core.CompletionCache.Set("PATH="+path, executables)
+	}
+	return executables
+}
+
+// pathExecutables lists the executable files in the directories of path,
+// sorted by name
+func pathExecutables(path string) []string {
+	var names []string
+	for _, dir := range strings.Split(path, ":") {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			// Links are followed to see what they point at
+			info, err := os.Stat(dir + "/" + entry.Name())
+			if err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				names = append(names, entry.Name())
+			}
 		}
 	}
+	sort.Strings(names)
+	return names
+}
 
-	// Add file completions (basic implementation)
-	// This could be expanded to include proper file system traversal
+// completeDirectories completes the argument of cd to the directories it
+// could name, here or in the directories of CDPATH
+func (r *Readline) completeDirectories(prefix string) []string {
+	matcher := r.newMatcher(pathBase(prefix))
+	r.completePathsIn(matcher, ".", prefix, true)
+	for _, root := range r.session.CdPath(prefix) {
+		r.completePathsIn(matcher, root, prefix, true)
+	}
+	return matcher.results()
+}
+
+// completePaths completes a path to the files it could name, directories
+// with a trailing slash, or with dirsOnly to the directories alone. Hidden
+// ones are offered once a dot is typed.
+func (r *Readline) completePaths(prefix string, dirsOnly bool) []string {
+	matcher := r.newMatcher(pathBase(prefix))
+	r.completePathsIn(matcher, ".", prefix, dirsOnly)
+	return matcher.results()
+}
+
+// pathBase returns the last component of a path being typed
+func pathBase(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+// completePathsIn adds the completions of a path relative to root, as
+// completePaths does for the current directory
+func (r *Readline) completePathsIn(matcher *completionMatcher, root, prefix string, dirsOnly bool) {
+	dir, base := "", prefix
+	if slash := strings.LastIndex(prefix, "/"); slash >= 0 {
+		dir, base = prefix[:slash+1], prefix[slash+1:]
+	}
+
+	listed := dir
+	if listed == "" {
+		listed = root
+	} else if strings.HasPrefix(listed, "~/") {
+		listed = os.Getenv("HOME") + listed[1:]
+	} else if !strings.HasPrefix(listed, "/") {
+		listed = root + "/" + listed
+	}
+	entries, err := os.ReadDir(listed)
+	if err != nil {
+		return
+	}
 
-	return completions
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		score, ok := matcher.match(name)
+		if !ok {
+			continue
+		}
+		// Links to directories count too
+		if info, err := os.Stat(listed + "/" + name); err == nil && info.IsDir() {
+			matcher.add(dir+name+"/", score)
+		} else if !dirsOnly && matcher.wantsFile(name) {
+			matcher.add(dir+name, score)
+		}
+	}
+}
+
+// completionLabel is how a completion is listed: a path by its last
+// component, as the directory part is the same for them all
+func completionLabel(completion string) string {
+	trimmed := strings.TrimSuffix(completion, "/")
+	if slash := strings.LastIndex(trimmed, "/"); slash >= 0 {
+		return completion[slash+1:]
+	}
+	return completion
+}
+
+// commonStart returns the longest text the completions starting with the
+// typed word share, leaving fuzzy matches out
+func (r *Readline) commonStart(completions []string, word string) []rune {
+	var prefixed []string
+	for _, completion := range completions {
+		if r.hasPrefix(completion, word) {
+			prefixed = append(prefixed, completion)
+		}
+	}
+	if len(prefixed) == 0 {
+		return nil
+	}
+	return commonPrefix(prefixed)
+}
+
+// commonPrefix returns the longest text every completion starts with
+func commonPrefix(completions []string) []rune {
+	prefix := []rune(completions[0])
+	for _, completion := range completions[1:] {
+		candidate := []rune(completion)
+		n := 0
+		for n < len(prefix) && n < len(candidate) && prefix[n] == candidate[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return prefix
+}
+
+// hasPrefix reports whether a completion candidate starts with the typed
+// text, ignoring case as ignoreCase says
+func (r *Readline) hasPrefix(candidate, prefix string) bool {
+	if !r.ignoreCase() {
+		return strings.HasPrefix(candidate, prefix)
+	}
+	return strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(prefix))
+}
+
+// ignoreCase reports whether completion ignores case: unless
+// case_sensitive is on, or with completion_ignore_case whatever it is
+func (r *Readline) ignoreCase() bool {
+	config := r.session.Config()
+	return !config.CaseSensitive || config.CompletionIgnoreCase
 }
 
 // Terminal control functions
-func isTerminal() bool {
+
+// IsTerminal reports whether stdin is a terminal
+func IsTerminal() bool {
+	return IsTerminalFd(syscall.Stdin)
+}
+
+// IsTerminalFd reports whether a file descriptor refers to a terminal
+func IsTerminalFd(fd int) bool {
 	var termios syscall.Termios
 	_, _, errno := syscall.Syscall6(
 		syscall.SYS_IOCTL,
-		uintptr(syscall.Stdin),
+		uintptr(fd),
 		uintptr(syscall.TCGETS),
 		uintptr(unsafe.Pointer(&termios)),
 		0, 0, 0,
@@ -435,7 +1152,9 @@ func isTerminal() bool {
 	return errno == 0
 }
 
-func setRawMode() (*syscall.Termios, error) {
+// SetRawMode switches the terminal to reading single keys without echo and
+// returns the previous state for RestoreTerminal
+func SetRawMode() (*syscall.Termios, error) {
 	var oldState syscall.Termios
 
 	// Get current terminal state
@@ -453,6 +1172,8 @@ func setRawMode() (*syscall.Termios, error) {
 	// Set raw mode
 	newState := oldState
 	newState.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG
+	// Ctrl+S and Ctrl+Q are keys, not flow control
+	newState.Iflag &^= syscall.IXON
 	newState.Cc[syscall.VMIN] = 1
 	newState.Cc[syscall.VTIME] = 0
 
@@ -470,7 +1191,8 @@ func setRawMode() (*syscall.Termios, error) {
 	return &oldState, nil
 }
 
-func restoreTerminal(oldState *syscall.Termios) {
+// RestoreTerminal puts the terminal back in the state SetRawMode saved
+func RestoreTerminal(oldState *syscall.Termios) {
 	syscall.Syscall6(
 		syscall.SYS_IOCTL,
 		uintptr(syscall.Stdin),
@@ -479,3 +1201,41 @@ func restoreTerminal(oldState *syscall.Termios) {
 		0, 0, 0,
 	)
 }
+
+// winsize is the terminal size reported by TIOCGWINSZ
+type winsize struct {
+	rows, cols, xpixel, ypixel uint16
+}
+
+// getWinsize queries the size of the terminal on stdout or stdin
+func getWinsize() (winsize, bool) {
+	var size winsize
+	for _, fd := range []int{syscall.Stdout, syscall.Stdin} {
+		_, _, errno := syscall.Syscall(
+			syscall.SYS_IOCTL,
+			uintptr(fd),
+			uintptr(syscall.TIOCGWINSZ),
+			uintptr(unsafe.Pointer(&size)),
+		)
+		if errno == 0 && size.cols > 0 && size.rows > 0 {
+			return size, true
+		}
+	}
+	return size, false
+}
+
+// TerminalSize returns the width and height of the terminal, or 80x24 when
+// it cannot be determined
+func TerminalSize() (int, int) {
+	if size, ok := getWinsize(); ok {
+		return int(size.cols), int(size.rows)
+	}
+	return 80, 24
+}
+
+// TerminalPixelSize returns the size of the terminal in pixels, or zeros
+// when the terminal does not report it
+func TerminalPixelSize() (int, int) {
+	size, _ := getWinsize()
+	return int(size.xpixel), int(size.ypixel)
+}
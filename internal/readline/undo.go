@@ -0,0 +1,70 @@
+package readline
+
+// undoLimit is how many changes to the line undo can take back
+const undoLimit = 100
+
+// lineState is the line and cursor as they were before or after a change
+type lineState struct {
+	line   []rune
+	cursor int
+}
+
+// saveState returns a copy of the line and cursor
+func (r *Readline) saveState() lineState {
+	return lineState{line: append([]rune(nil), r.line...), cursor: r.cursor}
+}
+
+// recordChange saves the line as it was before the key just handled, for
+// undo to go back to, when the key changed it. Characters typed one after
+// another make one change until a space is typed, so undo takes back a
+// word at a time.
+func (r *Readline) recordChange(before lineState) {
+	if r.action == actionUndo || string(before.line) == string(r.line) {
+		return
+	}
+	r.redoStack = r.redoStack[:0]
+
+	typing := r.action == actionInsert && r.lastAction == actionInsert
+	if typing && r.cursor > 0 && r.line[r.cursor-1] != ' ' && len(r.undoStack) > 0 {
+		return
+	}
+	r.undoStack = append(r.undoStack, before)
+	if len(r.undoStack) > undoLimit {
+		r.undoStack = r.undoStack[1:]
+	}
+}
+
+// resetUndo forgets the changes made so far, for a new line
+func (r *Readline) resetUndo() {
+	r.undoStack = r.undoStack[:0]
+	r.redoStack = r.redoStack[:0]
+}
+
+// undo takes back the last change to the line (Ctrl+_ or Ctrl+X Ctrl+U)
+func (r *Readline) undo() {
+	r.action = actionUndo
+	if len(r.undoStack) == 0 {
+		return
+	}
+	r.redoStack = append(r.redoStack, r.saveState())
+	r.restoreState(r.undoStack[len(r.undoStack)-1])
+	r.undoStack = r.undoStack[:len(r.undoStack)-1]
+}
+
+// redo makes the last change undo took back again (Alt+/)
+func (r *Readline) redo() {
+	r.action = actionUndo
+	if len(r.redoStack) == 0 {
+		return
+	}
+	r.undoStack = append(r.undoStack, r.saveState())
+	r.restoreState(r.redoStack[len(r.redoStack)-1])
+	r.redoStack = r.redoStack[:len(r.redoStack)-1]
+}
+
+// restoreState puts back a saved line and cursor
+func (r *Readline) restoreState(state lineState) {
+	r.line = append(r.line[:0], state.line...)
+	r.cursor = state.cursor
+	r.redrawLine()
+}
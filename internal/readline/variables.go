@@ -0,0 +1,88 @@
+package readline
+
+import (
+	"os"
+	"strings"
+)
+
+// isNameChar reports whether char may be part of a variable name
+func isNameChar(char rune) bool {
+	return char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+}
+
+// variableReference reports whether word ends in a variable name being
+// typed after $ or ${, and where that name starts
+func variableReference(word []rune) (start int, braced bool, ok bool) {
+	start = len(word)
+	for start > 0 && isNameChar(word[start-1]) {
+		start--
+	}
+	dollar := start - 1
+	if dollar >= 0 && word[dollar] == '{' {
+		dollar--
+		braced = true
+	}
+	if dollar < 0 || word[dollar] != '$' || (dollar > 0 && word[dollar-1] == '\\') {
+		return 0, false, false
+	}
+	// A name starts with a letter or _
+	if start < len(word) && word[start] >= '0' && word[start] <= '9' {
+		return 0, false, false
+	}
+	return start, braced, true
+}
+
+// completeVariable completes the variable name from start to the cursor
+// to the shell and environment variables, closing the brace of ${ once
+// the name is complete
+func (r *Readline) completeVariable(start int, braced bool) {
+	typed := string(r.line[start:r.cursor])
+	matcher := r.newMatcher(typed)
+	for _, name := range r.variableNames() {
+		if score, ok := matcher.match(name); ok {
+			matcher.add(name, score)
+		}
+	}
+	completions := matcher.results()
+
+	switch {
+	case len(completions) == 0:
+		return
+	case len(completions) == 1:
+		// A closing brace already there is stepped over
+		name, skip := completions[0], 0
+		if braced && r.cursor < len(r.line) && r.line[r.cursor] == '}' {
+			skip = 1
+		} else if braced {
+			name += "}"
+		}
+		r.replaceWord(start, name)
+		r.cursor += skip
+	default:
+		if common := r.commonStart(completions, typed); len(common) > len([]rune(typed)) {
+			r.replaceWord(start, string(common))
+		} else {
+			r.listCompletions(completions)
+			return
+		}
+	}
+	r.redrawLine()
+}
+
+// variableNames returns the names of the shell's variables, arrays and
+// environment
+func (r *Readline) variableNames() []string {
+	var names []string
+	for name := range r.session.GetVariables() {
+		names = append(names, name)
+	}
+	for name := range r.session.GetArrays() {
+		names = append(names, name)
+	}
+	for _, entry := range os.Environ() {
+		if eq := strings.IndexByte(entry, '='); eq > 0 {
+			names = append(names, entry[:eq])
+		}
+	}
+	return names
+}
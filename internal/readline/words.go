@@ -0,0 +1,81 @@
+package readline
+
+import "unicode"
+
+// isWordChar reports whether char is part of a word for Alt+B, Alt+F, Alt+D
+// and Alt+T, which, unlike Ctrl+W, stop at punctuation such as / and -
+func isWordChar(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char) || char == '_'
+}
+
+// previousWord returns where the word before pos starts
+func previousWord(line []rune, pos int) int {
+	for pos > 0 && !isWordChar(line[pos-1]) {
+		pos--
+	}
+	for pos > 0 && isWordChar(line[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+// nextWord returns where the word after pos ends
+func nextWord(line []rune, pos int) int {
+	for pos < len(line) && !isWordChar(line[pos]) {
+		pos++
+	}
+	for pos < len(line) && isWordChar(line[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// moveWordLeft moves to the start of the word before the cursor (Alt+B,
+// Ctrl+Left)
+func (r *Readline) moveWordLeft() {
+	r.cursor = previousWord(r.line, r.cursor)
+	r.redrawLine()
+}
+
+// moveWordRight moves to the end of the word after the cursor (Alt+F,
+// Ctrl+Right)
+func (r *Readline) moveWordRight() {
+	r.cursor = nextWord(r.line, r.cursor)
+	r.redrawLine()
+}
+
+// killWordForward kills to the end of the word after the cursor (Alt+D)
+func (r *Readline) killWordForward() {
+	end := nextWord(r.line, r.cursor)
+	r.kill(r.line[r.cursor:end], false)
+	if end > r.cursor {
+		r.line = append(r.line[:r.cursor], r.line[end:]...)
+		r.redrawLine()
+	}
+}
+
+// transposeWords swaps the word before the cursor with the word under or
+// after it and moves past both; at the end of the line it swaps the last
+// two words (Alt+T)
+func (r *Readline) transposeWords() {
+	end2 := nextWord(r.line, r.cursor)
+	start2 := previousWord(r.line, end2)
+	start1 := previousWord(r.line, start2)
+	end1 := start1
+	for end1 < start2 && isWordChar(r.line[end1]) {
+		end1++
+	}
+	if end1 == start1 || end1 == start2 {
+		// Fewer than two words
+		return
+	}
+
+	line := make([]rune, 0, len(r.line))
+	line = append(line, r.line[:start1]...)
+	line = append(line, r.line[start2:end2]...)
+	line = append(line, r.line[end1:start2]...)
+	line = append(line, r.line[start1:end1]...)
+	r.line = append(line, r.line[end2:]...)
+	r.cursor = end2
+	r.redrawLine()
+}
@@ -0,0 +1,111 @@
+package readline
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// terminalWidth returns how many columns the terminal has, or 0 when it
+// does not say, in which case lines are drawn as if they never wrap
+func terminalWidth() int {
+	if size, ok := getWinsize(); ok {
+		return int(size.cols)
+	}
+	return 0
+}
+
+// watchResize redraws the line being edited when the terminal changes
+// size. It is started once; Notify is repeated for each line as a trap
+// on WINCH being reset would stop the notifications.
+func (r *Readline) watchResize() {
+	if r.resized != nil {
+		signal.Notify(r.resized, syscall.SIGWINCH)
+		return
+	}
+	r.resized = make(chan os.Signal, 1)
+	signal.Notify(r.resized, syscall.SIGWINCH)
+	go func() {
+		for range r.resized {
+			r.mutex.Lock()
+			if r.editing {
+				r.resize()
+				r.flush()
+			}
+			r.mutex.Unlock()
+		}
+	}()
+}
+
+// resize draws the prompt and the line again for the terminal's new
+// width. The terminal rewraps what it shows, which moves the cursor to
+// another row of the line, so that row is worked out for the new width
+// before going up to the prompt.
+func (r *Readline) resize() {
+	r.width = terminalWidth()
+	r.shownRow, _, _, _ = r.layout(r.shown, r.shownCursor)
+	r.redrawRows(r.cells())
+}
+
+// promptWidth returns how many columns the last line of the prompt takes
+func (r *Readline) promptWidth() int {
+	return visibleWidth(r.prompt[strings.LastIndexByte(r.prompt, '\n')+1:])
+}
+
+// fits reports whether cells fit on the row of the prompt without
+// wrapping, so a change can be drawn in place
+func (r *Readline) fits(cells []cell) bool {
+	return !hasNewline(cells) && (r.width == 0 || r.promptWidth()+len(cells) < r.width)
+}
+
+// layout works out where cells are drawn after the prompt: the row and
+// column of position pos, and of the end of the last character. Rows
+// count from the last line of the prompt. A row wraps at the width of
+// the terminal, and after a newline starts behind the continuation
+// prompt. A row filled to the last column leaves the terminal waiting
+// to wrap, so its end column is the width itself.
+func (r *Readline) layout(cells []cell, pos int) (row, col, endRow, endCol int) {
+	continuation := visibleWidth(r.continuationPrompt())
+	endRow, endCol = 0, r.promptWidth()
+	for r.width > 0 && endCol > r.width {
+		endRow++
+		endCol -= r.width
+	}
+
+	// place moves to the next row when the current one is full
+	place := func() {
+		if r.width > 0 && endCol >= r.width {
+			endRow++
+			endCol = 0
+		}
+	}
+	for i, c := range cells {
+		if i == pos {
+			place()
+			row, col = endRow, endCol
+		}
+		if c.char == '\n' {
+			endRow++
+			endCol = continuation
+			continue
+		}
+		place()
+		endCol++
+	}
+	if pos >= len(cells) {
+		place()
+		row, col = endRow, endCol
+	}
+	return row, col, endRow, endCol
+}
+
+// promptRow returns the row the prompt ends on, counted from its last
+// line, which is not the first when that line wraps
+func (r *Readline) promptRow() int {
+	width := r.promptWidth()
+	if r.width == 0 || width == 0 {
+		return 0
+	}
+	return (width - 1) / r.width
+}
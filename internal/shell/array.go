@@ -0,0 +1,225 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Array is the value of an array variable. Indexed arrays may be sparse,
+// so both kinds map keys to values; an indexed array's keys are decimal
+// indices.
+type Array struct {
+	Associative bool
+	Elements    map[string]string
+}
+
+// NewArray creates an empty array
+func NewArray(associative bool) *Array {
+	return &Array{Associative: associative, Elements: make(map[string]string)}
+}
+
+// Keys returns the keys in order: indices from lowest to highest, or the
+// keys of an associative array sorted
+func (a *Array) Keys() []string {
+	keys := make([]string, 0, len(a.Elements))
+	for key := range a.Elements {
+		keys = append(keys, key)
+	}
+	if a.Associative {
+		sort.Strings(keys)
+		return keys
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		x, _ := strconv.Atoi(keys[i])
+		y, _ := strconv.Atoi(keys[j])
+		return x < y
+	})
+	return keys
+}
+
+// Values returns the values in the order of Keys
+func (a *Array) Values() []string {
+	keys := a.Keys()
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = a.Elements[key]
+	}
+	return values
+}
+
+// NextIndex returns the index after the highest one set
+func (a *Array) NextIndex() int {
+	next := 0
+	for key := range a.Elements {
+		if n, err := strconv.Atoi(key); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return next
+}
+
+// ResolveIndex turns a negative index, which counts back from the end,
+// into the index it stands for. It reports false when that falls before
+// the start of the array.
+func (a *Array) ResolveIndex(index int) (int, bool) {
+	if index >= 0 {
+		return index, true
+	}
+	index += a.NextIndex()
+	return index, index >= 0
+}
+
+// Append adds values to an indexed array after its highest index
+func (a *Array) Append(values ...string) {
+	next := a.NextIndex()
+	for _, value := range values {
+		a.Elements[strconv.Itoa(next)] = value
+		next++
+	}
+}
+
+// Copy returns an array with the same elements
+func (a *Array) Copy() *Array {
+	copied := NewArray(a.Associative)
+	for key, value := range a.Elements {
+		copied.Elements[key] = value
+	}
+	return copied
+}
+
+// DeclareArray makes name an array. A scalar's value becomes element 0;
+// an array of the other kind cannot be converted.
+func (s *Session) DeclareArray(name string, associative bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if array, exists := s.arrays[name]; exists {
+		if array.Associative == associative {
+			return nil
+		}
+		if array.Associative {
+			return fmt.Errorf("%s: cannot convert associative to indexed array", name)
+		}
+		return fmt.Errorf("%s: cannot convert indexed to associative array", name)
+	}
+	if s.readonly[name] {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+
+	array := NewArray(associative)
+	if value, exists := s.scalarValue(name); exists {
+		array.Elements["0"] = value
+	}
+	s.removeScalar(name)
+	s.arrays[name] = array
+	return nil
+}
+
+// SetArray replaces the elements of an array variable, keeping its kind
+// if it is an array already
+func (s *Session) SetArray(name string, array *Array) error {
+	array = array.Copy()
+	for key, value := range array.Elements {
+		value, err := s.evaluate(name, value)
+		if err != nil {
+			return err
+		}
+		array.Elements[key] = value
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readonly[name] {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if existing, exists := s.arrays[name]; exists && existing.Associative != array.Associative {
+		return fmt.Errorf("%s: cannot convert between indexed and associative arrays", name)
+	}
+	s.removeScalar(name)
+	s.arrays[name] = array
+	return nil
+}
+
+// SetArrayElement sets one element, making name an indexed array if it
+// is not an array yet
+func (s *Session) SetArrayElement(name, key, value string) error {
+	value, err := s.evaluate(name, value)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readonly[name] {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	array, exists := s.arrays[name]
+	if !exists {
+		array = NewArray(false)
+		if value, exists := s.scalarValue(name); exists {
+			array.Elements["0"] = value
+		}
+		s.removeScalar(name)
+		s.arrays[name] = array
+	}
+	array.Elements[key] = value
+	return nil
+}
+
+// UnsetArrayElement removes one element of an array
+func (s *Session) UnsetArrayElement(name, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readonly[name] {
+		return fmt.Errorf("%s: cannot unset: readonly variable", name)
+	}
+	if array, exists := s.arrays[name]; exists {
+		delete(array.Elements, key)
+	}
+	return nil
+}
+
+// GetArray returns a copy of an array variable
+func (s *Session) GetArray(name string) (*Array, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	array, exists := s.arrays[name]
+	if !exists {
+		return nil, false
+	}
+	return array.Copy(), true
+}
+
+// GetArrays returns a copy of every array variable
+func (s *Session) GetArrays() map[string]*Array {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make(map[string]*Array, len(s.arrays))
+	for name, array := range s.arrays {
+		result[name] = array.Copy()
+	}
+	return result
+}
+
+// scalarValue returns the value name has as a shell or environment
+// variable. The caller holds the mutex.
+func (s *Session) scalarValue(name string) (string, bool) {
+	if value, exists := s.variables[name]; exists {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// removeScalar forgets name as a shell or environment variable, for it to
+// become an array. The caller holds the mutex.
+func (s *Session) removeScalar(name string) {
+	delete(s.variables, name)
+	os.Unsetenv(name)
+}
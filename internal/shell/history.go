@@ -0,0 +1,154 @@
+package shell
+
+import (
+	"sort"
+	"strings"
+)
+
+// historyIndex keeps the history sorted by lowercased text, so the entries
+// starting with a prefix in any case form one run that binary search finds
+// without scanning the whole history. Entries are numbered by seq, which
+// counts every entry ever added and so stays valid when the oldest ones
+// are dropped.
+type historyIndex struct {
+	entries []indexEntry
+}
+
+type indexEntry struct {
+	key  string
+	line string
+	seq  int
+}
+
+// find returns where an entry belongs in the sorted entries
+func (x *historyIndex) find(key string, seq int) int {
+	return sort.Search(len(x.entries), func(i int) bool {
+		entry := x.entries[i]
+		return entry.key > key || (entry.key == key && entry.seq >= seq)
+	})
+}
+
+func (x *historyIndex) add(line string, seq int) {
+	key := strings.ToLower(line)
+	i := x.find(key, seq)
+	x.entries = append(x.entries, indexEntry{})
+	copy(x.entries[i+1:], x.entries[i:])
+	x.entries[i] = indexEntry{key: key, line: line, seq: seq}
+}
+
+func (x *historyIndex) remove(line string, seq int) {
+	key := strings.ToLower(line)
+	i := x.find(key, seq)
+	if i < len(x.entries) && x.entries[i].seq == seq && x.entries[i].line == line {
+		x.entries = append(x.entries[:i], x.entries[i+1:]...)
+	}
+}
+
+// withPrefix returns the run of entries that start with prefix in any case
+func (x *historyIndex) withPrefix(prefix string) []indexEntry {
+	prefix = strings.ToLower(prefix)
+	start := sort.Search(len(x.entries), func(i int) bool {
+		return x.entries[i].key >= prefix
+	})
+	end := start + sort.Search(len(x.entries)-start, func(i int) bool {
+		return !strings.HasPrefix(x.entries[start+i].key, prefix)
+	})
+	return x.entries[start:end]
+}
+
+// matchesPrefix reports whether a history entry starts with prefix, in
+// the same case unless case_sensitive is off
+func (s *Session) matchesPrefix(line, prefix string) bool {
+	return !s.config.CaseSensitive || strings.HasPrefix(line, prefix)
+}
+
+// SearchHistory returns the position of the newest history entry before
+// position before that starts with prefix and differs from skip, or -1.
+// The case of the prefix only matters with case_sensitive on.
+// Up-arrow prefix search passes the line it shows as skip, so repeats of
+// it are passed over.
+func (s *Session) SearchHistory(prefix string, before int, skip string) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	found := -1
+	for _, entry := range s.historyIndex.withPrefix(prefix) {
+		position := entry.seq - s.historyBase
+		if position < before && position > found && entry.line != skip && s.matchesPrefix(entry.line, prefix) {
+			found = position
+		}
+	}
+	return found
+}
+
+// SearchHistoryForward returns the position of the oldest history entry
+// after position after that starts with prefix and differs from skip, or -1
+func (s *Session) SearchHistoryForward(prefix string, after int, skip string) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	found := -1
+	for _, entry := range s.historyIndex.withPrefix(prefix) {
+		position := entry.seq - s.historyBase
+		if position > after && (found == -1 || position < found) && entry.line != skip && s.matchesPrefix(entry.line, prefix) {
+			found = position
+		}
+	}
+	return found
+}
+
+// dropOldestHistory removes the oldest n entries; the caller holds the
+// mutex
+func (s *Session) dropOldestHistory(n int) {
+	for i, entry := range s.history[:n] {
+		s.historyIndex.remove(entry.Line, s.historyBase+i)
+	}
+	copy(s.history, s.history[n:])
+	s.history = s.history[:len(s.history)-n]
+	s.historyBase += n
+}
+
+// eraseHistory removes every entry equal to line. Later entries move up,
+// so the index is numbered again. The caller holds the mutex.
+func (s *Session) eraseHistory(line string) {
+	kept := s.history[:0]
+	for _, entry := range s.history {
+		if entry.Line != line {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(s.history) {
+		return
+	}
+	s.history = kept
+	s.reindexHistory()
+}
+
+// reindexHistory builds the index again after entries moved. The caller
+// holds the mutex.
+func (s *Session) reindexHistory() {
+	s.historyIndex.entries = s.historyIndex.entries[:0]
+	for i, entry := range s.history {
+		s.historyIndex.entries = append(s.historyIndex.entries, indexEntry{
+			key:  strings.ToLower(entry.Line),
+			line: entry.Line,
+			seq:  s.historyBase + i,
+		})
+	}
+	sort.Slice(s.historyIndex.entries, func(i, j int) bool {
+		a, b := s.historyIndex.entries[i], s.historyIndex.entries[j]
+		return a.key < b.key || (a.key == b.key && a.seq < b.seq)
+	})
+}
+
+// RemoveLastHistory drops the newest entry, which fc replaces with the
+// commands it runs
+func (s *Session) RemoveLastHistory() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if n := len(s.history); n > 0 {
+		s.historyIndex.remove(s.history[n-1].Line, s.historyBase+n-1)
+		s.history = s.history[:n-1]
+	}
+}
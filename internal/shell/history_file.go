@@ -0,0 +1,188 @@
+package shell
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// historyRecord is one line of the history file. Lines are appended one
+// command at a time, so sessions running at once can share the file. The
+// directory, duration in milliseconds and status are there for commands
+// that recorded them.
+type historyRecord struct {
+	Time     int64  `json:"time"`
+	Line     string `json:"line"`
+	Session  int    `json:"session,omitempty"`
+	Dir      string `json:"dir,omitempty"`
+	Duration *int64 `json:"duration_ms,omitempty"`
+	Status   *int   `json:"status,omitempty"`
+}
+
+// newHistoryRecord returns the record of an entry, saved by a session
+func newHistoryRecord(entry HistoryEntry, session int) historyRecord {
+	record := historyRecord{Time: entry.Time.Unix(), Line: entry.Line, Session: session, Dir: entry.Dir}
+	if entry.Finished {
+		duration := entry.Duration.Milliseconds()
+		status := entry.Status
+		record.Duration, record.Status = &duration, &status
+	}
+	return record
+}
+
+// entry returns the history entry a record holds
+func (r historyRecord) entry() HistoryEntry {
+	entry := HistoryEntry{Line: r.Line, Time: time.Unix(r.Time, 0), Dir: r.Dir}
+	if r.Duration != nil && r.Status != nil {
+		entry.Duration = time.Duration(*r.Duration) * time.Millisecond
+		entry.Status = *r.Status
+		entry.Finished = true
+	}
+	return entry
+}
+
+// OpenHistoryFile loads the history saved in path and saves every command
+// added from now on to it. A file grown past twice the history limit is
+// rewritten with the newest entries.
+func (s *Session) OpenHistoryFile(path string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	s.historyFile = path
+	s.historyOffset = 0
+
+	records, err := s.readHistoryFile(false)
+	if err != nil {
+		return err
+	}
+	if records > 2*s.historyLimit {
+		return s.rewriteHistoryFile()
+	}
+	return nil
+}
+
+// SyncHistory adds the commands other sessions saved to the history file
+// since it was last read
+func (s *Session) SyncHistory() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.historyFile == "" {
+		return nil
+	}
+	_, err := s.readHistoryFile(true)
+	return err
+}
+
+// readHistoryFile adds the entries past the part of the file already read
+// and returns how many records it found. When syncing, this session's own
+// records are skipped as they are in the history already. The caller
+// holds the mutex.
+func (s *Session) readHistoryFile(sync bool) (int, error) {
+	file, err := os.Open(s.historyFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// Another session rewrote the file; its newest entries are known
+	if info, err := file.Stat(); err == nil && info.Size() < s.historyOffset {
+		s.historyOffset = info.Size()
+	}
+	if _, err := file.Seek(s.historyOffset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	records := 0
+	reader := bufio.NewReader(file)
+	for {
+		// A line without its newline is still being written
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		s.historyOffset += int64(len(line))
+
+		var record historyRecord
+		if json.Unmarshal(line, &record) != nil {
+			continue
+		}
+		records++
+		if sync && record.Session == os.Getpid() {
+			continue
+		}
+		s.addHistoryEntry(record.entry())
+	}
+	return records, nil
+}
+
+// saveHistoryEntry appends an entry to the history file. History is a
+// convenience, so a file that cannot be written is not an error. The
+// caller holds the mutex.
+func (s *Session) saveHistoryEntry(entry HistoryEntry) {
+	if s.historyFile == "" {
+		return
+	}
+	data, err := json.Marshal(newHistoryRecord(entry, os.Getpid()))
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(s.historyFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// One write per record, so appends from several sessions don't mix
+	file.Write(append(data, '\n'))
+}
+
+// rewriteHistoryFile replaces the history file with the entries in
+// memory, but for a command still running, which FinishHistory saves.
+// The caller holds the mutex.
+func (s *Session) rewriteHistoryFile() error {
+	temp, err := os.CreateTemp(filepath.Dir(s.historyFile), ".history-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	writer := bufio.NewWriter(temp)
+	for i, entry := range s.history {
+		if s.historyPending && s.historyBase+i == s.pendingSeq {
+			continue
+		}
+		data, err := json.Marshal(newHistoryRecord(entry, 0))
+		if err != nil {
+			continue
+		}
+		writer.Write(append(data, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), s.historyFile); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.historyFile)
+	if err != nil {
+		return err
+	}
+	s.historyOffset = info.Size()
+	return nil
+}
@@ -0,0 +1,239 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportHistory writes the history as a JSON array of the records the
+// history file holds, for history import on another machine
+func (s *Session) ExportHistory(w io.Writer) error {
+	s.mutex.RLock()
+	records := make([]historyRecord, len(s.history))
+	for i, entry := range s.history {
+		records[i] = newHistoryRecord(entry, 0)
+	}
+	s.mutex.RUnlock()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+// ExportHistoryText writes the history the way bash saves it with
+// HISTTIMEFORMAT set: a #time line before each command, so commands of
+// several lines stay whole
+func (s *Session) ExportHistoryText(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	for _, entry := range s.GetHistoryEntries() {
+		fmt.Fprintf(writer, "#%d\n%s\n", entry.Time.Unix(), entry.Line)
+	}
+	return writer.Flush()
+}
+
+var (
+	// zshExtended matches the start of a line of zsh's EXTENDED_HISTORY:
+	// when the command started and how many seconds it ran
+	zshExtended = regexp.MustCompile(`^: *(\d+):\d+;`)
+	// bashTimestamp matches the line bash writes before each command when
+	// HISTTIMEFORMAT is set
+	bashTimestamp = regexp.MustCompile(`^#(\d+)$`)
+)
+
+// ParseHistory reads history saved in any format history import takes:
+// the JSON of history export or of the history file, zsh's history with or
+// without EXTENDED_HISTORY, and bash's with or without timestamps.
+// Commands with no time recorded get the zero time; blank ones are left
+// out.
+func ParseHistory(data []byte) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return nil, nil
+	case trimmed[0] == '[':
+		var records []historyRecord
+		if err := json.Unmarshal(trimmed, &records); err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			entries = append(entries, record.entry())
+		}
+	case trimmed[0] == '{':
+		for _, line := range bytes.Split(trimmed, []byte("\n")) {
+			var record historyRecord
+			if json.Unmarshal(line, &record) == nil {
+				entries = append(entries, record.entry())
+			}
+		}
+	case zshExtended.Match(trimmed):
+		entries = parseZshHistory(unmetafy(data))
+	default:
+		entries = parseBashHistory(data)
+	}
+
+	commands := entries[:0]
+	for _, entry := range entries {
+		if strings.TrimSpace(entry.Line) != "" {
+			commands = append(commands, entry)
+		}
+	}
+	return commands, nil
+}
+
+// unmetafy undoes how zsh saves bytes it uses internally: 0x83 followed
+// by the byte xor 32
+func unmetafy(data []byte) []byte {
+	if bytes.IndexByte(data, 0x83) < 0 {
+		return data
+	}
+	result := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == 0x83 && i+1 < len(data) {
+			i++
+			result = append(result, data[i]^32)
+			continue
+		}
+		result = append(result, data[i])
+	}
+	return result
+}
+
+// parseZshHistory reads zsh's history, where a command of several lines
+// has a backslash ending each line but its last
+func parseZshHistory(data []byte) []HistoryEntry {
+	var entries []HistoryEntry
+	var current *HistoryEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if current == nil {
+			entry := HistoryEntry{}
+			if match := zshExtended.FindStringSubmatch(line); match != nil {
+				seconds, _ := strconv.ParseInt(match[1], 10, 64)
+				entry.Time = time.Unix(seconds, 0)
+				line = line[len(match[0]):]
+			}
+			current = &entry
+		} else {
+			current.Line += "\n"
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			current.Line += line[:len(line)-1]
+			continue
+		}
+		current.Line += line
+		entries = append(entries, *current)
+		current = nil
+	}
+	if current != nil {
+		entries = append(entries, *current)
+	}
+	return entries
+}
+
+// parseBashHistory reads bash's history. Each line is a command, but
+// after a #time line every line up to the next one belongs to the same
+// command, which is how commands of several lines are kept.
+func parseBashHistory(data []byte) []HistoryEntry {
+	var entries []HistoryEntry
+	timed := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if match := bashTimestamp.FindStringSubmatch(line); match != nil {
+			seconds, _ := strconv.ParseInt(match[1], 10, 64)
+			entries = append(entries, HistoryEntry{Time: time.Unix(seconds, 0)})
+			timed = true
+			continue
+		}
+		if !timed {
+			entries = append(entries, HistoryEntry{Line: line})
+			continue
+		}
+		last := &entries[len(entries)-1]
+		if last.Line != "" {
+			last.Line += "\n"
+		}
+		last.Line += line
+	}
+	return entries
+}
+
+// ImportHistory adds entries to the history and returns how many were
+// added. Commands already in history or repeated in entries are skipped,
+// as are those history_ignore matches. The rest go in by time, entries
+// without a time as if run now, and the history file is rewritten to
+// match.
+func (s *Session) ImportHistory(entries []HistoryEntry) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seen := make(map[string]bool, len(s.history)+len(entries))
+	for _, entry := range s.history {
+		seen[entry.Line] = true
+	}
+
+	now := time.Now()
+	var added []HistoryEntry
+	for _, entry := range entries {
+		entry.Line = strings.TrimSpace(entry.Line)
+		if entry.Line == "" || seen[entry.Line] || s.ignoredInHistory(entry.Line) {
+			continue
+		}
+		seen[entry.Line] = true
+		if entry.Time.IsZero() {
+			entry.Time = now
+		}
+		added = append(added, entry)
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	sort.SliceStable(added, func(i, j int) bool {
+		return added[i].Time.Before(added[j].Time)
+	})
+
+	// Merge by time, keeping track of the command being run, which
+	// FinishHistory finds by its number
+	running, pending := -1, -1
+	if s.historyPending {
+		running = s.pendingSeq - s.historyBase
+	}
+	merged := make([]HistoryEntry, 0, len(s.history)+len(added))
+	i, j := 0, 0
+	for i < len(s.history) || j < len(added) {
+		if j < len(added) && (i == len(s.history) || added[j].Time.Before(s.history[i].Time)) {
+			merged = append(merged, added[j])
+			j++
+			continue
+		}
+		if i == running {
+			pending = len(merged)
+		}
+		merged = append(merged, s.history[i])
+		i++
+	}
+
+	if drop := len(merged) - s.historyLimit; drop > 0 {
+		merged = merged[drop:]
+		s.historyBase += drop
+		pending -= drop
+	}
+	s.history = merged
+	s.reindexHistory()
+	if s.historyPending {
+		s.pendingSeq = s.historyBase + pending
+		s.historyPending = pending >= 0
+	}
+
+	if s.historyFile == "" {
+		return len(added), nil
+	}
+	return len(added), s.rewriteHistoryFile()
+}
@@ -0,0 +1,112 @@
+package shell
+
+import (
+	"strings"
+	"sync"
+)
+
+// outputRingSize is how many bytes of the latest output an OutputRing
+// keeps
+const outputRingSize = 1 << 20
+
+// OutputRing keeps the latest output commands wrote to the terminal, so it
+// can be searched after it scrolled by. Offsets count every byte ever
+// written, which keeps where a command's output starts meaningful once
+// older output is dropped.
+type OutputRing struct {
+	mutex   sync.Mutex
+	buf     []byte
+	written int64
+
+	// Where the command running now started, and the output of the one
+	// before it
+	start     int64
+	lastStart int64
+	lastEnd   int64
+}
+
+// NewOutputRing returns an empty OutputRing
+func NewOutputRing() *OutputRing {
+	return &OutputRing{}
+}
+
+// Write adds p, dropping the oldest output past the size of the ring
+func (r *OutputRing) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.buf = append(r.buf, p...)
+	if len(r.buf) > outputRingSize {
+		r.buf = r.buf[len(r.buf)-outputRingSize:]
+	}
+	r.written += int64(len(p))
+	return len(p), nil
+}
+
+// Mark notes that a command line starts, so what was written since the
+// last mark becomes the output of the last command
+func (r *OutputRing) Mark() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.lastStart, r.lastEnd = r.start, r.written
+	r.start = r.written
+}
+
+// Last returns the output of the last command, as much as is kept
+func (r *OutputRing) Last() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.text(r.lastStart, r.lastEnd)
+}
+
+// All returns the output kept from before the command running now
+func (r *OutputRing) All() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.text(0, r.start)
+}
+
+// text returns the output kept between two offsets without its escape
+// sequences; the caller holds the mutex
+func (r *OutputRing) text(from, to int64) string {
+	first := r.written - int64(len(r.buf))
+	from, to = max(from, first), max(to, first)
+	return StripEscapes(string(r.buf[from-first : to-first]))
+}
+
+// StripEscapes removes the control sequences that color and move output
+// on the terminal, leaving the text
+func StripEscapes(text string) string {
+	if !strings.Contains(text, "\x1b") {
+		return text
+	}
+	var stripped strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\x1b' {
+			stripped.WriteByte(text[i])
+			continue
+		}
+		switch {
+		case i+1 < len(text) && text[i+1] == '[':
+			// A control sequence ends with a letter
+			i += 2
+			for i < len(text) && (text[i] < '@' || text[i] > '~') {
+				i++
+			}
+		case i+1 < len(text) && text[i+1] == ']':
+			// An operating system command, such as a link, ends with BEL
+			// or ESC \
+			i += 2
+			for i < len(text) && text[i] != '\a' && text[i] != '\x1b' {
+				i++
+			}
+			if i < len(text) && text[i] == '\x1b' {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+	return stripped.String()
+}
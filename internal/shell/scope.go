@@ -0,0 +1,132 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// savedVariable is what a name held before local shadowed it, put back
+// when the function returns
+type savedVariable struct {
+	value    string
+	isScalar bool
+	array    *Array
+	env      string
+	exported bool
+	readonly bool
+	integer  bool
+}
+
+// PushScope starts the local variables of a function call
+func (s *Session) PushScope() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scopes = append(s.scopes, make(map[string]savedVariable))
+}
+
+// PopScope ends a function call, giving every name made local in it back
+// the value and attributes it had before
+func (s *Session) PopScope() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.scopes) == 0 {
+		return
+	}
+	scope := s.scopes[len(s.scopes)-1]
+	s.scopes = s.scopes[:len(s.scopes)-1]
+
+	for name, saved := range scope {
+		delete(s.variables, name)
+		delete(s.arrays, name)
+		os.Unsetenv(name)
+
+		switch {
+		case saved.isScalar:
+			s.variables[name] = saved.value
+		case saved.array != nil:
+			s.arrays[name] = saved.array
+		}
+		if saved.exported {
+			os.Setenv(name, saved.env)
+		}
+		if saved.readonly {
+			s.readonly[name] = true
+		} else {
+			delete(s.readonly, name)
+		}
+		if saved.integer {
+			s.integers[name] = true
+		} else {
+			delete(s.integers, name)
+		}
+	}
+}
+
+// InFunction reports whether a function is running, so local can be used
+func (s *Session) InFunction() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.scopes) > 0
+}
+
+// DeclareLocal makes name local to the running function. Its value is put
+// aside until the function returns; with unset the name starts out unset,
+// otherwise it keeps its value until assigned, so local x=$x works.
+func (s *Session) DeclareLocal(name string, unset bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.scopes) == 0 {
+		return fmt.Errorf("can only be used in a function")
+	}
+	if s.readonly[name] {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+
+	scope := s.scopes[len(s.scopes)-1]
+	if _, done := scope[name]; !done {
+		var saved savedVariable
+		saved.value, saved.isScalar = s.variables[name]
+		if array, exists := s.arrays[name]; exists {
+			saved.array = array.Copy()
+		}
+		saved.env, saved.exported = os.LookupEnv(name)
+		saved.readonly = s.readonly[name]
+		saved.integer = s.integers[name]
+		scope[name] = saved
+	}
+
+	// A local variable does not keep the integer attribute, and is not
+	// passed to commands unless exported again
+	delete(s.integers, name)
+	value, exported := os.LookupEnv(name)
+	os.Unsetenv(name)
+	if exported && !unset {
+		if _, isArray := s.arrays[name]; !isArray {
+			s.variables[name] = value
+		}
+	}
+	if unset {
+		delete(s.variables, name)
+		delete(s.arrays, name)
+	}
+	return nil
+}
+
+// LocalNames returns the sorted names made local in the running function
+func (s *Session) LocalNames() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if len(s.scopes) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(s.scopes[len(s.scopes)-1]))
+	for name := range s.scopes[len(s.scopes)-1] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
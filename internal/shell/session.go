@@ -1,32 +1,130 @@
 package shell
 
 import (
+	"fmt"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"gex/internal/cli"
+	"gex/internal/config"
+)
+
+// JobState is the state of a job's processes
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobStopped
+	JobDone
 )
 
+// Job is a background job started by the shell
+type Job struct {
+	ID       int
+	PID      int // process group leader under job control
+	Command  string
+	LogPath  string // empty when output goes to the terminal
+	Started  time.Time
+	State    JobState
+	Status   int  // exit status, valid once the job is done
+	Notified bool // completion has been reported
+	NoHangup bool // disown -h: not sent SIGHUP when the shell hangs up
+
+	done chan struct{}
+}
+
 // Session manages shell state and history
 type Session struct {
-	workingDir   string
-	previousDir  string
-	history      []string
-	aliases      map[string]string
-	variables    map[string]string
-	mutex        sync.RWMutex
-	historyLimit int
+	workingDir    string
+	previousDir   string
+	history       []HistoryEntry
+	aliases       map[string]string
+	globalAliases map[string]string
+	suffixAliases map[string]string
+	variables     map[string]string
+	arrays        map[string]*Array
+	scopes        []map[string]savedVariable
+	readonly      map[string]bool
+	integers      map[string]bool
+	functions     map[string]string
+	disabled      map[string]bool
+	positional    []string
+	scriptName    string
+	getopts       getoptsPosition
+	options       map[string]bool
+	lastStatus    int
+	pipeStatus    []int
+	traps         map[string]string
+	jobs          []*Job
+	nextJobID     int
+	config        *config.Config
+	mutex         sync.RWMutex
+	historyLimit  int
+
+	// historyBase numbers the oldest history entry in historyIndex
+	historyIndex historyIndex
+	historyBase  int
+
+	// historyIgnore holds the compiled history_ignore patterns
+	historyIgnore []*regexp.Regexp
+
+	// The file history is saved to, and how much of it has been read
+	historyFile   string
+	historyOffset int64
+
+	// The entry of the command running from the prompt, numbered like
+	// historyIndex, saved once it finishes
+	historyPending bool
+	pendingSeq     int
+	pendingLine    string
+
+	// output keeps what commands print with capture_output on, else nil
+	output *OutputRing
 }
 
 // NewSession creates a new shell session
-func NewSession(cfg interface{}) *Session {
+func NewSession(cfg *config.Config) *Session {
 	wd, _ := os.Getwd()
+	if cfg == nil {
+		cfg = config.New()
+	}
+
+	historyLimit := cfg.HistoryLimit
+	if historyLimit <= 0 {
+		historyLimit = 1000
+	}
+
+	var historyIgnore []*regexp.Regexp
+	for _, pattern := range cfg.HistoryIgnore {
+		if re, err := regexp.Compile(pattern); err == nil {
+			historyIgnore = append(historyIgnore, re)
+		}
+	}
 
 	return &Session{
-		workingDir:   wd,
-		previousDir:  "",
-		history:      make([]string, 0),
-		aliases:      make(map[string]string),
-		variables:    make(map[string]string),
-		historyLimit: 1000, // Default history limit
+		config:        cfg,
+		historyIgnore: historyIgnore,
+		nextJobID:     1,
+		workingDir:    wd,
+		previousDir:   "",
+		history:       make([]HistoryEntry, 0),
+		aliases:       make(map[string]string),
+		globalAliases: make(map[string]string),
+		suffixAliases: make(map[string]string),
+		variables:     map[string]string{"OPTIND": "1"}, // where getopts starts
+		arrays:        make(map[string]*Array),
+		readonly:      make(map[string]bool),
+		integers:      make(map[string]bool),
+		functions:     make(map[string]string),
+		disabled:      make(map[string]bool),
+		options:       make(map[string]bool),
+		traps:         make(map[string]string),
+		historyLimit:  historyLimit,
 	}
 }
 
@@ -55,24 +153,151 @@ func (s *Session) SetPreviousDir(dir string) {
 	s.previousDir = dir
 }
 
+// SetOutputRing sets where the output of commands is kept
+func (s *Session) SetOutputRing(ring *OutputRing) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.output = ring
+}
+
+// OutputRing returns where the output of commands is kept, or nil when it
+// is not captured
+func (s *Session) OutputRing() *OutputRing {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.output
+}
+
+// CdPath returns the directories of CDPATH that cd looks for target in,
+// with an empty entry meaning the current directory. A target that is
+// absolute or starts with ., .. or ~ is never looked up.
+func (s *Session) CdPath(target string) []string {
+	cdpath, _ := s.LookupVariable("CDPATH")
+	if cdpath == "" || target == "" || target == "." || target == ".." ||
+		strings.HasPrefix(target, "/") || strings.HasPrefix(target, "./") ||
+		strings.HasPrefix(target, "../") || strings.HasPrefix(target, "~") {
+		return nil
+	}
+
+	dirs := strings.Split(cdpath, ":")
+	for i, dir := range dirs {
+		if dir == "" {
+			dirs[i] = "."
+		}
+	}
+	return dirs
+}
+
+// HistoryEntry is a command line in the history and when it was entered.
+// Commands run from the prompt with history_metadata on also record the
+// directory they ran in and, when Finished, how long they ran and their
+// exit status.
+type HistoryEntry struct {
+	Line     string
+	Time     time.Time
+	Dir      string
+	Duration time.Duration
+	Status   int
+	Finished bool
+}
+
 // History Management
+
+// AddHistory records a command line as it was typed. Surrounding space is
+// removed, but a leading space first keeps the line out of history when
+// history_ignore_space is set.
 func (s *Session) AddHistory(cmd string) {
+	s.addHistory(cmd, false)
+}
+
+// BeginHistory records a command line typed at the prompt, like
+// AddHistory, with the directory it runs in when history_metadata is on.
+// It is then saved to the history file by FinishHistory.
+func (s *Session) BeginHistory(cmd string) {
+	s.addHistory(cmd, s.config.HistoryMetadata)
+}
+
+func (s *Session) addHistory(cmd string, pending bool) {
+	if s.config.HistoryIgnoreSpace && strings.HasPrefix(cmd, " ") {
+		return
+	}
+	cmd = strings.TrimSpace(cmd)
+	if s.ignoredInHistory(cmd) {
+		return
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	// Don't add empty commands or duplicates of the last command
-	if cmd == "" || (len(s.history) > 0 && s.history[len(s.history)-1] == cmd) {
+	entry := HistoryEntry{Line: cmd, Time: time.Now()}
+	if pending {
+		entry.Dir = s.workingDir
+	}
+	if !s.addHistoryEntry(entry) {
+		return
+	}
+	if pending {
+		s.historyPending = true
+		s.pendingSeq = s.historyBase + len(s.history) - 1
+		s.pendingLine = cmd
+		return
+	}
+	s.saveHistoryEntry(entry)
+}
+
+// ignoredInHistory reports whether a history_ignore pattern matches cmd
+func (s *Session) ignoredInHistory(cmd string) bool {
+	for _, pattern := range s.historyIgnore {
+		if pattern.MatchString(cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+// FinishHistory records how long the command BeginHistory recorded ran
+// and its exit status, and saves it to the history file. A command that
+// removed its own entry, as fc does, leaves nothing to save.
+func (s *Session) FinishHistory(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.historyPending {
 		return
 	}
+	s.historyPending = false
 
-	s.history = append(s.history, cmd)
+	position := s.pendingSeq - s.historyBase
+	if position < 0 || position >= len(s.history) || s.history[position].Line != s.pendingLine {
+		return
+	}
+	entry := &s.history[position]
+	entry.Duration = time.Since(entry.Time)
+	entry.Status = status
+	entry.Finished = true
+	s.saveHistoryEntry(*entry)
+}
+
+// addHistoryEntry appends an entry and reports whether it was added; the
+// caller holds the mutex
+func (s *Session) addHistoryEntry(entry HistoryEntry) bool {
+	// Don't add empty commands or duplicates of the last command
+	if entry.Line == "" || (len(s.history) > 0 && s.history[len(s.history)-1].Line == entry.Line) {
+		return false
+	}
+
+	if s.config.HistoryEraseDups {
+		s.eraseHistory(entry.Line)
+	}
+
+	s.history = append(s.history, entry)
+	s.historyIndex.add(entry.Line, s.historyBase+len(s.history)-1)
 
 	// Limit history size for performance
 	if len(s.history) > s.historyLimit {
-		// Remove oldest entries
-		copy(s.history, s.history[len(s.history)-s.historyLimit:])
-		s.history = s.history[:s.historyLimit]
+		s.dropOldestHistory(len(s.history) - s.historyLimit)
 	}
+	return true
 }
 
 func (s *Session) GetHistory() []string {
@@ -81,6 +306,19 @@ func (s *Session) GetHistory() []string {
 
 	// Return a copy to prevent external modification
 	result := make([]string, len(s.history))
+	for i, entry := range s.history {
+		result[i] = entry.Line
+	}
+	return result
+}
+
+// GetHistoryEntries returns a copy of the history with the time of each
+// command
+func (s *Session) GetHistoryEntries() []HistoryEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]HistoryEntry, len(s.history))
 	copy(result, s.history)
 	return result
 }
@@ -92,7 +330,7 @@ func (s *Session) GetHistoryEntry(index int) string {
 	if index < 0 || index >= len(s.history) {
 		return ""
 	}
-	return s.history[index]
+	return s.history[index].Line
 }
 
 func (s *Session) GetHistorySize() int {
@@ -101,11 +339,32 @@ func (s *Session) GetHistorySize() int {
 	return len(s.history)
 }
 
-// Alias Management
+// Alias Management. A name is a regular alias, expanded in command
+// position, or a global alias, expanded anywhere on the line; defining it
+// as one kind removes it as the other.
 func (s *Session) SetAlias(name, value string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.aliases[name] = value
+	delete(s.globalAliases, name)
+}
+
+func (s *Session) SetGlobalAlias(name, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.globalAliases[name] = value
+	delete(s.aliases, name)
+}
+
+func (s *Session) GetGlobalAliases() map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make(map[string]string, len(s.globalAliases))
+	for k, v := range s.globalAliases {
+		result[k] = v
+	}
+	return result
 }
 
 func (s *Session) GetAliases() map[string]string {
@@ -124,18 +383,120 @@ func (s *Session) RemoveAlias(name string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	delete(s.aliases, name)
+	delete(s.globalAliases, name)
+}
+
+// Suffix aliases map a file extension to the program that opens files
+// with it; they have names of their own, so md can be both an alias and a
+// suffix alias.
+func (s *Session) SetSuffixAlias(suffix, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.suffixAliases[suffix] = value
+}
+
+func (s *Session) GetSuffixAliases() map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make(map[string]string, len(s.suffixAliases))
+	for k, v := range s.suffixAliases {
+		result[k] = v
+	}
+	return result
+}
+
+func (s *Session) RemoveSuffixAlias(suffix string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.suffixAliases, suffix)
 }
 
 // Variable Management
-func (s *Session) SetVariable(name, value string) {
+
+// SetVariable sets a shell (unexported) variable
+func (s *Session) SetVariable(name, value string) error {
+	value, err := s.evaluate(name, value)
+	if err != nil {
+		return err
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+
+	if s.readonly[name] {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if array, isArray := s.arrays[name]; isArray {
+		array.Elements["0"] = value
+		return nil
+	}
 	s.variables[name] = value
+	return nil
 }
 
+// AssignVariable performs a NAME=value assignment: exported variables are
+// updated in the environment, an array gets its element 0 set, and
+// everything else becomes a shell variable. An integer variable gets the
+// value of value as an arithmetic expression.
+func (s *Session) AssignVariable(name, value string) error {
+	value, err := s.evaluate(name, value)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readonly[name] {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if array, isArray := s.arrays[name]; isArray {
+		array.Elements["0"] = value
+		return nil
+	}
+
+	if _, isShellVar := s.variables[name]; !isShellVar {
+		if _, exported := os.LookupEnv(name); exported {
+			return os.Setenv(name, value)
+		}
+	}
+
+	s.variables[name] = value
+	return nil
+}
+
+// LookupVariable resolves a name against shell variables, then the environment
+func (s *Session) LookupVariable(name string) (string, bool) {
+	if value, exists := s.GetVariable(name); exists {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// UnsetVariable removes a variable from both the shell and the environment
+func (s *Session) UnsetVariable(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readonly[name] {
+		return fmt.Errorf("%s: cannot unset: readonly variable", name)
+	}
+
+	delete(s.variables, name)
+	delete(s.arrays, name)
+	delete(s.integers, name)
+	return os.Unsetenv(name)
+}
+
+// GetVariable returns a shell variable's value; an array's is its element 0
 func (s *Session) GetVariable(name string) (string, bool) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
+	if array, isArray := s.arrays[name]; isArray {
+		value, exists := array.Elements["0"]
+		return value, exists
+	}
 	value, exists := s.variables[name]
 	return value, exists
 }
@@ -156,9 +517,490 @@ func (s *Session) RemoveVariable(name string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	delete(s.variables, name)
+	delete(s.arrays, name)
+}
+
+// SetReadonly marks a variable as read-only
+func (s *Session) SetReadonly(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readonly[name] = true
+}
+
+// IsReadonly reports whether a variable is read-only
+func (s *Session) IsReadonly(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.readonly[name]
+}
+
+// GetReadonly returns the sorted names of all read-only variables
+func (s *Session) GetReadonly() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make([]string, 0, len(s.readonly))
+	for name := range s.readonly {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetInteger gives a variable the integer attribute, or with integer
+// false takes it away. Values later assigned to an integer variable are
+// evaluated as arithmetic expressions.
+func (s *Session) SetInteger(name string, integer bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.readonly[name] {
+		return fmt.Errorf("%s: readonly variable", name)
+	}
+	if integer {
+		s.integers[name] = true
+	} else {
+		delete(s.integers, name)
+	}
+	return nil
+}
+
+// IsInteger reports whether a variable has the integer attribute
+func (s *Session) IsInteger(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.integers[name]
+}
+
+// SetExported moves a shell variable into the environment, or with
+// export false an environment variable into the shell's own variables.
+// Arrays cannot be exported and are left as they are.
+func (s *Session) SetExported(name string, export bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, isArray := s.arrays[name]; isArray {
+		return nil
+	}
+	if export {
+		if value, exists := s.variables[name]; exists {
+			delete(s.variables, name)
+			return os.Setenv(name, value)
+		}
+		return nil
+	}
+	if value, exists := os.LookupEnv(name); exists {
+		if _, isShellVar := s.variables[name]; !isShellVar {
+			s.variables[name] = value
+		}
+	}
+	return os.Unsetenv(name)
+}
+
+// IsExported reports whether a variable is passed to commands in the
+// environment
+func (s *Session) IsExported(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, isShellVar := s.variables[name]; isShellVar {
+		return false
+	}
+	if _, isArray := s.arrays[name]; isArray {
+		return false
+	}
+	_, exported := os.LookupEnv(name)
+	return exported
+}
+
+// evaluate returns the value an assignment stores: value itself, or for
+// an integer variable the result of value as an arithmetic expression.
+// It runs before the mutex is taken, as the expression may read and
+// assign variables.
+func (s *Session) evaluate(name, value string) (string, error) {
+	if !s.IsInteger(name) {
+		return value, nil
+	}
+	vars := cli.ArithVars{Get: s.LookupVariable, Set: s.AssignVariable}
+	result, err := cli.EvalArithmetic(value, vars)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", value, err)
+	}
+	return strconv.FormatInt(result, 10), nil
+}
+
+// Function Management
+func (s *Session) SetFunction(name, body string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.functions[name] = body
+}
+
+func (s *Session) GetFunction(name string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	body, exists := s.functions[name]
+	return body, exists
+}
+
+func (s *Session) GetFunctions() map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Return a copy to prevent external modification
+	result := make(map[string]string)
+	for k, v := range s.functions {
+		result[k] = v
+	}
+	return result
+}
+
+// RemoveFunction deletes a function and reports whether it existed
+func (s *Session) RemoveFunction(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, exists := s.functions[name]
+	delete(s.functions, name)
+	return exists
+}
+
+// Builtin Management
+
+// IsBuiltin reports whether name runs a builtin: it is one and has not
+// been disabled with enable -n
+func (s *Session) IsBuiltin(name string) bool {
+	if !cli.IsBuiltin(name) {
+		return false
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return !s.disabled[name]
+}
+
+// SetBuiltinEnabled enables or disables a builtin. A disabled builtin's
+// name runs the program of that name in PATH.
+func (s *Session) SetBuiltinEnabled(name string, enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if enabled {
+		delete(s.disabled, name)
+	} else {
+		s.disabled[name] = true
+	}
+}
+
+// Positional Parameters
+
+// SetPositionalParams replaces $1..$N and returns the previous values
+func (s *Session) SetPositionalParams(params []string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	previous := s.positional
+	s.positional = append([]string(nil), params...)
+	return previous
+}
+
+func (s *Session) GetPositionalParams() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]string, len(s.positional))
+	copy(result, s.positional)
+	return result
+}
+
+// SetScriptName sets $0, the name of the shell or of the script it runs
+func (s *Session) SetScriptName(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scriptName = name
+}
+
+// ScriptName returns $0
+func (s *Session) ScriptName() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.scriptName
+}
+
+// getoptsPosition is how far getopts has got into a group of options
+// such as -abc: offset characters into the argument OPTIND was index at
+type getoptsPosition struct {
+	index, offset int
+}
+
+// GetoptsPosition returns where getopts stopped inside the argument at
+// OPTIND index. Once OPTIND no longer is index, getopts starts at the
+// beginning of the argument again.
+func (s *Session) GetoptsPosition(index int) int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.getopts.index != index {
+		return 0
+	}
+	return s.getopts.offset
+}
+
+// SetGetoptsPosition records where getopts stopped
+func (s *Session) SetGetoptsPosition(index, offset int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.getopts = getoptsPosition{index: index, offset: offset}
+}
+
+// Shell Options
+
+// ShellOptions lists the options understood by set -o, in display order
+var ShellOptions = []string{"dotglob", "errexit", "failglob", "huponexit", "noclobber", "nounset", "nullglob", "pipefail", "xtrace"}
+
+// IsShellOption reports whether name is a known shell option
+func IsShellOption(name string) bool {
+	for _, option := range ShellOptions {
+		if option == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOption turns a shell option on or off
+func (s *Session) SetOption(name string, enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.options[name] = enabled
+}
+
+// Option reports whether a shell option is enabled
+func (s *Session) Option(name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.options[name]
+}
+
+// Exit status
+
+// SetLastStatus records the exit status of the last command, for $?
+func (s *Session) SetLastStatus(status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastStatus = status
+}
+
+// LastStatus returns the exit status of the last command
+func (s *Session) LastStatus() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.lastStatus
+}
+
+// SetPipeStatus records the exit status of every stage of the last
+// pipeline, for $PIPESTATUS; a simple command is a pipeline of one
+func (s *Session) SetPipeStatus(statuses []int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pipeStatus = append([]int(nil), statuses...)
+}
+
+// PipeStatus returns the exit statuses of the stages of the last pipeline
+func (s *Session) PipeStatus() []int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]int(nil), s.pipeStatus...)
+}
+
+// Traps
+
+// SetTrap registers the command run when a condition (a signal name such
+// as INT, or EXIT) occurs; an empty command ignores the signal
+func (s *Session) SetTrap(condition, command string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.traps[condition] = command
+}
+
+// RemoveTrap restores the default action for a condition
+func (s *Session) RemoveTrap(condition string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.traps, condition)
+}
+
+// GetTrap returns the command registered for a condition
+func (s *Session) GetTrap(condition string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	command, exists := s.traps[condition]
+	return command, exists
+}
+
+// GetTraps returns a copy of all registered traps
+func (s *Session) GetTraps() map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	traps := make(map[string]string, len(s.traps))
+	for condition, command := range s.traps {
+		traps[condition] = command
+	}
+	return traps
+}
+
+// Job Management
+
+// AddJob records a new background job and returns it with its job number
+func (s *Session) AddJob(pid int, command, logPath string) *Job {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job := &Job{
+		ID:      s.nextJobID,
+		PID:     pid,
+		Command: command,
+		LogPath: logPath,
+		Started: time.Now(),
+		done:    make(chan struct{}),
+	}
+	s.nextJobID++
+	s.jobs = append(s.jobs, job)
+	return job
+}
+
+// SetJobState records that a job was stopped or continued
+func (s *Session) SetJobState(id int, state JobState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if job := s.findJob(id); job != nil && job.State != JobDone {
+		job.State = state
+	}
+}
+
+// FinishJob records the exit status of a job and wakes up its waiters
+func (s *Session) FinishJob(id, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if job := s.findJob(id); job != nil {
+		job.State = JobDone
+		job.Status = status
+		close(job.done)
+	}
+}
+
+// MarkJobNotified records that the completion of a job has been reported
+func (s *Session) MarkJobNotified(id int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if job := s.findJob(id); job != nil {
+		job.Notified = true
+	}
+}
+
+// SetJobNoHangup keeps a job from being sent SIGHUP when the shell hangs
+// up or exits with huponexit, while leaving it in the job table
+func (s *Session) SetJobNoHangup(id int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if job := s.findJob(id); job != nil {
+		job.NoHangup = true
+	}
+}
+
+// RemoveJob drops a job from the job table
+func (s *Session) RemoveJob(id int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, job := range s.jobs {
+		if job.ID == id {
+			s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// WaitJob blocks until a job is done and returns its exit status
+func (s *Session) WaitJob(id int) (int, bool) {
+	s.mutex.RLock()
+	job := s.findJob(id)
+	s.mutex.RUnlock()
+	if job == nil {
+		return 0, false
+	}
+	<-job.done
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return job.Status, true
+}
+
+// JobDone returns a channel that is closed once a job is done
+func (s *Session) JobDone(id int) (<-chan struct{}, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	job := s.findJob(id)
+	if job == nil {
+		return nil, false
+	}
+	return job.done, true
+}
+
+// findJob returns the job with the given ID; the caller holds the lock
+func (s *Session) findJob(id int) *Job {
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// FindJobByPID returns the job whose process has the given PID
+func (s *Session) FindJobByPID(pid int) (Job, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, job := range s.jobs {
+		if job.PID == pid {
+			return *job, true
+		}
+	}
+	return Job{}, false
+}
+
+// GetJob returns a copy of the job with the given ID
+func (s *Session) GetJob(id int) (Job, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if job := s.findJob(id); job != nil {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+func (s *Session) GetJobs() []Job {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// Return copies to prevent external modification
+	result := make([]Job, len(s.jobs))
+	for i, job := range s.jobs {
+		result[i] = *job
+	}
+	return result
 }
 
 // Configuration
+func (s *Session) Config() *config.Config {
+	return s.config
+}
+
 func (s *Session) SetHistoryLimit(limit int) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -168,8 +1010,7 @@ func (s *Session) SetHistoryLimit(limit int) {
 
 		// Truncate current history if needed
 		if len(s.history) > limit {
-			copy(s.history, s.history[len(s.history)-limit:])
-			s.history = s.history[:limit]
+			s.dropOldestHistory(len(s.history) - limit)
 		}
 	}
 }
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -256,9 +257,13 @@ func GetRandomPromptColor() string {
 	return RainbowColors[rand.Intn(len(RainbowColors))]
 }
 
-// FormatPrompt creates a colorful prompt
-func (c *ColorConfig) FormatPrompt(username, hostname, cwd, shellName string) string {
+// FormatPrompt creates a colorful prompt; a failed last command shows its
+// exit status
+func (c *ColorConfig) FormatPrompt(username, hostname, cwd, shellName string, status int) string {
 	if !c.Enabled || !IsColorSupported() {
+		if status != 0 {
+			return fmt.Sprintf("[%d] %s> ", status, shellName)
+		}
 		return fmt.Sprintf("%s> ", shellName)
 	}
 
@@ -287,6 +292,9 @@ func (c *ColorConfig) FormatPrompt(username, hostname, cwd, shellName string) st
 
 	// Add shell name with dynamic color
 	shellPart := Colorize(shellName, promptColor)
+	if status != 0 {
+		shellPart = Colorize(fmt.Sprintf("[%d] ", status), BrightRed) + shellPart
+	}
 
 	// Combine parts
 	prompt := strings.Join(parts, "")
@@ -300,30 +308,55 @@ func (c *ColorConfig) FormatPrompt(username, hostname, cwd, shellName string) st
 
 // PrintSuccess prints success message in green
 func PrintSuccess(message string) {
-	fmt.Printf("%s✅ %s%s\n", BrightGreen, message, Reset)
+	FprintSuccess(os.Stdout, message)
 }
 
 // PrintError prints error message in red
 func PrintError(message string) {
-	fmt.Printf("%s❌ %s%s\n", BrightRed, message, Reset)
+	FprintError(os.Stdout, message)
 }
 
 // PrintWarning prints warning message in yellow
 func PrintWarning(message string) {
-	fmt.Printf("%s⚠️  %s%s\n", BrightYellow, message, Reset)
+	FprintWarning(os.Stdout, message)
 }
 
 // PrintInfo prints info message in blue
 func PrintInfo(message string) {
-	fmt.Printf("%s💡 %s%s\n", BrightBlue, message, Reset)
+	FprintInfo(os.Stdout, message)
 }
 
 // PrintHeader prints a colorful header
 func PrintHeader(title string) {
+	FprintHeader(os.Stdout, title)
+}
+
+// FprintSuccess writes a success message in green to w
+func FprintSuccess(w io.Writer, message string) {
+	fmt.Fprintf(w, "%s✅ %s%s\n", BrightGreen, message, Reset)
+}
+
+// FprintError writes an error message in red to w
+func FprintError(w io.Writer, message string) {
+	fmt.Fprintf(w, "%s❌ %s%s\n", BrightRed, message, Reset)
+}
+
+// FprintWarning writes a warning message in yellow to w
+func FprintWarning(w io.Writer, message string) {
+	fmt.Fprintf(w, "%s⚠️  %s%s\n", BrightYellow, message, Reset)
+}
+
+// FprintInfo writes an info message in blue to w
+func FprintInfo(w io.Writer, message string) {
+	fmt.Fprintf(w, "%s💡 %s%s\n", BrightBlue, message, Reset)
+}
+
+// FprintHeader writes a colorful header to w
+func FprintHeader(w io.Writer, title string) {
 	border := strings.Repeat("═", len(title)+4)
-	fmt.Printf("%s╔%s╗%s\n", BrightCyan, border, Reset)
-	fmt.Printf("%s║  %s%s%s  ║%s\n", BrightCyan, Bold+BrightWhite, title, BrightCyan, Reset)
-	fmt.Printf("%s╚%s╝%s\n", BrightCyan, border, Reset)
+	fmt.Fprintf(w, "%s╔%s╗%s\n", BrightCyan, border, Reset)
+	fmt.Fprintf(w, "%s║  %s%s%s  ║%s\n", BrightCyan, Bold+BrightWhite, title, BrightCyan, Reset)
+	fmt.Fprintf(w, "%s╚%s╝%s\n", BrightCyan, border, Reset)
 }
 
 // Rainbow effect for text
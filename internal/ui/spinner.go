@@ -0,0 +1,179 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// spinnerFrames are drawn in turn while an operation runs
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+const (
+	// spinnerDelay keeps operations that finish quickly from flashing a
+	// status line
+	spinnerDelay    = 150 * time.Millisecond
+	spinnerInterval = 80 * time.Millisecond
+)
+
+// Spinner shows an animated status line, usually on stderr, while a slow
+// operation runs, and replaces it with a final message when the operation
+// ends. When its output is not a terminal only the final message is
+// printed. All methods do nothing on a nil Spinner, so quiet modes can skip
+// creating one.
+type Spinner struct {
+	mutex   sync.Mutex
+	out     io.Writer
+	message string
+	shown   bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewSpinner creates a spinner writing to out with its initial message
+func NewSpinner(out io.Writer, message string) *Spinner {
+	return &Spinner{out: out, message: message}
+}
+
+// Start begins animating the status line
+func (s *Spinner) Start() *Spinner {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, terminal := terminalWidth(s.out); s.stop != nil || !terminal {
+		return s
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		select {
+		case <-time.After(spinnerDelay):
+		case <-s.stop:
+			return
+		}
+
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			s.mutex.Lock()
+			s.draw(spinnerFrames[frame%len(spinnerFrames)])
+			s.mutex.Unlock()
+
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Update changes the message shown next to the animation
+func (s *Spinner) Update(message string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.message = message
+	s.mutex.Unlock()
+}
+
+// Success ends the operation with a message in green
+func (s *Spinner) Success(message string) {
+	s.finish(Colorize("✅ "+message, BrightGreen))
+}
+
+// Fail ends the operation with a message in red
+func (s *Spinner) Fail(message string) {
+	s.finish(Colorize("❌ "+message, BrightRed))
+}
+
+// Stop ends the operation and removes the status line
+func (s *Spinner) Stop() {
+	s.finish("")
+}
+
+// finish stops the animation and prints line in place of the status line
+func (s *Spinner) finish(line string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	stop, done := s.stop, s.done
+	s.mutex.Unlock()
+	if stop != nil {
+		select {
+		case <-stop:
+		default:
+			close(stop)
+		}
+		<-done
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.shown {
+		fmt.Fprint(s.out, "\r\x1b[K\x1b[?25h")
+		s.shown = false
+	}
+	if line != "" {
+		fmt.Fprintln(s.out, line)
+	}
+}
+
+// draw redraws the status line; the caller holds the mutex
+func (s *Spinner) draw(frame string) {
+	// Keep the line from wrapping, which \r could not undo
+	cols, _ := terminalWidth(s.out)
+	message := []rune(s.message)
+	if len(message) > cols-3 && cols > 3 {
+		message = append(message[:cols-4], '…')
+	}
+
+	if !s.shown {
+		// Hide the cursor while it would flicker along the line
+		fmt.Fprint(s.out, "\x1b[?25l")
+		s.shown = true
+	}
+	fmt.Fprintf(s.out, "\r\x1b[K%s %s", Colorize(frame, BrightCyan), string(message))
+}
+
+// terminalWidth returns the width of the terminal out writes to, and
+// whether it is one at all. Like builtin.IsTerminal it looks through
+// wrappers that expose their file.
+func terminalWidth(out io.Writer) (int, bool) {
+	if wrapper, ok := out.(interface{ File() *os.File }); ok {
+		out = wrapper.File()
+	}
+	file, ok := out.(*os.File)
+	if !ok || file == nil {
+		return 0, false
+	}
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	var size struct{ rows, cols, xpixel, ypixel uint16 }
+	var errno syscall.Errno
+	conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&size)))
+	})
+	if errno != 0 {
+		return 0, false
+	}
+	if size.cols == 0 {
+		return 80, true
+	}
+	return int(size.cols), true
+}
@@ -0,0 +1,206 @@
+package ui
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// writerBufferSize is how much a buffered Writer holds before it writes
+// without waiting for Flush
+const writerBufferSize = 4096
+
+// Writer wraps the output of the shell. A buffered Writer collects small
+// writes until Flush, so a screen update reaches the terminal in a single
+// write. A throttled Writer passes output on at a limited rate, so a
+// runaway command cannot flood the terminal faster than it can be read.
+type Writer struct {
+	mutex    sync.Mutex
+	out      io.Writer
+	buffered bool
+	buf      []byte
+
+	// Token bucket of the throttle: credit bytes may be written now and
+	// it refills at rate bytes per second
+	rate    int
+	credit  float64
+	refill  time.Time
+	discard atomic.Bool
+}
+
+// NewWriter returns a Writer that holds output until Flush or until its
+// buffer is full
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out, buffered: true, buf: make([]byte, 0, writerBufferSize)}
+}
+
+// NewThrottledWriter returns a Writer that writes straight through, but no
+// faster than bytesPerSecond
+func NewThrottledWriter(out io.Writer, bytesPerSecond int) *Writer {
+	return &Writer{out: out, rate: bytesPerSecond, refill: time.Now()}
+}
+
+// Write buffers or writes p
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.discard.Load() {
+		return len(p), nil
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !w.buffered {
+		return w.writeThrottled(p)
+	}
+	if len(w.buf)+len(p) > writerBufferSize {
+		if err := w.flush(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) >= writerBufferSize {
+		return w.out.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// Flush writes out everything buffered
+func (w *Writer) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.out.Write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// writeThrottled writes p in pieces of at most a tenth of a second's
+// worth, waiting for the bucket to refill between them; the caller holds
+// the mutex
+func (w *Writer) writeThrottled(p []byte) (int, error) {
+	if w.rate <= 0 {
+		return w.out.Write(p)
+	}
+
+	burst := max(w.rate/10, 1)
+	written := 0
+	for written < len(p) {
+		if w.discard.Load() {
+			return len(p), nil
+		}
+
+		now := time.Now()
+		w.credit = min(w.credit+now.Sub(w.refill).Seconds()*float64(w.rate), float64(burst))
+		w.refill = now
+
+		chunk := min(len(p)-written, burst)
+		if w.credit < float64(chunk) {
+			wait := (float64(chunk) - w.credit) / float64(w.rate)
+			time.Sleep(time.Duration(wait * float64(time.Second)))
+			continue
+		}
+
+		n, err := w.out.Write(p[written : written+chunk])
+		written += n
+		w.credit -= float64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Discard makes the Writer drop output instead of writing it, for output
+// nobody wants to wait for any more, such as that of an interrupted command
+func (w *Writer) Discard(discard bool) {
+	w.discard.Store(discard)
+	if discard {
+		w.mutex.Lock()
+		w.buf = w.buf[:0]
+		w.mutex.Unlock()
+	}
+}
+
+// File returns the file the Writer writes to, or nil if it writes to
+// something else
+func (w *Writer) File() *os.File {
+	file, _ := w.out.(*os.File)
+	return file
+}
+
+// TeeWriter writes to a stream and copies what it writes to another
+// writer, such as the ring of recent output
+type TeeWriter struct {
+	out  io.Writer
+	copy io.Writer
+}
+
+// NewTeeWriter returns a TeeWriter writing to out and copying to copy
+func NewTeeWriter(out, copy io.Writer) *TeeWriter {
+	return &TeeWriter{out: out, copy: copy}
+}
+
+// Write writes p to the stream and copies what was written
+func (t *TeeWriter) Write(p []byte) (int, error) {
+	n, err := t.out.Write(p)
+	t.copy.Write(p[:n])
+	return n, err
+}
+
+// File returns the file of the stream, so builtins writing to a terminal
+// still know it
+func (t *TeeWriter) File() *os.File {
+	switch out := t.out.(type) {
+	case *os.File:
+		return out
+	case interface{ File() *os.File }:
+		return out.File()
+	}
+	return nil
+}
+
+// ColorWriter writes a stream in a color, such as stderr in red, so it
+// stands out from the output around it. Colors of the text's own end with
+// a reset, after which the stream's color is taken up again.
+type ColorWriter struct {
+	out   io.Writer
+	color string
+}
+
+// NewColorWriter returns a ColorWriter writing to out in color
+func NewColorWriter(out io.Writer, color string) *ColorWriter {
+	return &ColorWriter{out: out, color: color}
+}
+
+// Write writes p in the color, leaving the terminal's style reset after it
+func (c *ColorWriter) Write(p []byte) (int, error) {
+	text := string(p)
+	for _, reset := range []string{Reset, "\033[m"} {
+		text = strings.ReplaceAll(text, reset, reset+c.color)
+	}
+	if _, err := io.WriteString(c.out, c.color+text+Reset); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// File returns the file of the stream, so builtins writing to a terminal
+// still know it
+func (c *ColorWriter) File() *os.File {
+	switch out := c.out.(type) {
+	case *os.File:
+		return out
+	case interface{ File() *os.File }:
+		return out.File()
+	}
+	return nil
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
 	"os/user"
+	"path/filepath"
 	"strings"
-	"syscall"
 
+	"gex/internal/builtin"
 	"gex/internal/cli"
 	"gex/internal/config"
 	"gex/internal/core"
@@ -25,19 +26,127 @@ const (
 )
 
 func main() {
-	// Initialize signal handling
-	setupSignalHandling()
-
 	// Initialize configuration
-	cfg := config.New()
+	cfg, err := config.LoadDefault()
+	if err != nil {
+		ui.PrintWarning(fmt.Sprintf("Cannot load %s: %v", config.GetConfigPath(), err))
+		cfg = config.New()
+	}
 
 	// Initialize shell components
 	session := shell.NewSession(cfg)
-	executor := executor.New(session)
-	reader := readline.New(session)
+	session.SetScriptName(os.Args[0])
+	exe := executor.New(session)
+
+	// Dispatch signals to traps
+	exe.HandleSignals()
 
-	// Initialize command pool for performance
+	// Initialize command pool and caches for performance
 	core.InitializePool()
+	core.InitializeCache()
+
+	// Parse command line options. A login shell is started with a leading
+	// dash in argv[0] (by login or sshd) or with --login.
+	login := strings.HasPrefix(os.Args[0], "-")
+	var command string
+	var hasCommand bool
+	args := os.Args[1:]
+
+options:
+	for len(args) > 0 {
+		switch args[0] {
+		case "-l", "--login":
+			login = true
+			args = args[1:]
+		case "--throttle":
+			// Bytes per second commands may write to the terminal
+			if len(args) < 2 {
+				fmt.Fprintf(os.Stderr, "%s: --throttle: option requires an argument\n", SHELL_NAME)
+				os.Exit(2)
+			}
+			rate, err := builtin.ParseByteSize(args[1])
+			if err != nil || rate <= 0 {
+				fmt.Fprintf(os.Stderr, "%s: --throttle: invalid rate: %s\n", SHELL_NAME, args[1])
+				os.Exit(2)
+			}
+			exe.ThrottleOutput(int(rate))
+			args = args[2:]
+		case "-c":
+			if len(args) < 2 {
+				fmt.Fprintf(os.Stderr, "%s: -c: option requires an argument\n", SHELL_NAME)
+				os.Exit(2)
+			}
+			command = args[1]
+			hasCommand = true
+			args = args[2:]
+			break options
+		default:
+			break options
+		}
+	}
+
+	if login {
+		sourceProfiles(exe)
+	}
+
+	// One-shot mode: gex -c "command" [name [args...]], name being $0
+	if hasCommand {
+		if len(args) > 0 {
+			session.SetScriptName(args[0])
+			session.SetPositionalParams(args[1:])
+		}
+		os.Exit(exe.RunExitTrap(runCommandString(exe, command)))
+	}
+
+	// Script mode: gex script [args...], the script's path being $0
+	if len(args) > 0 {
+		file, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", SHELL_NAME, err)
+			os.Exit(127)
+		}
+		session.SetScriptName(args[0])
+		session.SetPositionalParams(args[1:])
+		status := runScript(exe, file)
+		file.Close()
+		os.Exit(exe.RunExitTrap(status))
+	}
+
+	// Non-interactive mode: run commands piped or redirected into stdin
+	if !readline.IsTerminal() {
+		os.Exit(exe.RunExitTrap(runScript(exe, os.Stdin)))
+	}
+
+	// Give foreground commands the terminal so Ctrl+C and Ctrl+Z reach them
+	exe.EnableJobControl()
+
+	// Keep history across sessions
+	if err := session.OpenHistoryFile(cfg.HistoryPath()); err != nil {
+		ui.PrintWarning(fmt.Sprintf("History not saved: %v", err))
+	}
+
+	reader := readline.New(session)
+	reader.SetOutput(ui.NewWriter(os.Stdout))
+	reader.SetValueSource(builtin.CompletionValues)
+
+	// Ctrl+C stops the running command, not the shell
+	exe.SetInteractive(reader.Interrupt)
+
+	// Leave the terminal usable while the shell is stopped at the prompt
+	exe.SetSuspendHandlers(reader.Suspend, reader.Resume)
+
+	// Keep what commands print to search it after it scrolled by
+	var output *shell.OutputRing
+	if cfg.CaptureOutput {
+		output = shell.NewOutputRing()
+		session.SetOutputRing(output)
+		exe.CaptureOutput(output)
+	}
+
+	// Tell errors apart from the output around them
+	if term := os.Getenv("TERM"); cfg.HighlightStderr && cfg.ColorOutput && term != "" && term != "dumb" && builtin.IsTerminal(os.Stderr) {
+		exe.HighlightStderr(ui.Red)
+	}
 
 	// Initialize color config
 	colorConfig := ui.DefaultColorConfig()
@@ -54,10 +163,25 @@ func main() {
 	}
 
 	// Main REPL loop
+	status := 0
 	for {
+		// Run traps for signals that arrived since the last command
+		if err := exe.RunPendingTraps(); err != nil {
+			status = executor.ExitCode(err)
+			break
+		}
+
+		// Report background jobs that finished
+		exe.NotifyJobs()
+
+		// Pick up commands entered in other sessions
+		if cfg.ShareHistory {
+			session.SyncHistory()
+		}
+
 		// Create dynamic colorful prompt
 		cwd, _ := os.Getwd()
-		prompt := colorConfig.FormatPrompt(username, hostname, cwd, SHELL_NAME)
+		prompt := colorConfig.FormatPrompt(username, hostname, cwd, SHELL_NAME, session.LastStatus())
 		reader.SetPrompt(prompt)
 
 		// Read input with readline support
@@ -65,46 +189,198 @@ func main() {
 		if err != nil {
 			if err.Error() == "EOF" {
 				fmt.Println("\nExiting...")
+				status = session.LastStatus()
 				break
 			}
 			continue
 		}
 
 		// Skip empty lines
+		typed := input
 		input = strings.TrimSpace(input)
 		if input == "" {
 			continue
 		}
 
-		// Add to history
-		session.AddHistory(input)
+		// Add to history as typed, so a leading space can keep it out;
+		// how long it ran and its status are added once it is done
+		session.BeginHistory(typed)
+
+		// ?pattern searches the output kept
+		if pattern, ok := strings.CutPrefix(input, "?"); ok && output != nil {
+			input = "last-output -a -- " + quoteWord(strings.TrimSpace(pattern))
+		}
 
 		// Parse and execute command
-		cmd, err := cli.Parse(input)
+		cmd, err := exe.Parse(input)
+		if errors.Is(err, cli.ErrEmptyCommand) {
+			session.FinishHistory(session.LastStatus())
+			continue
+		}
 		if err != nil {
 			ui.PrintError(fmt.Sprintf("Parse error: %v", err))
+			session.SetLastStatus(2)
+			session.FinishHistory(2)
 			continue
 		}
 
+		// What the command before printed becomes $OUT
+		if output != nil {
+			output.Mark()
+			session.SetVariable("OUT", output.Last())
+		}
+
 		// Execute command
-		if err := executor.Execute(cmd); err != nil {
+		if err := exe.Execute(cmd); err != nil {
 			if err.Error() == "exit" {
+				status = executor.ExitCode(err)
+				session.FinishHistory(status)
 				break
 			}
-			ui.PrintError(fmt.Sprintf("%v", err))
+			if executor.ShouldReport(err) {
+				ui.PrintError(fmt.Sprintf("%v", err))
+			}
+		}
+		session.FinishHistory(session.LastStatus())
+	}
+
+	// Jobs die with the terminal, and with huponexit with the shell too
+	if exe.HungUp() || session.Option("huponexit") {
+		exe.HangupJobs()
+	}
+
+	status = exe.RunExitTrap(status)
+	exe.ReleaseTerminal()
+	os.Exit(status)
+}
+
+// sourceProfiles runs the system and user profiles of a login shell
+func sourceProfiles(exe *executor.Executor) {
+	profiles := []string{"/etc/gexprofile"}
+	if home := os.Getenv("HOME"); home != "" {
+		profiles = append(profiles, filepath.Join(home, ".gex_profile"))
+	}
+
+	for _, path := range profiles {
+		file, err := os.Open(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", SHELL_NAME, err)
+			}
+			continue
 		}
+		runScript(exe, file)
+		file.Close()
+	}
+}
+
+// runCommandString parses and executes a command string and returns its
+// exit status
+func runCommandString(exe *executor.Executor, input string) int {
+	cmd, err := exe.Parse(input)
+	if errors.Is(err, cli.ErrEmptyCommand) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: parse error: %v\n", SHELL_NAME, err)
+		return 2
 	}
+
+	status, _ := runNonInteractive(exe, cmd)
+	return status
 }
 
-func setupSignalHandling() {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+// runScript executes commands read from a file or non-terminal stdin
+// without a prompt and returns the status of the last command
+func runScript(exe *executor.Executor, file *os.File) int {
+	status := 0
+	input := ""
+
+	for {
+		line, readErr := readScriptLine(file)
+		if line == "" && readErr != nil {
+			break
+		}
+
+		// A trailing backslash continues the command on the next line
+		if trailing := len(line) - len(strings.TrimRight(line, "\\")); trailing%2 == 1 && readErr == nil {
+			input += line[:len(line)-1]
+			continue
+		}
+		input += line
+
+		cmd, err := exe.Parse(input)
+		if cli.IsIncomplete(err) && readErr == nil {
+			// Quotes and function bodies may span several lines
+			input += "\n"
+			continue
+		}
+		input = ""
+
+		if errors.Is(err, cli.ErrEmptyCommand) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: parse error: %v\n", SHELL_NAME, err)
+			status = 2
+			continue
+		}
+
+		result, exit := runNonInteractive(exe, cmd)
+		if exit {
+			// A bare exit keeps the status of the previous command
+			if result != 0 {
+				return result
+			}
+			return status
+		}
+		status = result
+	}
+
+	if strings.TrimSpace(input) != "" {
+		fmt.Fprintf(os.Stderr, "%s: parse error: unexpected end of input\n", SHELL_NAME)
+		return 2
+	}
+
+	return status
+}
+
+// readScriptLine reads one line a byte at a time, so commands in the
+// script that read stdin get the input that follows them
+func readScriptLine(file *os.File) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+
+	for {
+		n, err := file.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				return strings.TrimSuffix(string(line), "\r"), nil
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// runNonInteractive executes a parsed command, reporting errors on stderr.
+// It returns the exit status and whether the shell should exit.
+func runNonInteractive(exe *executor.Executor, cmd *cli.Command) (int, bool) {
+	err := exe.Execute(cmd)
+	if err != nil && err.Error() == "exit" {
+		return executor.ExitCode(err), true
+	}
+	if executor.ShouldReport(err) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", SHELL_NAME, err)
+	}
+	return executor.ExitCode(err), false
+}
 
-	go func() {
-		<-c
-		fmt.Println("\nInterrupt received, exiting...")
-		os.Exit(0)
-	}()
+// quoteWord quotes text as a single word for the parser
+func quoteWord(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", `'\''`) + "'"
 }
 
 func printWelcome() {